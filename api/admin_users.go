@@ -0,0 +1,533 @@
+package api
+
+import (
+	"HMCTS-Developer-Challenge/audit"
+	"HMCTS-Developer-Challenge/database"
+	"HMCTS-Developer-Challenge/errors"
+	"HMCTS-Developer-Challenge/session"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+const defaultUserPageSize = 50
+const maxUserPageSize = 100
+
+// defaultAdminUsername is the name of the first admin when none is configured.
+const defaultAdminUsername = "admin"
+
+// adminUser is a user as admins see them, including the state of the account.
+type adminUser struct {
+	ID                    uint   `json:"id"`
+	Name                  string `json:"name"`
+	Role                  string `json:"role"`
+	Disabled              bool   `json:"disabled"`
+	PasswordResetRequired bool   `json:"password_reset_required"`
+}
+
+type userPage struct {
+	Users []adminUser `json:"users"`
+	Total int         `json:"total"`
+	Next  string      `json:"next,omitempty"`
+}
+
+type adminUserData struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+	Role     string `json:"role"`
+}
+
+type userRoleData struct {
+	Role string `json:"role"`
+}
+
+type userDisabledData struct {
+	Disabled bool `json:"disabled"`
+}
+
+type passwordResetData struct {
+	// Password is a temporary password the user has to replace when they next
+	// log in.
+	Password string `json:"password"`
+}
+
+var errOwnAccount = errors.Error("Admins Cannot Change Their Own Account")
+var errPasswordRequired = errors.Error("Password Required")
+var errInvalidUserPage = errors.Error("limit must be between 1 and 100 and cursor a user ID")
+
+// BootstrapAdmin creates the first admin from configuration when there is no
+// active admin yet, so that no install starts with a published password. The
+// admin has to choose a new password at their first login. An empty password
+// leaves the users as they are.
+func BootstrapAdmin(username, password string) error {
+	if password == "" {
+		return nil
+	}
+	if username == "" {
+		username = defaultAdminUsername
+	}
+
+	dbHandle, err := database.GetDBHandle()
+	if err != nil {
+		return errors.AddContext(err, "admin_users.go: BootstrapAdmin - GetDBHandle")
+	}
+
+	var exists bool
+	if err := dbHandle.QueryRow("SELECT EXISTS(SELECT 1 FROM users WHERE role = ? AND NOT disabled)", RoleAdmin).Scan(&exists); err != nil {
+		return errors.AddContext(err, "admin_users.go: BootstrapAdmin - QueryRow")
+	} else if exists {
+		return nil
+	}
+
+	userID, err := insertUser(dbHandle, username, password, RoleAdmin)
+	if err == errUserExists {
+		return errors.Errorf("admin_users.go: BootstrapAdmin - user %q already exists", username)
+	} else if err != nil {
+		return errors.AddContext(err, "admin_users.go: BootstrapAdmin - insertUser")
+	}
+	if _, err := dbHandle.Exec("UPDATE users SET password_reset_required = TRUE WHERE id = ?", userID); err != nil {
+		return errors.AddContext(err, "admin_users.go: BootstrapAdmin - Exec")
+	}
+
+	audit.Log(audit.Entry{
+		Event:    audit.EventSignup,
+		UserID:   audit.UserID(userID),
+		Username: username,
+		Details:  map[string]string{"method": "bootstrap", "role": RoleAdmin},
+	})
+	return nil
+}
+
+// AdminUsersHandler lets admins list, create, disable, reset and delete user
+// accounts under /api/admin/users.
+func AdminUsersHandler(w http.ResponseWriter, r *http.Request, adminID uint) {
+	pathParts := strings.Split(strings.TrimSuffix(r.URL.Path, "/"), "/")
+	userID := ""
+	if len(pathParts) > 4 {
+		userID = pathParts[4]
+	}
+
+	// Account changes live under /api/admin/users/{id}/{change}
+	if len(pathParts) > 5 {
+		if len(pathParts) != 6 {
+			http.Error(w, "Not Found", http.StatusNotFound)
+			return
+		}
+
+		switch pathParts[5] {
+		case "role":
+			userRoleHandler(w, r, adminID, userID)
+		case "disabled":
+			userDisabledHandler(w, r, adminID, userID)
+		case "password-reset":
+			passwordResetHandler(w, r, adminID, userID)
+		default:
+			http.Error(w, "Not Found", http.StatusNotFound)
+		}
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		if userID == "" {
+			page, err := getUserPage(r.URL)
+			if err == errInvalidUserPage {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				break
+			} else if err != nil {
+				errors.HandleServerError(w, err, "admin_users.go: AdminUsersHandler - getUserPage")
+				break
+			}
+			writeJSON(w, http.StatusOK, page)
+			break
+		}
+
+		u, err := getAdminUser(userID)
+		if err == errUserNotFound {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			break
+		} else if err != nil {
+			errors.HandleServerError(w, err, "admin_users.go: AdminUsersHandler - getAdminUser")
+			break
+		}
+		writeJSON(w, http.StatusOK, u)
+	case http.MethodPost:
+		if userID != "" {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			break
+		}
+
+		var data adminUserData
+		if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+			http.Error(w, "Invalid JSON", http.StatusBadRequest)
+			break
+		}
+
+		u, err := addUser(data)
+		if err == errEmptyUsernameOrPassword || err == errInvalidRole {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			break
+		} else if err == errUserExists {
+			http.Error(w, err.Error(), http.StatusConflict)
+			break
+		} else if err != nil {
+			errors.HandleServerError(w, err, "admin_users.go: AdminUsersHandler - addUser")
+			break
+		}
+
+		audit.Log(audit.Entry{
+			Event:    audit.EventSignup,
+			UserID:   audit.UserID(u.ID),
+			Username: u.Name,
+			SourceIP: audit.SourceIP(r),
+			Details:  map[string]string{"method": "admin", "role": u.Role, "created_by": strconv.FormatUint(uint64(adminID), 10)},
+		})
+		writeJSON(w, http.StatusCreated, u)
+	case http.MethodDelete:
+		if userID == "" {
+			http.Error(w, "User ID Required", http.StatusBadRequest)
+			break
+		}
+
+		policy, transferTo, err := parseUserDeletion(r.URL.Query())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			break
+		}
+
+		if err := removeUser(adminID, userID, policy, transferTo, taskEditOptions{SourceIP: audit.SourceIP(r)}); err == errUserNotFound {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			break
+		} else if err == errOwnAccount {
+			http.Error(w, err.Error(), http.StatusConflict)
+			break
+		} else if err == errUnknownAssignee {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			break
+		} else if err != nil {
+			errors.HandleServerError(w, err, "admin_users.go: AdminUsersHandler - removeUser")
+			break
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	case http.MethodOptions:
+		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, DELETE, OPTIONS")
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func userRoleHandler(w http.ResponseWriter, r *http.Request, adminID uint, userID string) {
+	switch r.Method {
+	case http.MethodPut:
+		var data userRoleData
+		if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+			http.Error(w, "Invalid JSON", http.StatusBadRequest)
+			break
+		}
+
+		u, err := setUserRole(adminID, userID, data.Role)
+		if err == errUserNotFound {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			break
+		} else if err == errInvalidRole {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			break
+		} else if err == errOwnAccount {
+			http.Error(w, err.Error(), http.StatusConflict)
+			break
+		} else if err != nil {
+			errors.HandleServerError(w, err, "admin_users.go: userRoleHandler - setUserRole")
+			break
+		}
+		writeJSON(w, http.StatusOK, u)
+	case http.MethodOptions:
+		w.Header().Set("Access-Control-Allow-Methods", "PUT, OPTIONS")
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func userDisabledHandler(w http.ResponseWriter, r *http.Request, adminID uint, userID string) {
+	switch r.Method {
+	case http.MethodPut:
+		var data userDisabledData
+		if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+			http.Error(w, "Invalid JSON", http.StatusBadRequest)
+			break
+		}
+
+		u, err := setUserDisabled(adminID, userID, data.Disabled)
+		if err == errUserNotFound {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			break
+		} else if err == errOwnAccount {
+			http.Error(w, err.Error(), http.StatusConflict)
+			break
+		} else if err != nil {
+			errors.HandleServerError(w, err, "admin_users.go: userDisabledHandler - setUserDisabled")
+			break
+		}
+		writeJSON(w, http.StatusOK, u)
+	case http.MethodOptions:
+		w.Header().Set("Access-Control-Allow-Methods", "PUT, OPTIONS")
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func passwordResetHandler(w http.ResponseWriter, r *http.Request, adminID uint, userID string) {
+	switch r.Method {
+	case http.MethodPost:
+		var data passwordResetData
+		if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+			http.Error(w, "Invalid JSON", http.StatusBadRequest)
+			break
+		}
+
+		u, err := resetUserPassword(adminID, userID, data.Password)
+		if err == errUserNotFound {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			break
+		} else if err == errPasswordRequired {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			break
+		} else if err == errOwnAccount {
+			http.Error(w, err.Error(), http.StatusConflict)
+			break
+		} else if err != nil {
+			errors.HandleServerError(w, err, "admin_users.go: passwordResetHandler - resetUserPassword")
+			break
+		}
+		writeJSON(w, http.StatusOK, u)
+	case http.MethodOptions:
+		w.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS")
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// getUserPage lists users by ID. The cursor is the ID of the last user on the
+// previous page, so users created while paging do not shift the pages.
+func getUserPage(base *url.URL) (userPage, error) {
+	page := userPage{Users: []adminUser{}}
+
+	values := base.Query()
+	limit := defaultUserPageSize
+	if l := values.Get("limit"); l != "" {
+		n, err := strconv.Atoi(l)
+		if err != nil || n < 1 || n > maxUserPageSize {
+			return page, errInvalidUserPage
+		}
+		limit = n
+	}
+
+	var after uint64
+	if cursor := values.Get("cursor"); cursor != "" {
+		var err error
+		if after, err = strconv.ParseUint(cursor, 10, 32); err != nil {
+			return page, errInvalidUserPage
+		}
+	}
+
+	dbHandle, err := database.GetDBHandle()
+	if err != nil {
+		return page, errors.AddContext(err, "admin_users.go: getUserPage - GetDBHandle")
+	}
+
+	if err := dbHandle.QueryRow("SELECT COUNT(*) FROM users").Scan(&page.Total); err != nil {
+		return page, errors.AddContext(err, "admin_users.go: getUserPage - QueryRow")
+	}
+
+	// Fetch one extra user to know whether there is a next page
+	rows, err := dbHandle.Query(
+		"SELECT id, name, role, disabled, password_reset_required FROM users WHERE id > ? ORDER BY id LIMIT ?",
+		after, limit+1,
+	)
+	if err != nil {
+		return page, errors.AddContext(err, "admin_users.go: getUserPage - Query")
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		u, err := scanAdminUser(rows)
+		if err != nil {
+			return page, errors.AddContext(err, "admin_users.go: getUserPage - Scan")
+		}
+		page.Users = append(page.Users, u)
+	}
+	if err := rows.Err(); err != nil {
+		return page, errors.AddContext(err, "admin_users.go: getUserPage - Rows")
+	}
+
+	if len(page.Users) > limit {
+		page.Users = page.Users[:limit]
+		page.Next = nextTaskPageURL(base, strconv.FormatUint(uint64(page.Users[limit-1].ID), 10))
+	}
+	return page, nil
+}
+
+func getAdminUser(userID string) (adminUser, error) {
+	dbHandle, err := database.GetDBHandle()
+	if err != nil {
+		return adminUser{}, errors.AddContext(err, "admin_users.go: getAdminUser - GetDBHandle")
+	}
+
+	u, err := scanAdminUser(dbHandle.QueryRow(
+		"SELECT id, name, role, disabled, password_reset_required FROM users WHERE id = ?",
+		userID,
+	))
+	if err == sql.ErrNoRows {
+		return u, errUserNotFound
+	} else if err != nil {
+		return u, errors.AddContext(err, "admin_users.go: getAdminUser - Scan")
+	}
+	return u, nil
+}
+
+func scanAdminUser(row rowScanner) (adminUser, error) {
+	var u adminUser
+	err := row.Scan(&u.ID, &u.Name, &u.Role, &u.Disabled, &u.PasswordResetRequired)
+	return u, err
+}
+
+func addUser(data adminUserData) (adminUser, error) {
+	role, err := normalizeRole(data.Role)
+	if err != nil {
+		return adminUser{}, err
+	}
+
+	userID, err := createUser(data.Username, data.Password, role)
+	if err != nil {
+		return adminUser{}, err
+	}
+	return getAdminUser(strconv.FormatUint(uint64(userID), 10))
+}
+
+// otherUser looks up the user an admin wants to change. Admins cannot change
+// their own account, so there is always another admin left to undo a change.
+func otherUser(adminID uint, userID string) (adminUser, error) {
+	u, err := getAdminUser(userID)
+	if err != nil {
+		return u, err
+	}
+	if u.ID == adminID {
+		return u, errOwnAccount
+	}
+	return u, nil
+}
+
+// setUserRole changes the role of a user. Their sessions pick up the new role
+// straight away.
+func setUserRole(adminID uint, userID string, role string) (adminUser, error) {
+	if strings.TrimSpace(role) == "" {
+		return adminUser{}, errInvalidRole
+	}
+	role, err := normalizeRole(role)
+	if err != nil {
+		return adminUser{}, err
+	}
+
+	u, err := otherUser(adminID, userID)
+	if err != nil {
+		return u, err
+	}
+
+	dbHandle, err := database.GetDBHandle()
+	if err != nil {
+		return u, errors.AddContext(err, "admin_users.go: setUserRole - GetDBHandle")
+	}
+
+	if _, err := dbHandle.Exec("UPDATE users SET role = ? WHERE id = ?", role, u.ID); err != nil {
+		return u, errors.AddContext(err, "admin_users.go: setUserRole - Exec")
+	}
+	session.SetUserRole(u.ID, role)
+
+	u.Role = role
+	return u, nil
+}
+
+// setUserDisabled disables or enables an account. Disabled users are logged
+// out, cannot log in and cannot be assigned tasks, but keep their tasks.
+func setUserDisabled(adminID uint, userID string, disabled bool) (adminUser, error) {
+	u, err := otherUser(adminID, userID)
+	if err != nil {
+		return u, err
+	}
+
+	dbHandle, err := database.GetDBHandle()
+	if err != nil {
+		return u, errors.AddContext(err, "admin_users.go: setUserDisabled - GetDBHandle")
+	}
+
+	if _, err := dbHandle.Exec("UPDATE users SET disabled = ? WHERE id = ?", disabled, u.ID); err != nil {
+		return u, errors.AddContext(err, "admin_users.go: setUserDisabled - Exec")
+	}
+	if disabled {
+		session.DeleteUserSessions(u.ID)
+	}
+
+	u.Disabled = disabled
+	return u, nil
+}
+
+// resetUserPassword gives a user a temporary password, logs them out and
+// makes them choose a new password when they next log in.
+func resetUserPassword(adminID uint, userID string, password string) (adminUser, error) {
+	if password == "" {
+		return adminUser{}, errPasswordRequired
+	}
+
+	u, err := otherUser(adminID, userID)
+	if err != nil {
+		return u, err
+	}
+
+	if err := setUserPassword(u.ID, password, true); err != nil {
+		return u, errors.AddContext(err, "admin_users.go: resetUserPassword - setUserPassword")
+	}
+	session.DeleteUserSessions(u.ID)
+
+	u.PasswordResetRequired = true
+	return u, nil
+}
+
+func removeUser(adminID uint, userID string, policy string, transferTo *uint, options taskEditOptions) error {
+	u, err := otherUser(adminID, userID)
+	if err != nil {
+		return err
+	}
+
+	if err := deleteUser(adminID, u.ID, policy, transferTo, options); err == errUserNotFound || err == errUnknownAssignee {
+		return err
+	} else if err != nil {
+		return errors.AddContext(err, "admin_users.go: removeUser - deleteUser")
+	}
+	session.DeleteUserSessions(u.ID)
+	return nil
+}
+
+// parseUserDeletion reads how to handle the tasks of a deleted user from the
+// tasks and to query parameters.
+func parseUserDeletion(values url.Values) (string, *uint, error) {
+	policy := values.Get("tasks")
+	switch policy {
+	case "", userDeletionOrphan, userDeletionReassign:
+		return policy, nil, nil
+	case userDeletionTransfer:
+		to, err := strconv.ParseUint(values.Get("to"), 10, 32)
+		if err != nil {
+			return "", nil, errors.Error("to must be the ID of the user to transfer the tasks to")
+		}
+		transferTo := uint(to)
+		return policy, &transferTo, nil
+	}
+	return "", nil, errors.Error("tasks must be orphan, reassign or transfer")
+}
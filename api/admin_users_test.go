@@ -0,0 +1,240 @@
+package api
+
+import (
+	"HMCTS-Developer-Challenge/database"
+	"HMCTS-Developer-Challenge/session"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+// User 3 is the admin of the test database
+const testAdminID = 3
+
+func performAdminRequest(t *testing.T, method, url string, body string) *httptest.ResponseRecorder {
+	req, err := http.NewRequest(method, url, bytes.NewBufferString(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	rr := httptest.NewRecorder()
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		AdminUsersHandler(w, r, testAdminID)
+	})
+	handler.ServeHTTP(rr, req)
+	return rr
+}
+
+func performLoginRequest(t *testing.T, body string) *httptest.ResponseRecorder {
+	req, err := http.NewRequest("POST", "/api/login", bytes.NewBufferString(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(LoginHandler).ServeHTTP(rr, req)
+	return rr
+}
+
+func TestParseUserDeletion(t *testing.T) {
+	for query, expected := range map[string]string{"": "", "tasks=orphan": userDeletionOrphan, "tasks=reassign": userDeletionReassign} {
+		values, _ := url.ParseQuery(query)
+		if policy, to, err := parseUserDeletion(values); err != nil || policy != expected || to != nil {
+			t.Errorf("%q: expected %q, got %q, %v (%v)", query, expected, policy, to, err)
+		}
+	}
+
+	values, _ := url.ParseQuery("tasks=transfer&to=2")
+	if policy, to, err := parseUserDeletion(values); err != nil || policy != userDeletionTransfer || to == nil || *to != 2 {
+		t.Errorf("Expected a transfer to user 2, got %q, %v (%v)", policy, to, err)
+	}
+
+	for _, query := range []string{"tasks=transfer", "tasks=transfer&to=bob", "tasks=cascade"} {
+		values, _ := url.ParseQuery(query)
+		if _, _, err := parseUserDeletion(values); err == nil {
+			t.Errorf("%q: expected an error", query)
+		}
+	}
+}
+
+func TestBootstrapAdmin(t *testing.T) {
+	db, err := database.GetDBHandle()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		db.Exec("UPDATE users SET disabled = FALSE WHERE id = 3")
+		db.Exec("DELETE FROM users WHERE name = 'bootstrapadmin'")
+	})
+
+	countAdmins := func() int {
+		var count int
+		if err := db.QueryRow("SELECT COUNT(*) FROM users WHERE name = 'bootstrapadmin' AND role = ? AND password_reset_required", RoleAdmin).Scan(&count); err != nil {
+			t.Fatal(err)
+		}
+		return count
+	}
+
+	// The seeded admin is enough
+	if err := BootstrapAdmin("bootstrapadmin", "temporary123"); err != nil || countAdmins() != 0 {
+		t.Fatalf("Expected no admin to be created, got %d (%v)", countAdmins(), err)
+	}
+
+	if _, err := db.Exec("UPDATE users SET disabled = TRUE WHERE id = 3"); err != nil {
+		t.Fatal(err)
+	}
+	if err := BootstrapAdmin("bootstrapadmin", ""); err != nil || countAdmins() != 0 {
+		t.Fatalf("Expected no admin without a password, got %d (%v)", countAdmins(), err)
+	}
+	for range 2 {
+		if err := BootstrapAdmin("bootstrapadmin", "temporary123"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if countAdmins() != 1 {
+		t.Errorf("Expected one admin who has to reset their password, got %d", countAdmins())
+	}
+}
+
+func TestAdminListUsers(t *testing.T) {
+	createTestUser(t, "paged-user-1")
+	createTestUser(t, "paged-user-2")
+
+	rr := performAdminRequest(t, "GET", "/api/admin/users?limit=2", "")
+	if rr.Code != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusOK)
+	}
+
+	var page userPage
+	if err := json.Unmarshal(rr.Body.Bytes(), &page); err != nil {
+		t.Fatalf("Failed to parse response as JSON: %v", err)
+	}
+	if len(page.Users) != 2 || page.Total < 6 || page.Next == "" {
+		t.Fatalf("Expected a first page of 2 users out of at least 6, got %+v", page)
+	}
+
+	// Following the next page continues after the last user
+	rr = performAdminRequest(t, "GET", page.Next, "")
+	var next userPage
+	if err := json.Unmarshal(rr.Body.Bytes(), &next); err != nil {
+		t.Fatalf("Failed to parse response as JSON: %v", err)
+	}
+	if len(next.Users) == 0 || next.Users[0].ID <= page.Users[1].ID {
+		t.Errorf("Expected the next page to start after user %d, got %+v", page.Users[1].ID, next.Users)
+	}
+
+	if rr := performAdminRequest(t, "GET", "/api/admin/users?limit=0", ""); rr.Code != http.StatusBadRequest {
+		t.Errorf("handler returned wrong status code for an invalid limit: got %v want %v", rr.Code, http.StatusBadRequest)
+	}
+}
+
+func TestAdminManageUser(t *testing.T) {
+	rr := performAdminRequest(t, "POST", "/api/admin/users", `{"username": "manageduser", "password": "password123"}`)
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("handler returned wrong status code: got %v want %v, %s", rr.Code, http.StatusCreated, rr.Body.String())
+	}
+
+	var created adminUser
+	if err := json.Unmarshal(rr.Body.Bytes(), &created); err != nil {
+		t.Fatalf("Failed to parse response as JSON: %v", err)
+	}
+	t.Cleanup(func() {
+		db, err := database.GetDBHandle()
+		if err != nil {
+			t.Fatal(err)
+		}
+		db.Exec("DELETE FROM users WHERE id = ?", created.ID)
+	})
+	if created.Role != RoleCaseworker || created.Disabled {
+		t.Errorf("Expected an enabled caseworker, got %+v", created)
+	}
+	userURL := fmt.Sprintf("/api/admin/users/%d", created.ID)
+
+	if rr := performAdminRequest(t, "POST", "/api/admin/users", `{"username": "manageduser", "password": "password123"}`); rr.Code != http.StatusConflict {
+		t.Errorf("handler returned wrong status code for a duplicate user: got %v want %v", rr.Code, http.StatusConflict)
+	}
+
+	// A role change applies to the sessions the user already has
+	cookie := sessionCookie(t, created.ID, RoleCaseworker)
+	if rr := performAdminRequest(t, "PUT", userURL+"/role", `{"role": "lead"}`); rr.Code != http.StatusOK {
+		t.Fatalf("handler returned wrong status code changing the role: got %v want %v", rr.Code, http.StatusOK)
+	}
+	req := httptest.NewRequest("GET", "/", nil)
+	req.AddCookie(cookie)
+	if s, err := session.GetUserFromSession(httptest.NewRecorder(), req); err != nil || s.Role != RoleLead {
+		t.Errorf("Expected the session to have role LEAD, got %q (%v)", s.Role, err)
+	}
+
+	// Disabling logs the user out and keeps them from logging in
+	if rr := performAdminRequest(t, "PUT", userURL+"/disabled", `{"disabled": true}`); rr.Code != http.StatusOK {
+		t.Fatalf("handler returned wrong status code disabling: got %v want %v", rr.Code, http.StatusOK)
+	}
+	if _, err := session.GetUserFromSession(httptest.NewRecorder(), req); err == nil {
+		t.Error("Expected the session to end when the user is disabled")
+	}
+	if rr := performLoginRequest(t, `{"username": "manageduser", "password": "password123"}`); rr.Code != http.StatusForbidden {
+		t.Errorf("Login returned wrong status code for a disabled user: got %v want %v", rr.Code, http.StatusForbidden)
+	}
+	if rr := performAdminRequest(t, "PUT", userURL+"/disabled", `{"disabled": false}`); rr.Code != http.StatusOK {
+		t.Fatalf("handler returned wrong status code enabling: got %v want %v", rr.Code, http.StatusOK)
+	}
+
+	// After a reset the user has to replace the temporary password
+	if rr := performAdminRequest(t, "POST", userURL+"/password-reset", `{"password": "temporary"}`); rr.Code != http.StatusOK {
+		t.Fatalf("handler returned wrong status code resetting the password: got %v want %v", rr.Code, http.StatusOK)
+	}
+	if rr := performLoginRequest(t, `{"username": "manageduser", "password": "temporary"}`); rr.Code != http.StatusForbidden {
+		t.Errorf("Login returned wrong status code with a temporary password: got %v want %v", rr.Code, http.StatusForbidden)
+	}
+	if rr := performLoginRequest(t, `{"username": "manageduser", "password": "temporary", "new_password": "newpassword"}`); rr.Code != http.StatusOK {
+		t.Errorf("Login returned wrong status code choosing a new password: got %v want %v", rr.Code, http.StatusOK)
+	}
+	if rr := performLoginRequest(t, `{"username": "manageduser", "password": "newpassword"}`); rr.Code != http.StatusOK {
+		t.Errorf("Login returned wrong status code with the new password: got %v want %v", rr.Code, http.StatusOK)
+	}
+
+	// Admins cannot lock themselves out
+	adminURL := fmt.Sprintf("/api/admin/users/%d", testAdminID)
+	if rr := performAdminRequest(t, "PUT", adminURL+"/disabled", `{"disabled": true}`); rr.Code != http.StatusConflict {
+		t.Errorf("handler returned wrong status code disabling themselves: got %v want %v", rr.Code, http.StatusConflict)
+	}
+	if rr := performAdminRequest(t, "DELETE", adminURL, ""); rr.Code != http.StatusConflict {
+		t.Errorf("handler returned wrong status code deleting themselves: got %v want %v", rr.Code, http.StatusConflict)
+	}
+
+	if rr := performAdminRequest(t, "GET", "/api/admin/users/999999", ""); rr.Code != http.StatusNotFound {
+		t.Errorf("handler returned wrong status code for an unknown user: got %v want %v", rr.Code, http.StatusNotFound)
+	}
+}
+
+func TestAdminDeleteUserTransfersTasks(t *testing.T) {
+	leaverID := createTestUser(t, "transferring-leaver")
+	taskID := createTestTask(t, leaverID, "Task to hand over")
+	userURL := fmt.Sprintf("/api/admin/users/%d", leaverID)
+
+	if rr := performAdminRequest(t, "DELETE", userURL+"?tasks=transfer&to=999999", ""); rr.Code != http.StatusBadRequest {
+		t.Errorf("handler returned wrong status code for an unknown user to transfer to: got %v want %v", rr.Code, http.StatusBadRequest)
+	}
+
+	if rr := performAdminRequest(t, "DELETE", userURL+"?tasks=transfer&to=2", ""); rr.Code != http.StatusNoContent {
+		t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusNoContent)
+	}
+
+	taskData, err := getTask(2, taskID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if taskData.AssigneeID == nil || *taskData.AssigneeID != 2 {
+		t.Errorf("Expected the task to be handed to user 2, got %v", taskData.AssigneeID)
+	}
+
+	if rr := performAdminRequest(t, "DELETE", userURL, ""); rr.Code != http.StatusNotFound {
+		t.Errorf("handler returned wrong status code deleting again: got %v want %v", rr.Code, http.StatusNotFound)
+	}
+}
@@ -0,0 +1,344 @@
+package api
+
+import (
+	"HMCTS-Developer-Challenge/database"
+	"HMCTS-Developer-Challenge/errors"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"time"
+)
+
+type assignmentData struct {
+	// AssigneeID is the user to hand the task to, or null to leave it
+	// unassigned.
+	AssigneeID *uint `json:"assignee_id"`
+}
+
+// taskAssignment records one change of a task's assignee. The user columns
+// are kept even after the users are deleted so the history stays complete.
+type taskAssignment struct {
+	ID         uint      `json:"id"`
+	TaskID     uint      `json:"task_id"`
+	FromUserID *uint     `json:"from_user_id"`
+	ToUserID   *uint     `json:"to_user_id"`
+	AssignedBy *uint     `json:"assigned_by"`
+	AssignedAt time.Time `json:"assigned_at"`
+}
+
+var errUnknownAssignee = errors.Error("Unknown Assignee")
+var errReassignForbidden = errors.Error("Not Allowed To Reassign Task")
+
+var errTaskAlreadyClaimed = errors.Error("Task Already Claimed")
+
+// taskAccess returns the condition matching the tasks a user may see and
+// change: those they created, those assigned to them and those owned by any
+// of their teams, as long as they are not in the trash. Admins and auditors
+// can see every task; auditors are kept from changing them by their
+// permissions.
+func taskAccess(userID uint) (string, []any) {
+	ownership, args := taskOwnership(userID)
+	return "(deleted_at IS NULL AND " + ownership + ")", args
+}
+
+// taskOwnership is taskAccess including the tasks in the trash.
+func taskOwnership(userID uint) (string, []any) {
+	return "(created_by = ? OR assignee_id = ? OR team_id IN (SELECT team_id FROM team_members WHERE user_id = ?)" +
+			" OR EXISTS (SELECT 1 FROM users WHERE id = ? AND role IN ('" + RoleAdmin + "', '" + RoleAuditor + "')))",
+		[]any{userID, userID, userID, userID}
+}
+
+// canReassign reports whether a user may hand a task to someone else as its
+// creator or assignee.
+func canReassign(t task, userID uint) bool {
+	return (t.CreatedBy != nil && *t.CreatedBy == userID) || (t.AssigneeID != nil && *t.AssigneeID == userID)
+}
+
+// canReassignTask extends canReassign to admins and the managers of the team
+// that owns the task.
+func canReassignTask(q rowQueryer, t task, userID uint) (bool, error) {
+	if canReassign(t, userID) {
+		return true, nil
+	}
+	if role, err := userRole(q, userID); err != nil {
+		return false, err
+	} else if role == RoleAdmin {
+		return true, nil
+	}
+	if t.TeamID == nil {
+		return false, nil
+	}
+
+	role, err := teamRole(q, *t.TeamID, userID)
+	return role == teamRoleManager, err
+}
+
+func assigneeHandler(w http.ResponseWriter, r *http.Request, userID uint, taskID string) {
+	switch r.Method {
+	case http.MethodPut:
+		var data assignmentData
+		if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+			http.Error(w, "Invalid JSON", http.StatusBadRequest)
+			break
+		}
+
+		t, err := reassignTask(userID, taskID, data.AssigneeID, parseTaskEditOptions(r))
+		if err == errTaskNotFound {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			break
+		} else if err == errUnknownAssignee {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			break
+		} else if err == errReassignForbidden {
+			forbidden(w, r, userID, err)
+			break
+		} else if err == errPreconditionFailed {
+			http.Error(w, err.Error(), http.StatusPreconditionFailed)
+			break
+		} else if err != nil {
+			errors.HandleServerError(w, err, "assignment.go: assigneeHandler - reassignTask")
+			break
+		}
+
+		w.Header().Set("ETag", taskETag(t))
+		writeJSON(w, http.StatusOK, t)
+	case http.MethodOptions:
+		w.Header().Set("Access-Control-Allow-Methods", "PUT, OPTIONS")
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// claimHandler assigns an unclaimed task in a team worklist to the current
+// user.
+func claimHandler(w http.ResponseWriter, r *http.Request, userID uint, taskID string) {
+	switch r.Method {
+	case http.MethodPost:
+		t, err := claimTask(userID, taskID, parseTaskEditOptions(r))
+		if err == errTaskNotFound {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			break
+		} else if err == errReassignForbidden {
+			forbidden(w, r, userID, err)
+			break
+		} else if err == errTaskAlreadyClaimed {
+			http.Error(w, err.Error(), http.StatusConflict)
+			break
+		} else if err != nil {
+			errors.HandleServerError(w, err, "assignment.go: claimHandler - claimTask")
+			break
+		}
+
+		w.Header().Set("ETag", taskETag(t))
+		writeJSON(w, http.StatusOK, t)
+	case http.MethodOptions:
+		w.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS")
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func assignmentsHandler(w http.ResponseWriter, r *http.Request, userID uint, taskID string) {
+	switch r.Method {
+	case http.MethodGet:
+		assignments, err := getTaskAssignments(userID, taskID)
+		if err == errTaskNotFound {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			break
+		} else if err != nil {
+			errors.HandleServerError(w, err, "assignment.go: assignmentsHandler - getTaskAssignments")
+			break
+		}
+
+		writeJSON(w, http.StatusOK, assignments)
+	case http.MethodOptions:
+		w.Header().Set("Access-Control-Allow-Methods", "GET, OPTIONS")
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// reassignTask hands a task to another user and records the change. The
+// returned task reflects the new assignee even if the caller can no longer
+// see the task as a result.
+func reassignTask(userID uint, taskID string, assigneeID *uint, options taskEditOptions) (task, error) {
+	current, err := getEditedTask(userID, taskID, options)
+	if err != nil {
+		return current, err
+	}
+
+	dbHandle, err := database.GetDBHandle()
+	if err != nil {
+		return current, errors.AddContext(err, "assignment.go: reassignTask - GetDBHandle")
+	}
+
+	edit, err := beginTaskEdit(dbHandle, options)
+	if err != nil {
+		return current, errors.AddContext(err, "assignment.go: reassignTask - Begin")
+	}
+	defer edit.Rollback()
+	tx := edit.Tx
+
+	if ok, err := canReassignTask(tx, current, userID); err != nil {
+		return current, errors.AddContext(err, "assignment.go: reassignTask - canReassignTask")
+	} else if !ok {
+		return current, errReassignForbidden
+	}
+	if sameUser(current.AssigneeID, assigneeID) {
+		return current, nil
+	}
+
+	if assigneeID != nil {
+		var exists bool
+		if err := tx.QueryRow("SELECT EXISTS(SELECT 1 FROM users WHERE id = ? AND NOT disabled)", *assigneeID).Scan(&exists); err != nil {
+			return current, errors.AddContext(err, "assignment.go: reassignTask - QueryRow")
+		} else if !exists {
+			return current, errUnknownAssignee
+		}
+	}
+
+	changes, err := trackTasks(tx, current.ID)
+	if err != nil {
+		return current, errors.AddContext(err, "assignment.go: reassignTask - trackTasks")
+	}
+
+	result, err := tx.Exec("UPDATE tasks SET assignee_id = ?, version = version + 1 WHERE id = ? AND version = ?", assigneeID, current.ID, current.Version)
+	if err != nil {
+		return current, errors.AddContext(err, "assignment.go: reassignTask - Exec")
+	}
+	if affected, err := result.RowsAffected(); err != nil {
+		return current, errors.AddContext(err, "assignment.go: reassignTask - RowsAffected")
+	} else if affected == 0 {
+		return current, errPreconditionFailed
+	}
+
+	if err := recordAssignment(tx, current.ID, current.AssigneeID, assigneeID, &userID); err != nil {
+		return current, errors.AddContext(err, "assignment.go: reassignTask - recordAssignment")
+	}
+	if err := changes.record(tx, userID, options); err != nil {
+		return current, errors.AddContext(err, "assignment.go: reassignTask - record")
+	}
+
+	if err := edit.Commit(); err != nil {
+		return current, errors.AddContext(err, "assignment.go: reassignTask - Commit")
+	}
+
+	current.AssigneeID = assigneeID
+	current.Version++
+	return current, nil
+}
+
+// claimTask assigns a task owned by one of the user's teams to the user. Only
+// unassigned tasks can be claimed, so two members claiming the same task at
+// once cannot both succeed.
+func claimTask(userID uint, taskID string, options taskEditOptions) (task, error) {
+	current, err := getEditedTask(userID, taskID, options)
+	if err != nil {
+		return current, err
+	}
+	if current.AssigneeID != nil && *current.AssigneeID == userID {
+		return current, nil
+	}
+
+	dbHandle, err := database.GetDBHandle()
+	if err != nil {
+		return current, errors.AddContext(err, "assignment.go: claimTask - GetDBHandle")
+	}
+
+	if current.TeamID == nil {
+		return current, errReassignForbidden
+	} else if role, err := teamRole(dbHandle, *current.TeamID, userID); err != nil {
+		return current, errors.AddContext(err, "assignment.go: claimTask - teamRole")
+	} else if role == "" {
+		return current, errReassignForbidden
+	}
+
+	edit, err := beginTaskEdit(dbHandle, options)
+	if err != nil {
+		return current, errors.AddContext(err, "assignment.go: claimTask - Begin")
+	}
+	defer edit.Rollback()
+	tx := edit.Tx
+
+	changes, err := trackTasks(tx, current.ID)
+	if err != nil {
+		return current, errors.AddContext(err, "assignment.go: claimTask - trackTasks")
+	}
+
+	// The task must still be in the team checked above and out of the trash
+	result, err := tx.Exec(
+		"UPDATE tasks SET assignee_id = ?, version = version + 1 WHERE id = ? AND assignee_id IS NULL AND team_id = ? AND deleted_at IS NULL",
+		userID, current.ID, *current.TeamID,
+	)
+	if err != nil {
+		return current, errors.AddContext(err, "assignment.go: claimTask - Exec")
+	}
+	if affected, err := result.RowsAffected(); err != nil {
+		return current, errors.AddContext(err, "assignment.go: claimTask - RowsAffected")
+	} else if affected == 0 {
+		return current, errTaskAlreadyClaimed
+	}
+
+	if err := recordAssignment(tx, current.ID, nil, &userID, &userID); err != nil {
+		return current, errors.AddContext(err, "assignment.go: claimTask - recordAssignment")
+	}
+	if err := changes.record(tx, userID, options); err != nil {
+		return current, errors.AddContext(err, "assignment.go: claimTask - record")
+	}
+
+	if err := edit.Commit(); err != nil {
+		return current, errors.AddContext(err, "assignment.go: claimTask - Commit")
+	}
+	return getEditedTask(userID, taskID, options)
+}
+
+func recordAssignment(tx *sql.Tx, taskID uint, from, to, by *uint) error {
+	_, err := tx.Exec("INSERT INTO task_assignments (task_id, from_user_id, to_user_id, assigned_by) VALUES (?, ?, ?, ?)", taskID, from, to, by)
+	return err
+}
+
+func getTaskAssignments(userID uint, taskID string) ([]taskAssignment, error) {
+	assignments := []taskAssignment{}
+
+	if exists, err := checkTaskExists(userID, taskID); err != nil {
+		return assignments, errors.AddContext(err, "assignment.go: getTaskAssignments - checkTaskExists")
+	} else if !exists {
+		return assignments, errTaskNotFound
+	}
+
+	dbHandle, err := database.GetDBHandle()
+	if err != nil {
+		return assignments, errors.AddContext(err, "assignment.go: getTaskAssignments - GetDBHandle")
+	}
+
+	rows, err := dbHandle.Query(
+		"SELECT id, task_id, from_user_id, to_user_id, assigned_by, assigned_at FROM task_assignments WHERE task_id = ? ORDER BY id",
+		taskID,
+	)
+	if err != nil {
+		return assignments, errors.AddContext(err, "assignment.go: getTaskAssignments - Query")
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var a taskAssignment
+		if err := rows.Scan(&a.ID, &a.TaskID, &a.FromUserID, &a.ToUserID, &a.AssignedBy, &a.AssignedAt); err != nil {
+			return assignments, errors.AddContext(err, "assignment.go: getTaskAssignments - Scan")
+		}
+		assignments = append(assignments, a)
+	}
+	if err := rows.Err(); err != nil {
+		return assignments, errors.AddContext(err, "assignment.go: getTaskAssignments - Rows")
+	}
+	return assignments, nil
+}
+
+func sameUser(a, b *uint) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
+}
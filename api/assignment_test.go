@@ -0,0 +1,180 @@
+package api
+
+import (
+	"HMCTS-Developer-Challenge/database"
+	"encoding/json"
+	"net/http"
+	"slices"
+	"strconv"
+	"testing"
+)
+
+func pageTaskIDs(page taskPage) []uint {
+	ids := make([]uint, len(page.Tasks))
+	for i, t := range page.Tasks {
+		ids[i] = t.ID
+	}
+	return ids
+}
+
+func createTestUser(t *testing.T, name string) uint {
+	db, err := database.GetDBHandle()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := db.Exec("INSERT INTO users (name, password_hash) VALUES (?, '')", name)
+	if err != nil {
+		t.Fatalf("Failed to insert test user: %v", err)
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() { db.Exec("DELETE FROM users WHERE id = ?", id) })
+	return uint(id)
+}
+
+func TestReassignTask(t *testing.T) {
+	taskID := createTestTask(t, 1, "Hand over to a colleague")
+	id, _ := strconv.ParseUint(taskID, 10, 32)
+
+	rr := performTaskRequest(t, "PUT", "/api/tasks/"+taskID+"/assignee", []byte(`{"assignee_id": 2}`), nil, 1)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusOK)
+	}
+
+	var reassigned task
+	if err := json.Unmarshal(rr.Body.Bytes(), &reassigned); err != nil {
+		t.Fatalf("Failed to parse response as JSON: %v", err)
+	}
+	if reassigned.AssigneeID == nil || *reassigned.AssigneeID != 2 || reassigned.CreatedBy == nil || *reassigned.CreatedBy != 1 {
+		t.Errorf("Expected a task created by 1 and assigned to 2, got %v and %v", reassigned.CreatedBy, reassigned.AssigneeID)
+	}
+
+	// The new assignee can see the task in their worklist, the creator in
+	// the tasks they created
+	if _, page := getTaskPage(t, "/api/tasks/?view=assigned&limit=200", 2); !slices.Contains(pageTaskIDs(page), uint(id)) {
+		t.Errorf("Expected task %s in user 2's assigned view", taskID)
+	}
+	if _, page := getTaskPage(t, "/api/tasks/?view=assigned&limit=200", 1); slices.Contains(pageTaskIDs(page), uint(id)) {
+		t.Errorf("Expected task %s to have left user 1's assigned view", taskID)
+	}
+	if _, page := getTaskPage(t, "/api/tasks/?view=created&limit=200", 1); !slices.Contains(pageTaskIDs(page), uint(id)) {
+		t.Errorf("Expected task %s in user 1's created view", taskID)
+	}
+
+	// The assignee hands it back
+	rr = performTaskRequest(t, "PUT", "/api/tasks/"+taskID+"/assignee", []byte(`{"assignee_id": 1}`), nil, 2)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusOK)
+	}
+
+	rr = performTaskRequest(t, "GET", "/api/tasks/"+taskID+"/assignments", nil, nil, 1)
+	var history []taskAssignment
+	if err := json.Unmarshal(rr.Body.Bytes(), &history); err != nil {
+		t.Fatalf("Failed to parse response as JSON: %v", err)
+	}
+	if len(history) != 2 {
+		t.Fatalf("Expected 2 reassignments, got %d", len(history))
+	}
+	if *history[0].FromUserID != 1 || *history[0].ToUserID != 2 || *history[0].AssignedBy != 1 {
+		t.Errorf("Unexpected first reassignment: %+v", history[0])
+	}
+	if *history[1].FromUserID != 2 || *history[1].ToUserID != 1 || *history[1].AssignedBy != 2 {
+		t.Errorf("Unexpected second reassignment: %+v", history[1])
+	}
+}
+
+func TestReassignTaskErrors(t *testing.T) {
+	taskID := createTestTask(t, 1, "Task that stays put")
+
+	rr := performTaskRequest(t, "PUT", "/api/tasks/"+taskID+"/assignee", []byte(`{"assignee_id": 999999}`), nil, 1)
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("handler returned wrong status code for an unknown user: got %v want %v", rr.Code, http.StatusBadRequest)
+	}
+
+	// Task 3 is created by and assigned to user 2
+	rr = performTaskRequest(t, "PUT", "/api/tasks/3/assignee", []byte(`{"assignee_id": 1}`), nil, 1)
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("handler returned wrong status code for another user's task: got %v want %v", rr.Code, http.StatusNotFound)
+	}
+
+	if rr, _ := getTaskPage(t, "/api/tasks/?view=mine", 1); rr.Code != http.StatusBadRequest {
+		t.Errorf("handler returned wrong status code for an invalid view: got %v want %v", rr.Code, http.StatusBadRequest)
+	}
+}
+
+func TestSetUserDeletionPolicy(t *testing.T) {
+	defer SetUserDeletionPolicy("")
+
+	for _, value := range []string{"orphan", "reassign"} {
+		if err := SetUserDeletionPolicy(value); err != nil || userDeletionPolicy != value {
+			t.Errorf("Expected policy %q, got %q (%v)", value, userDeletionPolicy, err)
+		}
+	}
+	if err := SetUserDeletionPolicy("cascade"); err == nil {
+		t.Error("Expected an error for an unknown policy")
+	}
+	if err := SetUserDeletionPolicy(""); err != nil || userDeletionPolicy != userDeletionOrphan {
+		t.Errorf("Expected the default policy, got %q (%v)", userDeletionPolicy, err)
+	}
+}
+
+func TestDeleteUserReassignsTasks(t *testing.T) {
+	defer SetUserDeletionPolicy("")
+
+	for _, policy := range []string{userDeletionOrphan, userDeletionReassign} {
+		if err := SetUserDeletionPolicy(policy); err != nil {
+			t.Fatal(err)
+		}
+
+		leaverID := createTestUser(t, "leaver-"+policy)
+		taskID := createTestTask(t, 1, "Task of a leaver")
+		rr := performTaskRequest(t, "PUT", "/api/tasks/"+taskID+"/assignee", []byte(`{"assignee_id": `+strconv.FormatUint(uint64(leaverID), 10)+`}`), nil, 1)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusOK)
+		}
+
+		if err := deleteUser(3, leaverID, "", nil, taskEditOptions{}); err != nil {
+			t.Fatal(err)
+		}
+
+		// The creator can still see the task either way
+		taskData, err := getTask(1, taskID)
+		if err != nil {
+			t.Fatalf("%s: %v", policy, err)
+		}
+		if policy == userDeletionReassign && (taskData.AssigneeID == nil || *taskData.AssigneeID != 1) {
+			t.Errorf("%s: expected the task to go back to its creator, got %v", policy, taskData.AssigneeID)
+		}
+		if policy == userDeletionOrphan && taskData.AssigneeID != nil {
+			t.Errorf("%s: expected the task to be unassigned, got %d", policy, *taskData.AssigneeID)
+		}
+	}
+
+	if err := deleteUser(3, 999999, "", nil, taskEditOptions{}); err != errUserNotFound {
+		t.Errorf("Expected errUserNotFound, got %v", err)
+	}
+}
+
+func TestAdminAndAuditorSeeEveryTask(t *testing.T) {
+	taskID := createTestTask(t, 2, "Task of another caseworker")
+
+	// User 3 is an admin and user 4 an auditor
+	for _, userID := range []uint{3, 4} {
+		if rr := performTaskRequest(t, "GET", "/api/tasks/"+taskID, nil, nil, userID); rr.Code != http.StatusOK {
+			t.Errorf("handler returned wrong status code for user %d: got %v want %v", userID, rr.Code, http.StatusOK)
+		}
+	}
+	if rr := performTaskRequest(t, "GET", "/api/tasks/"+taskID, nil, nil, 1); rr.Code != http.StatusNotFound {
+		t.Errorf("handler returned wrong status code for another caseworker: got %v want %v", rr.Code, http.StatusNotFound)
+	}
+
+	// Admins can also hand the task to someone else
+	rr := performTaskRequest(t, "PUT", "/api/tasks/"+taskID+"/assignee", []byte(`{"assignee_id": 1}`), nil, 3)
+	if rr.Code != http.StatusOK {
+		t.Errorf("handler returned wrong status code for an admin reassigning: got %v want %v", rr.Code, http.StatusOK)
+	}
+}
@@ -0,0 +1,612 @@
+package api
+
+import (
+	"HMCTS-Developer-Challenge/blobstore"
+	"HMCTS-Developer-Challenge/database"
+	"HMCTS-Developer-Challenge/errors"
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"io"
+	"log"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+	defaultMaxAttachmentSize   = 20 << 20
+	defaultAttachmentUserQuota = 500 << 20
+	maxAttachmentNameLength    = 255
+
+	// Room in an upload for the multipart headers and boundaries around the
+	// file itself.
+	maxMultipartOverhead = 64 << 10
+)
+
+var (
+	maxAttachmentSize   int64 = defaultMaxAttachmentSize
+	attachmentUserQuota int64 = defaultAttachmentUserQuota
+)
+
+// allowedAttachmentTypes are the types, as sniffed from the content rather
+// than taken from the client, that may be uploaded: court orders and forms
+// as PDFs or scans, and e-mails saved as text.
+var allowedAttachmentTypes = map[string]bool{
+	"application/pdf": true,
+	"image/png":       true,
+	"image/jpeg":      true,
+	"image/gif":       true,
+	"image/webp":      true,
+	"text/plain":      true,
+}
+
+// blobs holds the contents of attachments. Attachments cannot be uploaded
+// until it is set.
+var blobs blobstore.Store
+
+type taskAttachment struct {
+	ID          uint      `json:"id"`
+	TaskID      uint      `json:"task_id"`
+	UploadedBy  *uint     `json:"uploaded_by"`
+	FileName    string    `json:"file_name"`
+	ContentType string    `json:"content_type"`
+	Size        int64     `json:"size"`
+	SHA256      string    `json:"sha256"`
+	CreatedAt   time.Time `json:"created_at"`
+
+	storageKey string
+}
+
+var errAttachmentNotFound = errors.Error("Attachment Not Found")
+var errMissingAttachment = errors.Error("Missing Attachment")
+var errAttachmentTooLarge = errors.Error("Attachment Too Large")
+var errAttachmentQuotaExceeded = errors.Error("Attachment Quota Exceeded")
+var errUnsupportedAttachmentType = errors.Error("Unsupported Attachment Type")
+var errAttachmentForbidden = errors.Error("Not Allowed To Remove Attachment")
+
+const attachmentColumns = "id, task_id, uploaded_by, file_name, content_type, size, sha256, storage_key, created_at"
+
+// SetBlobStore sets where the contents of attachments are kept.
+func SetBlobStore(store blobstore.Store) {
+	blobs = store
+}
+
+// SetAttachmentLimits sets the largest file that can be attached and the
+// total size of the files each user can attach, in bytes with an optional
+// K, M or G suffix. Empty values keep the defaults.
+func SetAttachmentLimits(maxSize, userQuota string) error {
+	size, err := parseByteSize(maxSize, defaultMaxAttachmentSize)
+	if err != nil {
+		return errors.AddContext(err, "attachments.go: SetAttachmentLimits - max size")
+	}
+	quota, err := parseByteSize(userQuota, defaultAttachmentUserQuota)
+	if err != nil {
+		return errors.AddContext(err, "attachments.go: SetAttachmentLimits - user quota")
+	}
+
+	maxAttachmentSize = size
+	attachmentUserQuota = quota
+	return nil
+}
+
+func parseByteSize(value string, fallback int64) (int64, error) {
+	value = strings.TrimSuffix(strings.ToUpper(strings.TrimSpace(value)), "B")
+	if value == "" {
+		return fallback, nil
+	}
+
+	shift := 0
+	switch value[len(value)-1] {
+	case 'K':
+		shift = 10
+	case 'M':
+		shift = 20
+	case 'G':
+		shift = 30
+	}
+	if shift != 0 {
+		value = value[:len(value)-1]
+	}
+
+	n, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || n < 1 || n > (1<<62)>>shift {
+		return 0, errors.Errorf("invalid size %q", value)
+	}
+	return n << shift, nil
+}
+
+func attachmentsHandler(w http.ResponseWriter, r *http.Request, userID uint, taskID string, attachmentID string) {
+	switch r.Method {
+	case http.MethodGet:
+		if attachmentID != "" {
+			downloadAttachment(w, r, userID, taskID, attachmentID)
+			break
+		}
+
+		attachments, err := getAttachments(userID, taskID)
+		if err == errTaskNotFound {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			break
+		} else if err != nil {
+			errors.HandleServerError(w, err, "attachments.go: attachmentsHandler - getAttachments")
+			break
+		}
+		writeJSON(w, http.StatusOK, attachments)
+	case http.MethodPost:
+		if attachmentID != "" {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			break
+		}
+
+		r.Body = http.MaxBytesReader(w, r.Body, maxAttachmentSize+maxMultipartOverhead)
+		reader, err := r.MultipartReader()
+		if err != nil {
+			http.Error(w, "Expected multipart/form-data", http.StatusBadRequest)
+			break
+		}
+
+		a, err := addAttachment(r.Context(), userID, taskID, reader)
+		if err == errTaskNotFound {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			break
+		} else if err == errMissingAttachment {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			break
+		} else if err == errAttachmentTooLarge || err == errAttachmentQuotaExceeded {
+			http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+			break
+		} else if err == errUnsupportedAttachmentType {
+			http.Error(w, err.Error(), http.StatusUnsupportedMediaType)
+			break
+		} else if err != nil {
+			errors.HandleServerError(w, err, "attachments.go: attachmentsHandler - addAttachment")
+			break
+		}
+		writeJSON(w, http.StatusCreated, a)
+	case http.MethodDelete:
+		if attachmentID == "" {
+			http.Error(w, "Attachment ID Required", http.StatusBadRequest)
+			break
+		}
+
+		if err := deleteAttachment(userID, taskID, attachmentID); err == errTaskNotFound || err == errAttachmentNotFound {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			break
+		} else if err == errAttachmentForbidden {
+			forbidden(w, r, userID, err)
+			break
+		} else if err != nil {
+			errors.HandleServerError(w, err, "attachments.go: attachmentsHandler - deleteAttachment")
+			break
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	case http.MethodOptions:
+		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, DELETE, OPTIONS")
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// downloadAttachment streams the contents of an attachment from the blob
+// store. It is always offered as a download so that files are never rendered
+// as part of the site.
+func downloadAttachment(w http.ResponseWriter, r *http.Request, userID uint, taskID string, attachmentID string) {
+	a, err := getAttachment(userID, taskID, attachmentID)
+	if err == errTaskNotFound || err == errAttachmentNotFound {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	} else if err != nil {
+		errors.HandleServerError(w, err, "attachments.go: downloadAttachment - getAttachment")
+		return
+	}
+
+	if blobs == nil {
+		errors.HandleServerError(w, errors.Error("no blob store"), "attachments.go: downloadAttachment")
+		return
+	}
+	contents, err := blobs.Get(r.Context(), a.storageKey)
+	if err != nil {
+		errors.HandleServerError(w, err, "attachments.go: downloadAttachment - Get")
+		return
+	}
+	defer contents.Close()
+
+	recordExport(r, userID, "attachment", map[string]string{
+		"task_id":       taskID,
+		"attachment_id": attachmentID,
+		"file_name":     a.FileName,
+		"size":          strconv.FormatInt(a.Size, 10),
+	})
+
+	disposition := mime.FormatMediaType("attachment", map[string]string{"filename": a.FileName})
+	if disposition == "" {
+		disposition = "attachment"
+	}
+	w.Header().Set("Content-Type", a.ContentType)
+	w.Header().Set("Content-Length", strconv.FormatInt(a.Size, 10))
+	w.Header().Set("Content-Disposition", disposition)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("ETag", `"`+a.SHA256+`"`)
+	w.WriteHeader(http.StatusOK)
+
+	// Once the headers are sent a failure can only cut the download short,
+	// which the client sees from the length.
+	if _, err := io.Copy(w, contents); err != nil {
+		log.Printf("Error: attachments.go: downloadAttachment - Copy: %s\n", err)
+	}
+}
+
+// normalizeAttachmentName keeps the last part of the name a browser gives
+// for a file, without control characters.
+func normalizeAttachmentName(name string) string {
+	if i := strings.LastIndexAny(name, `/\`); i >= 0 {
+		name = name[i+1:]
+	}
+	name = strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) || r == utf8.RuneError {
+			return -1
+		}
+		return r
+	}, name)
+	name = strings.TrimSpace(name)
+
+	if utf8.RuneCountInString(name) > maxAttachmentNameLength {
+		name = string([]rune(name)[:maxAttachmentNameLength])
+	}
+	if name == "" || name == "." || name == ".." {
+		return "attachment"
+	}
+	return name
+}
+
+// sniffAttachmentType works out the type of a file from its first bytes.
+func sniffAttachmentType(head []byte) (string, error) {
+	contentType := http.DetectContentType(head)
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil || !allowedAttachmentTypes[mediaType] {
+		return "", errUnsupportedAttachmentType
+	}
+	return contentType, nil
+}
+
+// spoolAttachment copies an uploaded file to a temporary file, checking its
+// type and size and hashing it on the way. The file is rewound ready to be
+// stored; the caller must close and remove it.
+func spoolAttachment(r io.Reader) (*os.File, string, int64, string, error) {
+	head := make([]byte, 512)
+	n, err := io.ReadFull(r, head)
+	if err == io.EOF {
+		return nil, "", 0, "", errMissingAttachment
+	} else if err != nil && err != io.ErrUnexpectedEOF {
+		return nil, "", 0, "", err
+	}
+	head = head[:n]
+
+	contentType, err := sniffAttachmentType(head)
+	if err != nil {
+		return nil, "", 0, "", err
+	}
+
+	f, err := os.CreateTemp("", "attachment-*")
+	if err != nil {
+		return nil, "", 0, "", errors.AddContext(err, "attachments.go: spoolAttachment - CreateTemp")
+	}
+
+	hash := sha256.New()
+	size, err := io.Copy(io.MultiWriter(f, hash), io.LimitReader(io.MultiReader(bytes.NewReader(head), r), maxAttachmentSize+1))
+	if err == nil && size > maxAttachmentSize {
+		err = errAttachmentTooLarge
+	}
+	if err == nil {
+		_, err = f.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return nil, "", 0, "", err
+	}
+	return f, contentType, size, hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+func getAttachments(userID uint, taskID string) ([]taskAttachment, error) {
+	attachments := []taskAttachment{}
+
+	if exists, err := checkTaskExists(userID, taskID); err != nil {
+		return attachments, errors.AddContext(err, "attachments.go: getAttachments - checkTaskExists")
+	} else if !exists {
+		return attachments, errTaskNotFound
+	}
+
+	dbHandle, err := database.GetDBHandle()
+	if err != nil {
+		return attachments, errors.AddContext(err, "attachments.go: getAttachments - GetDBHandle")
+	}
+
+	loc, err := userLocation(userID)
+	if err != nil {
+		return attachments, errors.AddContext(err, "attachments.go: getAttachments - userLocation")
+	}
+
+	rows, err := dbHandle.Query("SELECT "+attachmentColumns+" FROM task_attachments WHERE task_id = ? ORDER BY id", taskID)
+	if err != nil {
+		return attachments, errors.AddContext(err, "attachments.go: getAttachments - Query")
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		a, err := scanAttachment(rows)
+		if err != nil {
+			return attachments, errors.AddContext(err, "attachments.go: getAttachments - Scan")
+		}
+		a.CreatedAt = a.CreatedAt.In(loc)
+		attachments = append(attachments, a)
+	}
+	if err := rows.Err(); err != nil {
+		return attachments, errors.AddContext(err, "attachments.go: getAttachments - Rows")
+	}
+	return attachments, nil
+}
+
+func getAttachment(userID uint, taskID string, attachmentID string) (taskAttachment, error) {
+	if exists, err := checkTaskExists(userID, taskID); err != nil {
+		return taskAttachment{}, errors.AddContext(err, "attachments.go: getAttachment - checkTaskExists")
+	} else if !exists {
+		return taskAttachment{}, errTaskNotFound
+	}
+
+	dbHandle, err := database.GetDBHandle()
+	if err != nil {
+		return taskAttachment{}, errors.AddContext(err, "attachments.go: getAttachment - GetDBHandle")
+	}
+
+	a, err := queryAttachment(dbHandle, taskID, attachmentID)
+	if err != nil {
+		return a, err
+	}
+
+	loc, err := userLocation(userID)
+	if err != nil {
+		return a, errors.AddContext(err, "attachments.go: getAttachment - userLocation")
+	}
+	a.CreatedAt = a.CreatedAt.In(loc)
+	return a, nil
+}
+
+// addAttachment stores the file in the "file" part of an upload. The user's
+// quota is checked before the file is stored and again, with their row
+// locked, before it is recorded, so concurrent uploads cannot exceed it.
+func addAttachment(ctx context.Context, userID uint, taskID string, reader *multipart.Reader) (taskAttachment, error) {
+	if exists, err := checkTaskExists(userID, taskID); err != nil {
+		return taskAttachment{}, errors.AddContext(err, "attachments.go: addAttachment - checkTaskExists")
+	} else if !exists {
+		return taskAttachment{}, errTaskNotFound
+	}
+	if blobs == nil {
+		return taskAttachment{}, errors.Error("attachments.go: addAttachment - no blob store")
+	}
+
+	var part *multipart.Part
+	for {
+		p, err := reader.NextPart()
+		if err == io.EOF {
+			return taskAttachment{}, errMissingAttachment
+		} else if _, ok := err.(*http.MaxBytesError); ok {
+			return taskAttachment{}, errAttachmentTooLarge
+		} else if err != nil {
+			return taskAttachment{}, errMissingAttachment
+		}
+		if p.FormName() == "file" {
+			part = p
+			break
+		}
+	}
+
+	f, contentType, size, checksum, err := spoolAttachment(part)
+	if _, ok := err.(*http.MaxBytesError); ok {
+		return taskAttachment{}, errAttachmentTooLarge
+	} else if err != nil {
+		return taskAttachment{}, err
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	dbHandle, err := database.GetDBHandle()
+	if err != nil {
+		return taskAttachment{}, errors.AddContext(err, "attachments.go: addAttachment - GetDBHandle")
+	}
+	if err := checkAttachmentQuota(dbHandle, userID, size); err != nil {
+		return taskAttachment{}, err
+	}
+
+	key, err := newStorageKey()
+	if err != nil {
+		return taskAttachment{}, errors.AddContext(err, "attachments.go: addAttachment - newStorageKey")
+	}
+	if err := blobs.Put(ctx, key, f, size); err != nil {
+		return taskAttachment{}, errors.AddContext(err, "attachments.go: addAttachment - Put")
+	}
+
+	id, err := recordAttachment(dbHandle, userID, taskID, normalizeAttachmentName(part.FileName()), contentType, size, checksum, key)
+	if err != nil {
+		removeBlobs(key)
+		return taskAttachment{}, err
+	}
+	return getAttachment(userID, taskID, strconv.FormatInt(id, 10))
+}
+
+func recordAttachment(dbHandle *sql.DB, userID uint, taskID string, name, contentType string, size int64, checksum, key string) (int64, error) {
+	tx, err := dbHandle.Begin()
+	if err != nil {
+		return 0, errors.AddContext(err, "attachments.go: recordAttachment - Begin")
+	}
+	defer tx.Rollback()
+
+	var locked uint
+	if err := tx.QueryRow("SELECT id FROM users WHERE id = ? FOR UPDATE", userID).Scan(&locked); err != nil {
+		return 0, errors.AddContext(err, "attachments.go: recordAttachment - Lock User")
+	}
+	if err := checkAttachmentQuota(tx, userID, size); err != nil {
+		return 0, err
+	}
+
+	access, accessArgs := taskAccess(userID)
+	var exists bool
+	if err := tx.QueryRow("SELECT EXISTS(SELECT 1 FROM tasks WHERE id = ? AND "+access+" FOR SHARE)", append([]any{taskID}, accessArgs...)...).Scan(&exists); err != nil {
+		return 0, errors.AddContext(err, "attachments.go: recordAttachment - QueryRow")
+	} else if !exists {
+		return 0, errTaskNotFound
+	}
+
+	result, err := tx.Exec(
+		"INSERT INTO task_attachments (task_id, uploaded_by, file_name, content_type, size, sha256, storage_key) VALUES (?, ?, ?, ?, ?, ?, ?)",
+		taskID, userID, name, contentType, size, checksum, key,
+	)
+	if err != nil {
+		return 0, errors.AddContext(err, "attachments.go: recordAttachment - Exec")
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return 0, errors.AddContext(err, "attachments.go: recordAttachment - LastInsertId")
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, errors.AddContext(err, "attachments.go: recordAttachment - Commit")
+	}
+	return id, nil
+}
+
+func checkAttachmentQuota(q rowQueryer, userID uint, size int64) error {
+	var used int64
+	if err := q.QueryRow("SELECT COALESCE(SUM(size), 0) FROM task_attachments WHERE uploaded_by = ?", userID).Scan(&used); err != nil {
+		return errors.AddContext(err, "attachments.go: checkAttachmentQuota - QueryRow")
+	}
+	if used+size > attachmentUserQuota {
+		return errAttachmentQuotaExceeded
+	}
+	return nil
+}
+
+// deleteAttachment removes an attachment. Like a comment, only the user who
+// uploaded it or an admin may remove it.
+func deleteAttachment(userID uint, taskID string, attachmentID string) error {
+	dbHandle, err := database.GetDBHandle()
+	if err != nil {
+		return errors.AddContext(err, "attachments.go: deleteAttachment - GetDBHandle")
+	}
+
+	tx, err := dbHandle.Begin()
+	if err != nil {
+		return errors.AddContext(err, "attachments.go: deleteAttachment - Begin")
+	}
+	defer tx.Rollback()
+
+	access, accessArgs := taskAccess(userID)
+	var exists bool
+	if err := tx.QueryRow("SELECT EXISTS(SELECT 1 FROM tasks WHERE id = ? AND "+access+")", append([]any{taskID}, accessArgs...)...).Scan(&exists); err != nil {
+		return errors.AddContext(err, "attachments.go: deleteAttachment - QueryRow")
+	} else if !exists {
+		return errTaskNotFound
+	}
+
+	current, err := queryAttachment(tx, taskID, attachmentID)
+	if err != nil {
+		return err
+	}
+	if current.UploadedBy == nil || *current.UploadedBy != userID {
+		if role, err := userRole(tx, userID); err != nil {
+			return errors.AddContext(err, "attachments.go: deleteAttachment - userRole")
+		} else if role != RoleAdmin {
+			return errAttachmentForbidden
+		}
+	}
+
+	if _, err := tx.Exec("DELETE FROM task_attachments WHERE id = ?", current.ID); err != nil {
+		return errors.AddContext(err, "attachments.go: deleteAttachment - Exec")
+	}
+	if err := tx.Commit(); err != nil {
+		return errors.AddContext(err, "attachments.go: deleteAttachment - Commit")
+	}
+
+	removeBlobs(current.storageKey)
+	return nil
+}
+
+// taskAttachmentKeys lists the blobs of the attachments of a task and of its
+// subtasks at any depth, which go when the task is purged from the trash.
+func taskAttachmentKeys(tx *sql.Tx, taskID uint) ([]string, error) {
+	keys := []string{}
+	rows, err := tx.Query(
+		"WITH RECURSIVE descendants (id) AS ("+
+			"SELECT id FROM tasks WHERE id = ? "+
+			"UNION ALL SELECT t.id FROM tasks t JOIN descendants d ON t.parent_id = d.id"+
+			") SELECT storage_key FROM task_attachments WHERE task_id IN (SELECT id FROM descendants)",
+		taskID,
+	)
+	if err != nil {
+		return keys, errors.AddContext(err, "attachments.go: taskAttachmentKeys - Query")
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var key string
+		if err := rows.Scan(&key); err != nil {
+			return keys, errors.AddContext(err, "attachments.go: taskAttachmentKeys - Scan")
+		}
+		keys = append(keys, key)
+	}
+	if err := rows.Err(); err != nil {
+		return keys, errors.AddContext(err, "attachments.go: taskAttachmentKeys - Rows")
+	}
+	return keys, nil
+}
+
+// removeBlobs removes the contents of attachments once their metadata is
+// gone. A blob that cannot be removed is only logged, since the attachment
+// is already unreachable.
+func removeBlobs(keys ...string) {
+	if blobs == nil {
+		return
+	}
+	for _, key := range keys {
+		if err := blobs.Delete(context.Background(), key); err != nil {
+			log.Printf("Error: attachments.go: removeBlobs - Delete %s: %s\n", key, err)
+		}
+	}
+}
+
+func newStorageKey() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+func queryAttachment(q rowQueryer, taskID any, attachmentID string) (taskAttachment, error) {
+	a, err := scanAttachment(q.QueryRow("SELECT "+attachmentColumns+" FROM task_attachments WHERE id = ? AND task_id = ?", attachmentID, taskID))
+	if err == sql.ErrNoRows {
+		return a, errAttachmentNotFound
+	} else if err != nil {
+		return a, errors.AddContext(err, "attachments.go: queryAttachment - Scan")
+	}
+	return a, nil
+}
+
+func scanAttachment(row rowScanner) (taskAttachment, error) {
+	var a taskAttachment
+	err := row.Scan(&a.ID, &a.TaskID, &a.UploadedBy, &a.FileName, &a.ContentType, &a.Size, &a.SHA256, &a.storageKey, &a.CreatedAt)
+	return a, err
+}
@@ -0,0 +1,188 @@
+package api
+
+import (
+	"HMCTS-Developer-Challenge/blobstore"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+)
+
+// Enough of a PDF for its type to be sniffed
+var testPDF = []byte("%PDF-1.7\n1 0 obj << /Type /Catalog >> endobj\ntrailer << /Root 1 0 R >>\n%%EOF\n")
+
+func multipartUpload(t *testing.T, field, name string, contents []byte) ([]byte, map[string]string) {
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	part, err := writer.CreateFormFile(field, name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	part.Write(contents)
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return body.Bytes(), map[string]string{"Content-Type": writer.FormDataContentType()}
+}
+
+func TestParseByteSize(t *testing.T) {
+	tests := []struct {
+		value    string
+		expected int64
+	}{
+		{"", 42},
+		{"1024", 1024},
+		{"20M", 20 << 20},
+		{"20MB", 20 << 20},
+		{" 1gb ", 1 << 30},
+		{"512k", 512 << 10},
+	}
+	for _, tc := range tests {
+		if got, err := parseByteSize(tc.value, 42); err != nil || got != tc.expected {
+			t.Errorf("%q: expected %d, got %d (%v)", tc.value, tc.expected, got, err)
+		}
+	}
+
+	for _, invalid := range []string{"0", "-1", "lots", "1T", "M"} {
+		if _, err := parseByteSize(invalid, 42); err == nil {
+			t.Errorf("%q: expected an invalid size", invalid)
+		}
+	}
+}
+
+func TestNormalizeAttachmentName(t *testing.T) {
+	tests := []struct {
+		name     string
+		expected string
+	}{
+		{"order.pdf", "order.pdf"},
+		{`C:\fakepath\Order 12.pdf`, "Order 12.pdf"},
+		{"../../etc/passwd", "passwd"},
+		{"bad\r\nname.txt", "badname.txt"},
+		{"Gorchymyn llys – ŵ.pdf", "Gorchymyn llys – ŵ.pdf"},
+		{"  ", "attachment"},
+		{"..", "attachment"},
+		{strings.Repeat("a", 300), strings.Repeat("a", maxAttachmentNameLength)},
+	}
+	for _, tc := range tests {
+		if got := normalizeAttachmentName(tc.name); got != tc.expected {
+			t.Errorf("%q: expected %q, got %q", tc.name, tc.expected, got)
+		}
+	}
+}
+
+func TestSniffAttachmentType(t *testing.T) {
+	tests := []struct {
+		head     []byte
+		expected string
+	}{
+		{testPDF, "application/pdf"},
+		{[]byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR"), "image/png"},
+		{[]byte("From: clerk@court.example\r\nSubject: Hearing\r\n\r\nSee attached."), "text/plain; charset=utf-8"},
+	}
+	for _, tc := range tests {
+		if got, err := sniffAttachmentType(tc.head); err != nil || got != tc.expected {
+			t.Errorf("Expected %s, got %s (%v)", tc.expected, got, err)
+		}
+	}
+
+	// The type claimed by the client plays no part
+	for _, head := range [][]byte{[]byte("<html><script>alert(1)</script>"), []byte("MZ\x90\x00\x03\x00"), []byte("PK\x03\x04")} {
+		if _, err := sniffAttachmentType(head); err != errUnsupportedAttachmentType {
+			t.Errorf("%q: expected %v, got %v", head, errUnsupportedAttachmentType, err)
+		}
+	}
+}
+
+func TestTaskAttachments(t *testing.T) {
+	dir := t.TempDir()
+	store, err := blobstore.NewLocal(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	previous := blobs
+	SetBlobStore(store)
+	t.Cleanup(func() {
+		SetBlobStore(previous)
+		maxAttachmentSize, attachmentUserQuota = defaultMaxAttachmentSize, defaultAttachmentUserQuota
+	})
+
+	taskID := createTestTask(t, 1, "Task with attachments")
+	attachmentsURL := "/api/tasks/" + taskID + "/attachments"
+
+	body, headers := multipartUpload(t, "file", "Order – 12.pdf", testPDF)
+	rr := performTaskRequest(t, "POST", attachmentsURL, body, headers, 1)
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("handler returned wrong status code: got %v want %v, %s", rr.Code, http.StatusCreated, rr.Body.String())
+	}
+	var a taskAttachment
+	if err := json.Unmarshal(rr.Body.Bytes(), &a); err != nil {
+		t.Fatalf("Failed to parse response as JSON: %v", err)
+	}
+	checksum := sha256.Sum256(testPDF)
+	if a.FileName != "Order – 12.pdf" || a.ContentType != "application/pdf" || a.Size != int64(len(testPDF)) || a.SHA256 != hex.EncodeToString(checksum[:]) {
+		t.Errorf("Unexpected attachment %+v", a)
+	}
+
+	// Downloads stream the stored file back
+	attachmentURL := fmt.Sprintf("%s/%d", attachmentsURL, a.ID)
+	rr = performTaskRequest(t, "GET", attachmentURL, nil, nil, 4)
+	if rr.Code != http.StatusOK || !bytes.Equal(rr.Body.Bytes(), testPDF) {
+		t.Fatalf("Expected the file back, got %v %q", rr.Code, rr.Body.String())
+	}
+	if got := rr.Header().Get("Content-Disposition"); got != "attachment; filename*=utf-8''Order%20%E2%80%93%2012.pdf" {
+		t.Errorf("Unexpected Content-Disposition %s", got)
+	}
+	if rr.Header().Get("Content-Type") != "application/pdf" || rr.Header().Get("X-Content-Type-Options") != "nosniff" {
+		t.Errorf("Unexpected headers %v", rr.Header())
+	}
+
+	if rr := performTaskRequest(t, "GET", attachmentURL, nil, nil, 2); rr.Code != http.StatusNotFound {
+		t.Errorf("handler returned wrong status code for another user's task: got %v want %v", rr.Code, http.StatusNotFound)
+	}
+
+	body, headers = multipartUpload(t, "file", "page.html", []byte("<html><body>Not a document</body></html>"))
+	if rr := performTaskRequest(t, "POST", attachmentsURL, body, headers, 1); rr.Code != http.StatusUnsupportedMediaType {
+		t.Errorf("handler returned wrong status code for HTML: got %v want %v", rr.Code, http.StatusUnsupportedMediaType)
+	}
+	body, headers = multipartUpload(t, "document", "order.pdf", testPDF)
+	if rr := performTaskRequest(t, "POST", attachmentsURL, body, headers, 1); rr.Code != http.StatusBadRequest {
+		t.Errorf("handler returned wrong status code without a file: got %v want %v", rr.Code, http.StatusBadRequest)
+	}
+
+	maxAttachmentSize = int64(len(testPDF)) - 1
+	body, headers = multipartUpload(t, "file", "order.pdf", testPDF)
+	if rr := performTaskRequest(t, "POST", attachmentsURL, body, headers, 1); rr.Code != http.StatusRequestEntityTooLarge || !strings.Contains(rr.Body.String(), "Attachment Too Large") {
+		t.Errorf("Expected the file to be too large, got %v %s", rr.Code, rr.Body.String())
+	}
+	maxAttachmentSize = defaultMaxAttachmentSize
+
+	// The first upload uses up the quota
+	attachmentUserQuota = int64(len(testPDF)) + 1
+	if rr := performTaskRequest(t, "POST", attachmentsURL, body, headers, 1); rr.Code != http.StatusRequestEntityTooLarge || !strings.Contains(rr.Body.String(), "Attachment Quota Exceeded") {
+		t.Errorf("Expected the quota to be exceeded, got %v %s", rr.Code, rr.Body.String())
+	}
+
+	if rr := performTaskRequest(t, "DELETE", attachmentURL, nil, nil, 4); rr.Code != http.StatusForbidden {
+		t.Errorf("handler returned wrong status code for the auditor: got %v want %v", rr.Code, http.StatusForbidden)
+	}
+	if rr := performTaskRequest(t, "DELETE", attachmentURL, nil, nil, 1); rr.Code != http.StatusNoContent {
+		t.Errorf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusNoContent)
+	}
+	if rr := performTaskRequest(t, "GET", attachmentURL, nil, nil, 1); rr.Code != http.StatusNotFound {
+		t.Errorf("handler returned wrong status code for a removed attachment: got %v want %v", rr.Code, http.StatusNotFound)
+	}
+
+	// Rejected uploads never reach the store and removing an attachment
+	// removes its contents
+	entries, err := os.ReadDir(dir)
+	if err != nil || len(entries) != 0 {
+		t.Errorf("Expected an empty blob store, got %v (%v)", entries, err)
+	}
+}
@@ -0,0 +1,181 @@
+package api
+
+import (
+	"HMCTS-Developer-Challenge/audit"
+	"HMCTS-Developer-Challenge/errors"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const defaultAuditPageSize = 100
+const maxAuditPageSize = 500
+
+type auditPage struct {
+	Entries []audit.Entry `json:"entries"`
+	Next    string        `json:"next,omitempty"`
+}
+
+// AdminAuditHandler lets admins and auditors search the audit log under
+// /api/admin/audit and check that it has not been tampered with under
+// /api/admin/audit/verify.
+func AdminAuditHandler(w http.ResponseWriter, r *http.Request, userID uint) {
+	pathParts := strings.Split(strings.TrimSuffix(r.URL.Path, "/"), "/")
+	verify := false
+	if len(pathParts) > 4 {
+		if len(pathParts) != 5 || pathParts[4] != "verify" {
+			http.Error(w, "Not Found", http.StatusNotFound)
+			return
+		}
+		verify = true
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		if verify {
+			report, err := audit.Verify()
+			if err != nil {
+				errors.HandleServerError(w, err, "audit.go: AdminAuditHandler - Verify")
+				break
+			}
+			writeJSON(w, http.StatusOK, report)
+			break
+		}
+
+		loc, err := userLocation(userID)
+		if err != nil {
+			errors.HandleServerError(w, err, "audit.go: AdminAuditHandler - userLocation")
+			break
+		}
+
+		filter, err := parseAuditFilter(r.URL.Query(), loc)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			break
+		}
+
+		page, err := getAuditPage(r.URL, filter, loc)
+		if err != nil {
+			errors.HandleServerError(w, err, "audit.go: AdminAuditHandler - getAuditPage")
+			break
+		}
+		writeJSON(w, http.StatusOK, page)
+	case http.MethodOptions:
+		w.Header().Set("Access-Control-Allow-Methods", "GET, OPTIONS")
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// parseAuditFilter reads the filters of an audit log search. Events can be
+// given several times or separated by commas, and times without an offset
+// are in the user's zone.
+func parseAuditFilter(values url.Values, loc *time.Location) (audit.Filter, error) {
+	filter := audit.Filter{
+		Username: values.Get("username"),
+		SourceIP: values.Get("ip"),
+		Limit:    defaultAuditPageSize,
+	}
+
+	for _, value := range values["event"] {
+		for _, event := range strings.Split(value, ",") {
+			event := audit.Event(strings.ToUpper(strings.TrimSpace(event)))
+			if !slices.Contains(audit.Events, event) {
+				return filter, errors.Errorf("invalid event: %q", event)
+			}
+			filter.Events = append(filter.Events, event)
+		}
+	}
+
+	if value := values.Get("user_id"); value != "" {
+		id, err := strconv.ParseUint(value, 10, 32)
+		if err != nil {
+			return filter, errors.Errorf("invalid value for user_id: %q", value)
+		}
+		filter.UserID = audit.UserID(uint(id))
+	}
+
+	var err error
+	if filter.From, err = parseTaskQueryTime(values, "from", loc); err != nil {
+		return filter, err
+	}
+	if filter.To, err = parseTaskQueryTime(values, "to", loc); err != nil {
+		return filter, err
+	}
+
+	if limit := values.Get("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n < 1 || n > maxAuditPageSize {
+			return filter, errors.Errorf("limit must be between 1 and %d", maxAuditPageSize)
+		}
+		filter.Limit = n
+	}
+
+	if cursor := values.Get("cursor"); cursor != "" {
+		before, err := strconv.ParseUint(cursor, 10, 32)
+		if err != nil || before == 0 {
+			return filter, errors.Error("invalid cursor")
+		}
+		filter.Before = uint(before)
+	}
+	return filter, nil
+}
+
+// getAuditPage returns a page of matching entries, newest first, with their
+// times in loc.
+func getAuditPage(base *url.URL, filter audit.Filter, loc *time.Location) (auditPage, error) {
+	// Fetch one extra entry to know whether there is a next page
+	limit := filter.Limit
+	filter.Limit++
+	entries, err := audit.Query(filter)
+	if err != nil {
+		return auditPage{Entries: entries}, errors.AddContext(err, "audit.go: getAuditPage - Query")
+	}
+
+	page := auditPage{Entries: entries}
+	if len(entries) > limit {
+		page.Entries = entries[:limit]
+		page.Next = nextTaskPageURL(base, strconv.FormatUint(uint64(entries[limit-1].ID), 10))
+	}
+	for i := range page.Entries {
+		page.Entries[i].Time = page.Entries[i].Time.In(loc)
+	}
+	return page, nil
+}
+
+// forbidden refuses a request that the user is not allowed to make and
+// records the denial in the audit log.
+func forbidden(w http.ResponseWriter, r *http.Request, userID uint, err error) {
+	RecordPermissionDenied(r, userID, err.Error())
+	http.Error(w, err.Error(), http.StatusForbidden)
+}
+
+// RecordPermissionDenied records in the audit log that a user was refused a
+// request. userID is 0 when nobody is logged in.
+func RecordPermissionDenied(r *http.Request, userID uint, reason string) {
+	e := audit.Entry{
+		Event:    audit.EventPermissionDenied,
+		SourceIP: audit.SourceIP(r),
+		Details:  map[string]string{"method": r.Method, "path": r.URL.Path, "reason": reason},
+	}
+	if userID != 0 {
+		e.UserID = audit.UserID(userID)
+	}
+	audit.Log(e)
+}
+
+// recordExport records in the audit log that data left the system, such as
+// a downloaded attachment.
+func recordExport(r *http.Request, userID uint, kind string, details map[string]string) {
+	details["kind"] = kind
+	audit.Log(audit.Entry{
+		Event:    audit.EventExport,
+		UserID:   audit.UserID(userID),
+		SourceIP: audit.SourceIP(r),
+		Details:  details,
+	})
+}
@@ -0,0 +1,127 @@
+package api
+
+import (
+	"HMCTS-Developer-Challenge/audit"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseAuditFilter(t *testing.T) {
+	values := url.Values{
+		"event":   {"login_failed,LOGOUT", "EXPORT"},
+		"user_id": {"3"},
+		"ip":      {"192.0.2.1"},
+		"from":    {"2025-06-02"},
+		"limit":   {"20"},
+		"cursor":  {"41"},
+	}
+	filter, err := parseAuditFilter(values, courtLocation)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := audit.Filter{
+		Events:   []audit.Event{audit.EventLoginFailed, audit.EventLogout, audit.EventExport},
+		UserID:   audit.UserID(3),
+		SourceIP: "192.0.2.1",
+		// Midnight in London during summer time
+		From:   time.Date(2025, 6, 1, 23, 0, 0, 0, time.UTC),
+		Limit:  20,
+		Before: 41,
+	}
+	if !reflect.DeepEqual(filter, expected) {
+		t.Errorf("Expected %+v, got %+v", expected, filter)
+	}
+
+	for _, invalid := range []url.Values{
+		{"event": {"LOGIN,HACKED"}},
+		{"user_id": {"someone"}},
+		{"to": {"yesterday"}},
+		{"limit": {"0"}},
+		{"limit": {"501"}},
+		{"cursor": {"0"}},
+	} {
+		if _, err := parseAuditFilter(invalid, courtLocation); err == nil {
+			t.Errorf("Expected an error for %v", invalid)
+		}
+	}
+}
+
+func TestAdminAudit(t *testing.T) {
+	// httptest requests come from 192.0.2.1
+	req := httptest.NewRequest("POST", "/api/login", strings.NewReader(`{"username": "testuser2", "password": "wrong"}`))
+	rr := httptest.NewRecorder()
+	LoginHandler(rr, req)
+	if rr.Code != http.StatusBadRequest {
+		t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusBadRequest)
+	}
+
+	req = httptest.NewRequest("POST", "/api/login", strings.NewReader(`{"username": "testuser2", "password": "demo123"}`))
+	rr = httptest.NewRecorder()
+	LoginHandler(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusOK)
+	}
+
+	// Signing up without an invitation is a permission denial
+	req = httptest.NewRequest("POST", "/api/signup", strings.NewReader(`{"username": "intruder", "password": "demo123"}`))
+	rr = httptest.NewRecorder()
+	SignUpHandler(rr, req)
+	if rr.Code != http.StatusForbidden {
+		t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusForbidden)
+	}
+
+	req = httptest.NewRequest("GET", "/api/admin/audit?username=testuser2&limit=2", nil)
+	rr = httptest.NewRecorder()
+	AdminAuditHandler(rr, req, 4)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v, %s", rr.Code, http.StatusOK, rr.Body.String())
+	}
+	var page auditPage
+	if err := json.Unmarshal(rr.Body.Bytes(), &page); err != nil {
+		t.Fatalf("Failed to parse response as JSON: %v", err)
+	}
+	if len(page.Entries) != 2 {
+		t.Fatalf("Expected two entries, got %+v", page.Entries)
+	}
+	login, failed := page.Entries[0], page.Entries[1]
+	if login.Event != audit.EventLogin || login.UserID == nil || *login.UserID != 2 || login.SourceIP != "192.0.2.1" || login.PrevHash != failed.Hash {
+		t.Errorf("Unexpected login %+v", login)
+	}
+	if failed.Event != audit.EventLoginFailed || failed.UserID != nil || failed.Details["reason"] != errWrongPassword.Error() {
+		t.Errorf("Unexpected failed login %+v", failed)
+	}
+
+	req = httptest.NewRequest("GET", "/api/admin/audit?event=PERMISSION_DENIED&limit=1", nil)
+	rr = httptest.NewRecorder()
+	AdminAuditHandler(rr, req, 3)
+	if err := json.Unmarshal(rr.Body.Bytes(), &page); err != nil {
+		t.Fatalf("Failed to parse response as JSON: %v", err)
+	}
+	if len(page.Entries) != 1 || page.Entries[0].Details["path"] != "/api/signup" {
+		t.Errorf("Unexpected page %+v", page)
+	}
+
+	req = httptest.NewRequest("GET", "/api/admin/audit/verify", nil)
+	rr = httptest.NewRecorder()
+	AdminAuditHandler(rr, req, 4)
+	var report audit.Report
+	if err := json.Unmarshal(rr.Body.Bytes(), &report); err != nil {
+		t.Fatalf("Failed to parse response as JSON: %v", err)
+	}
+	if !report.Valid || report.Checked < 3 || report.LastHash == "" {
+		t.Errorf("Expected an intact audit log, got %+v", report)
+	}
+
+	req = httptest.NewRequest("DELETE", "/api/admin/audit", nil)
+	rr = httptest.NewRecorder()
+	AdminAuditHandler(rr, req, 3)
+	if rr.Code != http.StatusMethodNotAllowed {
+		t.Errorf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusMethodNotAllowed)
+	}
+}
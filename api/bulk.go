@@ -0,0 +1,393 @@
+package api
+
+import (
+	"HMCTS-Developer-Challenge/database"
+	"HMCTS-Developer-Challenge/errors"
+	"encoding/json"
+	"log"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+)
+
+// maxBulkOperations is how many operations a single bulk request may hold.
+const maxBulkOperations = 100
+
+// maxBulkBodySize bounds the body of a bulk request, which is read whole.
+const maxBulkBodySize = 1 << 20
+
+const (
+	bulkAtomic     = "atomic"
+	bulkBestEffort = "best_effort"
+)
+
+const (
+	bulkCreate   = "create"
+	bulkUpdate   = "update"
+	bulkPatch    = "patch"
+	bulkDelete   = "delete"
+	bulkStatus   = "status"
+	bulkRetag    = "retag"
+	bulkReassign = "reassign"
+)
+
+var errInvalidBulkMode = errors.Error("Invalid Bulk Mode")
+var errNoBulkOperations = errors.Error("No Operations")
+var errTooManyBulkOperations = errors.Error("Too Many Operations")
+
+// errBulkRolledBack and errBulkNotRun explain the results of the operations
+// around the one that failed an atomic bulk request.
+var errBulkRolledBack = errors.Error("Rolled Back")
+var errBulkNotRun = errors.Error("Not Run")
+
+type bulkRequest struct {
+	// Mode is atomic, where any failure undoes every operation, or
+	// best_effort, where each operation succeeds or fails on its own.
+	Mode       string          `json:"mode"`
+	Operations []bulkOperation `json:"operations"`
+}
+
+// bulkOperation is one change in a bulk request. Version takes the place of
+// the If-Match header of the single requests and is required by every
+// operation that changes an existing task except reassign, as with the single
+// requests.
+type bulkOperation struct {
+	Op      string `json:"op"`
+	ID      uint   `json:"id"`
+	Version *uint  `json:"version"`
+
+	// Task is the task to create, or the task to replace it with on update.
+	Task *jsonData `json:"task"`
+
+	// ParentID creates the task as a subtask of another.
+	ParentID *uint `json:"parent_id"`
+
+	// Patch is a JSON Merge Patch, or a JSON Patch when ContentType says so.
+	Patch       json.RawMessage `json:"patch"`
+	ContentType string          `json:"content_type"`
+
+	Status string `json:"status"`
+
+	// Add and Remove are the tags to add to and remove from the task.
+	Add    []string `json:"add"`
+	Remove []string `json:"remove"`
+
+	AssigneeID *uint `json:"assignee_id"`
+}
+
+// bulkResult is the outcome of one operation, with the HTTP status the single
+// request would have answered with.
+type bulkResult struct {
+	Index  int    `json:"index"`
+	Op     string `json:"op"`
+	ID     uint   `json:"id,omitempty"`
+	Status int    `json:"status"`
+	Error  string `json:"error,omitempty"`
+
+	// Task is the task as it is after the request, for operations that
+	// leave one the user can still see.
+	Task *task `json:"task,omitempty"`
+}
+
+type bulkResponse struct {
+	Committed bool         `json:"committed"`
+	Results   []bulkResult `json:"results"`
+}
+
+// BulkTasksHandler applies a list of task operations in one transaction
+// through POST /api/tasks/bulk.
+func BulkTasksHandler(w http.ResponseWriter, r *http.Request, userID uint) {
+	switch r.Method {
+	case http.MethodPost:
+		var request bulkRequest
+		r.Body = http.MaxBytesReader(w, r.Body, maxBulkBodySize)
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			http.Error(w, "Invalid JSON", http.StatusBadRequest)
+			break
+		}
+
+		if err := request.validate(); err == errTooManyBulkOperations {
+			http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+			break
+		} else if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			break
+		}
+
+		response, err := runBulk(r, userID, request, parseTaskEditOptions(r))
+		if err != nil {
+			errors.HandleServerError(w, err, "bulk.go: BulkTasksHandler - runBulk")
+			break
+		}
+
+		code := http.StatusOK
+		if !response.Committed {
+			code = http.StatusUnprocessableEntity
+		}
+		writeJSON(w, code, response)
+	case http.MethodOptions:
+		w.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS")
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// validate checks the shape of a bulk request before anything is run. A task
+// may only appear in one operation, since each operation is checked against
+// the version of the task from before the request.
+func (b *bulkRequest) validate() error {
+	if b.Mode == "" {
+		b.Mode = bulkAtomic
+	} else if b.Mode != bulkAtomic && b.Mode != bulkBestEffort {
+		return errInvalidBulkMode
+	}
+
+	if len(b.Operations) == 0 {
+		return errNoBulkOperations
+	} else if len(b.Operations) > maxBulkOperations {
+		return errTooManyBulkOperations
+	}
+
+	seen := map[uint]int{}
+	for i, op := range b.Operations {
+		switch op.Op {
+		case bulkCreate, bulkUpdate:
+			if op.Task == nil {
+				return errors.Errorf("operation %d: missing task", i)
+			}
+		case bulkPatch:
+			if len(op.Patch) == 0 {
+				return errors.Errorf("operation %d: missing patch", i)
+			}
+		case bulkStatus:
+			if op.Status == "" {
+				return errors.Errorf("operation %d: missing status", i)
+			}
+		case bulkRetag:
+			if len(op.Add) == 0 && len(op.Remove) == 0 {
+				return errors.Errorf("operation %d: missing tags to add or remove", i)
+			}
+		case bulkDelete, bulkReassign:
+		default:
+			return errors.Errorf("operation %d: unknown operation %q", i, op.Op)
+		}
+
+		if op.Op == bulkCreate {
+			continue
+		}
+		if op.ID == 0 {
+			return errors.Errorf("operation %d: missing task id", i)
+		}
+		if first, ok := seen[op.ID]; ok {
+			return errors.Errorf("operation %d: task %d is already changed by operation %d", i, op.ID, first)
+		}
+		seen[op.ID] = i
+	}
+	return nil
+}
+
+// runBulk applies the operations in order in a single transaction. Each one
+// runs in a savepoint, so that a failed operation leaves nothing behind. An
+// atomic request stops at the first failure and rolls everything back; a best
+// effort one carries on and commits the operations that succeeded.
+func runBulk(r *http.Request, userID uint, request bulkRequest, options taskEditOptions) (bulkResponse, error) {
+	response := bulkResponse{Results: make([]bulkResult, len(request.Operations))}
+	for i, op := range request.Operations {
+		response.Results[i] = bulkResult{Index: i, Op: op.Op, ID: op.ID}
+	}
+
+	dbHandle, err := database.GetDBHandle()
+	if err != nil {
+		return response, errors.AddContext(err, "bulk.go: runBulk - GetDBHandle")
+	}
+
+	tx, err := dbHandle.Begin()
+	if err != nil {
+		return response, errors.AddContext(err, "bulk.go: runBulk - Begin")
+	}
+	defer tx.Rollback()
+	options.Tx = tx
+
+	// Versions are matched against tasks rendered in the user's timezone
+	loc, err := userLocation(userID)
+	if err != nil {
+		return response, errors.AddContext(err, "bulk.go: runBulk - userLocation")
+	}
+
+	failed := -1
+	for i, op := range request.Operations {
+		result := &response.Results[i]
+
+		if _, err := tx.Exec("SAVEPOINT bulk_operation"); err != nil {
+			return response, errors.AddContext(err, "bulk.go: runBulk - SAVEPOINT")
+		}
+
+		id, err := runBulkOperation(userID, op, loc, options)
+		if err == nil {
+			result.ID, result.Status = id, bulkSuccessStatus(op.Op)
+			continue
+		}
+
+		result.Status, result.Error = bulkErrorStatus(err)
+		if result.Status == http.StatusInternalServerError {
+			log.Printf("Error: %s\n", errors.AddContext(err, "bulk.go: runBulk - operation "+strconv.Itoa(i)))
+		} else if err == errReassignForbidden {
+			RecordPermissionDenied(r, userID, err.Error())
+		}
+
+		// A failed statement can end the whole transaction, such as when it
+		// is chosen as a deadlock victim, in which case nothing can be kept
+		if _, err := tx.Exec("ROLLBACK TO SAVEPOINT bulk_operation"); err != nil {
+			return response, errors.AddContext(err, "bulk.go: runBulk - ROLLBACK TO SAVEPOINT")
+		}
+		if request.Mode == bulkAtomic {
+			failed = i
+			break
+		}
+	}
+
+	if failed >= 0 {
+		for i := range response.Results {
+			if i < failed {
+				response.Results[i].Status, response.Results[i].Error = http.StatusFailedDependency, errBulkRolledBack.Error()
+			} else if i > failed {
+				response.Results[i].Status, response.Results[i].Error = http.StatusFailedDependency, errBulkNotRun.Error()
+			}
+		}
+		return response, nil
+	}
+
+	if err := tx.Commit(); err != nil {
+		return response, errors.AddContext(err, "bulk.go: runBulk - Commit")
+	}
+	response.Committed = true
+
+	for i := range response.Results {
+		result := &response.Results[i]
+		if result.Error != "" || result.Op == bulkDelete {
+			continue
+		}
+		t, err := getTask(userID, strconv.FormatUint(uint64(result.ID), 10))
+		if err == errTaskNotFound {
+			continue
+		} else if err != nil {
+			return response, errors.AddContext(err, "bulk.go: runBulk - getTask")
+		}
+		result.Task = &t
+	}
+	return response, nil
+}
+
+// runBulkOperation applies one operation through the function its single
+// request uses and returns the ID of the task it changed.
+func runBulkOperation(userID uint, op bulkOperation, loc *time.Location, options taskEditOptions) (uint, error) {
+	taskID := strconv.FormatUint(uint64(op.ID), 10)
+	ifMatch := ""
+	if op.Version != nil {
+		ifMatch = versionETag(*op.Version, loc)
+	}
+
+	switch op.Op {
+	case bulkCreate:
+		if op.ParentID == nil {
+			return createTask(userID, nil, *op.Task, options)
+		}
+		t, err := addSubtask(userID, strconv.FormatUint(uint64(*op.ParentID), 10), *op.Task, options)
+		return t.ID, err
+	case bulkUpdate:
+		return op.ID, editTask(userID, taskID, ifMatch, *op.Task, options)
+	case bulkPatch:
+		contentType := op.ContentType
+		if contentType == "" {
+			contentType = mergePatchContentType
+		}
+		_, err := patchTask(userID, taskID, ifMatch, contentType, op.Patch, options)
+		return op.ID, err
+	case bulkDelete:
+		return op.ID, deleteTask(userID, taskID, ifMatch, options)
+	case bulkStatus:
+		patch, err := json.Marshal(map[string]string{"status": op.Status})
+		if err != nil {
+			return op.ID, err
+		}
+		_, err = patchTask(userID, taskID, ifMatch, mergePatchContentType, patch, options)
+		return op.ID, err
+	case bulkRetag:
+		return op.ID, retagTask(userID, taskID, ifMatch, op.Add, op.Remove, options)
+	case bulkReassign:
+		_, err := reassignTask(userID, taskID, op.AssigneeID, options)
+		return op.ID, err
+	}
+	return op.ID, errors.Errorf("bulk.go: runBulkOperation - unknown operation %q", op.Op)
+}
+
+// retagTask adds tags to and removes tags from a task, keeping the rest.
+func retagTask(userID uint, taskID string, ifMatch string, add []string, remove []string, options taskEditOptions) error {
+	current, err := getEditedTask(userID, taskID, options)
+	if err != nil {
+		return err
+	}
+
+	if add, err = normalizeTagNames(add); err != nil {
+		return err
+	}
+	if remove, err = normalizeTagNames(remove); err != nil {
+		return err
+	}
+
+	tags := []string{}
+	for _, name := range append(current.Tags, add...) {
+		if !slices.Contains(remove, name) && !slices.Contains(tags, name) {
+			tags = append(tags, name)
+		}
+	}
+
+	patch, err := json.Marshal(map[string][]string{"tags": tags})
+	if err != nil {
+		return err
+	}
+	_, err = patchTask(userID, taskID, ifMatch, mergePatchContentType, patch, options)
+	return err
+}
+
+func bulkSuccessStatus(op string) int {
+	switch op {
+	case bulkCreate:
+		return http.StatusCreated
+	case bulkDelete:
+		return http.StatusNoContent
+	}
+	return http.StatusOK
+}
+
+// bulkErrorStatus returns the status and message the single request would
+// have answered an error with.
+func bulkErrorStatus(err error) (int, string) {
+	if statusErr, ok := err.(*statusError); ok {
+		return http.StatusUnprocessableEntity, statusErr.Message
+	} else if _, ok := err.(*blockedSubtaskError); ok {
+		return http.StatusConflict, err.Error()
+	}
+
+	switch err {
+	case errTaskNotFound:
+		return http.StatusNotFound, err.Error()
+	case errReassignForbidden:
+		return http.StatusForbidden, err.Error()
+	case errPreconditionRequired:
+		return http.StatusPreconditionRequired, err.Error()
+	case errPreconditionFailed:
+		return http.StatusPreconditionFailed, err.Error()
+	case errUnsupportedPatchType:
+		return http.StatusUnsupportedMediaType, err.Error()
+	case errPatchTestFailed, errOpenSubtasks, errOpenBlockers:
+		return http.StatusConflict, err.Error()
+	case errMissingJsonData, errUnknownTaskField, errInvalidTaskField, errInvalidPatch, errInvalidTaskPriority,
+		errInvalidTagName, errInvalidRecurrenceRule, errInvalidDeadline, errMaxTaskDepth, errTeamNotFound, errCaseNotFound,
+		errHearingNotFound, errInvalidDeadlineRule, errHearingCaseMismatch, errInvalidWorkingDays, errUnknownAssignee:
+		return http.StatusBadRequest, err.Error()
+	}
+	return http.StatusInternalServerError, "Internal Server Error"
+}
@@ -0,0 +1,211 @@
+package api
+
+import (
+	"HMCTS-Developer-Challenge/database"
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func performBulkRequest(t *testing.T, body string, userID uint) (int, bulkResponse) {
+	req, err := http.NewRequest("POST", "/api/tasks/bulk", bytes.NewBufferString(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr := httptest.NewRecorder()
+	BulkTasksHandler(rr, req, userID)
+
+	var response bulkResponse
+	if rr.Code == http.StatusOK || rr.Code == http.StatusUnprocessableEntity {
+		if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil {
+			t.Fatalf("Failed to parse response as JSON: %v", err)
+		}
+	}
+	return rr.Code, response
+}
+
+func TestBulkRequestValidate(t *testing.T) {
+	request := bulkRequest{Operations: []bulkOperation{
+		{Op: bulkCreate, Task: &jsonData{Name: "File bundle"}},
+		{Op: bulkStatus, ID: 1, Status: "COMPLETE"},
+		{Op: bulkReassign, ID: 2},
+	}}
+	if err := request.validate(); err != nil || request.Mode != bulkAtomic {
+		t.Errorf("Expected a valid atomic request, got %q (%v)", request.Mode, err)
+	}
+
+	tooMany := bulkRequest{Operations: make([]bulkOperation, maxBulkOperations+1)}
+	if err := tooMany.validate(); err != errTooManyBulkOperations {
+		t.Errorf("Expected %v, got %v", errTooManyBulkOperations, err)
+	}
+
+	for _, invalid := range []bulkRequest{
+		{Mode: "eventually", Operations: []bulkOperation{{Op: bulkDelete, ID: 1}}},
+		{},
+		{Operations: []bulkOperation{{Op: "archive", ID: 1}}},
+		{Operations: []bulkOperation{{Op: bulkCreate}}},
+		{Operations: []bulkOperation{{Op: bulkDelete}}},
+		{Operations: []bulkOperation{{Op: bulkRetag, ID: 1}}},
+		{Operations: []bulkOperation{{Op: bulkDelete, ID: 1}, {Op: bulkStatus, ID: 1, Status: "COMPLETE"}}},
+	} {
+		if err := invalid.validate(); err == nil {
+			t.Errorf("Expected an error for %+v", invalid)
+		}
+	}
+}
+
+func TestBulkTasks(t *testing.T) {
+	db, err := database.GetDBHandle()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Exec("DELETE FROM tasks WHERE name = 'Bulk created task'") })
+
+	completeID := createTestTask(t, 1, "Bulk status task")
+	retagID := createTestTask(t, 1, "Bulk retag task")
+	deleteID := createTestTask(t, 1, "Bulk delete task")
+
+	body := `{"operations": [
+		{"op": "create", "task": {"name": "Bulk created task", "status": "INCOMPLETE", "deadline": "2025-12-31 00:00:00", "tags": ["week-42"]}},
+		{"op": "status", "id": ` + completeID + `, "version": 1, "status": "COMPLETE"},
+		{"op": "retag", "id": ` + retagID + `, "version": 1, "add": ["week-42", "Urgent"]},
+		{"op": "delete", "id": ` + deleteID + `, "version": 1}
+	]}`
+	code, response := performBulkRequest(t, body, 1)
+	if code != http.StatusOK || !response.Committed || len(response.Results) != 4 {
+		t.Fatalf("Expected the request to be committed, got %v %+v", code, response)
+	}
+	for i, expected := range []int{http.StatusCreated, http.StatusOK, http.StatusOK, http.StatusNoContent} {
+		if response.Results[i].Status != expected {
+			t.Errorf("Operation %d: expected %v, got %+v", i, expected, response.Results[i])
+		}
+	}
+	if created := response.Results[0].Task; created == nil || created.Name != "Bulk created task" || !slices.Equal(created.Tags, []string{"week-42"}) {
+		t.Errorf("Unexpected created task %+v", created)
+	}
+	if completed := response.Results[1].Task; completed == nil || completed.Status != "COMPLETE" || completed.Version != 2 {
+		t.Errorf("Unexpected completed task %+v", completed)
+	}
+	if retagged := response.Results[2].Task; retagged == nil || !slices.Equal(retagged.Tags, []string{"urgent", "week-42"}) {
+		t.Errorf("Unexpected retagged task %+v", retagged)
+	}
+	if rr := performTaskRequest(t, "GET", "/api/tasks/"+deleteID, nil, nil, 1); rr.Code != http.StatusNotFound {
+		t.Errorf("Expected the deleted task to be gone, got %v", rr.Code)
+	}
+
+	// A stale version undoes the whole atomic request
+	body = `{"operations": [
+		{"op": "status", "id": ` + retagID + `, "version": 2, "status": "COMPLETE"},
+		{"op": "patch", "id": ` + completeID + `, "version": 1, "patch": {"priority": "HIGH"}},
+		{"op": "reassign", "id": 999999, "assignee_id": 2}
+	]}`
+	code, response = performBulkRequest(t, body, 1)
+	if code != http.StatusUnprocessableEntity || response.Committed {
+		t.Fatalf("Expected the request to be rolled back, got %v %+v", code, response)
+	}
+	for i, expected := range []bulkResult{
+		{Status: http.StatusFailedDependency, Error: errBulkRolledBack.Error()},
+		{Status: http.StatusPreconditionFailed, Error: errPreconditionFailed.Error()},
+		{Status: http.StatusFailedDependency, Error: errBulkNotRun.Error()},
+	} {
+		if got := response.Results[i]; got.Status != expected.Status || got.Error != expected.Error {
+			t.Errorf("Operation %d: expected %+v, got %+v", i, expected, got)
+		}
+	}
+	rr := performTaskRequest(t, "GET", "/api/tasks/"+retagID, nil, nil, 1)
+	var unchanged task
+	if err := json.Unmarshal(rr.Body.Bytes(), &unchanged); err != nil {
+		t.Fatalf("Failed to parse response as JSON: %v", err)
+	}
+	if unchanged.Status != "INCOMPLETE" || unchanged.Version != 2 {
+		t.Errorf("Expected the first operation to be rolled back, got %+v", unchanged)
+	}
+
+	// Best effort keeps what succeeds
+	body = strings.Replace(body, `"operations"`, `"mode": "best_effort", "operations"`, 1)
+	code, response = performBulkRequest(t, body, 1)
+	if code != http.StatusOK || !response.Committed {
+		t.Fatalf("Expected the request to be committed, got %v %+v", code, response)
+	}
+	for i, expected := range []int{http.StatusOK, http.StatusPreconditionFailed, http.StatusNotFound} {
+		if response.Results[i].Status != expected {
+			t.Errorf("Operation %d: expected %v, got %+v", i, expected, response.Results[i])
+		}
+	}
+	if task := response.Results[0].Task; task == nil || task.Status != "COMPLETE" || strconv.FormatUint(uint64(task.ID), 10) != retagID {
+		t.Errorf("Unexpected task %+v", task)
+	}
+}
+
+func TestBulkTasksSeeEarlierOperations(t *testing.T) {
+	parentID := createTestTask(t, 1, "Bulk parent task")
+	subtask := createTestSubtask(t, parentID, "Bulk subtask")
+	subtaskID := strconv.FormatUint(uint64(subtask.ID), 10)
+
+	// Deleting the parent takes the subtask with it, so the next operation
+	// no longer finds it
+	body := `{"operations": [
+		{"op": "delete", "id": ` + parentID + `, "version": 2},
+		{"op": "status", "id": ` + subtaskID + `, "version": 1, "status": "COMPLETE"}
+	]}`
+	code, response := performBulkRequest(t, body, 1)
+	if code != http.StatusUnprocessableEntity || response.Committed {
+		t.Fatalf("Expected the request to be rolled back, got %v %+v", code, response)
+	}
+	if got := response.Results[1]; got.Status != http.StatusNotFound {
+		t.Errorf("Expected the subtask not to be found, got %+v", got)
+	}
+	if rr := performTaskRequest(t, "GET", "/api/tasks/"+subtaskID, nil, nil, 1); rr.Code != http.StatusOK {
+		t.Errorf("Expected the subtask to be kept, got %v", rr.Code)
+	}
+
+	body = `{"operations": [
+		{"op": "delete", "id": ` + parentID + `, "version": 2},
+		{"op": "create", "parent_id": ` + parentID + `, "task": {"name": "Bulk late subtask", "status": "INCOMPLETE", "deadline": "2025-12-31 00:00:00"}}
+	]}`
+	code, response = performBulkRequest(t, body, 1)
+	if code != http.StatusUnprocessableEntity || response.Results[1].Status != http.StatusNotFound {
+		t.Fatalf("Expected no subtask under a deleted task, got %v %+v", code, response)
+	}
+
+	// A subtask created first is seen by the delete as a change to its parent
+	body = `{"operations": [
+		{"op": "create", "parent_id": ` + parentID + `, "task": {"name": "Bulk late subtask", "status": "INCOMPLETE", "deadline": "2025-12-31 00:00:00"}},
+		{"op": "delete", "id": ` + parentID + `, "version": 2}
+	]}`
+	code, response = performBulkRequest(t, body, 1)
+	if code != http.StatusUnprocessableEntity || response.Results[1].Status != http.StatusPreconditionFailed {
+		t.Fatalf("Expected the parent to have changed, got %v %+v", code, response)
+	}
+}
+
+func TestBulkTasksLimits(t *testing.T) {
+	operations := make([]string, maxBulkOperations+1)
+	for i := range operations {
+		operations[i] = `{"op": "delete", "id": ` + strconv.Itoa(i+1) + `, "version": 1}`
+	}
+	req, err := http.NewRequest("POST", "/api/tasks/bulk", strings.NewReader(`{"operations": [`+strings.Join(operations, ",")+`]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr := httptest.NewRecorder()
+	BulkTasksHandler(rr, req, 1)
+	if rr.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusRequestEntityTooLarge)
+	}
+
+	req, err = http.NewRequest("GET", "/api/tasks/bulk", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr = httptest.NewRecorder()
+	BulkTasksHandler(rr, req, 1)
+	if rr.Code != http.StatusMethodNotAllowed {
+		t.Errorf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,160 @@
+package api
+
+import (
+	"HMCTS-Developer-Challenge/calendar"
+	"HMCTS-Developer-Challenge/errors"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxWorkingDays is the furthest ahead a deadline can be set in working days.
+const maxWorkingDays = 365
+
+// calendarDivision is the part of the UK whose bank holidays count as days
+// off when working days are counted.
+var calendarDivision = calendar.EnglandAndWales
+
+var errInvalidWorkingDays = errors.Error("Invalid Working Days")
+
+type holidayList struct {
+	Division calendar.Division  `json:"division"`
+	Year     int                `json:"year"`
+	Holidays []calendar.Holiday `json:"holidays"`
+}
+
+type workingDaysPreview struct {
+	Division calendar.Division `json:"division"`
+	Start    time.Time         `json:"start"`
+	Days     int               `json:"days"`
+	Deadline time.Time         `json:"deadline"`
+}
+
+// SetCalendarDivision sets whose bank holidays are days off, one of
+// "england-and-wales", "scotland" or "northern-ireland". An empty value keeps
+// the default of England and Wales.
+func SetCalendarDivision(value string) error {
+	division, err := calendar.ParseDivision(value)
+	if err != nil {
+		return errors.Errorf("calendar.go: SetCalendarDivision - invalid division %q", value)
+	}
+	calendarDivision = division
+	return nil
+}
+
+// CalendarHandler lists bank holidays through /api/calendar/holidays and
+// previews deadlines counted in working days through
+// /api/calendar/working-days.
+func CalendarHandler(w http.ResponseWriter, r *http.Request, userID uint) {
+	switch r.Method {
+	case http.MethodGet:
+		var response any
+		var err error
+
+		switch strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/api/calendar/"), "/") {
+		case "holidays":
+			response, err = listHolidays(r.URL.Query())
+		case "working-days":
+			loc, locErr := userLocation(userID)
+			if locErr != nil {
+				errors.HandleServerError(w, locErr, "calendar.go: CalendarHandler - userLocation")
+				return
+			}
+			response, err = previewWorkingDays(r.URL.Query(), loc)
+		default:
+			http.Error(w, "Not Found", http.StatusNotFound)
+			return
+		}
+
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			break
+		}
+		writeJSON(w, http.StatusOK, response)
+	case http.MethodOptions:
+		w.Header().Set("Access-Control-Allow-Methods", "GET, OPTIONS")
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// queryDivision reads the division of a calendar query, defaulting to the
+// configured one.
+func queryDivision(values url.Values) (calendar.Division, error) {
+	if value := values.Get("division"); value != "" {
+		return calendar.ParseDivision(value)
+	}
+	return calendarDivision, nil
+}
+
+// listHolidays lists the bank holidays of a year, this year by default.
+func listHolidays(values url.Values) (holidayList, error) {
+	var list holidayList
+
+	division, err := queryDivision(values)
+	if err != nil {
+		return list, err
+	}
+
+	year := time.Now().UTC().Year()
+	if value := values.Get("year"); value != "" {
+		if year, err = strconv.Atoi(value); err != nil || year < 1 || year > 9999 {
+			return list, errors.Error("Invalid Year")
+		}
+	}
+
+	return holidayList{Division: division, Year: year, Holidays: calendar.Current().Holidays(division, year)}, nil
+}
+
+// previewWorkingDays gives the deadline a number of working days after start,
+// which defaults to now. Times are read and shown in loc.
+func previewWorkingDays(values url.Values, loc *time.Location) (workingDaysPreview, error) {
+	var preview workingDaysPreview
+
+	division, err := queryDivision(values)
+	if err != nil {
+		return preview, err
+	}
+
+	days, err := strconv.Atoi(values.Get("days"))
+	if err != nil || days < 0 || days > maxWorkingDays {
+		return preview, errInvalidWorkingDays
+	}
+
+	start := time.Now().UTC().Truncate(time.Second)
+	if value := values.Get("start"); value != "" {
+		var ok bool
+		if start, ok = parseTaskTime(value, loc); !ok {
+			return preview, errors.Error("Invalid Start")
+		}
+	}
+
+	return workingDaysPreview{
+		Division: division,
+		Start:    start.In(loc),
+		Days:     days,
+		Deadline: calendar.Current().AddWorkingDays(division, start.In(courtLocation), days).In(loc),
+	}, nil
+}
+
+// workingDaysDeadline returns the deadline n working days from now, at the
+// current time of day in the courts' zone.
+func workingDaysDeadline(n int) (time.Time, error) {
+	if n < 0 || n > maxWorkingDays {
+		return time.Time{}, errInvalidWorkingDays
+	}
+	now := time.Now().In(courtLocation).Truncate(time.Second)
+	return calendar.Current().AddWorkingDays(calendarDivision, now, n), nil
+}
+
+// nonWorkingDeadline says why a deadline falls on a day off in the courts'
+// zone, or returns an empty string when it falls on a working day.
+func nonWorkingDeadline(deadline time.Time) string {
+	if deadline.IsZero() {
+		return ""
+	}
+	return calendar.Current().NonWorkingReason(calendarDivision, deadline.In(courtLocation))
+}
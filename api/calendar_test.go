@@ -0,0 +1,150 @@
+package api
+
+import (
+	"HMCTS-Developer-Challenge/calendar"
+	"HMCTS-Developer-Challenge/database"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func performCalendarRequest(t *testing.T, url string) *httptest.ResponseRecorder {
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr := httptest.NewRecorder()
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		CalendarHandler(w, r, 1)
+	})
+	handler.ServeHTTP(rr, req)
+	return rr
+}
+
+func TestCalendarHandler(t *testing.T) {
+	rr := performCalendarRequest(t, "/api/calendar/holidays?division=scotland&year=2025")
+	if rr.Code != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusOK)
+	}
+	var list holidayList
+	if err := json.Unmarshal(rr.Body.Bytes(), &list); err != nil {
+		t.Fatalf("Failed to parse response as JSON: %v", err)
+	}
+	if list.Division != calendar.Scotland || list.Year != 2025 || len(list.Holidays) != 9 || list.Holidays[1].Title != "2nd January" {
+		t.Errorf("Expected the 9 Scottish bank holidays of 2025, got %+v", list)
+	}
+
+	rr = performCalendarRequest(t, "/api/calendar/working-days?start=2025-12-24T17:00&days=1")
+	if rr.Code != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v, %s", rr.Code, http.StatusOK, rr.Body.String())
+	}
+	var preview workingDaysPreview
+	if err := json.Unmarshal(rr.Body.Bytes(), &preview); err != nil {
+		t.Fatalf("Failed to parse response as JSON: %v", err)
+	}
+	if preview.Deadline.Format(time.RFC3339) != "2025-12-29T17:00:00Z" || preview.Division != calendar.EnglandAndWales {
+		t.Errorf("Expected the Monday after Boxing Day, got %+v", preview)
+	}
+
+	for _, url := range []string{
+		"/api/calendar/holidays?division=wales",
+		"/api/calendar/holidays?year=soon",
+		"/api/calendar/working-days?days=-1",
+		"/api/calendar/working-days?days=366",
+		"/api/calendar/working-days?days=1&start=soon",
+	} {
+		if rr := performCalendarRequest(t, url); rr.Code != http.StatusBadRequest {
+			t.Errorf("%s: handler returned wrong status code: got %v want %v", url, rr.Code, http.StatusBadRequest)
+		}
+	}
+	if rr := performCalendarRequest(t, "/api/calendar/unknown"); rr.Code != http.StatusNotFound {
+		t.Errorf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusNotFound)
+	}
+}
+
+func TestSetCalendarDivision(t *testing.T) {
+	t.Cleanup(func() { SetCalendarDivision("") })
+
+	if err := SetCalendarDivision("northern-ireland"); err != nil || calendarDivision != calendar.NorthernIreland {
+		t.Errorf("Expected Northern Ireland, got %s (%v)", calendarDivision, err)
+	}
+	if nonWorkingDeadline(time.Date(2025, 7, 14, 9, 0, 0, 0, time.UTC)) == "" {
+		t.Error("Expected the Battle of the Boyne holiday to be a day off in Northern Ireland")
+	}
+	if err := SetCalendarDivision("wales"); err == nil {
+		t.Error("Expected an error for an unknown division")
+	}
+	if err := SetCalendarDivision(""); err != nil || calendarDivision != calendar.EnglandAndWales {
+		t.Errorf("Expected the default of England and Wales, got %s (%v)", calendarDivision, err)
+	}
+}
+
+func TestNonWorkingDeadline(t *testing.T) {
+	tests := map[string]string{
+		"2025-06-14T10:00:00Z": "Saturday",
+		"2025-06-16T10:00:00Z": "",
+		"2025-08-25T22:59:00Z": "Summer bank holiday",
+		// Just after midnight on Saturday in London
+		"2025-06-13T23:30:00Z": "Saturday",
+		"2025-06-15T23:30:00Z": "",
+	}
+	for value, expected := range tests {
+		deadline, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := nonWorkingDeadline(deadline); got != expected {
+			t.Errorf("%s: expected %q, got %q", value, expected, got)
+		}
+	}
+	if got := nonWorkingDeadline(time.Time{}); got != "" {
+		t.Errorf("Expected no reason without a deadline, got %q", got)
+	}
+}
+
+func TestWorkingDaysDeadline(t *testing.T) {
+	deadline, err := workingDaysDeadline(3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !calendar.Current().IsWorkingDay(calendarDivision, deadline) || !deadline.After(time.Now().AddDate(0, 0, 2)) {
+		t.Errorf("Expected a working day at least 3 days away, got %s", deadline)
+	}
+
+	if _, err := workingDaysDeadline(-1); err != errInvalidWorkingDays {
+		t.Errorf("Expected %v, got %v", errInvalidWorkingDays, err)
+	}
+}
+
+func TestCreateTaskInWorkingDays(t *testing.T) {
+	db, err := database.GetDBHandle()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Exec("DELETE FROM tasks WHERE name = 'Working days task'") })
+
+	body := []byte(`{"name": "Working days task", "status": "INCOMPLETE", "deadline_working_days": 2}`)
+	if rr := performTaskRequest(t, "POST", "/api/tasks", body, nil, 1); rr.Code != http.StatusCreated {
+		t.Fatalf("handler returned wrong status code: got %v want %v, %s", rr.Code, http.StatusCreated, rr.Body.String())
+	}
+
+	var id string
+	if err := db.QueryRow("SELECT id FROM tasks WHERE name = 'Working days task'").Scan(&id); err != nil {
+		t.Fatal(err)
+	}
+	created, err := getTask(1, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if created.NonWorkingDeadline != "" {
+		t.Errorf("Expected the deadline on a working day, got %s (%s)", created.Deadline, created.NonWorkingDeadline)
+	}
+
+	body = []byte(`{"name": "Too far", "status": "INCOMPLETE", "deadline_working_days": 400}`)
+	if rr := performTaskRequest(t, "POST", "/api/tasks", body, nil, 1); rr.Code != http.StatusBadRequest {
+		t.Errorf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusBadRequest)
+	}
+}
@@ -0,0 +1,786 @@
+package api
+
+import (
+	"HMCTS-Developer-Challenge/database"
+	"HMCTS-Developer-Challenge/errors"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	maxCaseTypeLength  = 64
+	maxPartyNameLength = 255
+	maxPartyRoleLength = 32
+)
+
+// Cases move from OPEN to CLOSED, and may be STAYED in between while the
+// proceedings are paused.
+const (
+	caseStatusOpen   = "OPEN"
+	caseStatusStayed = "STAYED"
+	caseStatusClosed = "CLOSED"
+)
+
+var caseStatuses = []string{caseStatusOpen, caseStatusStayed, caseStatusClosed}
+
+// ccdReferencePattern matches the 16 digit references given to cases in the
+// Core Case Data service, which also end in a Luhn check digit. Dashes and
+// spaces between the groups of four are dropped before matching.
+var ccdReferencePattern = regexp.MustCompile(`^\d{16}$`)
+
+// caseReferencePatterns lists the reference formats accepted in each
+// jurisdiction besides CCD references. References are upper cased before
+// they are matched.
+var caseReferencePatterns = map[string]*regexp.Regexp{
+	// County Court claim numbers, e.g. K00CL123 or A1QZ1234
+	"CIVIL": regexp.MustCompile(`^[A-Z0-9]{8}$`),
+	// Family Court case numbers: court code, year, case type and serial
+	// number, e.g. ZW24C50123
+	"FAMILY": regexp.MustCompile(`^[A-Z]{2}\d{2}[A-Z]\d{5}$`),
+	// Crown Court case numbers for trials, sentences and appeals, e.g.
+	// T20240123
+	"CRIME": regexp.MustCompile(`^[TSA]\d{8}$`),
+	// Employment Tribunal case numbers, e.g. 6012345/2024
+	"EMPLOYMENT": regexp.MustCompile(`^\d{7}/\d{4}$`),
+	// Immigration and Asylum Chamber appeal numbers, e.g. PA/12345/2024
+	"IMMIGRATION": regexp.MustCompile(`^[A-Z]{2}/\d{5}/\d{4}$`),
+}
+
+// courtCase is a case that tasks are about. It is not called case because
+// that is a keyword.
+type courtCase struct {
+	ID           uint        `json:"id"`
+	Reference    string      `json:"reference"`
+	Jurisdiction string      `json:"jurisdiction"`
+	CaseType     string      `json:"case_type"`
+	Status       string      `json:"status"`
+	Parties      []caseParty `json:"parties"`
+	CreatedBy    *uint       `json:"created_by"`
+	CreatedAt    time.Time   `json:"created_at"`
+}
+
+// caseParty is someone involved in a case, e.g. the applicant or the
+// respondent.
+type caseParty struct {
+	Name string `json:"name"`
+	Role string `json:"role"`
+}
+
+type caseData struct {
+	Reference    string      `json:"reference"`
+	Jurisdiction string      `json:"jurisdiction"`
+	CaseType     string      `json:"case_type"`
+	Status       string      `json:"status"`
+	Parties      []caseParty `json:"parties"`
+}
+
+type taskCaseData struct {
+	// CaseID is the case to link the task to, or null to unlink it.
+	CaseID *uint `json:"case_id"`
+}
+
+// caseView is a case with the tasks about it that the user can see, grouped
+// by status in workflow order.
+type caseView struct {
+	Case  courtCase       `json:"case"`
+	Tasks []caseTaskGroup `json:"tasks"`
+	Total int             `json:"total"`
+}
+
+type caseTaskGroup struct {
+	Status string `json:"status"`
+	Tasks  []task `json:"tasks"`
+}
+
+var errCaseNotFound = errors.Error("Case Not Found")
+var errCaseExists = errors.Error("Case Already Exists")
+var errInvalidJurisdiction = errors.Error("Invalid Jurisdiction")
+var errInvalidCaseReference = errors.Error("Invalid Case Reference")
+var errInvalidCaseStatus = errors.Error("Invalid Case Status")
+var errInvalidCaseParty = errors.Error("Invalid Case Party")
+var errInvalidCaseType = errors.Error("Invalid Case Type")
+var errCaseForbidden = errors.Error("Not Allowed To Change Case")
+
+func CasesHandler(w http.ResponseWriter, r *http.Request, userID uint) {
+	pathParts := strings.Split(strings.TrimSuffix(r.URL.Path, "/"), "/")
+	caseID := ""
+	if len(pathParts) > 3 {
+		caseID = pathParts[3]
+	}
+
+	// The case view lives under /api/cases/{id}/tasks and hearings under
+	// /api/cases/{id}/hearings/{hearing_id}
+	if len(pathParts) > 4 {
+		switch {
+		case pathParts[4] == "tasks" && len(pathParts) == 5:
+			caseTasksHandler(w, r, userID, caseID)
+		case pathParts[4] == "hearings" && len(pathParts) <= 6:
+			hearingID := ""
+			if len(pathParts) == 6 {
+				hearingID = pathParts[5]
+			}
+			hearingsHandler(w, r, userID, caseID, hearingID)
+		default:
+			http.Error(w, "Not Found", http.StatusNotFound)
+		}
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		var cases any
+		var err error
+		if caseID == "" {
+			cases, err = getCases(r.URL.Query().Get("jurisdiction"), r.URL.Query().Get("status"))
+			if err == errInvalidJurisdiction || err == errInvalidCaseStatus {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				break
+			}
+		} else {
+			cases, err = getCase(caseID)
+			if err == errCaseNotFound {
+				http.Error(w, err.Error(), http.StatusNotFound)
+				break
+			}
+		}
+
+		if err != nil {
+			errors.HandleServerError(w, err, "cases.go: CasesHandler - getCases")
+			break
+		}
+		writeJSON(w, http.StatusOK, cases)
+	case http.MethodPost:
+		if caseID != "" {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			break
+		}
+
+		var data caseData
+		if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+			http.Error(w, "Invalid JSON", http.StatusBadRequest)
+			break
+		}
+
+		c, err := addCase(userID, data)
+		if err == errInvalidJurisdiction || err == errInvalidCaseReference || err == errInvalidCaseStatus || err == errInvalidCaseParty || err == errInvalidCaseType {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			break
+		} else if err == errCaseExists {
+			http.Error(w, err.Error(), http.StatusConflict)
+			break
+		} else if err != nil {
+			errors.HandleServerError(w, err, "cases.go: CasesHandler - addCase")
+			break
+		}
+		writeJSON(w, http.StatusCreated, c)
+	case http.MethodPut:
+		if caseID == "" {
+			http.Error(w, "Case ID Required", http.StatusBadRequest)
+			break
+		}
+
+		var data caseData
+		if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+			http.Error(w, "Invalid JSON", http.StatusBadRequest)
+			break
+		}
+
+		c, err := editCase(userID, caseID, data)
+		if err == errCaseNotFound {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			break
+		} else if err == errCaseForbidden {
+			forbidden(w, r, userID, err)
+			break
+		} else if err == errInvalidJurisdiction || err == errInvalidCaseReference || err == errInvalidCaseStatus || err == errInvalidCaseParty || err == errInvalidCaseType {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			break
+		} else if err == errCaseExists {
+			http.Error(w, err.Error(), http.StatusConflict)
+			break
+		} else if err != nil {
+			errors.HandleServerError(w, err, "cases.go: CasesHandler - editCase")
+			break
+		}
+		writeJSON(w, http.StatusOK, c)
+	case http.MethodDelete:
+		if caseID == "" {
+			http.Error(w, "Case ID Required", http.StatusBadRequest)
+			break
+		}
+
+		if err := deleteCase(userID, caseID, parseTaskEditOptions(r)); err == errCaseNotFound {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			break
+		} else if err == errCaseForbidden {
+			forbidden(w, r, userID, err)
+			break
+		} else if err != nil {
+			errors.HandleServerError(w, err, "cases.go: CasesHandler - deleteCase")
+			break
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	case http.MethodOptions:
+		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func caseTasksHandler(w http.ResponseWriter, r *http.Request, userID uint, caseID string) {
+	switch r.Method {
+	case http.MethodGet:
+		view, err := getCaseView(userID, caseID)
+		if err == errCaseNotFound {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			break
+		} else if err != nil {
+			errors.HandleServerError(w, err, "cases.go: caseTasksHandler - getCaseView")
+			break
+		}
+		writeJSON(w, http.StatusOK, view)
+	case http.MethodOptions:
+		w.Header().Set("Access-Control-Allow-Methods", "GET, OPTIONS")
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// taskCaseHandler links a task to a case, or unlinks it, through
+// /api/tasks/{id}/case.
+func taskCaseHandler(w http.ResponseWriter, r *http.Request, userID uint, taskID string) {
+	switch r.Method {
+	case http.MethodPut:
+		var data taskCaseData
+		if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+			http.Error(w, "Invalid JSON", http.StatusBadRequest)
+			break
+		}
+
+		t, err := setTaskCase(userID, taskID, data.CaseID, parseTaskEditOptions(r))
+		if err == errTaskNotFound {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			break
+		} else if err == errCaseNotFound {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			break
+		} else if err == errPreconditionFailed {
+			http.Error(w, err.Error(), http.StatusPreconditionFailed)
+			break
+		} else if err != nil {
+			errors.HandleServerError(w, err, "cases.go: taskCaseHandler - setTaskCase")
+			break
+		}
+
+		w.Header().Set("ETag", taskETag(t))
+		writeJSON(w, http.StatusOK, t)
+	case http.MethodOptions:
+		w.Header().Set("Access-Control-Allow-Methods", "PUT, OPTIONS")
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// normalizeCaseReference checks a reference against the formats of its
+// jurisdiction and returns both in the form they are stored in.
+func normalizeCaseReference(jurisdiction, reference string) (string, string, error) {
+	jurisdiction = strings.ToUpper(strings.TrimSpace(jurisdiction))
+	pattern, ok := caseReferencePatterns[jurisdiction]
+	if !ok {
+		return "", "", errInvalidJurisdiction
+	}
+
+	reference = strings.ToUpper(strings.TrimSpace(reference))
+	if pattern.MatchString(reference) {
+		return jurisdiction, reference, nil
+	}
+
+	// Criminal cases are not held in CCD
+	ccd := strings.NewReplacer("-", "", " ", "").Replace(reference)
+	if jurisdiction != "CRIME" && ccdReferencePattern.MatchString(ccd) && luhnValid(ccd) {
+		return jurisdiction, ccd, nil
+	}
+	return "", "", errInvalidCaseReference
+}
+
+// luhnValid reports whether a string of digits ends in a valid Luhn check
+// digit.
+func luhnValid(digits string) bool {
+	sum := 0
+	double := false
+	for i := len(digits) - 1; i >= 0; i-- {
+		d := int(digits[i] - '0')
+		if double {
+			if d *= 2; d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+		double = !double
+	}
+	return sum%10 == 0
+}
+
+func normalizeCaseStatus(status string) (string, error) {
+	status = strings.ToUpper(strings.TrimSpace(status))
+	if status == "" {
+		return caseStatusOpen, nil
+	}
+	if !slices.Contains(caseStatuses, status) {
+		return "", errInvalidCaseStatus
+	}
+	return status, nil
+}
+
+// normalizeCaseData validates everything about a case that does not need
+// the database.
+func normalizeCaseData(data caseData) (caseData, error) {
+	var err error
+	if data.Jurisdiction, data.Reference, err = normalizeCaseReference(data.Jurisdiction, data.Reference); err != nil {
+		return data, err
+	}
+	if data.Status, err = normalizeCaseStatus(data.Status); err != nil {
+		return data, err
+	}
+
+	data.CaseType = strings.TrimSpace(data.CaseType)
+	if utf8.RuneCountInString(data.CaseType) > maxCaseTypeLength {
+		return data, errInvalidCaseType
+	}
+
+	parties := make([]caseParty, len(data.Parties))
+	for i, party := range data.Parties {
+		party.Name = strings.TrimSpace(party.Name)
+		party.Role = strings.TrimSpace(party.Role)
+		if party.Name == "" || utf8.RuneCountInString(party.Name) > maxPartyNameLength || utf8.RuneCountInString(party.Role) > maxPartyRoleLength {
+			return data, errInvalidCaseParty
+		}
+		parties[i] = party
+	}
+	data.Parties = parties
+	return data, nil
+}
+
+func getCases(jurisdiction, status string) ([]courtCase, error) {
+	cases := []courtCase{}
+
+	var clauses []string
+	var args []any
+	if jurisdiction != "" {
+		jurisdiction = strings.ToUpper(jurisdiction)
+		if _, ok := caseReferencePatterns[jurisdiction]; !ok {
+			return cases, errInvalidJurisdiction
+		}
+		clauses = append(clauses, "jurisdiction = ?")
+		args = append(args, jurisdiction)
+	}
+	if status != "" {
+		status = strings.ToUpper(status)
+		if !slices.Contains(caseStatuses, status) {
+			return cases, errInvalidCaseStatus
+		}
+		clauses = append(clauses, "status = ?")
+		args = append(args, status)
+	}
+
+	statement := "SELECT id, reference, jurisdiction, case_type, status, created_by, created_at FROM cases"
+	if len(clauses) > 0 {
+		statement += " WHERE " + strings.Join(clauses, " AND ")
+	}
+
+	dbHandle, err := database.GetDBHandle()
+	if err != nil {
+		return cases, errors.AddContext(err, "cases.go: getCases - GetDBHandle")
+	}
+
+	rows, err := dbHandle.Query(statement+" ORDER BY jurisdiction, reference", args...)
+	if err != nil {
+		return cases, errors.AddContext(err, "cases.go: getCases - Query")
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		c, err := scanCase(rows)
+		if err != nil {
+			return cases, errors.AddContext(err, "cases.go: getCases - Scan")
+		}
+		cases = append(cases, c)
+	}
+	if err := rows.Err(); err != nil {
+		return cases, errors.AddContext(err, "cases.go: getCases - Rows")
+	}
+
+	if err := loadCaseParties(dbHandle, cases); err != nil {
+		return cases, errors.AddContext(err, "cases.go: getCases - loadCaseParties")
+	}
+	return cases, nil
+}
+
+func getCase(caseID string) (courtCase, error) {
+	dbHandle, err := database.GetDBHandle()
+	if err != nil {
+		return courtCase{}, errors.AddContext(err, "cases.go: getCase - GetDBHandle")
+	}
+
+	c, err := scanCase(dbHandle.QueryRow("SELECT id, reference, jurisdiction, case_type, status, created_by, created_at FROM cases WHERE id = ?", caseID))
+	if err == sql.ErrNoRows {
+		return c, errCaseNotFound
+	} else if err != nil {
+		return c, errors.AddContext(err, "cases.go: getCase - QueryRow")
+	}
+
+	cases := []courtCase{c}
+	if err := loadCaseParties(dbHandle, cases); err != nil {
+		return c, errors.AddContext(err, "cases.go: getCase - loadCaseParties")
+	}
+	return cases[0], nil
+}
+
+func scanCase(row rowScanner) (courtCase, error) {
+	var c courtCase
+	err := row.Scan(&c.ID, &c.Reference, &c.Jurisdiction, &c.CaseType, &c.Status, &c.CreatedBy, &c.CreatedAt)
+	return c, err
+}
+
+// loadCaseParties fills in the parties of each case in the order they were
+// given.
+func loadCaseParties(q queryer, cases []courtCase) error {
+	if len(cases) == 0 {
+		return nil
+	}
+
+	byID := make(map[uint]*courtCase, len(cases))
+	args := make([]any, len(cases))
+	for i := range cases {
+		cases[i].Parties = []caseParty{}
+		byID[cases[i].ID] = &cases[i]
+		args[i] = cases[i].ID
+	}
+
+	rows, err := q.Query(
+		"SELECT case_id, name, role FROM case_parties WHERE case_id IN (?"+strings.Repeat(", ?", len(cases)-1)+") ORDER BY case_id, position",
+		args...,
+	)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var caseID uint
+		var party caseParty
+		if err := rows.Scan(&caseID, &party.Name, &party.Role); err != nil {
+			return err
+		}
+		byID[caseID].Parties = append(byID[caseID].Parties, party)
+	}
+	return rows.Err()
+}
+
+func addCase(userID uint, data caseData) (courtCase, error) {
+	data, err := normalizeCaseData(data)
+	if err != nil {
+		return courtCase{}, err
+	}
+
+	dbHandle, err := database.GetDBHandle()
+	if err != nil {
+		return courtCase{}, errors.AddContext(err, "cases.go: addCase - GetDBHandle")
+	}
+
+	tx, err := dbHandle.Begin()
+	if err != nil {
+		return courtCase{}, errors.AddContext(err, "cases.go: addCase - Begin")
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(
+		"INSERT INTO cases (reference, jurisdiction, case_type, status, created_by) VALUES (?, ?, ?, ?, ?)",
+		data.Reference, data.Jurisdiction, data.CaseType, data.Status, userID,
+	)
+	if isDuplicateEntry(err) {
+		return courtCase{}, errCaseExists
+	} else if err != nil {
+		return courtCase{}, errors.AddContext(err, "cases.go: addCase - Exec")
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return courtCase{}, errors.AddContext(err, "cases.go: addCase - LastInsertId")
+	}
+
+	if err := setCaseParties(tx, id, data.Parties); err != nil {
+		return courtCase{}, errors.AddContext(err, "cases.go: addCase - setCaseParties")
+	}
+
+	if err := tx.Commit(); err != nil {
+		return courtCase{}, errors.AddContext(err, "cases.go: addCase - Commit")
+	}
+	return getCase(strconv.FormatInt(id, 10))
+}
+
+func editCase(userID uint, caseID string, data caseData) (courtCase, error) {
+	data, err := normalizeCaseData(data)
+	if err != nil {
+		return courtCase{}, err
+	}
+
+	dbHandle, err := database.GetDBHandle()
+	if err != nil {
+		return courtCase{}, errors.AddContext(err, "cases.go: editCase - GetDBHandle")
+	}
+
+	tx, err := dbHandle.Begin()
+	if err != nil {
+		return courtCase{}, errors.AddContext(err, "cases.go: editCase - Begin")
+	}
+	defer tx.Rollback()
+
+	id, err := lockManagedCase(tx, userID, caseID)
+	if err != nil {
+		return courtCase{}, err
+	}
+
+	if _, err := tx.Exec(
+		"UPDATE cases SET reference = ?, jurisdiction = ?, case_type = ?, status = ? WHERE id = ?",
+		data.Reference, data.Jurisdiction, data.CaseType, data.Status, id,
+	); isDuplicateEntry(err) {
+		return courtCase{}, errCaseExists
+	} else if err != nil {
+		return courtCase{}, errors.AddContext(err, "cases.go: editCase - Exec")
+	}
+
+	if err := setCaseParties(tx, id, data.Parties); err != nil {
+		return courtCase{}, errors.AddContext(err, "cases.go: editCase - setCaseParties")
+	}
+
+	if err := tx.Commit(); err != nil {
+		return courtCase{}, errors.AddContext(err, "cases.go: editCase - Commit")
+	}
+	return getCase(caseID)
+}
+
+// setCaseParties replaces the parties of a case.
+func setCaseParties(tx *sql.Tx, caseID any, parties []caseParty) error {
+	if _, err := tx.Exec("DELETE FROM case_parties WHERE case_id = ?", caseID); err != nil {
+		return err
+	}
+	for i, party := range parties {
+		if _, err := tx.Exec("INSERT INTO case_parties (case_id, position, name, role) VALUES (?, ?, ?, ?)", caseID, i, party.Name, party.Role); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// deleteCase removes a case with its hearings. Its tasks are kept, unlinked
+// from it by the foreign key, and lose any deadline that followed one of its
+// hearings.
+func deleteCase(userID uint, caseID string, options taskEditOptions) error {
+	dbHandle, err := database.GetDBHandle()
+	if err != nil {
+		return errors.AddContext(err, "cases.go: deleteCase - GetDBHandle")
+	}
+
+	tx, err := dbHandle.Begin()
+	if err != nil {
+		return errors.AddContext(err, "cases.go: deleteCase - Begin")
+	}
+	defer tx.Rollback()
+
+	id, err := lockManagedCase(tx, userID, caseID)
+	if err != nil {
+		return err
+	}
+
+	changes, err := trackTaskQuery(tx, "SELECT id FROM tasks WHERE case_id = ? ORDER BY id", id)
+	if err != nil {
+		return errors.AddContext(err, "cases.go: deleteCase - trackTaskQuery")
+	}
+
+	if _, err := tx.Exec(
+		"UPDATE tasks SET hearing_id = NULL, hearing_offset = NULL, hearing_offset_unit = NULL, version = version + 1 WHERE case_id = ?", id,
+	); err != nil {
+		return errors.AddContext(err, "cases.go: deleteCase - Exec touch")
+	}
+	if _, err := tx.Exec("DELETE FROM cases WHERE id = ?", id); err != nil {
+		return errors.AddContext(err, "cases.go: deleteCase - Exec")
+	}
+	if err := changes.record(tx, userID, options); err != nil {
+		return errors.AddContext(err, "cases.go: deleteCase - record")
+	}
+
+	if err := tx.Commit(); err != nil {
+		return errors.AddContext(err, "cases.go: deleteCase - Commit")
+	}
+	return nil
+}
+
+// lockManagedCase locks a case the user is about to change. Only the user who
+// created it, admins and leads may change a case; everyone else can link
+// tasks to it.
+func lockManagedCase(tx *sql.Tx, userID uint, caseID string) (uint, error) {
+	var id uint
+	var createdBy *uint
+	if err := tx.QueryRow("SELECT id, created_by FROM cases WHERE id = ? FOR UPDATE", caseID).Scan(&id, &createdBy); err == sql.ErrNoRows {
+		return 0, errCaseNotFound
+	} else if err != nil {
+		return 0, errors.AddContext(err, "cases.go: lockManagedCase - QueryRow")
+	}
+	if createdBy != nil && *createdBy == userID {
+		return id, nil
+	}
+
+	if role, err := userRole(tx, userID); err != nil {
+		return 0, errors.AddContext(err, "cases.go: lockManagedCase - userRole")
+	} else if role != RoleAdmin && role != RoleLead {
+		return 0, errCaseForbidden
+	}
+	return id, nil
+}
+
+func caseExists(q rowQueryer, caseID uint) (bool, error) {
+	var exists bool
+	err := q.QueryRow("SELECT EXISTS(SELECT 1 FROM cases WHERE id = ?)", caseID).Scan(&exists)
+	return exists, err
+}
+
+// getCaseView returns a case with the tasks linked to it that the user can
+// see.
+func getCaseView(userID uint, caseID string) (caseView, error) {
+	c, err := getCase(caseID)
+	if err != nil {
+		return caseView{}, err
+	}
+
+	dbHandle, err := database.GetDBHandle()
+	if err != nil {
+		return caseView{}, errors.AddContext(err, "cases.go: getCaseView - GetDBHandle")
+	}
+
+	access, accessArgs := taskAccess(userID)
+	rows, err := dbHandle.Query(
+		"SELECT "+taskColumns+" FROM tasks WHERE case_id = ? AND "+access+" ORDER BY deadline, id",
+		append([]any{c.ID}, accessArgs...)...,
+	)
+	if err != nil {
+		return caseView{}, errors.AddContext(err, "cases.go: getCaseView - Query")
+	}
+	defer rows.Close()
+
+	var tasks []task
+	for rows.Next() {
+		t, err := scanTask(rows)
+		if err != nil {
+			return caseView{}, errors.AddContext(err, "cases.go: getCaseView - Scan")
+		}
+		tasks = append(tasks, t)
+	}
+	if err := rows.Err(); err != nil {
+		return caseView{}, errors.AddContext(err, "cases.go: getCaseView - Rows")
+	}
+
+	pointers := make([]*task, len(tasks))
+	for i := range tasks {
+		pointers[i] = &tasks[i]
+	}
+	if err := loadTaskDetails(userID, pointers...); err != nil {
+		return caseView{}, errors.AddContext(err, "cases.go: getCaseView - loadTaskDetails")
+	}
+
+	return caseView{Case: c, Tasks: groupTasksByStatus(tasks, workflow.names()), Total: len(tasks)}, nil
+}
+
+// groupTasksByStatus groups tasks by status, keeping their order within each
+// group. Groups follow the order of statuses, and statuses that are no longer
+// part of the workflow come last in alphabetical order. Empty groups are left
+// out.
+func groupTasksByStatus(tasks []task, statuses []string) []caseTaskGroup {
+	byStatus := map[string][]task{}
+	var others []string
+	for _, t := range tasks {
+		if _, ok := byStatus[t.Status]; !ok && !slices.Contains(statuses, t.Status) {
+			others = append(others, t.Status)
+		}
+		byStatus[t.Status] = append(byStatus[t.Status], t)
+	}
+	sort.Strings(others)
+
+	groups := []caseTaskGroup{}
+	for _, status := range append(slices.Clone(statuses), others...) {
+		if len(byStatus[status]) > 0 {
+			groups = append(groups, caseTaskGroup{Status: status, Tasks: byStatus[status]})
+		}
+	}
+	return groups
+}
+
+// setTaskCase links a task the user can see to a case, or unlinks it when
+// caseID is nil.
+func setTaskCase(userID uint, taskID string, caseID *uint, options taskEditOptions) (task, error) {
+	current, err := getTask(userID, taskID)
+	if err != nil {
+		return current, err
+	}
+	if sameUser(current.CaseID, caseID) {
+		return current, nil
+	}
+
+	dbHandle, err := database.GetDBHandle()
+	if err != nil {
+		return current, errors.AddContext(err, "cases.go: setTaskCase - GetDBHandle")
+	}
+
+	if caseID != nil {
+		if exists, err := caseExists(dbHandle, *caseID); err != nil {
+			return current, errors.AddContext(err, "cases.go: setTaskCase - caseExists")
+		} else if !exists {
+			return current, errCaseNotFound
+		}
+	}
+
+	tx, err := dbHandle.Begin()
+	if err != nil {
+		return current, errors.AddContext(err, "cases.go: setTaskCase - Begin")
+	}
+	defer tx.Rollback()
+
+	changes, err := trackTasks(tx, current.ID)
+	if err != nil {
+		return current, errors.AddContext(err, "cases.go: setTaskCase - trackTasks")
+	}
+
+	// The hearing a deadline follows belongs to the old case
+	result, err := tx.Exec(
+		"UPDATE tasks SET case_id = ?, hearing_id = NULL, hearing_offset = NULL, hearing_offset_unit = NULL, version = version + 1 WHERE id = ? AND version = ?",
+		caseID, current.ID, current.Version,
+	)
+	if err != nil {
+		return current, errors.AddContext(err, "cases.go: setTaskCase - Exec")
+	}
+	if affected, err := result.RowsAffected(); err != nil {
+		return current, errors.AddContext(err, "cases.go: setTaskCase - RowsAffected")
+	} else if affected == 0 {
+		return current, errPreconditionFailed
+	}
+
+	if err := changes.record(tx, userID, options); err != nil {
+		return current, errors.AddContext(err, "cases.go: setTaskCase - record")
+	}
+	if err := tx.Commit(); err != nil {
+		return current, errors.AddContext(err, "cases.go: setTaskCase - Commit")
+	}
+	return getTask(userID, taskID)
+}
@@ -0,0 +1,247 @@
+package api
+
+import (
+	"HMCTS-Developer-Challenge/database"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"testing"
+)
+
+func createTestCase(t *testing.T, jurisdiction, reference string) courtCase {
+	return createTestResource[courtCase](t, CasesHandler, "/api/cases", fmt.Sprintf(`{"jurisdiction": %q, "reference": %q}`, jurisdiction, reference), 1, "cases")
+}
+
+func TestNormalizeCaseReference(t *testing.T) {
+	valid := []struct {
+		jurisdiction, reference, expected string
+	}{
+		{"civil", " k00cl123 ", "K00CL123"},
+		{"FAMILY", "ZW24C50123", "ZW24C50123"},
+		{"Crime", "T20240123", "T20240123"},
+		{"EMPLOYMENT", "6012345/2024", "6012345/2024"},
+		{"IMMIGRATION", "pa/12345/2024", "PA/12345/2024"},
+		{"FAMILY", "1612-3456-7890-1239", "1612345678901239"},
+		{"CIVIL", "1612 3456 7890 1239", "1612345678901239"},
+	}
+	for _, tc := range valid {
+		jurisdiction, reference, err := normalizeCaseReference(tc.jurisdiction, tc.reference)
+		if err != nil || reference != tc.expected || !slices.Contains([]string{"CIVIL", "FAMILY", "CRIME", "EMPLOYMENT", "IMMIGRATION"}, jurisdiction) {
+			t.Errorf("%s %q: expected %q, got %s %q (%v)", tc.jurisdiction, tc.reference, tc.expected, jurisdiction, reference, err)
+		}
+	}
+
+	invalid := []struct {
+		jurisdiction, reference string
+		err                     error
+	}{
+		{"CIVIL", "K00CL12", errInvalidCaseReference},
+		{"FAMILY", "T20240123", errInvalidCaseReference},
+		{"CRIME", "X20240123", errInvalidCaseReference},
+		{"CRIME", "1612345678901239", errInvalidCaseReference},
+		{"FAMILY", "1612345678901238", errInvalidCaseReference},
+		{"EMPLOYMENT", "601234/2024", errInvalidCaseReference},
+		{"CHANCERY", "K00CL123", errInvalidJurisdiction},
+		{"", "K00CL123", errInvalidJurisdiction},
+	}
+	for _, tc := range invalid {
+		if _, _, err := normalizeCaseReference(tc.jurisdiction, tc.reference); err != tc.err {
+			t.Errorf("%s %q: expected %v, got %v", tc.jurisdiction, tc.reference, tc.err, err)
+		}
+	}
+}
+
+func TestNormalizeCaseData(t *testing.T) {
+	data, err := normalizeCaseData(caseData{
+		Jurisdiction: "family",
+		Reference:    "zw24c50123",
+		CaseType:     " Child arrangements ",
+		Parties:      []caseParty{{Name: " Jane Smith ", Role: "Applicant"}},
+	})
+	if err != nil || data.Status != caseStatusOpen || data.CaseType != "Child arrangements" || data.Parties[0].Name != "Jane Smith" {
+		t.Errorf("Expected a normalised open case, got %+v (%v)", data, err)
+	}
+
+	if _, err := normalizeCaseData(caseData{Jurisdiction: "FAMILY", Reference: "ZW24C50123", Status: "adjourned"}); err != errInvalidCaseStatus {
+		t.Errorf("Expected errInvalidCaseStatus, got %v", err)
+	}
+	if _, err := normalizeCaseData(caseData{Jurisdiction: "FAMILY", Reference: "ZW24C50123", Parties: []caseParty{{Name: " "}}}); err != errInvalidCaseParty {
+		t.Errorf("Expected errInvalidCaseParty, got %v", err)
+	}
+}
+
+func TestGroupTasksByStatus(t *testing.T) {
+	tasks := []task{
+		{ID: 1, Status: "COMPLETE"},
+		{ID: 2, Status: "INCOMPLETE"},
+		{ID: 3, Status: "RETIRED"},
+		{ID: 4, Status: "COMPLETE"},
+	}
+	groups := groupTasksByStatus(tasks, []string{"INCOMPLETE", "IN_PROGRESS", "COMPLETE"})
+
+	var statuses []string
+	for _, group := range groups {
+		statuses = append(statuses, group.Status)
+	}
+	if !slices.Equal(statuses, []string{"INCOMPLETE", "COMPLETE", "RETIRED"}) {
+		t.Fatalf("Expected groups in workflow order without empty ones, got %v", statuses)
+	}
+	if len(groups[1].Tasks) != 2 || groups[1].Tasks[0].ID != 1 || groups[1].Tasks[1].ID != 4 {
+		t.Errorf("Expected the complete tasks in their original order, got %+v", groups[1].Tasks)
+	}
+
+	if groups := groupTasksByStatus(nil, []string{"INCOMPLETE"}); groups == nil || len(groups) != 0 {
+		t.Errorf("Expected an empty list of groups, got %v", groups)
+	}
+}
+
+func TestCasesCRUD(t *testing.T) {
+	created := createTestCase(t, "family", "zw24c50123")
+	if created.Reference != "ZW24C50123" || created.Jurisdiction != "FAMILY" || created.Status != caseStatusOpen {
+		t.Errorf("Expected a normalised open family case, got %+v", created)
+	}
+
+	if rr := performHandlerRequest(t, CasesHandler, "POST", "/api/cases", []byte(`{"jurisdiction": "FAMILY", "reference": "ZW24C50123"}`), nil, 2); rr.Code != http.StatusConflict {
+		t.Errorf("handler returned wrong status code for a duplicate case: got %v want %v", rr.Code, http.StatusConflict)
+	}
+	if rr := performHandlerRequest(t, CasesHandler, "POST", "/api/cases", []byte(`{"jurisdiction": "CRIME", "reference": "ZW24C50123"}`), nil, 1); rr.Code != http.StatusBadRequest {
+		t.Errorf("handler returned wrong status code for an invalid reference: got %v want %v", rr.Code, http.StatusBadRequest)
+	}
+
+	caseURL := fmt.Sprintf("/api/cases/%d", created.ID)
+	body := `{"jurisdiction": "FAMILY", "reference": "ZW24C50123", "case_type": "Divorce", "status": "stayed", "parties": [{"name": "Jane Smith", "role": "Applicant"}, {"name": "John Smith", "role": "Respondent"}]}`
+
+	// Another caseworker can use the case but not change it
+	for _, method := range []string{"PUT", "DELETE"} {
+		if rr := performHandlerRequest(t, CasesHandler, method, caseURL, []byte(body), nil, 2); rr.Code != http.StatusForbidden {
+			t.Errorf("%s: handler returned wrong status code: got %v want %v", method, rr.Code, http.StatusForbidden)
+		}
+	}
+
+	rr := performHandlerRequest(t, CasesHandler, "PUT", caseURL, []byte(body), nil, 1)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v, %s", rr.Code, http.StatusOK, rr.Body.String())
+	}
+
+	rr = performHandlerRequest(t, CasesHandler, "GET", caseURL, nil, nil, 1)
+	var edited courtCase
+	if err := json.Unmarshal(rr.Body.Bytes(), &edited); err != nil {
+		t.Fatalf("Failed to parse response as JSON: %v", err)
+	}
+	if edited.Status != caseStatusStayed || edited.CaseType != "Divorce" || len(edited.Parties) != 2 || edited.Parties[1].Name != "John Smith" {
+		t.Errorf("Expected the edited case with its parties in order, got %+v", edited)
+	}
+
+	rr = performHandlerRequest(t, CasesHandler, "GET", "/api/cases?jurisdiction=family&status=stayed", nil, nil, 1)
+	var cases []courtCase
+	if err := json.Unmarshal(rr.Body.Bytes(), &cases); err != nil {
+		t.Fatalf("Failed to parse response as JSON: %v", err)
+	}
+	if !slices.ContainsFunc(cases, func(c courtCase) bool { return c.ID == created.ID }) {
+		t.Errorf("Expected the case in the filtered list, got %+v", cases)
+	}
+
+	if rr := performHandlerRequest(t, CasesHandler, "DELETE", caseURL, nil, nil, 1); rr.Code != http.StatusNoContent {
+		t.Errorf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusNoContent)
+	}
+	if rr := performHandlerRequest(t, CasesHandler, "GET", caseURL, nil, nil, 1); rr.Code != http.StatusNotFound {
+		t.Errorf("handler returned wrong status code for a deleted case: got %v want %v", rr.Code, http.StatusNotFound)
+	}
+}
+
+func TestCaseTasks(t *testing.T) {
+	created := createTestCase(t, "CIVIL", "K00CL123")
+
+	body := fmt.Sprintf(`{"name": "Case task", "status": "INCOMPLETE", "deadline": "2025-12-31 00:00:00", "case_id": %d}`, created.ID)
+	if rr := performTaskRequest(t, "POST", "/api/tasks", []byte(body), nil, 1); rr.Code != http.StatusCreated {
+		t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusCreated)
+	}
+	if rr := performTaskRequest(t, "POST", "/api/tasks", []byte(`{"name": "Lost task", "status": "INCOMPLETE", "deadline": "2025-12-31 00:00:00", "case_id": 999999}`), nil, 1); rr.Code != http.StatusBadRequest {
+		t.Errorf("handler returned wrong status code for an unknown case: got %v want %v", rr.Code, http.StatusBadRequest)
+	}
+
+	// Link an existing task, then complete it
+	taskID := createTestTask(t, 1, "Linked task")
+	rr := performTaskRequest(t, "PUT", "/api/tasks/"+taskID+"/case", []byte(fmt.Sprintf(`{"case_id": %d}`, created.ID)), nil, 1)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("handler returned wrong status code linking: got %v want %v", rr.Code, http.StatusOK)
+	}
+	if rr := performTaskRequest(t, "PATCH", "/api/tasks/"+taskID, []byte(`{"status": "COMPLETE"}`), map[string]string{"If-Match": rr.Header().Get("ETag")}, 1); rr.Code != http.StatusOK {
+		t.Fatalf("handler returned wrong status code completing: got %v want %v, %s", rr.Code, http.StatusOK, rr.Body.String())
+	}
+	db, err := database.GetDBHandle()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Exec("DELETE FROM tasks WHERE case_id = ?", created.ID) })
+
+	_, page := getTaskPage(t, fmt.Sprintf("/api/tasks/?case=%d", created.ID), 1)
+	if page.Total != 2 {
+		t.Errorf("Expected 2 tasks for the case, got %d", page.Total)
+	}
+
+	rr = performHandlerRequest(t, CasesHandler, "GET", fmt.Sprintf("/api/cases/%d/tasks", created.ID), nil, nil, 1)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusOK)
+	}
+	var view caseView
+	if err := json.Unmarshal(rr.Body.Bytes(), &view); err != nil {
+		t.Fatalf("Failed to parse response as JSON: %v", err)
+	}
+	if view.Case.ID != created.ID || view.Total != 2 || len(view.Tasks) != 2 || view.Tasks[0].Status != "INCOMPLETE" || view.Tasks[1].Status != "COMPLETE" {
+		t.Errorf("Expected one open and one complete task, got %+v", view)
+	}
+
+	// User 2 sees the case but none of user 1's tasks
+	rr = performHandlerRequest(t, CasesHandler, "GET", fmt.Sprintf("/api/cases/%d/tasks", created.ID), nil, nil, 2)
+	if err := json.Unmarshal(rr.Body.Bytes(), &view); err != nil {
+		t.Fatalf("Failed to parse response as JSON: %v", err)
+	}
+	if view.Total != 0 || len(view.Tasks) != 0 {
+		t.Errorf("Expected no tasks for user 2, got %+v", view.Tasks)
+	}
+
+	if rr := performTaskRequest(t, "PUT", "/api/tasks/"+taskID+"/case", []byte(`{"case_id": null}`), nil, 1); rr.Code != http.StatusOK {
+		t.Errorf("handler returned wrong status code unlinking: got %v want %v", rr.Code, http.StatusOK)
+	}
+}
+
+func TestDeleteCaseTouchesTasks(t *testing.T) {
+	created := createTestCase(t, "CIVIL", "K00CL456")
+	taskID := createTestTask(t, 1, "Task on a deleted case")
+	rr := performTaskRequest(t, "PUT", "/api/tasks/"+taskID+"/case", []byte(fmt.Sprintf(`{"case_id": %d}`, created.ID)), nil, 1)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("handler returned wrong status code linking: got %v want %v", rr.Code, http.StatusOK)
+	}
+	etag := rr.Header().Get("ETag")
+
+	// An admin may delete a case someone else created
+	if rr := performHandlerRequest(t, CasesHandler, "DELETE", fmt.Sprintf("/api/cases/%d", created.ID), nil, nil, 3); rr.Code != http.StatusNoContent {
+		t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusNoContent)
+	}
+
+	rr = performTaskRequest(t, "GET", "/api/tasks/"+taskID, nil, map[string]string{"If-None-Match": etag}, 1)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected the task to have changed, got %v", rr.Code)
+	}
+	var unlinked task
+	if err := json.Unmarshal(rr.Body.Bytes(), &unlinked); err != nil {
+		t.Fatalf("Failed to parse response as JSON: %v", err)
+	}
+	if unlinked.CaseID != nil || unlinked.DeadlineRule != nil {
+		t.Errorf("Expected the task to be unlinked, got %+v", unlinked)
+	}
+
+	rr = performTaskRequest(t, "GET", "/api/tasks/"+taskID+"/history", nil, nil, 1)
+	var events []taskEvent
+	if err := json.Unmarshal(rr.Body.Bytes(), &events); err != nil {
+		t.Fatalf("Failed to parse response as JSON: %v", err)
+	}
+	if n := len(events); n == 0 || events[n-1].Version != unlinked.Version || events[n-1].ActorID == nil || *events[n-1].ActorID != 3 {
+		t.Fatalf("Expected the unlinking to be recorded by user 3, got %+v", events)
+	}
+	if _, ok := events[len(events)-1].Changes["case_id"]; !ok {
+		t.Errorf("Expected the case_id change to be recorded, got %+v", events[len(events)-1].Changes)
+	}
+}
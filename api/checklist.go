@@ -0,0 +1,275 @@
+package api
+
+import (
+	"HMCTS-Developer-Challenge/database"
+	"HMCTS-Developer-Challenge/errors"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"unicode/utf8"
+)
+
+const maxChecklistItemLength = 255
+
+type checklistItem struct {
+	ID       uint   `json:"id"`
+	TaskID   uint   `json:"task_id"`
+	Text     string `json:"text"`
+	Done     bool   `json:"done"`
+	Position uint   `json:"position"`
+}
+
+type checklistItemData struct {
+	Text string `json:"text"`
+	Done bool   `json:"done"`
+}
+
+var errChecklistItemNotFound = errors.Error("Checklist Item Not Found")
+var errInvalidChecklistItem = errors.Error("Invalid Checklist Item")
+
+const checklistColumns = "id, task_id, text, done, position"
+
+func checklistHandler(w http.ResponseWriter, r *http.Request, userID uint, taskID string, itemID string) {
+	switch r.Method {
+	case http.MethodGet:
+		if itemID != "" {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			break
+		}
+
+		items, err := getChecklist(userID, taskID)
+		if err == errTaskNotFound {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			break
+		} else if err != nil {
+			errors.HandleServerError(w, err, "checklist.go: checklistHandler - getChecklist")
+			break
+		}
+		writeJSON(w, http.StatusOK, items)
+	case http.MethodPost:
+		if itemID != "" {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			break
+		}
+
+		var data checklistItemData
+		if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+			http.Error(w, "Invalid JSON", http.StatusBadRequest)
+			break
+		}
+
+		item, err := addChecklistItem(userID, taskID, data)
+		if err == errTaskNotFound {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			break
+		} else if err == errInvalidChecklistItem {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			break
+		} else if err != nil {
+			errors.HandleServerError(w, err, "checklist.go: checklistHandler - addChecklistItem")
+			break
+		}
+		writeJSON(w, http.StatusCreated, item)
+	case http.MethodPut:
+		if itemID == "" {
+			http.Error(w, "Checklist Item ID Required", http.StatusBadRequest)
+			break
+		}
+
+		var data checklistItemData
+		if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+			http.Error(w, "Invalid JSON", http.StatusBadRequest)
+			break
+		}
+
+		item, err := editChecklistItem(userID, taskID, itemID, data)
+		if err == errTaskNotFound || err == errChecklistItemNotFound {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			break
+		} else if err == errInvalidChecklistItem {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			break
+		} else if err != nil {
+			errors.HandleServerError(w, err, "checklist.go: checklistHandler - editChecklistItem")
+			break
+		}
+		writeJSON(w, http.StatusOK, item)
+	case http.MethodDelete:
+		if itemID == "" {
+			http.Error(w, "Checklist Item ID Required", http.StatusBadRequest)
+			break
+		}
+
+		if err := deleteChecklistItem(userID, taskID, itemID); err == errTaskNotFound || err == errChecklistItemNotFound {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			break
+		} else if err != nil {
+			errors.HandleServerError(w, err, "checklist.go: checklistHandler - deleteChecklistItem")
+			break
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	case http.MethodOptions:
+		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func normalizeChecklistItem(data checklistItemData) (checklistItemData, error) {
+	data.Text = strings.TrimSpace(data.Text)
+	if data.Text == "" || utf8.RuneCountInString(data.Text) > maxChecklistItemLength {
+		return data, errInvalidChecklistItem
+	}
+	return data, nil
+}
+
+func getChecklist(userID uint, taskID string) ([]checklistItem, error) {
+	items := []checklistItem{}
+
+	if exists, err := checkTaskExists(userID, taskID); err != nil {
+		return items, errors.AddContext(err, "checklist.go: getChecklist - checkTaskExists")
+	} else if !exists {
+		return items, errTaskNotFound
+	}
+
+	dbHandle, err := database.GetDBHandle()
+	if err != nil {
+		return items, errors.AddContext(err, "checklist.go: getChecklist - GetDBHandle")
+	}
+
+	rows, err := dbHandle.Query("SELECT "+checklistColumns+" FROM task_checklist_items WHERE task_id = ? ORDER BY position, id", taskID)
+	if err != nil {
+		return items, errors.AddContext(err, "checklist.go: getChecklist - Query")
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var item checklistItem
+		if err := rows.Scan(&item.ID, &item.TaskID, &item.Text, &item.Done, &item.Position); err != nil {
+			return items, errors.AddContext(err, "checklist.go: getChecklist - Scan")
+		}
+		items = append(items, item)
+	}
+	if err := rows.Err(); err != nil {
+		return items, errors.AddContext(err, "checklist.go: getChecklist - Rows")
+	}
+	return items, nil
+}
+
+func addChecklistItem(userID uint, taskID string, data checklistItemData) (checklistItem, error) {
+	data, err := normalizeChecklistItem(data)
+	if err != nil {
+		return checklistItem{}, err
+	}
+
+	var item checklistItem
+	err = withChecklistTx(userID, taskID, func(tx *sql.Tx) error {
+		result, err := tx.Exec(
+			"INSERT INTO task_checklist_items (task_id, text, done, position) "+
+				"SELECT ?, ?, ?, COALESCE(MAX(position), 0) + 1 FROM task_checklist_items WHERE task_id = ?",
+			taskID, data.Text, data.Done, taskID,
+		)
+		if err != nil {
+			return errors.AddContext(err, "checklist.go: addChecklistItem - Exec")
+		}
+
+		id, err := result.LastInsertId()
+		if err != nil {
+			return errors.AddContext(err, "checklist.go: addChecklistItem - LastInsertId")
+		}
+
+		item, err = getChecklistItem(tx, taskID, id)
+		return err
+	})
+	return item, err
+}
+
+func editChecklistItem(userID uint, taskID string, itemID string, data checklistItemData) (checklistItem, error) {
+	data, err := normalizeChecklistItem(data)
+	if err != nil {
+		return checklistItem{}, err
+	}
+
+	var item checklistItem
+	err = withChecklistTx(userID, taskID, func(tx *sql.Tx) error {
+		if _, err := getChecklistItem(tx, taskID, itemID); err != nil {
+			return err
+		}
+
+		if _, err := tx.Exec(
+			"UPDATE task_checklist_items SET text = ?, done = ? WHERE id = ? AND task_id = ?",
+			data.Text, data.Done, itemID, taskID,
+		); err != nil {
+			return errors.AddContext(err, "checklist.go: editChecklistItem - Exec")
+		}
+
+		item, err = getChecklistItem(tx, taskID, itemID)
+		return err
+	})
+	return item, err
+}
+
+func deleteChecklistItem(userID uint, taskID string, itemID string) error {
+	return withChecklistTx(userID, taskID, func(tx *sql.Tx) error {
+		if _, err := getChecklistItem(tx, taskID, itemID); err != nil {
+			return err
+		}
+
+		if _, err := tx.Exec("DELETE FROM task_checklist_items WHERE id = ? AND task_id = ?", itemID, taskID); err != nil {
+			return errors.AddContext(err, "checklist.go: deleteChecklistItem - Exec")
+		}
+		return nil
+	})
+}
+
+func getChecklistItem(tx *sql.Tx, taskID any, itemID any) (checklistItem, error) {
+	var item checklistItem
+	err := tx.QueryRow("SELECT "+checklistColumns+" FROM task_checklist_items WHERE id = ? AND task_id = ?", itemID, taskID).
+		Scan(&item.ID, &item.TaskID, &item.Text, &item.Done, &item.Position)
+	if err == sql.ErrNoRows {
+		return item, errChecklistItemNotFound
+	} else if err != nil {
+		return item, errors.AddContext(err, "checklist.go: getChecklistItem - QueryRow")
+	}
+	return item, nil
+}
+
+// withChecklistTx runs fn in a transaction after checking the user can access
+// the task. The task's version is bumped since its progress is changing.
+func withChecklistTx(userID uint, taskID string, fn func(*sql.Tx) error) error {
+	dbHandle, err := database.GetDBHandle()
+	if err != nil {
+		return errors.AddContext(err, "checklist.go: withChecklistTx - GetDBHandle")
+	}
+
+	tx, err := dbHandle.Begin()
+	if err != nil {
+		return errors.AddContext(err, "checklist.go: withChecklistTx - Begin")
+	}
+	defer tx.Rollback()
+
+	access, accessArgs := taskAccess(userID)
+	var id uint
+	err = tx.QueryRow("SELECT id FROM tasks WHERE id = ? AND "+access+" FOR UPDATE", append([]any{taskID}, accessArgs...)...).Scan(&id)
+	if err == sql.ErrNoRows {
+		return errTaskNotFound
+	} else if err != nil {
+		return errors.AddContext(err, "checklist.go: withChecklistTx - QueryRow")
+	}
+
+	if err := fn(tx); err != nil {
+		return err
+	}
+
+	if err := touchTask(tx, id); err != nil {
+		return errors.AddContext(err, "checklist.go: withChecklistTx - touchTask")
+	}
+
+	if err := tx.Commit(); err != nil {
+		return errors.AddContext(err, "checklist.go: withChecklistTx - Commit")
+	}
+	return nil
+}
@@ -0,0 +1,71 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+)
+
+func TestChecklist(t *testing.T) {
+	taskID := createTestTask(t, 1, "Task with a checklist")
+
+	var items []checklistItem
+	for _, text := range []string{"Call claimant", "Request documents"} {
+		rr := performTaskRequest(t, "POST", "/api/tasks/"+taskID+"/checklist", []byte(`{"text": "`+text+`"}`), nil, 1)
+		if rr.Code != http.StatusCreated {
+			t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusCreated)
+		}
+
+		var item checklistItem
+		if err := json.Unmarshal(rr.Body.Bytes(), &item); err != nil {
+			t.Fatalf("Failed to parse response as JSON: %v", err)
+		}
+		items = append(items, item)
+	}
+	if items[0].Position != 1 || items[1].Position != 2 {
+		t.Errorf("Expected positions 1 and 2, got %d and %d", items[0].Position, items[1].Position)
+	}
+
+	itemURL := fmt.Sprintf("/api/tasks/%s/checklist/%d", taskID, items[0].ID)
+	rr := performTaskRequest(t, "PUT", itemURL, []byte(`{"text": "Call claimant", "done": true}`), nil, 1)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusOK)
+	}
+
+	taskData, err := getTask(1, taskID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if taskData.Progress.Checklist != (progressCount{Done: 1, Total: 2}) {
+		t.Errorf("Expected 1 of 2 checklist items done, got %+v", taskData.Progress.Checklist)
+	}
+
+	rr = performTaskRequest(t, "POST", "/api/tasks/"+taskID+"/checklist", []byte(`{"text": "   "}`), nil, 1)
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("handler returned wrong status code for an empty item: got %v want %v", rr.Code, http.StatusBadRequest)
+	}
+
+	rr = performTaskRequest(t, "GET", "/api/tasks/"+taskID+"/checklist", nil, nil, 2)
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("handler returned wrong status code for another user's task: got %v want %v", rr.Code, http.StatusNotFound)
+	}
+
+	rr = performTaskRequest(t, "DELETE", itemURL, nil, nil, 1)
+	if rr.Code != http.StatusNoContent {
+		t.Errorf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusNoContent)
+	}
+
+	rr = performTaskRequest(t, "DELETE", itemURL, nil, nil, 1)
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("handler returned wrong status code deleting twice: got %v want %v", rr.Code, http.StatusNotFound)
+	}
+
+	rr = performTaskRequest(t, "GET", "/api/tasks/"+taskID+"/checklist", nil, nil, 1)
+	if err := json.Unmarshal(rr.Body.Bytes(), &items); err != nil {
+		t.Fatalf("Failed to parse response as JSON: %v", err)
+	}
+	if len(items) != 1 || items[0].Text != "Request documents" {
+		t.Errorf("Expected only the remaining item, got %+v", items)
+	}
+}
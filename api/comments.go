@@ -0,0 +1,613 @@
+package api
+
+import (
+	"HMCTS-Developer-Challenge/database"
+	"HMCTS-Developer-Challenge/errors"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	maxCommentLength       = 5000
+	maxCommentMentions     = 20
+	defaultCommentPageSize = 50
+	maxCommentPageSize     = 200
+)
+
+// A mention is an @ followed by a user name at the start of the text or after
+// anything but a word character, so e-mail addresses are not read as one.
+var mentionPattern = regexp.MustCompile(`(?:^|[^\w@])@([\w.-]+)`)
+
+type taskComment struct {
+	ID       uint   `json:"id"`
+	TaskID   uint   `json:"task_id"`
+	AuthorID *uint  `json:"author_id"`
+	Author   string `json:"author"`
+	Body     string `json:"body"`
+	// Mentions are the names of the users mentioned in the body who can see
+	// the task. They are recorded so that the users can be notified.
+	Mentions  []string   `json:"mentions"`
+	CreatedAt time.Time  `json:"created_at"`
+	EditedAt  *time.Time `json:"edited_at"`
+}
+
+// taskCommentRevision keeps the body a comment had before an edit. edited_by
+// is kept even after the user is deleted so the history stays complete.
+type taskCommentRevision struct {
+	ID        uint      `json:"id"`
+	CommentID uint      `json:"comment_id"`
+	Body      string    `json:"body"`
+	EditedBy  *uint     `json:"edited_by"`
+	EditedAt  time.Time `json:"edited_at"`
+}
+
+type taskCommentData struct {
+	Body string `json:"body"`
+}
+
+type commentPage struct {
+	Comments []taskComment `json:"comments"`
+	Total    int           `json:"total"`
+	Next     string        `json:"next,omitempty"`
+}
+
+type commentQuery struct {
+	Limit int
+	// After is the id of the last comment of the previous page.
+	After uint
+}
+
+var errCommentNotFound = errors.Error("Comment Not Found")
+var errInvalidComment = errors.Error("Invalid Comment")
+var errCommentForbidden = errors.Error("Not Allowed To Change Comment")
+
+const commentColumns = "c.id, c.task_id, c.author_id, COALESCE(u.name, ''), c.body, c.created_at, c.edited_at"
+
+func commentsHandler(w http.ResponseWriter, r *http.Request, userID uint, taskID string, commentID string) {
+	switch r.Method {
+	case http.MethodGet:
+		if commentID != "" {
+			comment, err := getComment(userID, taskID, commentID)
+			if err == errTaskNotFound || err == errCommentNotFound {
+				http.Error(w, err.Error(), http.StatusNotFound)
+				break
+			} else if err != nil {
+				errors.HandleServerError(w, err, "comments.go: commentsHandler - getComment")
+				break
+			}
+			writeJSON(w, http.StatusOK, comment)
+			break
+		}
+
+		query, err := parseCommentQuery(r.URL.Query())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			break
+		}
+
+		page, err := getComments(userID, taskID, query, r.URL)
+		if err == errTaskNotFound {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			break
+		} else if err != nil {
+			errors.HandleServerError(w, err, "comments.go: commentsHandler - getComments")
+			break
+		}
+		writeJSON(w, http.StatusOK, page)
+	case http.MethodPost:
+		if commentID != "" {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			break
+		}
+
+		var data taskCommentData
+		if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+			http.Error(w, "Invalid JSON", http.StatusBadRequest)
+			break
+		}
+
+		comment, err := addComment(userID, taskID, data)
+		if err == errTaskNotFound {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			break
+		} else if err == errInvalidComment {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			break
+		} else if err != nil {
+			errors.HandleServerError(w, err, "comments.go: commentsHandler - addComment")
+			break
+		}
+		writeJSON(w, http.StatusCreated, comment)
+	case http.MethodPut:
+		if commentID == "" {
+			http.Error(w, "Comment ID Required", http.StatusBadRequest)
+			break
+		}
+
+		var data taskCommentData
+		if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+			http.Error(w, "Invalid JSON", http.StatusBadRequest)
+			break
+		}
+
+		comment, err := editComment(userID, taskID, commentID, data)
+		if err == errTaskNotFound || err == errCommentNotFound {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			break
+		} else if err == errInvalidComment {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			break
+		} else if err == errCommentForbidden {
+			forbidden(w, r, userID, err)
+			break
+		} else if err != nil {
+			errors.HandleServerError(w, err, "comments.go: commentsHandler - editComment")
+			break
+		}
+		writeJSON(w, http.StatusOK, comment)
+	case http.MethodDelete:
+		if commentID == "" {
+			http.Error(w, "Comment ID Required", http.StatusBadRequest)
+			break
+		}
+
+		if err := deleteComment(userID, taskID, commentID); err == errTaskNotFound || err == errCommentNotFound {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			break
+		} else if err == errCommentForbidden {
+			forbidden(w, r, userID, err)
+			break
+		} else if err != nil {
+			errors.HandleServerError(w, err, "comments.go: commentsHandler - deleteComment")
+			break
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	case http.MethodOptions:
+		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func commentHistoryHandler(w http.ResponseWriter, r *http.Request, userID uint, taskID string, commentID string) {
+	switch r.Method {
+	case http.MethodGet:
+		revisions, err := getCommentHistory(userID, taskID, commentID)
+		if err == errTaskNotFound || err == errCommentNotFound {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			break
+		} else if err != nil {
+			errors.HandleServerError(w, err, "comments.go: commentHistoryHandler - getCommentHistory")
+			break
+		}
+		writeJSON(w, http.StatusOK, revisions)
+	case http.MethodOptions:
+		w.Header().Set("Access-Control-Allow-Methods", "GET, OPTIONS")
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func parseCommentQuery(values url.Values) (commentQuery, error) {
+	query := commentQuery{Limit: defaultCommentPageSize}
+
+	if limit := values.Get("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n < 1 || n > maxCommentPageSize {
+			return query, errors.Errorf("limit must be between 1 and %d", maxCommentPageSize)
+		}
+		query.Limit = n
+	}
+
+	if cursor := values.Get("cursor"); cursor != "" {
+		after, err := decodeCommentCursor(cursor)
+		if err != nil {
+			return query, errors.Error("invalid cursor")
+		}
+		query.After = after
+	}
+	return query, nil
+}
+
+func encodeCommentCursor(id uint) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(strconv.FormatUint(uint64(id), 10)))
+}
+
+func decodeCommentCursor(cursor string) (uint, error) {
+	data, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return 0, errors.AddContext(err, "comments.go: decodeCommentCursor - DecodeString")
+	}
+	id, err := strconv.ParseUint(string(data), 10, 32)
+	if err != nil {
+		return 0, errors.AddContext(err, "comments.go: decodeCommentCursor - ParseUint")
+	}
+	return uint(id), nil
+}
+
+func normalizeComment(data taskCommentData) (taskCommentData, error) {
+	data.Body = strings.TrimSpace(data.Body)
+	if data.Body == "" || utf8.RuneCountInString(data.Body) > maxCommentLength {
+		return data, errInvalidComment
+	}
+	return data, nil
+}
+
+// parseMentions returns the distinct names mentioned in a comment body in the
+// order they first appear, up to maxCommentMentions of them.
+func parseMentions(body string) []string {
+	names := []string{}
+	seen := map[string]bool{}
+	for _, match := range mentionPattern.FindAllStringSubmatch(body, -1) {
+		// A mention at the end of a sentence keeps its full stop out
+		name := strings.TrimRight(match[1], ".")
+		if name == "" || seen[name] {
+			continue
+		}
+		seen[name] = true
+		names = append(names, name)
+		if len(names) == maxCommentMentions {
+			break
+		}
+	}
+	return names
+}
+
+func getComments(userID uint, taskID string, query commentQuery, requestURL *url.URL) (commentPage, error) {
+	page := commentPage{Comments: []taskComment{}}
+
+	if exists, err := checkTaskExists(userID, taskID); err != nil {
+		return page, errors.AddContext(err, "comments.go: getComments - checkTaskExists")
+	} else if !exists {
+		return page, errTaskNotFound
+	}
+
+	dbHandle, err := database.GetDBHandle()
+	if err != nil {
+		return page, errors.AddContext(err, "comments.go: getComments - GetDBHandle")
+	}
+
+	if err := dbHandle.QueryRow("SELECT COUNT(*) FROM task_comments WHERE task_id = ?", taskID).Scan(&page.Total); err != nil {
+		return page, errors.AddContext(err, "comments.go: getComments - Count")
+	}
+
+	// The thread reads oldest first. One extra row is fetched to find out
+	// whether there is another page.
+	rows, err := dbHandle.Query(
+		"SELECT "+commentColumns+" FROM task_comments c LEFT JOIN users u ON u.id = c.author_id "+
+			"WHERE c.task_id = ? AND c.id > ? ORDER BY c.id LIMIT ?",
+		taskID, query.After, query.Limit+1,
+	)
+	if err != nil {
+		return page, errors.AddContext(err, "comments.go: getComments - Query")
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		c, err := scanComment(rows)
+		if err != nil {
+			return page, errors.AddContext(err, "comments.go: getComments - Scan")
+		}
+		page.Comments = append(page.Comments, c)
+	}
+	if err := rows.Err(); err != nil {
+		return page, errors.AddContext(err, "comments.go: getComments - Rows")
+	}
+
+	if len(page.Comments) > query.Limit {
+		page.Comments = page.Comments[:query.Limit]
+		page.Next = nextTaskPageURL(requestURL, encodeCommentCursor(page.Comments[query.Limit-1].ID))
+	}
+
+	comments := make([]*taskComment, len(page.Comments))
+	for i := range page.Comments {
+		comments[i] = &page.Comments[i]
+	}
+	if err := loadCommentDetails(dbHandle, userID, comments...); err != nil {
+		return page, errors.AddContext(err, "comments.go: getComments - loadCommentDetails")
+	}
+	return page, nil
+}
+
+func getComment(userID uint, taskID string, commentID string) (taskComment, error) {
+	if exists, err := checkTaskExists(userID, taskID); err != nil {
+		return taskComment{}, errors.AddContext(err, "comments.go: getComment - checkTaskExists")
+	} else if !exists {
+		return taskComment{}, errTaskNotFound
+	}
+
+	dbHandle, err := database.GetDBHandle()
+	if err != nil {
+		return taskComment{}, errors.AddContext(err, "comments.go: getComment - GetDBHandle")
+	}
+
+	c, err := queryComment(dbHandle, taskID, commentID)
+	if err != nil {
+		return c, err
+	}
+	if err := loadCommentDetails(dbHandle, userID, &c); err != nil {
+		return c, errors.AddContext(err, "comments.go: getComment - loadCommentDetails")
+	}
+	return c, nil
+}
+
+func addComment(userID uint, taskID string, data taskCommentData) (taskComment, error) {
+	data, err := normalizeComment(data)
+	if err != nil {
+		return taskComment{}, err
+	}
+
+	var id int64
+	err = withCommentTx(userID, taskID, func(tx *sql.Tx) error {
+		result, err := tx.Exec("INSERT INTO task_comments (task_id, author_id, body) VALUES (?, ?, ?)", taskID, userID, data.Body)
+		if err != nil {
+			return errors.AddContext(err, "comments.go: addComment - Exec")
+		}
+
+		id, err = result.LastInsertId()
+		if err != nil {
+			return errors.AddContext(err, "comments.go: addComment - LastInsertId")
+		}
+		return recordMentions(tx, userID, taskID, id, data.Body)
+	})
+	if err != nil {
+		return taskComment{}, err
+	}
+	return getComment(userID, taskID, strconv.FormatInt(id, 10))
+}
+
+// editComment replaces the body of a comment, keeping the one it replaces in
+// the comment's history. Only the author may edit a comment.
+func editComment(userID uint, taskID string, commentID string, data taskCommentData) (taskComment, error) {
+	data, err := normalizeComment(data)
+	if err != nil {
+		return taskComment{}, err
+	}
+
+	err = withCommentTx(userID, taskID, func(tx *sql.Tx) error {
+		current, err := queryComment(tx, taskID, commentID)
+		if err != nil {
+			return err
+		}
+		if current.AuthorID == nil || *current.AuthorID != userID {
+			return errCommentForbidden
+		}
+		if current.Body == data.Body {
+			return nil
+		}
+
+		if _, err := tx.Exec(
+			"INSERT INTO task_comment_revisions (comment_id, body, edited_by) VALUES (?, ?, ?)",
+			current.ID, current.Body, userID,
+		); err != nil {
+			return errors.AddContext(err, "comments.go: editComment - Exec Revision")
+		}
+		if _, err := tx.Exec("UPDATE task_comments SET body = ?, edited_at = CURRENT_TIMESTAMP WHERE id = ?", data.Body, current.ID); err != nil {
+			return errors.AddContext(err, "comments.go: editComment - Exec")
+		}
+		return recordMentions(tx, userID, taskID, int64(current.ID), data.Body)
+	})
+	if err != nil {
+		return taskComment{}, err
+	}
+	return getComment(userID, taskID, commentID)
+}
+
+// deleteComment removes a comment along with its history. Admins may remove
+// any comment, for example to take down something posted in error.
+func deleteComment(userID uint, taskID string, commentID string) error {
+	return withCommentTx(userID, taskID, func(tx *sql.Tx) error {
+		current, err := queryComment(tx, taskID, commentID)
+		if err != nil {
+			return err
+		}
+		if current.AuthorID == nil || *current.AuthorID != userID {
+			if role, err := userRole(tx, userID); err != nil {
+				return errors.AddContext(err, "comments.go: deleteComment - userRole")
+			} else if role != RoleAdmin {
+				return errCommentForbidden
+			}
+		}
+
+		if _, err := tx.Exec("DELETE FROM task_comments WHERE id = ?", current.ID); err != nil {
+			return errors.AddContext(err, "comments.go: deleteComment - Exec")
+		}
+		return nil
+	})
+}
+
+func getCommentHistory(userID uint, taskID string, commentID string) ([]taskCommentRevision, error) {
+	revisions := []taskCommentRevision{}
+
+	c, err := getComment(userID, taskID, commentID)
+	if err != nil {
+		return revisions, err
+	}
+
+	dbHandle, err := database.GetDBHandle()
+	if err != nil {
+		return revisions, errors.AddContext(err, "comments.go: getCommentHistory - GetDBHandle")
+	}
+
+	loc, err := userLocation(userID)
+	if err != nil {
+		return revisions, errors.AddContext(err, "comments.go: getCommentHistory - userLocation")
+	}
+
+	rows, err := dbHandle.Query("SELECT id, comment_id, body, edited_by, edited_at FROM task_comment_revisions WHERE comment_id = ? ORDER BY id", c.ID)
+	if err != nil {
+		return revisions, errors.AddContext(err, "comments.go: getCommentHistory - Query")
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var rev taskCommentRevision
+		if err := rows.Scan(&rev.ID, &rev.CommentID, &rev.Body, &rev.EditedBy, &rev.EditedAt); err != nil {
+			return revisions, errors.AddContext(err, "comments.go: getCommentHistory - Scan")
+		}
+		rev.EditedAt = rev.EditedAt.In(loc)
+		revisions = append(revisions, rev)
+	}
+	if err := rows.Err(); err != nil {
+		return revisions, errors.AddContext(err, "comments.go: getCommentHistory - Rows")
+	}
+	return revisions, nil
+}
+
+// recordMentions brings the mentions of a comment in line with its body.
+// Names that are not users, or users who cannot see the task, are left as
+// plain text. Mentions kept across an edit keep their notified_at, so users
+// are not told twice about the same comment.
+func recordMentions(tx *sql.Tx, authorID uint, taskID string, commentID int64, body string) error {
+	mentioned := []any{}
+	for _, name := range parseMentions(body) {
+		var id uint
+		err := tx.QueryRow("SELECT id FROM users WHERE name = ? AND disabled = FALSE", name).Scan(&id)
+		if err == sql.ErrNoRows || id == authorID {
+			continue
+		} else if err != nil {
+			return errors.AddContext(err, "comments.go: recordMentions - QueryRow User")
+		}
+
+		access, accessArgs := taskAccess(id)
+		var visible bool
+		if err := tx.QueryRow("SELECT EXISTS(SELECT 1 FROM tasks WHERE id = ? AND "+access+")", append([]any{taskID}, accessArgs...)...).Scan(&visible); err != nil {
+			return errors.AddContext(err, "comments.go: recordMentions - QueryRow Access")
+		}
+		if visible {
+			mentioned = append(mentioned, id)
+		}
+	}
+
+	if len(mentioned) == 0 {
+		if _, err := tx.Exec("DELETE FROM task_comment_mentions WHERE comment_id = ?", commentID); err != nil {
+			return errors.AddContext(err, "comments.go: recordMentions - Exec Delete")
+		}
+		return nil
+	}
+
+	in := "(?" + strings.Repeat(", ?", len(mentioned)-1) + ")"
+	if _, err := tx.Exec("DELETE FROM task_comment_mentions WHERE comment_id = ? AND user_id NOT IN "+in, append([]any{commentID}, mentioned...)...); err != nil {
+		return errors.AddContext(err, "comments.go: recordMentions - Exec Delete")
+	}
+	for _, id := range mentioned {
+		if _, err := tx.Exec("INSERT IGNORE INTO task_comment_mentions (comment_id, user_id) VALUES (?, ?)", commentID, id); err != nil {
+			return errors.AddContext(err, "comments.go: recordMentions - Exec Insert")
+		}
+	}
+	return nil
+}
+
+// withCommentTx runs fn in a transaction after checking the user can access
+// the task. The task is locked so that changes to its comments are made one
+// at a time, but unlike the checklist its version is left alone since
+// comments are not part of the task's representation.
+func withCommentTx(userID uint, taskID string, fn func(*sql.Tx) error) error {
+	dbHandle, err := database.GetDBHandle()
+	if err != nil {
+		return errors.AddContext(err, "comments.go: withCommentTx - GetDBHandle")
+	}
+
+	tx, err := dbHandle.Begin()
+	if err != nil {
+		return errors.AddContext(err, "comments.go: withCommentTx - Begin")
+	}
+	defer tx.Rollback()
+
+	access, accessArgs := taskAccess(userID)
+	var id uint
+	err = tx.QueryRow("SELECT id FROM tasks WHERE id = ? AND "+access+" FOR UPDATE", append([]any{taskID}, accessArgs...)...).Scan(&id)
+	if err == sql.ErrNoRows {
+		return errTaskNotFound
+	} else if err != nil {
+		return errors.AddContext(err, "comments.go: withCommentTx - QueryRow")
+	}
+
+	if err := fn(tx); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return errors.AddContext(err, "comments.go: withCommentTx - Commit")
+	}
+	return nil
+}
+
+func queryComment(q rowQueryer, taskID any, commentID string) (taskComment, error) {
+	row := q.QueryRow(
+		"SELECT "+commentColumns+" FROM task_comments c LEFT JOIN users u ON u.id = c.author_id WHERE c.id = ? AND c.task_id = ?",
+		commentID, taskID,
+	)
+	c, err := scanComment(row)
+	if err == sql.ErrNoRows {
+		return c, errCommentNotFound
+	} else if err != nil {
+		return c, errors.AddContext(err, "comments.go: queryComment - Scan")
+	}
+	return c, nil
+}
+
+func scanComment(row rowScanner) (taskComment, error) {
+	c := taskComment{Mentions: []string{}}
+	err := row.Scan(&c.ID, &c.TaskID, &c.AuthorID, &c.Author, &c.Body, &c.CreatedAt, &c.EditedAt)
+	return c, err
+}
+
+// loadCommentDetails fills in the mentions of each comment and shows its
+// times in the zone of the user reading it.
+func loadCommentDetails(q queryer, userID uint, comments ...*taskComment) error {
+	if len(comments) == 0 {
+		return nil
+	}
+
+	loc, err := userLocation(userID)
+	if err != nil {
+		return err
+	}
+
+	byID := make(map[uint]*taskComment, len(comments))
+	args := make([]any, 0, len(comments))
+	for _, c := range comments {
+		c.CreatedAt = c.CreatedAt.In(loc)
+		if c.EditedAt != nil {
+			editedAt := c.EditedAt.In(loc)
+			c.EditedAt = &editedAt
+		}
+		byID[c.ID] = c
+		args = append(args, c.ID)
+	}
+
+	rows, err := q.Query(
+		"SELECT m.comment_id, u.name FROM task_comment_mentions m JOIN users u ON u.id = m.user_id "+
+			"WHERE m.comment_id IN (?"+strings.Repeat(", ?", len(args)-1)+") ORDER BY u.name",
+		args...,
+	)
+	if err != nil {
+		return errors.AddContext(err, "comments.go: loadCommentDetails - Query")
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var commentID uint
+		var name string
+		if err := rows.Scan(&commentID, &name); err != nil {
+			return errors.AddContext(err, "comments.go: loadCommentDetails - Scan")
+		}
+		byID[commentID].Mentions = append(byID[commentID].Mentions, name)
+	}
+	if err := rows.Err(); err != nil {
+		return errors.AddContext(err, "comments.go: loadCommentDetails - Rows")
+	}
+	return nil
+}
@@ -0,0 +1,132 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+func TestParseMentions(t *testing.T) {
+	tests := []struct {
+		body     string
+		expected []string
+	}{
+		{"@testuser1 can you look at this?", []string{"testuser1"}},
+		{"Thanks @testuser2. Passing to @testadmin and @testuser2", []string{"testuser2", "testadmin"}},
+		{"(@j.smith) and @a_b-c!", []string{"j.smith", "a_b-c"}},
+		// Addresses and lone @ signs are not mentions
+		{"Email clerk@court.example or @ the desk", []string{}},
+		{"@@testuser1", []string{}},
+	}
+	for _, tc := range tests {
+		if got := parseMentions(tc.body); !reflect.DeepEqual(got, tc.expected) {
+			t.Errorf("%q: expected %v, got %v", tc.body, tc.expected, got)
+		}
+	}
+
+	body := ""
+	for i := 0; i < maxCommentMentions+5; i++ {
+		body += fmt.Sprintf("@user%d ", i)
+	}
+	if got := parseMentions(body); len(got) != maxCommentMentions {
+		t.Errorf("Expected at most %d mentions, got %d", maxCommentMentions, len(got))
+	}
+}
+
+func TestCommentCursor(t *testing.T) {
+	after, err := decodeCommentCursor(encodeCommentCursor(42))
+	if err != nil || after != 42 {
+		t.Errorf("Expected 42, got %d (%v)", after, err)
+	}
+	if _, err := parseCommentQuery(map[string][]string{"cursor": {"not a cursor"}}); err == nil {
+		t.Error("Expected an invalid cursor to be rejected")
+	}
+	if _, err := parseCommentQuery(map[string][]string{"limit": {"0"}}); err == nil {
+		t.Error("Expected a limit of 0 to be rejected")
+	}
+}
+
+func TestTaskComments(t *testing.T) {
+	taskID := createTestTask(t, 1, "Task with comments")
+	commentsURL := "/api/tasks/" + taskID + "/comments"
+
+	// testuser2 cannot see the task, so only the admin is recorded
+	body := []byte(`{"body": "Please check the bundle @testadmin, and @testuser2 or @nobody."}`)
+	rr := performTaskRequest(t, "POST", commentsURL, body, nil, 1)
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("handler returned wrong status code: got %v want %v, %s", rr.Code, http.StatusCreated, rr.Body.String())
+	}
+	var comment taskComment
+	if err := json.Unmarshal(rr.Body.Bytes(), &comment); err != nil {
+		t.Fatalf("Failed to parse response as JSON: %v", err)
+	}
+	if comment.Author != "testuser1" || comment.EditedAt != nil || !reflect.DeepEqual(comment.Mentions, []string{"testadmin"}) {
+		t.Errorf("Unexpected comment %+v", comment)
+	}
+
+	commentURL := fmt.Sprintf("%s/%d", commentsURL, comment.ID)
+	if rr := performTaskRequest(t, "PUT", commentURL, []byte(`{"body": "Not mine to change"}`), nil, 3); rr.Code != http.StatusForbidden {
+		t.Errorf("handler returned wrong status code for another user's comment: got %v want %v", rr.Code, http.StatusForbidden)
+	}
+	if rr := performTaskRequest(t, "POST", commentsURL, []byte(`{"body": "  "}`), nil, 1); rr.Code != http.StatusBadRequest {
+		t.Errorf("handler returned wrong status code for an empty comment: got %v want %v", rr.Code, http.StatusBadRequest)
+	}
+
+	rr = performTaskRequest(t, "PUT", commentURL, []byte(`{"body": "Bundle checked, thanks"}`), nil, 1)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusOK)
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &comment); err != nil {
+		t.Fatalf("Failed to parse response as JSON: %v", err)
+	}
+	if comment.EditedAt == nil || len(comment.Mentions) != 0 {
+		t.Errorf("Expected an edited comment without mentions, got %+v", comment)
+	}
+
+	rr = performTaskRequest(t, "GET", commentURL+"/history", nil, nil, 1)
+	var revisions []taskCommentRevision
+	if err := json.Unmarshal(rr.Body.Bytes(), &revisions); err != nil {
+		t.Fatalf("Failed to parse response as JSON: %v", err)
+	}
+	if len(revisions) != 1 || revisions[0].Body != "Please check the bundle @testadmin, and @testuser2 or @nobody." {
+		t.Errorf("Expected the original body in the history, got %+v", revisions)
+	}
+
+	for _, text := range []string{"Second", "Third"} {
+		if rr := performTaskRequest(t, "POST", commentsURL, []byte(`{"body": "`+text+`"}`), nil, 1); rr.Code != http.StatusCreated {
+			t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusCreated)
+		}
+	}
+
+	// The auditor can read the thread a page at a time
+	rr = performTaskRequest(t, "GET", commentsURL+"?limit=2", nil, nil, 4)
+	var page commentPage
+	if err := json.Unmarshal(rr.Body.Bytes(), &page); err != nil {
+		t.Fatalf("Failed to parse response as JSON: %v", err)
+	}
+	if page.Total != 3 || len(page.Comments) != 2 || page.Comments[0].ID != comment.ID || page.Next == "" {
+		t.Fatalf("Unexpected first page %+v", page)
+	}
+	rr = performTaskRequest(t, "GET", page.Next, nil, nil, 4)
+	page = commentPage{}
+	if err := json.Unmarshal(rr.Body.Bytes(), &page); err != nil {
+		t.Fatalf("Failed to parse response as JSON: %v", err)
+	}
+	if len(page.Comments) != 1 || page.Comments[0].Body != "Third" || page.Next != "" {
+		t.Errorf("Unexpected last page %+v", page)
+	}
+
+	if rr := performTaskRequest(t, "GET", commentsURL, nil, nil, 2); rr.Code != http.StatusNotFound {
+		t.Errorf("handler returned wrong status code for another user's task: got %v want %v", rr.Code, http.StatusNotFound)
+	}
+
+	// Admins may remove any comment
+	if rr := performTaskRequest(t, "DELETE", commentURL, nil, nil, 3); rr.Code != http.StatusNoContent {
+		t.Errorf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusNoContent)
+	}
+	if rr := performTaskRequest(t, "GET", commentURL+"/history", nil, nil, 1); rr.Code != http.StatusNotFound {
+		t.Errorf("handler returned wrong status code for a deleted comment: got %v want %v", rr.Code, http.StatusNotFound)
+	}
+}
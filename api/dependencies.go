@@ -0,0 +1,473 @@
+package api
+
+import (
+	"HMCTS-Developer-Challenge/database"
+	"HMCTS-Developer-Challenge/errors"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+var errDependencyCycle = errors.Error("Dependency Would Create A Cycle")
+var errDependencyNotFound = errors.Error("Dependency Not Found")
+var errOpenBlockers = errors.Error("Task Is Blocked By Open Tasks")
+
+// blockedSubtaskError is errOpenBlockers for a subtask that a cascade would
+// have closed along with its parent.
+type blockedSubtaskError struct {
+	SubtaskID uint
+}
+
+func (e *blockedSubtaskError) Error() string {
+	return fmt.Sprintf("Subtask %d Is Blocked By Open Tasks", e.SubtaskID)
+}
+
+type blockerData struct {
+	BlockerID uint `json:"blocker_id"`
+}
+
+// taskPlan orders open tasks so that every task comes after its blockers.
+// Each stage holds the tasks that can be worked on once all earlier stages
+// are done.
+type taskPlan struct {
+	Tasks  []task   `json:"tasks"`
+	Stages [][]uint `json:"stages"`
+}
+
+// dependencyGraph maps each task to the tasks blocking it.
+type dependencyGraph map[uint][]uint
+
+// reaches reports whether from is blocked, directly or through other tasks,
+// by to. Adding "to is blocked by from" would then create a cycle.
+func (g dependencyGraph) reaches(from, to uint) bool {
+	seen := map[uint]bool{}
+	stack := []uint{from}
+	for len(stack) > 0 {
+		id := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if id == to {
+			return true
+		}
+		if seen[id] {
+			continue
+		}
+		seen[id] = true
+		stack = append(stack, g[id]...)
+	}
+	return false
+}
+
+// stages sorts ids topologically in layers using Kahn's algorithm. Blockers
+// outside ids are treated as already done. Within a stage tasks keep the
+// order they have in ids.
+func (g dependencyGraph) stages(ids []uint) ([][]uint, error) {
+	remaining := map[uint]int{}
+	blocking := map[uint][]uint{}
+	for _, id := range ids {
+		remaining[id] = 0
+	}
+	for _, id := range ids {
+		for _, blocker := range g[id] {
+			if _, ok := remaining[blocker]; ok {
+				remaining[id]++
+				blocking[blocker] = append(blocking[blocker], id)
+			}
+		}
+	}
+
+	stages := [][]uint{}
+	done := 0
+	for done < len(ids) {
+		var stage []uint
+		for _, id := range ids {
+			if n, ok := remaining[id]; ok && n == 0 {
+				stage = append(stage, id)
+			}
+		}
+		if len(stage) == 0 {
+			return nil, errDependencyCycle
+		}
+
+		for _, id := range stage {
+			delete(remaining, id)
+			for _, blocked := range blocking[id] {
+				remaining[blocked]--
+			}
+		}
+		stages = append(stages, stage)
+		done += len(stage)
+	}
+	return stages, nil
+}
+
+func blockersHandler(w http.ResponseWriter, r *http.Request, userID uint, taskID string, blockerID string) {
+	switch r.Method {
+	case http.MethodPost:
+		if blockerID != "" {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			break
+		}
+
+		var data blockerData
+		if err := json.NewDecoder(r.Body).Decode(&data); err != nil || data.BlockerID == 0 {
+			http.Error(w, "Invalid JSON", http.StatusBadRequest)
+			break
+		}
+
+		t, err := addBlocker(userID, taskID, data.BlockerID)
+		if err == errTaskNotFound {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			break
+		} else if err == errDependencyCycle {
+			http.Error(w, err.Error(), http.StatusConflict)
+			break
+		} else if err != nil {
+			errors.HandleServerError(w, err, "dependencies.go: blockersHandler - addBlocker")
+			break
+		}
+
+		w.Header().Set("ETag", taskETag(t))
+		writeJSON(w, http.StatusCreated, t)
+	case http.MethodDelete:
+		if blockerID == "" {
+			http.Error(w, "Blocker ID Required", http.StatusBadRequest)
+			break
+		}
+
+		if err := removeBlocker(userID, taskID, blockerID); err == errTaskNotFound || err == errDependencyNotFound {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			break
+		} else if err != nil {
+			errors.HandleServerError(w, err, "dependencies.go: blockersHandler - removeBlocker")
+			break
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	case http.MethodOptions:
+		w.Header().Set("Access-Control-Allow-Methods", "POST, DELETE, OPTIONS")
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// addBlocker records that taskID cannot be completed until blockerID is. The
+// user must be able to access both tasks.
+func addBlocker(userID uint, taskID string, blockerID uint) (task, error) {
+	current, err := getTask(userID, taskID)
+	if err != nil {
+		return current, err
+	}
+	if current.ID == blockerID {
+		return current, errDependencyCycle
+	}
+
+	access, accessArgs := taskAccess(userID)
+	err = withDependencyTx(func(tx *sql.Tx) error {
+		var exists bool
+		if err := tx.QueryRow("SELECT EXISTS(SELECT 1 FROM tasks WHERE id = ? AND "+access+")", append([]any{blockerID}, accessArgs...)...).Scan(&exists); err != nil {
+			return errors.AddContext(err, "dependencies.go: addBlocker - QueryRow")
+		} else if !exists {
+			return errTaskNotFound
+		}
+
+		graph, err := loadDependencyGraph(tx)
+		if err != nil {
+			return err
+		}
+		if slices.Contains(graph[current.ID], blockerID) {
+			return nil
+		}
+		if graph.reaches(blockerID, current.ID) {
+			return errDependencyCycle
+		}
+
+		if _, err := tx.Exec("INSERT INTO task_dependencies (task_id, blocker_id) VALUES (?, ?)", current.ID, blockerID); err != nil {
+			return errors.AddContext(err, "dependencies.go: addBlocker - Exec")
+		}
+		return touchDependencyTasks(tx, current.ID, blockerID)
+	})
+	if err != nil {
+		return current, err
+	}
+	return getTask(userID, taskID)
+}
+
+func removeBlocker(userID uint, taskID string, blockerID string) error {
+	current, err := getTask(userID, taskID)
+	if err != nil {
+		return err
+	}
+
+	return withDependencyTx(func(tx *sql.Tx) error {
+		result, err := tx.Exec("DELETE FROM task_dependencies WHERE task_id = ? AND blocker_id = ?", current.ID, blockerID)
+		if err != nil {
+			return errors.AddContext(err, "dependencies.go: removeBlocker - Exec")
+		}
+
+		if affected, err := result.RowsAffected(); err != nil {
+			return errors.AddContext(err, "dependencies.go: removeBlocker - RowsAffected")
+		} else if affected == 0 {
+			return errDependencyNotFound
+		}
+		return touchDependencyTasks(tx, current.ID, blockerID)
+	})
+}
+
+// withDependencyTx runs fn in a transaction while holding a named lock. Tasks
+// of different users can depend on each other, so every dependency change is
+// serialised to stop two concurrent additions from forming a cycle.
+func withDependencyTx(fn func(*sql.Tx) error) error {
+	dbHandle, err := database.GetDBHandle()
+	if err != nil {
+		return errors.AddContext(err, "dependencies.go: withDependencyTx - GetDBHandle")
+	}
+
+	// The lock belongs to the connection, so the transaction has to run on
+	// the same one.
+	ctx := context.Background()
+	conn, err := dbHandle.Conn(ctx)
+	if err != nil {
+		return errors.AddContext(err, "dependencies.go: withDependencyTx - Conn")
+	}
+	defer conn.Close()
+
+	var locked sql.NullInt64
+	if err := conn.QueryRowContext(ctx, "SELECT GET_LOCK('task_dependencies', 10)").Scan(&locked); err != nil {
+		return errors.AddContext(err, "dependencies.go: withDependencyTx - GET_LOCK")
+	} else if locked.Int64 != 1 {
+		return errors.Error("dependencies.go: withDependencyTx - timed out waiting for lock")
+	}
+	defer conn.ExecContext(ctx, "DO RELEASE_LOCK('task_dependencies')")
+
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return errors.AddContext(err, "dependencies.go: withDependencyTx - Begin")
+	}
+	defer tx.Rollback()
+
+	if err := fn(tx); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return errors.AddContext(err, "dependencies.go: withDependencyTx - Commit")
+	}
+	return nil
+}
+
+// touchDependencyTasks bumps both tasks of a dependency since it appears in
+// the blocked_by list of one and the blocking list of the other.
+func touchDependencyTasks(tx *sql.Tx, taskID uint, blockerID any) error {
+	if err := touchTask(tx, taskID); err != nil {
+		return errors.AddContext(err, "dependencies.go: touchDependencyTasks - taskID")
+	}
+	if err := touchTask(tx, blockerID); err != nil {
+		return errors.AddContext(err, "dependencies.go: touchDependencyTasks - blockerID")
+	}
+	return nil
+}
+
+type queryer interface {
+	Query(query string, args ...any) (*sql.Rows, error)
+}
+
+// loadDependencyGraph loads every dependency, including those between tasks
+// the user cannot see or that are in the trash, since any of them could close
+// a cycle.
+func loadDependencyGraph(q queryer) (dependencyGraph, error) {
+	rows, err := q.Query("SELECT task_id, blocker_id FROM task_dependencies")
+	if err != nil {
+		return nil, errors.AddContext(err, "dependencies.go: loadDependencyGraph - Query")
+	}
+	defer rows.Close()
+
+	graph := dependencyGraph{}
+	for rows.Next() {
+		var taskID, blockerID uint
+		if err := rows.Scan(&taskID, &blockerID); err != nil {
+			return nil, errors.AddContext(err, "dependencies.go: loadDependencyGraph - Scan")
+		}
+		graph[taskID] = append(graph[taskID], blockerID)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, errors.AddContext(err, "dependencies.go: loadDependencyGraph - Rows")
+	}
+	return graph, nil
+}
+
+// checkBlockers is called when a task changes status and refuses to move it
+// to a terminal status while any of its blockers are open. Blockers in the
+// trash are ignored.
+func checkBlockers(tx *sql.Tx, taskID uint, status string) error {
+	if s, ok := workflow.status(status); !ok || !s.Terminal {
+		return nil
+	}
+
+	rows, err := tx.Query(
+		"SELECT t.status FROM task_dependencies d JOIN tasks t ON t.id = d.blocker_id WHERE d.task_id = ? AND t.deleted_at IS NULL",
+		taskID,
+	)
+	if err != nil {
+		return errors.AddContext(err, "dependencies.go: checkBlockers - Query")
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var blockerStatus string
+		if err := rows.Scan(&blockerStatus); err != nil {
+			return errors.AddContext(err, "dependencies.go: checkBlockers - Scan")
+		}
+		if s, ok := workflow.status(blockerStatus); !ok || !s.Terminal {
+			return errOpenBlockers
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return errors.AddContext(err, "dependencies.go: checkBlockers - Rows")
+	}
+	return nil
+}
+
+// loadTaskDependencies fills in the blocked_by and blocking lists of each
+// task.
+func loadTaskDependencies(tasks ...*task) error {
+	if len(tasks) == 0 {
+		return nil
+	}
+
+	dbHandle, err := database.GetDBHandle()
+	if err != nil {
+		return errors.AddContext(err, "dependencies.go: loadTaskDependencies - GetDBHandle")
+	}
+
+	byID := make(map[uint][]*task, len(tasks))
+	args := make([]any, 0, len(tasks))
+	for _, t := range tasks {
+		t.BlockedBy = []uint{}
+		t.Blocking = []uint{}
+		byID[t.ID] = append(byID[t.ID], t)
+		args = append(args, t.ID)
+	}
+	in := "(?" + strings.Repeat(", ?", len(args)-1) + ")"
+
+	rows, err := dbHandle.Query(
+		"SELECT d.task_id, d.blocker_id FROM task_dependencies d "+
+			"JOIN tasks t ON t.id = d.task_id AND t.deleted_at IS NULL JOIN tasks b ON b.id = d.blocker_id AND b.deleted_at IS NULL "+
+			"WHERE d.task_id IN "+in+" OR d.blocker_id IN "+in+" ORDER BY d.task_id, d.blocker_id",
+		append(args, args...)...,
+	)
+	if err != nil {
+		return errors.AddContext(err, "dependencies.go: loadTaskDependencies - Query")
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var taskID, blockerID uint
+		if err := rows.Scan(&taskID, &blockerID); err != nil {
+			return errors.AddContext(err, "dependencies.go: loadTaskDependencies - Scan")
+		}
+		for _, t := range byID[taskID] {
+			t.BlockedBy = append(t.BlockedBy, blockerID)
+		}
+		for _, t := range byID[blockerID] {
+			t.Blocking = append(t.Blocking, taskID)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return errors.AddContext(err, "dependencies.go: loadTaskDependencies - Rows")
+	}
+	return nil
+}
+
+func TaskPlanHandler(w http.ResponseWriter, r *http.Request, userID uint) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	plan, err := getTaskPlan(userID)
+	if err != nil {
+		errors.HandleServerError(w, err, "dependencies.go: TaskPlanHandler - getTaskPlan")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, plan)
+}
+
+// getTaskPlan returns the user's open tasks in an order that respects their
+// dependencies. Within a stage the most urgent tasks come first.
+func getTaskPlan(userID uint) (taskPlan, error) {
+	plan := taskPlan{Tasks: []task{}, Stages: [][]uint{}}
+
+	dbHandle, err := database.GetDBHandle()
+	if err != nil {
+		return plan, errors.AddContext(err, "dependencies.go: getTaskPlan - GetDBHandle")
+	}
+
+	access, accessArgs := taskAccess(userID)
+	rows, err := dbHandle.Query("SELECT "+taskColumns+" FROM tasks WHERE "+access+" ORDER BY deadline, id", accessArgs...)
+	if err != nil {
+		return plan, errors.AddContext(err, "dependencies.go: getTaskPlan - Query")
+	}
+	defer rows.Close()
+
+	var open []task
+	for rows.Next() {
+		t, err := scanTask(rows)
+		if err != nil {
+			return plan, errors.AddContext(err, "dependencies.go: getTaskPlan - Scan")
+		}
+		if s, ok := workflow.status(t.Status); ok && s.Terminal {
+			continue
+		}
+		open = append(open, t)
+	}
+	if err := rows.Err(); err != nil {
+		return plan, errors.AddContext(err, "dependencies.go: getTaskPlan - Rows")
+	}
+
+	slices.SortStableFunc(open, func(a, b task) int {
+		switch {
+		case *a.Urgency > *b.Urgency:
+			return -1
+		case *a.Urgency < *b.Urgency:
+			return 1
+		}
+		return 0
+	})
+
+	graph, err := loadDependencyGraph(dbHandle)
+	if err != nil {
+		return plan, errors.AddContext(err, "dependencies.go: getTaskPlan - loadDependencyGraph")
+	}
+
+	ids := make([]uint, len(open))
+	byID := make(map[uint]task, len(open))
+	for i, t := range open {
+		ids[i] = t.ID
+		byID[t.ID] = t
+	}
+
+	if plan.Stages, err = graph.stages(ids); err != nil {
+		return plan, errors.AddContext(err, "dependencies.go: getTaskPlan - stages")
+	}
+
+	for _, stage := range plan.Stages {
+		for _, id := range stage {
+			plan.Tasks = append(plan.Tasks, byID[id])
+		}
+	}
+
+	tasks := make([]*task, len(plan.Tasks))
+	for i := range plan.Tasks {
+		tasks[i] = &plan.Tasks[i]
+	}
+	if err := loadTaskDetails(userID, tasks...); err != nil {
+		return plan, errors.AddContext(err, "dependencies.go: getTaskPlan - loadTaskDetails")
+	}
+	return plan, nil
+}
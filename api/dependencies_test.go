@@ -0,0 +1,155 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"slices"
+	"strconv"
+	"testing"
+)
+
+func addTestBlocker(t *testing.T, taskID string, blockerID string) *httptest.ResponseRecorder {
+	return performTaskRequest(t, "POST", "/api/tasks/"+taskID+"/blockers", []byte(`{"blocker_id": `+blockerID+`}`), nil, 1)
+}
+
+func TestDependencyGraph(t *testing.T) {
+	// 3 is blocked by 2, which is blocked by 1. 4 is independent.
+	graph := dependencyGraph{3: {2}, 2: {1}}
+
+	if !graph.reaches(3, 1) {
+		t.Error("Expected 3 to be transitively blocked by 1")
+	}
+	if graph.reaches(1, 3) {
+		t.Error("Expected 1 not to be blocked by 3")
+	}
+
+	stages, err := graph.stages([]uint{4, 3, 2, 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := [][]uint{{4, 1}, {2}, {3}}; !reflect.DeepEqual(stages, expected) {
+		t.Errorf("Expected stages %v, got %v", expected, stages)
+	}
+
+	// Blockers that are not part of the plan, e.g. completed tasks, are
+	// ignored
+	stages, err = graph.stages([]uint{3, 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := [][]uint{{2}, {3}}; !reflect.DeepEqual(stages, expected) {
+		t.Errorf("Expected stages %v, got %v", expected, stages)
+	}
+
+	cyclic := dependencyGraph{1: {2}, 2: {1}}
+	if _, err := cyclic.stages([]uint{1, 2}); err != errDependencyCycle {
+		t.Errorf("Expected errDependencyCycle, got %v", err)
+	}
+}
+
+func TestAddBlockerRejectsCycles(t *testing.T) {
+	first := createTestTask(t, 1, "Issue claim")
+	second := createTestTask(t, 1, "Serve claim form")
+	third := createTestTask(t, 1, "File certificate of service")
+
+	if rr := addTestBlocker(t, second, first); rr.Code != http.StatusCreated {
+		t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusCreated)
+	}
+	if rr := addTestBlocker(t, third, second); rr.Code != http.StatusCreated {
+		t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusCreated)
+	}
+
+	// Both a transitive cycle and a task blocking itself are rejected
+	for _, blocked := range []string{first, third} {
+		if rr := addTestBlocker(t, blocked, third); rr.Code != http.StatusConflict {
+			t.Errorf("%s blocked by %s: handler returned wrong status code: got %v want %v", blocked, third, rr.Code, http.StatusConflict)
+		}
+	}
+
+	taskData, err := getTask(1, second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	firstID, _ := strconv.Atoi(first)
+	thirdID, _ := strconv.Atoi(third)
+	if !reflect.DeepEqual(taskData.BlockedBy, []uint{uint(firstID)}) || !reflect.DeepEqual(taskData.Blocking, []uint{uint(thirdID)}) {
+		t.Errorf("Expected blocked_by [%s] and blocking [%s], got %v and %v", first, third, taskData.BlockedBy, taskData.Blocking)
+	}
+
+	// Task 3 belongs to user 2
+	if rr := addTestBlocker(t, first, "3"); rr.Code != http.StatusNotFound {
+		t.Errorf("handler returned wrong status code for another user's task: got %v want %v", rr.Code, http.StatusNotFound)
+	}
+}
+
+func TestCompleteBlockedTask(t *testing.T) {
+	blocker := createTestTask(t, 1, "Receive payment")
+	blocked := createTestTask(t, 1, "Release funds")
+	if rr := addTestBlocker(t, blocked, blocker); rr.Code != http.StatusCreated {
+		t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusCreated)
+	}
+
+	rr := performTaskRequest(t, "PATCH", "/api/tasks/"+blocked, []byte(`{"status": "COMPLETE"}`), map[string]string{"If-Match": "*"}, 1)
+	if rr.Code != http.StatusConflict {
+		t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusConflict)
+	}
+
+	// Every terminal status is gated, but starting work is not
+	rr = performTaskRequest(t, "PATCH", "/api/tasks/"+blocked, []byte(`{"status": "CANCELLED"}`), map[string]string{"If-Match": "*"}, 1)
+	if rr.Code != http.StatusConflict {
+		t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusConflict)
+	}
+	rr = performTaskRequest(t, "PATCH", "/api/tasks/"+blocked, []byte(`{"status": "IN_PROGRESS"}`), map[string]string{"If-Match": "*"}, 1)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusOK)
+	}
+
+	rr = performTaskRequest(t, "PATCH", "/api/tasks/"+blocker, []byte(`{"status": "COMPLETE"}`), map[string]string{"If-Match": "*"}, 1)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusOK)
+	}
+
+	rr = performTaskRequest(t, "PATCH", "/api/tasks/"+blocked, []byte(`{"status": "COMPLETE"}`), map[string]string{"If-Match": "*"}, 1)
+	if rr.Code != http.StatusOK {
+		t.Errorf("handler returned wrong status code once the blocker is complete: got %v want %v", rr.Code, http.StatusOK)
+	}
+}
+
+func TestTaskPlan(t *testing.T) {
+	first := createTestTask(t, 1, "Plan step one")
+	second := createTestTask(t, 1, "Plan step two")
+	if rr := addTestBlocker(t, first, second); rr.Code != http.StatusCreated {
+		t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusCreated)
+	}
+
+	req, err := http.NewRequest("GET", "/api/tasks/plan", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		TaskPlanHandler(w, r, 1)
+	}).ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusOK)
+	}
+
+	var plan taskPlan
+	if err := json.Unmarshal(rr.Body.Bytes(), &plan); err != nil {
+		t.Fatalf("Failed to parse response as JSON: %v", err)
+	}
+
+	order := make([]string, len(plan.Tasks))
+	for i, task := range plan.Tasks {
+		order[i] = strconv.FormatUint(uint64(task.ID), 10)
+		if task.Status == "COMPLETE" {
+			t.Errorf("Expected only open tasks in the plan, got task %d", task.ID)
+		}
+	}
+	if slices.Index(order, second) > slices.Index(order, first) {
+		t.Errorf("Expected task %s before task %s in %v", second, first, order)
+	}
+}
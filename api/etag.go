@@ -0,0 +1,73 @@
+package api
+
+import (
+	"HMCTS-Developer-Challenge/errors"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var errPreconditionRequired = errors.Error("Precondition Required")
+var errPreconditionFailed = errors.Error("Precondition Failed")
+
+// taskETag is a strong validator for a single task. The version column is
+// incremented by every write so it changes whenever the stored task does,
+// which is why a single task leaves out its urgency. Times are rendered in
+// the user's timezone, so the zone the task was localized to is part of it.
+func taskETag(t task) string {
+	return versionETag(t.Version, t.Deadline.Location())
+}
+
+// versionETag is the ETag of a task at version rendered in loc.
+func versionETag(version uint, loc *time.Location) string {
+	return `"` + strconv.FormatUint(uint64(version), 10) + "-" + loc.String() + `"`
+}
+
+// bodyETag is a weak validator derived from a response body, used for
+// representations such as the task list that have no version of their own.
+func bodyETag(body []byte) string {
+	sum := sha256.Sum256(body)
+	return `W/"` + hex.EncodeToString(sum[:16]) + `"`
+}
+
+// checkIfMatch validates an If-Match header against the current ETag of a
+// resource that is about to be modified.
+func checkIfMatch(ifMatch string, etag string) error {
+	if ifMatch == "" {
+		return errPreconditionRequired
+	}
+	if !etagListMatches(ifMatch, etag, false) {
+		return errPreconditionFailed
+	}
+	return nil
+}
+
+// notModified reports whether the If-None-Match header of r matches etag.
+func notModified(r *http.Request, etag string) bool {
+	ifNoneMatch := r.Header.Get("If-None-Match")
+	return ifNoneMatch != "" && etagListMatches(ifNoneMatch, etag, true)
+}
+
+// etagListMatches compares etag against a comma separated list of entity
+// tags. If-Match uses the strong comparison and If-None-Match the weak one
+// (RFC 9110 section 8.8.3.2).
+func etagListMatches(header string, etag string, weak bool) bool {
+	if strings.TrimSpace(header) == "*" {
+		return true
+	}
+
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if weak {
+			if strings.TrimPrefix(candidate, "W/") == strings.TrimPrefix(etag, "W/") {
+				return true
+			}
+		} else if !strings.HasPrefix(candidate, "W/") && !strings.HasPrefix(etag, "W/") && candidate == etag {
+			return true
+		}
+	}
+	return false
+}
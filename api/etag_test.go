@@ -0,0 +1,181 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func performTaskRequest(t *testing.T, method, url string, body []byte, headers map[string]string, userID uint) *httptest.ResponseRecorder {
+	return performHandlerRequest(t, TasksHandler, method, url, body, headers, userID)
+}
+
+// performHandlerRequest sends a JSON request to one of the API handlers as if
+// userID were logged in.
+func performHandlerRequest(t *testing.T, handler func(http.ResponseWriter, *http.Request, uint), method, url string, body []byte, headers map[string]string, userID uint) *httptest.ResponseRecorder {
+	req, err := http.NewRequest(method, url, bytes.NewBuffer(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
+
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		handler(w, r, userID)
+	}).ServeHTTP(rr, req)
+	return rr
+}
+
+func TestGetTaskETag(t *testing.T) {
+	taskID := createTestTask(t, 1, "Task with ETag")
+
+	rr := performTaskRequest(t, "GET", "/api/tasks/"+taskID, nil, nil, 1)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusOK)
+	}
+
+	etag := rr.Header().Get("ETag")
+	if etag != `"1-Europe/London"` {
+		t.Fatalf("Expected ETag \"1-Europe/London\" for a new task, got %s", etag)
+	}
+
+	rr = performTaskRequest(t, "GET", "/api/tasks/"+taskID, nil, map[string]string{"If-None-Match": etag}, 1)
+	if rr.Code != http.StatusNotModified {
+		t.Errorf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusNotModified)
+	}
+	if rr.Body.Len() != 0 {
+		t.Errorf("Expected an empty body for a 304 response, got %s", rr.Body.String())
+	}
+
+	rr = performTaskRequest(t, "GET", "/api/tasks/"+taskID, nil, map[string]string{"If-None-Match": `"0", W/` + etag}, 1)
+	if rr.Code != http.StatusNotModified {
+		t.Errorf("handler returned wrong status code for a weak match: got %v want %v", rr.Code, http.StatusNotModified)
+	}
+}
+
+func TestGetTasksETag(t *testing.T) {
+	rr := performTaskRequest(t, "GET", "/api/tasks/", nil, nil, 1)
+	etag := rr.Header().Get("ETag")
+	if etag == "" {
+		t.Fatal("Expected an ETag on the task list")
+	}
+
+	rr = performTaskRequest(t, "GET", "/api/tasks/", nil, map[string]string{"If-None-Match": etag}, 1)
+	if rr.Code != http.StatusNotModified {
+		t.Errorf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusNotModified)
+	}
+
+	// Changing a task must change the list ETag
+	taskID := createTestTask(t, 1, "Task changing the list")
+	rr = performTaskRequest(t, "GET", "/api/tasks/", nil, map[string]string{"If-None-Match": etag}, 1)
+	if rr.Code != http.StatusOK {
+		t.Errorf("handler returned wrong status code after adding task %s: got %v want %v", taskID, rr.Code, http.StatusOK)
+	}
+}
+
+func TestEditTaskRequiresIfMatch(t *testing.T) {
+	taskID := createTestTask(t, 1, "Task requiring If-Match")
+	body, err := json.Marshal(jsonData{Name: "Edited", Status: "COMPLETE", Deadline: "2025-12-31 00:00:00"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr := performTaskRequest(t, "PUT", "/api/tasks/"+taskID, body, nil, 1)
+	if rr.Code != http.StatusPreconditionRequired {
+		t.Errorf("PUT: handler returned wrong status code: got %v want %v", rr.Code, http.StatusPreconditionRequired)
+	}
+
+	rr = performTaskRequest(t, "PATCH", "/api/tasks/"+taskID, []byte(`{"status": "COMPLETE"}`), nil, 1)
+	if rr.Code != http.StatusPreconditionRequired {
+		t.Errorf("PATCH: handler returned wrong status code: got %v want %v", rr.Code, http.StatusPreconditionRequired)
+	}
+
+	rr = performTaskRequest(t, "DELETE", "/api/tasks/"+taskID, nil, nil, 1)
+	if rr.Code != http.StatusPreconditionRequired {
+		t.Errorf("DELETE: handler returned wrong status code: got %v want %v", rr.Code, http.StatusPreconditionRequired)
+	}
+}
+
+func TestEditTaskStaleIfMatch(t *testing.T) {
+	taskID := createTestTask(t, 1, "Task edited twice")
+	body, err := json.Marshal(jsonData{Name: "First edit", Status: "INCOMPLETE", Deadline: "2025-12-31 00:00:00"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Both tabs loaded version 1, the first save wins
+	loaded := versionETag(1, courtLocation)
+	rr := performTaskRequest(t, "PUT", "/api/tasks/"+taskID, body, map[string]string{"If-Match": loaded}, 1)
+	if rr.Code != http.StatusNoContent {
+		t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusNoContent)
+	}
+
+	body, err = json.Marshal(jsonData{Name: "Second edit", Status: "INCOMPLETE", Deadline: "2025-12-31 00:00:00"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr = performTaskRequest(t, "PUT", "/api/tasks/"+taskID, body, map[string]string{"If-Match": loaded}, 1)
+	if rr.Code != http.StatusPreconditionFailed {
+		t.Errorf("PUT: handler returned wrong status code: got %v want %v", rr.Code, http.StatusPreconditionFailed)
+	}
+
+	rr = performTaskRequest(t, "PATCH", "/api/tasks/"+taskID, []byte(`{"status": "COMPLETE"}`), map[string]string{"If-Match": loaded}, 1)
+	if rr.Code != http.StatusPreconditionFailed {
+		t.Errorf("PATCH: handler returned wrong status code: got %v want %v", rr.Code, http.StatusPreconditionFailed)
+	}
+
+	rr = performTaskRequest(t, "DELETE", "/api/tasks/"+taskID, nil, map[string]string{"If-Match": loaded}, 1)
+	if rr.Code != http.StatusPreconditionFailed {
+		t.Errorf("DELETE: handler returned wrong status code: got %v want %v", rr.Code, http.StatusPreconditionFailed)
+	}
+
+	taskData, err := getTask(1, taskID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if taskData.Name != "First edit" || taskData.Version != 2 {
+		t.Errorf("Expected the first edit at version 2 to survive, got %+v", taskData)
+	}
+}
+
+func TestPatchTaskReturnsNewETag(t *testing.T) {
+	taskID := createTestTask(t, 1, "Task patched with ETag")
+
+	rr := performTaskRequest(t, "PATCH", "/api/tasks/"+taskID, []byte(`{"status": "COMPLETE"}`), map[string]string{"If-Match": versionETag(1, courtLocation)}, 1)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusOK)
+	}
+	if etag := rr.Header().Get("ETag"); etag != versionETag(2, courtLocation) {
+		t.Errorf("Expected the version 2 ETag after the patch, got %s", etag)
+	}
+}
+
+func TestETagListMatches(t *testing.T) {
+	cases := []struct {
+		header   string
+		etag     string
+		weak     bool
+		expected bool
+	}{
+		{`"1"`, `"1"`, false, true},
+		{`"2"`, `"1"`, false, false},
+		{`"0", "1"`, `"1"`, false, true},
+		{`*`, `"1"`, false, true},
+		{`W/"1"`, `"1"`, false, false},
+		{`W/"1"`, `"1"`, true, true},
+		{`"1"`, `W/"1"`, true, true},
+		{`"1"`, `W/"1"`, false, false},
+	}
+
+	for _, c := range cases {
+		if result := etagListMatches(c.header, c.etag, c.weak); result != c.expected {
+			t.Errorf("etagListMatches(%s, %s, %v): expected %v, got %v", c.header, c.etag, c.weak, c.expected, result)
+		}
+	}
+}
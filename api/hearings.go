@@ -0,0 +1,603 @@
+package api
+
+import (
+	"HMCTS-Developer-Challenge/audit"
+	"HMCTS-Developer-Challenge/calendar"
+	"HMCTS-Developer-Challenge/database"
+	"HMCTS-Developer-Challenge/errors"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	maxHearingTypeLength = 64
+
+	// maxHearingOffset is the furthest a deadline can be from its hearing,
+	// in either direction.
+	maxHearingOffset = 365
+)
+
+// A deadline rule counts its offset in calendar days or in working days,
+// which skip weekends and bank holidays.
+const (
+	offsetUnitDays        = "DAYS"
+	offsetUnitWorkingDays = "WORKING_DAYS"
+)
+
+type hearing struct {
+	ID        uint      `json:"id"`
+	CaseID    uint      `json:"case_id"`
+	Type      string    `json:"type"`
+	StartsAt  time.Time `json:"starts_at"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type hearingData struct {
+	Type string `json:"type"`
+
+	// StartsAt is read in the user's zone when it has no offset.
+	StartsAt string `json:"starts_at"`
+}
+
+// deadlineRule puts a task's deadline a number of days before (a negative
+// offset) or after a hearing, at the time the hearing starts.
+type deadlineRule struct {
+	HearingID uint   `json:"hearing_id"`
+	Offset    int    `json:"offset"`
+	Unit      string `json:"unit"`
+}
+
+// deadlineChange reports a deadline that moved with its hearing.
+type deadlineChange struct {
+	TaskID           uint      `json:"task_id"`
+	Name             string    `json:"name"`
+	PreviousDeadline time.Time `json:"previous_deadline"`
+	Deadline         time.Time `json:"deadline"`
+}
+
+// hearingUpdate is the response to moving a hearing. Only the tasks the user
+// can see are listed; the others that moved are counted.
+type hearingUpdate struct {
+	Hearing         hearing          `json:"hearing"`
+	Recalculated    []deadlineChange `json:"recalculated"`
+	OtherTasksMoved int              `json:"other_tasks_moved"`
+}
+
+var errHearingNotFound = errors.Error("Hearing Not Found")
+var errInvalidHearing = errors.Error("Invalid Hearing")
+var errInvalidDeadlineRule = errors.Error("Invalid Deadline Rule")
+var errHearingCaseMismatch = errors.Error("Hearing Belongs To Another Case")
+
+func hearingsHandler(w http.ResponseWriter, r *http.Request, userID uint, caseID string, hearingID string) {
+	switch r.Method {
+	case http.MethodGet:
+		var hearings any
+		var err error
+		if hearingID == "" {
+			hearings, err = getHearings(userID, caseID)
+		} else {
+			hearings, err = getHearing(userID, caseID, hearingID)
+		}
+
+		if err == errCaseNotFound || err == errHearingNotFound {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			break
+		} else if err != nil {
+			errors.HandleServerError(w, err, "hearings.go: hearingsHandler - getHearings")
+			break
+		}
+		writeJSON(w, http.StatusOK, hearings)
+	case http.MethodPost:
+		if hearingID != "" {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			break
+		}
+
+		var data hearingData
+		if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+			http.Error(w, "Invalid JSON", http.StatusBadRequest)
+			break
+		}
+
+		h, err := addHearing(userID, caseID, data)
+		if err == errCaseNotFound {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			break
+		} else if err == errInvalidHearing {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			break
+		} else if err != nil {
+			errors.HandleServerError(w, err, "hearings.go: hearingsHandler - addHearing")
+			break
+		}
+		writeJSON(w, http.StatusCreated, h)
+	case http.MethodPut:
+		if hearingID == "" {
+			http.Error(w, "Hearing ID Required", http.StatusBadRequest)
+			break
+		}
+
+		var data hearingData
+		if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+			http.Error(w, "Invalid JSON", http.StatusBadRequest)
+			break
+		}
+
+		update, err := editHearing(userID, caseID, hearingID, data, taskEditOptions{SourceIP: audit.SourceIP(r)})
+		if err == errHearingNotFound {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			break
+		} else if err == errInvalidHearing {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			break
+		} else if err != nil {
+			errors.HandleServerError(w, err, "hearings.go: hearingsHandler - editHearing")
+			break
+		}
+		writeJSON(w, http.StatusOK, update)
+	case http.MethodDelete:
+		if hearingID == "" {
+			http.Error(w, "Hearing ID Required", http.StatusBadRequest)
+			break
+		}
+
+		if err := deleteHearing(userID, caseID, hearingID, taskEditOptions{SourceIP: audit.SourceIP(r)}); err == errHearingNotFound {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			break
+		} else if err != nil {
+			errors.HandleServerError(w, err, "hearings.go: hearingsHandler - deleteHearing")
+			break
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	case http.MethodOptions:
+		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// taskDeadlineRuleHandler makes the deadline of a task follow a hearing, or
+// stop following it, through /api/tasks/{id}/deadline-rule.
+func taskDeadlineRuleHandler(w http.ResponseWriter, r *http.Request, userID uint, taskID string) {
+	switch r.Method {
+	case http.MethodPut, http.MethodDelete:
+		var rule *deadlineRule
+		if r.Method == http.MethodPut {
+			rule = &deadlineRule{}
+			if err := json.NewDecoder(r.Body).Decode(rule); err != nil {
+				http.Error(w, "Invalid JSON", http.StatusBadRequest)
+				break
+			}
+		}
+
+		t, err := setTaskDeadlineRule(userID, taskID, rule, parseTaskEditOptions(r))
+		if err == errTaskNotFound {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			break
+		} else if err == errHearingNotFound || err == errInvalidDeadlineRule || err == errHearingCaseMismatch {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			break
+		} else if err == errPreconditionFailed {
+			http.Error(w, err.Error(), http.StatusPreconditionFailed)
+			break
+		} else if err != nil {
+			errors.HandleServerError(w, err, "hearings.go: taskDeadlineRuleHandler - setTaskDeadlineRule")
+			break
+		}
+
+		w.Header().Set("ETag", taskETag(t))
+		writeJSON(w, http.StatusOK, t)
+	case http.MethodOptions:
+		w.Header().Set("Access-Control-Allow-Methods", "PUT, DELETE, OPTIONS")
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (rule *deadlineRule) normalize() error {
+	rule.Unit = strings.ToUpper(strings.TrimSpace(rule.Unit))
+	if rule.Unit == "" {
+		rule.Unit = offsetUnitDays
+	}
+	if rule.HearingID == 0 || (rule.Unit != offsetUnitDays && rule.Unit != offsetUnitWorkingDays) ||
+		rule.Offset < -maxHearingOffset || rule.Offset > maxHearingOffset {
+		return errInvalidDeadlineRule
+	}
+	return nil
+}
+
+// deadline returns the deadline the rule gives for a hearing starting at
+// start. Days are counted in the courts' zone so the deadline keeps the
+// hearing's time of day across the changes to and from British Summer Time.
+func (rule deadlineRule) deadline(start time.Time) time.Time {
+	start = start.In(courtLocation)
+	if rule.Unit == offsetUnitWorkingDays {
+		return calendar.Current().AddWorkingDays(calendarDivision, start, rule.Offset)
+	}
+	return start.AddDate(0, 0, rule.Offset)
+}
+
+// applyDeadlineRule looks up the hearing of a rule and returns the case the
+// task belongs to through it and the deadline the rule gives. A task already
+// linked to a case can only follow that case's hearings.
+func applyDeadlineRule(q rowQueryer, rule deadlineRule, caseID *uint) (*uint, time.Time, error) {
+	var hearingCaseID uint
+	var start time.Time
+	err := q.QueryRow("SELECT case_id, starts_at FROM hearings WHERE id = ?", rule.HearingID).Scan(&hearingCaseID, &start)
+	if err == sql.ErrNoRows {
+		return caseID, start, errHearingNotFound
+	} else if err != nil {
+		return caseID, start, err
+	}
+	if caseID != nil && *caseID != hearingCaseID {
+		return caseID, start, errHearingCaseMismatch
+	}
+	return &hearingCaseID, rule.deadline(start), nil
+}
+
+// normalizeHearingData checks a hearing and returns when it starts, reading
+// a time without an offset in loc.
+func normalizeHearingData(data hearingData, loc *time.Location) (hearingData, time.Time, error) {
+	data.Type = strings.TrimSpace(data.Type)
+	if utf8.RuneCountInString(data.Type) > maxHearingTypeLength {
+		return data, time.Time{}, errInvalidHearing
+	}
+
+	start, ok := parseTaskTime(strings.TrimSpace(data.StartsAt), loc)
+	if !ok {
+		return data, time.Time{}, errInvalidHearing
+	}
+	return data, start, nil
+}
+
+// getHearings lists the hearings of a case in date order.
+func getHearings(userID uint, caseID string) ([]hearing, error) {
+	hearings := []hearing{}
+
+	dbHandle, err := database.GetDBHandle()
+	if err != nil {
+		return hearings, errors.AddContext(err, "hearings.go: getHearings - GetDBHandle")
+	}
+
+	if exists, err := caseExists(dbHandle, parseID(caseID)); err != nil {
+		return hearings, errors.AddContext(err, "hearings.go: getHearings - caseExists")
+	} else if !exists {
+		return hearings, errCaseNotFound
+	}
+
+	loc, err := userLocation(userID)
+	if err != nil {
+		return hearings, errors.AddContext(err, "hearings.go: getHearings - userLocation")
+	}
+
+	rows, err := dbHandle.Query("SELECT id, case_id, hearing_type, starts_at, created_at FROM hearings WHERE case_id = ? ORDER BY starts_at, id", caseID)
+	if err != nil {
+		return hearings, errors.AddContext(err, "hearings.go: getHearings - Query")
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		h, err := scanHearing(rows, loc)
+		if err != nil {
+			return hearings, errors.AddContext(err, "hearings.go: getHearings - Scan")
+		}
+		hearings = append(hearings, h)
+	}
+	if err := rows.Err(); err != nil {
+		return hearings, errors.AddContext(err, "hearings.go: getHearings - Rows")
+	}
+	return hearings, nil
+}
+
+func getHearing(userID uint, caseID, hearingID string) (hearing, error) {
+	dbHandle, err := database.GetDBHandle()
+	if err != nil {
+		return hearing{}, errors.AddContext(err, "hearings.go: getHearing - GetDBHandle")
+	}
+
+	loc, err := userLocation(userID)
+	if err != nil {
+		return hearing{}, errors.AddContext(err, "hearings.go: getHearing - userLocation")
+	}
+
+	h, err := scanHearing(dbHandle.QueryRow("SELECT id, case_id, hearing_type, starts_at, created_at FROM hearings WHERE id = ? AND case_id = ?", hearingID, caseID), loc)
+	if err == sql.ErrNoRows {
+		return h, errHearingNotFound
+	} else if err != nil {
+		return h, errors.AddContext(err, "hearings.go: getHearing - QueryRow")
+	}
+	return h, nil
+}
+
+// scanHearing reads a hearing, showing its times in loc.
+func scanHearing(row rowScanner, loc *time.Location) (hearing, error) {
+	var h hearing
+	err := row.Scan(&h.ID, &h.CaseID, &h.Type, &h.StartsAt, &h.CreatedAt)
+	h.StartsAt, h.CreatedAt = h.StartsAt.In(loc), h.CreatedAt.In(loc)
+	return h, err
+}
+
+func addHearing(userID uint, caseID string, data hearingData) (hearing, error) {
+	loc, err := userLocation(userID)
+	if err != nil {
+		return hearing{}, errors.AddContext(err, "hearings.go: addHearing - userLocation")
+	}
+
+	data, start, err := normalizeHearingData(data, loc)
+	if err != nil {
+		return hearing{}, err
+	}
+
+	dbHandle, err := database.GetDBHandle()
+	if err != nil {
+		return hearing{}, errors.AddContext(err, "hearings.go: addHearing - GetDBHandle")
+	}
+
+	if exists, err := caseExists(dbHandle, parseID(caseID)); err != nil {
+		return hearing{}, errors.AddContext(err, "hearings.go: addHearing - caseExists")
+	} else if !exists {
+		return hearing{}, errCaseNotFound
+	}
+
+	result, err := dbHandle.Exec("INSERT INTO hearings (case_id, hearing_type, starts_at) VALUES (?, ?, ?)", caseID, data.Type, start)
+	if err != nil {
+		return hearing{}, errors.AddContext(err, "hearings.go: addHearing - Exec")
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return hearing{}, errors.AddContext(err, "hearings.go: addHearing - LastInsertId")
+	}
+	return getHearing(userID, caseID, strconv.FormatInt(id, 10))
+}
+
+// editHearing changes a hearing and moves the deadlines of the open tasks
+// that follow it, all in one transaction.
+func editHearing(userID uint, caseID, hearingID string, data hearingData, options taskEditOptions) (hearingUpdate, error) {
+	update := hearingUpdate{Recalculated: []deadlineChange{}}
+
+	loc, err := userLocation(userID)
+	if err != nil {
+		return update, errors.AddContext(err, "hearings.go: editHearing - userLocation")
+	}
+
+	data, start, err := normalizeHearingData(data, loc)
+	if err != nil {
+		return update, err
+	}
+
+	dbHandle, err := database.GetDBHandle()
+	if err != nil {
+		return update, errors.AddContext(err, "hearings.go: editHearing - GetDBHandle")
+	}
+
+	tx, err := dbHandle.Begin()
+	if err != nil {
+		return update, errors.AddContext(err, "hearings.go: editHearing - Begin")
+	}
+	defer tx.Rollback()
+
+	var id uint
+	if err := tx.QueryRow("SELECT id FROM hearings WHERE id = ? AND case_id = ? FOR UPDATE", hearingID, caseID).Scan(&id); err == sql.ErrNoRows {
+		return update, errHearingNotFound
+	} else if err != nil {
+		return update, errors.AddContext(err, "hearings.go: editHearing - QueryRow")
+	}
+
+	if _, err := tx.Exec("UPDATE hearings SET hearing_type = ?, starts_at = ? WHERE id = ?", data.Type, start, id); err != nil {
+		return update, errors.AddContext(err, "hearings.go: editHearing - Exec")
+	}
+
+	if err := recalculateDeadlines(tx, userID, id, start, &update, options); err != nil {
+		return update, errors.AddContext(err, "hearings.go: editHearing - recalculateDeadlines")
+	}
+
+	if err := tx.Commit(); err != nil {
+		return update, errors.AddContext(err, "hearings.go: editHearing - Commit")
+	}
+
+	for i := range update.Recalculated {
+		change := &update.Recalculated[i]
+		change.PreviousDeadline, change.Deadline = change.PreviousDeadline.In(loc), change.Deadline.In(loc)
+	}
+
+	update.Hearing, err = getHearing(userID, caseID, hearingID)
+	return update, err
+}
+
+// recalculateDeadlines moves the deadlines of the tasks following a hearing
+// to match its new start and records each move in update. Tasks in a
+// terminal status keep the deadline they were finished against.
+func recalculateDeadlines(tx *sql.Tx, userID uint, hearingID uint, start time.Time, update *hearingUpdate, options taskEditOptions) error {
+	type follower struct {
+		change  deadlineChange
+		status  string
+		rule    deadlineRule
+		visible bool
+	}
+
+	access, accessArgs := taskAccess(userID)
+	rows, err := tx.Query(
+		"SELECT id, name, deadline, status, hearing_offset, hearing_offset_unit, "+access+" FROM tasks WHERE hearing_id = ? ORDER BY id FOR UPDATE",
+		append(accessArgs, hearingID)...,
+	)
+	if err != nil {
+		return err
+	}
+
+	var followers []follower
+	for rows.Next() {
+		f := follower{rule: deadlineRule{HearingID: hearingID}}
+		if err := rows.Scan(&f.change.TaskID, &f.change.Name, &f.change.PreviousDeadline, &f.status, &f.rule.Offset, &f.rule.Unit, &f.visible); err != nil {
+			rows.Close()
+			return err
+		}
+		followers = append(followers, f)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	ids := make([]uint, len(followers))
+	for i, f := range followers {
+		ids[i] = f.change.TaskID
+	}
+	changes, err := trackTasks(tx, ids...)
+	if err != nil {
+		return err
+	}
+
+	for _, f := range followers {
+		if s, ok := workflow.status(f.status); ok && s.Terminal {
+			continue
+		}
+
+		f.change.Deadline = f.rule.deadline(start)
+		if f.change.PreviousDeadline.Equal(f.change.Deadline) {
+			continue
+		}
+		if _, err := tx.Exec("UPDATE tasks SET deadline = ?, version = version + 1 WHERE id = ?", f.change.Deadline, f.change.TaskID); err != nil {
+			return err
+		}
+
+		if f.visible {
+			update.Recalculated = append(update.Recalculated, f.change)
+		} else {
+			update.OtherTasksMoved++
+		}
+	}
+	return changes.record(tx, userID, options)
+}
+
+// deleteHearing removes a hearing. The tasks that followed it keep their
+// current deadlines.
+func deleteHearing(userID uint, caseID, hearingID string, options taskEditOptions) error {
+	dbHandle, err := database.GetDBHandle()
+	if err != nil {
+		return errors.AddContext(err, "hearings.go: deleteHearing - GetDBHandle")
+	}
+
+	tx, err := dbHandle.Begin()
+	if err != nil {
+		return errors.AddContext(err, "hearings.go: deleteHearing - Begin")
+	}
+	defer tx.Rollback()
+
+	var id uint
+	if err := tx.QueryRow("SELECT id FROM hearings WHERE id = ? AND case_id = ? FOR UPDATE", hearingID, caseID).Scan(&id); err == sql.ErrNoRows {
+		return errHearingNotFound
+	} else if err != nil {
+		return errors.AddContext(err, "hearings.go: deleteHearing - QueryRow")
+	}
+
+	changes, err := trackTaskQuery(tx, "SELECT id FROM tasks WHERE hearing_id = ? ORDER BY id", id)
+	if err != nil {
+		return errors.AddContext(err, "hearings.go: deleteHearing - trackTaskQuery")
+	}
+
+	if _, err := tx.Exec(
+		"UPDATE tasks SET hearing_id = NULL, hearing_offset = NULL, hearing_offset_unit = NULL, version = version + 1 WHERE hearing_id = ?", id,
+	); err != nil {
+		return errors.AddContext(err, "hearings.go: deleteHearing - Exec")
+	}
+	if _, err := tx.Exec("DELETE FROM hearings WHERE id = ?", id); err != nil {
+		return errors.AddContext(err, "hearings.go: deleteHearing - Exec")
+	}
+	if err := changes.record(tx, userID, options); err != nil {
+		return errors.AddContext(err, "hearings.go: deleteHearing - record")
+	}
+
+	if err := tx.Commit(); err != nil {
+		return errors.AddContext(err, "hearings.go: deleteHearing - Commit")
+	}
+	return nil
+}
+
+// setTaskDeadlineRule makes the deadline of a task follow a hearing and moves
+// it there straight away. A nil rule stops the deadline following its
+// hearing and leaves it where it is.
+func setTaskDeadlineRule(userID uint, taskID string, rule *deadlineRule, options taskEditOptions) (task, error) {
+	current, err := getTask(userID, taskID)
+	if err != nil {
+		return current, err
+	}
+
+	dbHandle, err := database.GetDBHandle()
+	if err != nil {
+		return current, errors.AddContext(err, "hearings.go: setTaskDeadlineRule - GetDBHandle")
+	}
+
+	tx, err := dbHandle.Begin()
+	if err != nil {
+		return current, errors.AddContext(err, "hearings.go: setTaskDeadlineRule - Begin")
+	}
+	defer tx.Rollback()
+
+	changes, err := trackTasks(tx, current.ID)
+	if err != nil {
+		return current, errors.AddContext(err, "hearings.go: setTaskDeadlineRule - trackTasks")
+	}
+
+	var result sql.Result
+	if rule == nil {
+		result, err = tx.Exec(
+			"UPDATE tasks SET hearing_id = NULL, hearing_offset = NULL, hearing_offset_unit = NULL, version = version + 1 WHERE id = ? AND version = ?",
+			current.ID, current.Version,
+		)
+	} else {
+		if err := rule.normalize(); err != nil {
+			return current, err
+		}
+		caseID, deadline, err := applyDeadlineRule(tx, *rule, current.CaseID)
+		if err == errHearingNotFound || err == errHearingCaseMismatch {
+			return current, err
+		} else if err != nil {
+			return current, errors.AddContext(err, "hearings.go: setTaskDeadlineRule - applyDeadlineRule")
+		}
+
+		result, err = tx.Exec(
+			"UPDATE tasks SET case_id = ?, deadline = ?, hearing_id = ?, hearing_offset = ?, hearing_offset_unit = ?, version = version + 1 WHERE id = ? AND version = ?",
+			caseID, deadline, rule.HearingID, rule.Offset, rule.Unit, current.ID, current.Version,
+		)
+	}
+	if err != nil {
+		return current, errors.AddContext(err, "hearings.go: setTaskDeadlineRule - Exec")
+	}
+	if affected, err := result.RowsAffected(); err != nil {
+		return current, errors.AddContext(err, "hearings.go: setTaskDeadlineRule - RowsAffected")
+	} else if affected == 0 {
+		return current, errPreconditionFailed
+	}
+
+	if err := changes.record(tx, userID, options); err != nil {
+		return current, errors.AddContext(err, "hearings.go: setTaskDeadlineRule - record")
+	}
+	if err := tx.Commit(); err != nil {
+		return current, errors.AddContext(err, "hearings.go: setTaskDeadlineRule - Commit")
+	}
+	return getTask(userID, taskID)
+}
+
+// clearDeadlineRule stops the deadline of a task following its hearing.
+func clearDeadlineRule(tx *sql.Tx, taskID uint) error {
+	_, err := tx.Exec("UPDATE tasks SET hearing_id = NULL, hearing_offset = NULL, hearing_offset_unit = NULL WHERE id = ?", taskID)
+	return err
+}
+
+// parseID converts an ID from a URL path, giving 0 for anything that is not
+// an ID so that lookups find nothing.
+func parseID(id string) uint {
+	n, err := strconv.ParseUint(id, 10, 32)
+	if err != nil {
+		return 0
+	}
+	return uint(n)
+}
@@ -0,0 +1,139 @@
+package api
+
+import (
+	"HMCTS-Developer-Challenge/database"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestDeadlineRule(t *testing.T) {
+	start := time.Date(2025, 6, 16, 10, 0, 0, 0, courtLocation)
+
+	rule := deadlineRule{HearingID: 1, Offset: -7, Unit: " days "}
+	if err := rule.normalize(); err != nil || rule.Unit != offsetUnitDays {
+		t.Fatalf("Expected a valid rule in days, got %+v (%v)", rule, err)
+	}
+	if got := rule.deadline(start).Format(time.DateTime); got != "2025-06-09 10:00:00" {
+		t.Errorf("Expected 7 days before, got %s", got)
+	}
+
+	rule = deadlineRule{HearingID: 1, Offset: -7, Unit: "working_days"}
+	if err := rule.normalize(); err != nil {
+		t.Fatal(err)
+	}
+	if got := rule.deadline(start).Format(time.DateTime); got != "2025-06-05 10:00:00" {
+		t.Errorf("Expected 7 working days before, got %s", got)
+	}
+
+	// The spring bank holiday on Monday 26 May 2025 is skipped
+	rule = deadlineRule{HearingID: 1, Offset: -1, Unit: offsetUnitWorkingDays}
+	if got := rule.deadline(time.Date(2025, 5, 27, 10, 0, 0, 0, courtLocation)).Format(time.DateOnly); got != "2025-05-23" {
+		t.Errorf("Expected the Friday before the bank holiday, got %s", got)
+	}
+
+	// Counting back over the end of British Summer Time keeps the hearing's
+	// time of day in London, an hour earlier in UTC
+	rule = deadlineRule{HearingID: 1, Offset: -14, Unit: offsetUnitDays}
+	deadline := rule.deadline(time.Date(2025, 11, 3, 10, 0, 0, 0, time.UTC))
+	if got := deadline.Format(time.RFC3339); got != "2025-10-20T10:00:00+01:00" {
+		t.Errorf("Expected 10am BST, got %s", got)
+	}
+
+	rule = deadlineRule{HearingID: 1}
+	if err := rule.normalize(); err != nil || rule.Unit != offsetUnitDays {
+		t.Errorf("Expected the unit to default to days, got %+v (%v)", rule, err)
+	}
+
+	for _, invalid := range []deadlineRule{
+		{Offset: 1},
+		{HearingID: 1, Unit: "WEEKS"},
+		{HearingID: 1, Offset: maxHearingOffset + 1},
+		{HearingID: 1, Offset: -maxHearingOffset - 1},
+	} {
+		if err := invalid.normalize(); err != errInvalidDeadlineRule {
+			t.Errorf("%+v: expected %v, got %v", invalid, errInvalidDeadlineRule, err)
+		}
+	}
+}
+
+func TestHearingDeadlines(t *testing.T) {
+	created := createTestCase(t, "FAMILY", "ZW24C50999")
+	hearingsURL := fmt.Sprintf("/api/cases/%d/hearings", created.ID)
+	db, err := database.GetDBHandle()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Exec("DELETE FROM tasks WHERE case_id = ?", created.ID) })
+
+	rr := performHandlerRequest(t, CasesHandler, "POST", hearingsURL, []byte(`{"type": "Final hearing", "starts_at": "2025-06-16 10:00:00"}`), nil, 1)
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("handler returned wrong status code: got %v want %v, %s", rr.Code, http.StatusCreated, rr.Body.String())
+	}
+	var h hearing
+	if err := json.Unmarshal(rr.Body.Bytes(), &h); err != nil {
+		t.Fatalf("Failed to parse response as JSON: %v", err)
+	}
+	if rr := performHandlerRequest(t, CasesHandler, "POST", hearingsURL, []byte(`{"starts_at": "soon"}`), nil, 1); rr.Code != http.StatusBadRequest {
+		t.Errorf("handler returned wrong status code for an invalid date: got %v want %v", rr.Code, http.StatusBadRequest)
+	}
+
+	// The case is taken from the hearing
+	body := fmt.Sprintf(`{"name": "File bundle", "status": "INCOMPLETE", "deadline_rule": {"hearing_id": %d, "offset": -5, "unit": "WORKING_DAYS"}}`, h.ID)
+	if rr := performTaskRequest(t, "POST", "/api/tasks", []byte(body), nil, 1); rr.Code != http.StatusCreated {
+		t.Fatalf("handler returned wrong status code: got %v want %v, %s", rr.Code, http.StatusCreated, rr.Body.String())
+	}
+	_, page := getTaskPage(t, fmt.Sprintf("/api/tasks/?case=%d", created.ID), 1)
+	if len(page.Tasks) != 1 {
+		t.Fatalf("Expected 1 task for the case, got %d", len(page.Tasks))
+	}
+	bundle := page.Tasks[0]
+	if bundle.CaseID == nil || *bundle.CaseID != created.ID || bundle.Deadline.Format(time.DateTime) != "2025-06-09 10:00:00" || bundle.DeadlineRule == nil {
+		t.Errorf("Expected the deadline 5 working days before the hearing, got %+v", bundle)
+	}
+
+	// A completed task keeps its deadline
+	done := createTestTask(t, 1, "Witness statements")
+	rr = performTaskRequest(t, "PUT", "/api/tasks/"+done+"/deadline-rule", []byte(fmt.Sprintf(`{"hearing_id": %d, "offset": -14}`, h.ID)), nil, 1)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v, %s", rr.Code, http.StatusOK, rr.Body.String())
+	}
+	if rr := performTaskRequest(t, "PATCH", "/api/tasks/"+done, []byte(`{"status": "COMPLETE"}`), map[string]string{"If-Match": rr.Header().Get("ETag")}, 1); rr.Code != http.StatusOK {
+		t.Fatalf("handler returned wrong status code completing: got %v want %v, %s", rr.Code, http.StatusOK, rr.Body.String())
+	}
+
+	rr = performHandlerRequest(t, CasesHandler, "PUT", fmt.Sprintf("%s/%d", hearingsURL, h.ID), []byte(`{"type": "Final hearing", "starts_at": "2025-07-01 14:00:00"}`), nil, 1)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v, %s", rr.Code, http.StatusOK, rr.Body.String())
+	}
+	var update hearingUpdate
+	if err := json.Unmarshal(rr.Body.Bytes(), &update); err != nil {
+		t.Fatalf("Failed to parse response as JSON: %v", err)
+	}
+	if len(update.Recalculated) != 1 || update.Recalculated[0].TaskID != bundle.ID ||
+		update.Recalculated[0].PreviousDeadline.Format(time.DateTime) != "2025-06-09 10:00:00" || update.Recalculated[0].Deadline.Format(time.DateTime) != "2025-06-24 14:00:00" {
+		t.Errorf("Expected only the open task to move, got %+v", update)
+	}
+
+	// User 2 cannot see the task but is told it moved
+	rr = performHandlerRequest(t, CasesHandler, "PUT", fmt.Sprintf("%s/%d", hearingsURL, h.ID), []byte(`{"type": "Final hearing", "starts_at": "2025-07-02 14:00:00"}`), nil, 2)
+	if err := json.Unmarshal(rr.Body.Bytes(), &update); err != nil {
+		t.Fatalf("Failed to parse response as JSON: %v", err)
+	}
+	if len(update.Recalculated) != 0 || update.OtherTasksMoved != 1 {
+		t.Errorf("Expected one hidden task to move, got %+v", update)
+	}
+
+	if rr := performHandlerRequest(t, CasesHandler, "DELETE", fmt.Sprintf("%s/%d", hearingsURL, h.ID), nil, nil, 1); rr.Code != http.StatusNoContent {
+		t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusNoContent)
+	}
+	rr = performTaskRequest(t, "GET", fmt.Sprintf("/api/tasks/%d", bundle.ID), nil, nil, 1)
+	if err := json.Unmarshal(rr.Body.Bytes(), &bundle); err != nil {
+		t.Fatalf("Failed to parse response as JSON: %v", err)
+	}
+	if bundle.DeadlineRule != nil || bundle.Deadline.Format(time.DateTime) != "2025-06-25 14:00:00" {
+		t.Errorf("Expected the task to keep its deadline without a rule, got %+v", bundle)
+	}
+}
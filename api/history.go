@@ -0,0 +1,588 @@
+package api
+
+import (
+	"HMCTS-Developer-Challenge/database"
+	"HMCTS-Developer-Challenge/errors"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The actions a task event records. An update that changes the status of a
+// task is recorded as a status change. Moving a task to the trash is a
+// deletion and taking it out again an undeletion.
+const (
+	taskEventCreate   = "CREATE"
+	taskEventUpdate   = "UPDATE"
+	taskEventStatus   = "STATUS"
+	taskEventDelete   = "DELETE"
+	taskEventRestore  = "RESTORE"
+	taskEventUndelete = "UNDELETE"
+)
+
+// taskSnapshot is the state of a task kept with each of its events: every
+// field a user can change, directly or through a related resource.
+type taskSnapshot struct {
+	Name         string        `json:"name"`
+	Description  string        `json:"description"`
+	Status       string        `json:"status"`
+	Deadline     time.Time     `json:"deadline"`
+	Priority     string        `json:"priority"`
+	Recurrence   string        `json:"recurrence"`
+	AssigneeID   *uint         `json:"assignee_id"`
+	TeamID       *uint         `json:"team_id"`
+	CaseID       *uint         `json:"case_id"`
+	ParentID     *uint         `json:"parent_id"`
+	DeadlineRule *deadlineRule `json:"deadline_rule"`
+	Tags         []string      `json:"tags"`
+
+	version uint
+}
+
+type fieldChange struct {
+	From any `json:"from"`
+	To   any `json:"to"`
+}
+
+// taskEvent is one change to a task. Events are never changed or removed, so
+// they outlive the task and the user who made the change. Snapshot is the
+// state of the task after the change, or before it for a deletion, and is
+// only included when a single version is asked for.
+type taskEvent struct {
+	ID       uint                   `json:"id"`
+	TaskID   uint                   `json:"task_id"`
+	Version  uint                   `json:"version"`
+	Action   string                 `json:"action"`
+	ActorID  *uint                  `json:"actor_id"`
+	Actor    string                 `json:"actor"`
+	SourceIP string                 `json:"source_ip"`
+	Changes  map[string]fieldChange `json:"changes"`
+	Snapshot *taskSnapshot          `json:"snapshot,omitempty"`
+	// RestoredFrom is the version a RESTORE event went back to.
+	RestoredFrom *uint     `json:"restored_from"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+// taskVersion names the version of a task an edit restores.
+type taskVersion struct {
+	TaskID  uint
+	Version uint
+}
+
+var errTaskVersionNotFound = errors.Error("Task Version Not Found")
+
+const taskEventColumns = "e.id, e.task_id, e.version, e.action, e.actor_id, COALESCE(u.name, ''), e.source_ip, e.changes, e.restored_from, e.created_at"
+
+// taskHistoryHandler serves /api/tasks/{id}/history, one of its versions at
+// /history/{version} and restoring that version at
+// /history/{version}/restore.
+func taskHistoryHandler(w http.ResponseWriter, r *http.Request, userID uint, taskID string, version string, restore bool) {
+	switch {
+	case r.Method == http.MethodGet && !restore:
+		var history any
+		var err error
+		if version == "" {
+			history, err = getTaskHistory(userID, taskID)
+		} else {
+			history, err = getTaskVersion(userID, taskID, version)
+		}
+		if err == errTaskNotFound || err == errTaskVersionNotFound {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		} else if err != nil {
+			errors.HandleServerError(w, err, "history.go: taskHistoryHandler - getTaskHistory")
+			return
+		}
+		writeJSON(w, http.StatusOK, history)
+	case r.Method == http.MethodPost && restore:
+		t, err := restoreTaskVersion(userID, taskID, version, r.Header.Get("If-Match"), parseTaskEditOptions(r))
+		if err == errTaskNotFound || err == errTaskVersionNotFound {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		} else if err == errMissingJsonData || err == errInvalidTagName || err == errInvalidRecurrenceRule || err == errInvalidTaskPriority || err == errInvalidDeadline {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		} else if err == errPreconditionRequired {
+			http.Error(w, err.Error(), http.StatusPreconditionRequired)
+			return
+		} else if err == errPreconditionFailed {
+			http.Error(w, err.Error(), http.StatusPreconditionFailed)
+			return
+		} else if err == errOpenSubtasks || err == errOpenBlockers {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		} else if _, ok := err.(*blockedSubtaskError); ok {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		} else if statusErr, ok := err.(*statusError); ok {
+			writeStatusError(w, statusErr)
+			return
+		} else if err != nil {
+			errors.HandleServerError(w, err, "history.go: taskHistoryHandler - restoreTaskVersion")
+			return
+		}
+
+		w.Header().Set("ETag", taskETag(t))
+		writeJSON(w, http.StatusOK, t)
+	case r.Method == http.MethodOptions:
+		if restore {
+			w.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS")
+		} else {
+			w.Header().Set("Access-Control-Allow-Methods", "GET, OPTIONS")
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// getTaskHistory lists the events of a task, oldest first. Admins and
+// auditors can still read the history of a task once it has been deleted.
+func getTaskHistory(userID uint, taskID string) ([]taskEvent, error) {
+	events := []taskEvent{}
+
+	dbHandle, err := database.GetDBHandle()
+	if err != nil {
+		return events, errors.AddContext(err, "history.go: getTaskHistory - GetDBHandle")
+	}
+
+	if err := checkHistoryAccess(dbHandle, userID, taskID); err != nil {
+		return events, err
+	}
+
+	loc, err := userLocation(userID)
+	if err != nil {
+		return events, errors.AddContext(err, "history.go: getTaskHistory - userLocation")
+	}
+
+	rows, err := dbHandle.Query("SELECT "+taskEventColumns+" FROM task_events e LEFT JOIN users u ON u.id = e.actor_id WHERE e.task_id = ? ORDER BY e.id", taskID)
+	if err != nil {
+		return events, errors.AddContext(err, "history.go: getTaskHistory - Query")
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		e, err := scanTaskEvent(rows)
+		if err != nil {
+			return events, errors.AddContext(err, "history.go: getTaskHistory - Scan")
+		}
+		events = append(events, localizeTaskEvent(e, loc))
+	}
+	if err := rows.Err(); err != nil {
+		return events, errors.AddContext(err, "history.go: getTaskHistory - Rows")
+	}
+	return events, nil
+}
+
+// getTaskVersion returns the event that left a task at a version, along with
+// the snapshot of the task at that version.
+func getTaskVersion(userID uint, taskID string, version string) (taskEvent, error) {
+	dbHandle, err := database.GetDBHandle()
+	if err != nil {
+		return taskEvent{}, errors.AddContext(err, "history.go: getTaskVersion - GetDBHandle")
+	}
+
+	if err := checkHistoryAccess(dbHandle, userID, taskID); err != nil {
+		return taskEvent{}, err
+	}
+
+	loc, err := userLocation(userID)
+	if err != nil {
+		return taskEvent{}, errors.AddContext(err, "history.go: getTaskVersion - userLocation")
+	}
+
+	e, err := queryTaskVersion(dbHandle, taskID, version)
+	if err != nil {
+		return e, err
+	}
+	return localizeTaskEvent(e, loc), nil
+}
+
+// checkHistoryAccess allows the history of a task to be read by the users who
+// can see the task and, once it is in the trash or gone, by admins and
+// auditors.
+func checkHistoryAccess(q rowQueryer, userID uint, taskID string) error {
+	access, accessArgs := taskAccess(userID)
+	var visible bool
+	if err := q.QueryRow(
+		"SELECT EXISTS(SELECT 1 FROM tasks WHERE id = ? AND "+access+") OR "+
+			"(NOT EXISTS(SELECT 1 FROM tasks WHERE id = ? AND deleted_at IS NULL) AND EXISTS(SELECT 1 FROM task_events WHERE task_id = ?) AND "+
+			"EXISTS(SELECT 1 FROM users WHERE id = ? AND role IN ('"+RoleAdmin+"', '"+RoleAuditor+"')))",
+		append([]any{taskID}, append(accessArgs, taskID, taskID, userID)...)...,
+	).Scan(&visible); err != nil {
+		return errors.AddContext(err, "history.go: checkHistoryAccess - QueryRow")
+	}
+	if !visible {
+		return errTaskNotFound
+	}
+	return nil
+}
+
+// queryTaskVersion reads the latest event that left a task at a version.
+// Changes that only bump the version, such as a new subtask, have no event of
+// their own.
+func queryTaskVersion(q rowQueryer, taskID string, version string) (taskEvent, error) {
+	var snapshot []byte
+	e, err := scanTaskEvent(q.QueryRow(
+		"SELECT "+taskEventColumns+", e.snapshot FROM task_events e LEFT JOIN users u ON u.id = e.actor_id "+
+			"WHERE e.task_id = ? AND e.version = ? ORDER BY e.id DESC LIMIT 1",
+		taskID, parseID(version),
+	), &snapshot)
+	if err == sql.ErrNoRows {
+		return e, errTaskVersionNotFound
+	} else if err != nil {
+		return e, errors.AddContext(err, "history.go: queryTaskVersion - QueryRow")
+	}
+
+	e.Snapshot = &taskSnapshot{}
+	if err := json.Unmarshal(snapshot, e.Snapshot); err != nil {
+		return e, errors.AddContext(err, "history.go: queryTaskVersion - Unmarshal")
+	}
+	return e, nil
+}
+
+func scanTaskEvent(row rowScanner, extra ...any) (taskEvent, error) {
+	var e taskEvent
+	var changes []byte
+	dest := []any{&e.ID, &e.TaskID, &e.Version, &e.Action, &e.ActorID, &e.Actor, &e.SourceIP, &changes, &e.RestoredFrom, &e.CreatedAt}
+	if err := row.Scan(append(dest, extra...)...); err != nil {
+		return e, err
+	}
+	if err := json.Unmarshal(changes, &e.Changes); err != nil {
+		return e, err
+	}
+	return e, nil
+}
+
+// localizeTaskEvent shows the times of an event, including the deadlines it
+// records, in the zone of the user reading it.
+func localizeTaskEvent(e taskEvent, loc *time.Location) taskEvent {
+	e.CreatedAt = e.CreatedAt.In(loc)
+	if change, ok := e.Changes["deadline"]; ok {
+		change.From, change.To = localizeTimeValue(change.From, loc), localizeTimeValue(change.To, loc)
+		e.Changes["deadline"] = change
+	}
+	if e.Snapshot != nil {
+		e.Snapshot.Deadline = e.Snapshot.Deadline.In(loc)
+	}
+	return e
+}
+
+func localizeTimeValue(value any, loc *time.Location) any {
+	s, ok := value.(string)
+	if !ok {
+		return value
+	}
+	t, err := time.Parse(time.RFC3339Nano, s)
+	if err != nil {
+		return value
+	}
+	return t.In(loc).Format(time.RFC3339)
+}
+
+// restoreTaskVersion edits a task back to the name, description, status,
+// deadline, priority, recurrence and tags it had at an earlier version. The
+// restore is a new edit, so it is checked like any other and adds to the
+// history rather than rewriting it.
+func restoreTaskVersion(userID uint, taskID string, version string, ifMatch string, options taskEditOptions) (task, error) {
+	current, err := getTask(userID, taskID)
+	if err != nil {
+		return current, err
+	}
+
+	dbHandle, err := database.GetDBHandle()
+	if err != nil {
+		return current, errors.AddContext(err, "history.go: restoreTaskVersion - GetDBHandle")
+	}
+
+	e, err := queryTaskVersion(dbHandle, taskID, version)
+	if err != nil {
+		return current, err
+	}
+
+	snapshot := e.Snapshot
+	data := jsonData{
+		Name:        snapshot.Name,
+		Description: snapshot.Description,
+		Status:      snapshot.Status,
+		Deadline:    snapshot.Deadline.Format(time.RFC3339),
+		Priority:    snapshot.Priority,
+		Recurrence:  snapshot.Recurrence,
+		Tags:        append([]string{}, snapshot.Tags...),
+	}
+	options.Restore = &taskVersion{TaskID: current.ID, Version: e.Version}
+	if err := editTask(userID, taskID, ifMatch, data, options); err != nil {
+		return current, err
+	}
+	return getTask(userID, taskID)
+}
+
+// taskChanges holds the state of some tasks before they are changed in a
+// transaction, so that an event can be recorded for each task the change
+// actually affected.
+type taskChanges struct {
+	ids    []uint
+	before map[uint]taskSnapshot
+}
+
+// trackTasks takes the state of tasks before a change.
+func trackTasks(tx *sql.Tx, ids ...uint) (*taskChanges, error) {
+	before, err := loadTaskSnapshots(tx, ids...)
+	if err != nil {
+		return nil, err
+	}
+	return &taskChanges{ids: ids, before: before}, nil
+}
+
+// trackTaskQuery takes the state of the tasks whose IDs a query selects
+// before a change.
+func trackTaskQuery(tx *sql.Tx, query string, args ...any) (*taskChanges, error) {
+	rows, err := tx.Query(query, args...)
+	if err != nil {
+		return nil, errors.AddContext(err, "history.go: trackTaskQuery - Query")
+	}
+
+	var ids []uint
+	for rows.Next() {
+		var id uint
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return nil, errors.AddContext(err, "history.go: trackTaskQuery - Scan")
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, errors.AddContext(err, "history.go: trackTaskQuery - Rows")
+	}
+	return trackTasks(tx, ids...)
+}
+
+// recordTaskCreated records the creation of a task inserted in tx.
+func recordTaskCreated(tx *sql.Tx, userID uint, taskID uint, options taskEditOptions) error {
+	changes := &taskChanges{ids: []uint{taskID}, before: map[uint]taskSnapshot{}}
+	return changes.record(tx, userID, options)
+}
+
+// record compares the tracked tasks with their state before the change and
+// records an event for each one that was changed, created or deleted. A
+// userID of 0 records a change made by the system.
+func (c *taskChanges) record(tx *sql.Tx, userID uint, options taskEditOptions) error {
+	after, err := loadTaskSnapshots(tx, c.ids...)
+	if err != nil {
+		return err
+	}
+
+	var actorID *uint
+	if userID != 0 {
+		actorID = &userID
+	}
+
+	for _, id := range c.ids {
+		var before, current *taskSnapshot
+		if s, ok := c.before[id]; ok {
+			before = &s
+		}
+		if s, ok := after[id]; ok {
+			current = &s
+		}
+		if before == nil && current == nil {
+			continue
+		}
+
+		changes, err := diffTaskSnapshots(before, current)
+		if err != nil {
+			return errors.AddContext(err, "history.go: record - diffTaskSnapshots")
+		}
+		if len(changes) == 0 {
+			continue
+		}
+
+		action, snapshot, restoredFrom := taskEventUpdate, current, (*uint)(nil)
+		if before == nil && options.Undelete {
+			action = taskEventUndelete
+		} else if before == nil {
+			action = taskEventCreate
+		} else if current == nil {
+			action, snapshot = taskEventDelete, before
+		} else if options.Restore != nil && options.Restore.TaskID == id {
+			action, restoredFrom = taskEventRestore, &options.Restore.Version
+		} else if _, ok := changes["status"]; ok {
+			action = taskEventStatus
+		}
+
+		changesJSON, err := json.Marshal(changes)
+		if err != nil {
+			return errors.AddContext(err, "history.go: record - Marshal changes")
+		}
+		snapshotJSON, err := json.Marshal(snapshot)
+		if err != nil {
+			return errors.AddContext(err, "history.go: record - Marshal snapshot")
+		}
+
+		if _, err := tx.Exec(
+			"INSERT INTO task_events (task_id, version, action, actor_id, source_ip, changes, snapshot, restored_from) VALUES (?, ?, ?, ?, ?, ?, ?, ?)",
+			id, snapshot.version, action, actorID, options.SourceIP, changesJSON, snapshotJSON, restoredFrom,
+		); err != nil {
+			return errors.AddContext(err, "history.go: record - Exec")
+		}
+	}
+	return nil
+}
+
+// diffTaskSnapshots lists the fields that differ between two states of a
+// task. A nil state is a task that does not exist, so every field it has set
+// is reported as a change from or to null.
+func diffTaskSnapshots(before, after *taskSnapshot) (map[string]fieldChange, error) {
+	from, err := snapshotFields(before)
+	if err != nil {
+		return nil, err
+	}
+	to, err := snapshotFields(after)
+	if err != nil {
+		return nil, err
+	}
+
+	changes := map[string]fieldChange{}
+	for _, fields := range []map[string]any{from, to} {
+		for field := range fields {
+			if _, seen := changes[field]; seen || reflect.DeepEqual(from[field], to[field]) {
+				continue
+			}
+			changes[field] = fieldChange{From: from[field], To: to[field]}
+		}
+	}
+	return changes, nil
+}
+
+// snapshotFields returns a snapshot in its JSON form, leaving out the fields
+// that are not set so that they compare equal to a missing task.
+func snapshotFields(s *taskSnapshot) (map[string]any, error) {
+	fields := map[string]any{}
+	if s == nil {
+		return fields, nil
+	}
+
+	data, err := json.Marshal(s)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, err
+	}
+	for field, value := range fields {
+		if value == nil || value == "" {
+			delete(fields, field)
+		} else if list, ok := value.([]any); ok && len(list) == 0 {
+			delete(fields, field)
+		}
+	}
+	return fields, nil
+}
+
+// loadTaskSnapshots reads the current state of tasks within a transaction,
+// leaving out the ones that do not exist or are in the trash.
+func loadTaskSnapshots(tx *sql.Tx, ids ...uint) (map[uint]taskSnapshot, error) {
+	snapshots := make(map[uint]taskSnapshot, len(ids))
+	if len(ids) == 0 {
+		return snapshots, nil
+	}
+
+	args := make([]any, len(ids))
+	for i, id := range ids {
+		args[i] = id
+	}
+	in := "(?" + strings.Repeat(", ?", len(ids)-1) + ")"
+
+	rows, err := tx.Query("SELECT "+taskColumns+" FROM tasks WHERE id IN "+in+" AND deleted_at IS NULL", args...)
+	if err != nil {
+		return nil, errors.AddContext(err, "history.go: loadTaskSnapshots - Query")
+	}
+	for rows.Next() {
+		t, err := scanTask(rows)
+		if err != nil {
+			rows.Close()
+			return nil, errors.AddContext(err, "history.go: loadTaskSnapshots - Scan")
+		}
+		snapshots[t.ID] = taskSnapshot{
+			Name:         t.Name,
+			Description:  t.Description,
+			Status:       t.Status,
+			Deadline:     t.Deadline.UTC(),
+			Priority:     t.Priority,
+			Recurrence:   t.Recurrence,
+			AssigneeID:   t.AssigneeID,
+			TeamID:       t.TeamID,
+			CaseID:       t.CaseID,
+			ParentID:     t.ParentID,
+			DeadlineRule: t.DeadlineRule,
+			Tags:         []string{},
+			version:      t.Version,
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, errors.AddContext(err, "history.go: loadTaskSnapshots - Rows")
+	}
+
+	rows, err = tx.Query(
+		"SELECT tt.task_id, tg.name FROM task_tags tt JOIN tags tg ON tg.id = tt.tag_id WHERE tt.task_id IN "+in,
+		args...,
+	)
+	if err != nil {
+		return nil, errors.AddContext(err, "history.go: loadTaskSnapshots - Query tags")
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var taskID uint
+		var name string
+		if err := rows.Scan(&taskID, &name); err != nil {
+			return nil, errors.AddContext(err, "history.go: loadTaskSnapshots - Scan tags")
+		}
+		if s, ok := snapshots[taskID]; ok {
+			s.Tags = append(s.Tags, name)
+			snapshots[taskID] = s
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, errors.AddContext(err, "history.go: loadTaskSnapshots - Rows tags")
+	}
+
+	for id, s := range snapshots {
+		sort.Strings(s.Tags)
+		snapshots[id] = s
+	}
+	return snapshots, nil
+}
+
+// taskTreeQuery selects the IDs of a task and all of its subtasks that are
+// not already in the trash.
+const taskTreeQuery = "WITH RECURSIVE tree (id) AS (" +
+	"SELECT id FROM tasks WHERE id = ? " +
+	"UNION ALL SELECT t.id FROM tasks t JOIN tree ON t.parent_id = tree.id WHERE t.deleted_at IS NULL" +
+	") SELECT id FROM tree ORDER BY id"
+
+// parseHistoryPath splits what follows /api/tasks/{id}/history into the
+// version and whether it is being restored. ok is false for any other path.
+func parseHistoryPath(parts []string) (version string, restore bool, ok bool) {
+	switch {
+	case len(parts) == 0 || (len(parts) == 1 && parts[0] == ""):
+		return "", false, true
+	case len(parts) == 1:
+		return parts[0], false, isVersion(parts[0])
+	case len(parts) == 2 && parts[1] == "restore":
+		return parts[0], true, isVersion(parts[0])
+	}
+	return "", false, false
+}
+
+func isVersion(s string) bool {
+	n, err := strconv.ParseUint(s, 10, 32)
+	return err == nil && n > 0
+}
@@ -0,0 +1,164 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDiffTaskSnapshots(t *testing.T) {
+	teamID := uint(7)
+	before := &taskSnapshot{
+		Name:     "Prepare bundle",
+		Status:   "INCOMPLETE",
+		Deadline: time.Date(2025, 12, 31, 0, 0, 0, 0, time.UTC),
+		Priority: "MEDIUM",
+		Tags:     []string{"urgent"},
+	}
+	after := *before
+	after.Name = "Prepare hearing bundle"
+	after.TeamID = &teamID
+	after.Tags = []string{}
+
+	changes, err := diffTaskSnapshots(before, &after)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]fieldChange{
+		"name":    {From: "Prepare bundle", To: "Prepare hearing bundle"},
+		"team_id": {From: nil, To: float64(7)},
+		"tags":    {From: []any{"urgent"}, To: nil},
+	}
+	if !reflect.DeepEqual(changes, expected) {
+		t.Errorf("Expected %v, got %v", expected, changes)
+	}
+
+	if changes, err := diffTaskSnapshots(before, before); err != nil || len(changes) != 0 {
+		t.Errorf("Expected no changes, got %v (%v)", changes, err)
+	}
+
+	// A new task reports every field that is set
+	changes, err = diffTaskSnapshots(nil, before)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changes) != 5 || changes["deadline"].To != "2025-12-31T00:00:00Z" || changes["status"].From != nil {
+		t.Errorf("Unexpected changes for a new task %v", changes)
+	}
+}
+
+func TestParseHistoryPath(t *testing.T) {
+	tests := []struct {
+		parts   []string
+		version string
+		restore bool
+		ok      bool
+	}{
+		{nil, "", false, true},
+		{[]string{""}, "", false, true},
+		{[]string{"3"}, "3", false, true},
+		{[]string{"3", "restore"}, "3", true, true},
+		{[]string{"0"}, "", false, false},
+		{[]string{"latest"}, "", false, false},
+		{[]string{"3", "undo"}, "", false, false},
+		{[]string{"3", "restore", "again"}, "", false, false},
+	}
+	for _, tc := range tests {
+		version, restore, ok := parseHistoryPath(tc.parts)
+		if ok != tc.ok || (ok && (version != tc.version || restore != tc.restore)) {
+			t.Errorf("%q: expected %q %v %v, got %q %v %v", tc.parts, tc.version, tc.restore, tc.ok, version, restore, ok)
+		}
+	}
+}
+
+func TestTaskHistory(t *testing.T) {
+	taskID := createTestTask(t, 1, "Task with history")
+	taskURL := "/api/tasks/" + taskID
+	historyURL := taskURL + "/history"
+	anyVersion := map[string]string{"If-Match": "*"}
+
+	// httptest requests come from 192.0.2.1
+	req := httptest.NewRequest("PATCH", taskURL, strings.NewReader(`{"name": "First name"}`))
+	req.Header.Set("If-Match", "*")
+	rr := httptest.NewRecorder()
+	TasksHandler(rr, req, 1)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v, %s", rr.Code, http.StatusOK, rr.Body.String())
+	}
+	if rr := performTaskRequest(t, "PATCH", taskURL, []byte(`{"name": "Second name", "priority": "HIGH"}`), anyVersion, 1); rr.Code != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v, %s", rr.Code, http.StatusOK, rr.Body.String())
+	}
+
+	rr = performTaskRequest(t, "GET", historyURL, nil, nil, 1)
+	var events []taskEvent
+	if err := json.Unmarshal(rr.Body.Bytes(), &events); err != nil {
+		t.Fatalf("Failed to parse response as JSON: %v", err)
+	}
+	if len(events) != 2 {
+		t.Fatalf("Expected two events, got %+v", events)
+	}
+	first := events[0]
+	if first.Action != taskEventUpdate || first.Actor != "testuser1" || first.SourceIP != "192.0.2.1" || first.Snapshot != nil ||
+		!reflect.DeepEqual(first.Changes, map[string]fieldChange{"name": {From: "Task with history", To: "First name"}}) {
+		t.Errorf("Unexpected event %+v", first)
+	}
+
+	if rr := performTaskRequest(t, "GET", historyURL, nil, nil, 2); rr.Code != http.StatusNotFound {
+		t.Errorf("handler returned wrong status code for another user's task: got %v want %v", rr.Code, http.StatusNotFound)
+	}
+
+	versionURL := fmt.Sprintf("%s/%d", historyURL, first.Version)
+	rr = performTaskRequest(t, "GET", versionURL, nil, nil, 1)
+	var version taskEvent
+	if err := json.Unmarshal(rr.Body.Bytes(), &version); err != nil {
+		t.Fatalf("Failed to parse response as JSON: %v", err)
+	}
+	if version.Snapshot == nil || version.Snapshot.Name != "First name" || version.Snapshot.Priority != "MEDIUM" {
+		t.Errorf("Unexpected version %+v", version)
+	}
+
+	// Restoring is an edit like any other
+	if rr := performTaskRequest(t, "POST", versionURL+"/restore", nil, nil, 1); rr.Code != http.StatusPreconditionRequired {
+		t.Errorf("handler returned wrong status code without If-Match: got %v want %v", rr.Code, http.StatusPreconditionRequired)
+	}
+	rr = performTaskRequest(t, "POST", versionURL+"/restore", nil, anyVersion, 1)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v, %s", rr.Code, http.StatusOK, rr.Body.String())
+	}
+	var restored task
+	if err := json.Unmarshal(rr.Body.Bytes(), &restored); err != nil {
+		t.Fatalf("Failed to parse response as JSON: %v", err)
+	}
+	if restored.Name != "First name" || restored.Priority != "MEDIUM" {
+		t.Errorf("Expected the first version back, got %+v", restored)
+	}
+
+	if rr := performTaskRequest(t, "DELETE", taskURL, nil, anyVersion, 1); rr.Code != http.StatusNoContent {
+		t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusNoContent)
+	}
+
+	// Only admins and auditors can read the history of a deleted task
+	if rr := performTaskRequest(t, "GET", historyURL, nil, nil, 1); rr.Code != http.StatusNotFound {
+		t.Errorf("handler returned wrong status code for a deleted task: got %v want %v", rr.Code, http.StatusNotFound)
+	}
+	rr = performTaskRequest(t, "GET", historyURL, nil, nil, 4)
+	if err := json.Unmarshal(rr.Body.Bytes(), &events); err != nil {
+		t.Fatalf("Failed to parse response as JSON: %v", err)
+	}
+	if len(events) != 4 {
+		t.Fatalf("Expected four events, got %+v", events)
+	}
+	restore, deletion := events[2], events[3]
+	if restore.Action != taskEventRestore || restore.RestoredFrom == nil || *restore.RestoredFrom != first.Version ||
+		!reflect.DeepEqual(restore.Changes["priority"], fieldChange{From: "HIGH", To: "MEDIUM"}) {
+		t.Errorf("Unexpected restore %+v", restore)
+	}
+	if deletion.Action != taskEventDelete || deletion.Changes["name"].From != "First name" || deletion.Changes["name"].To != nil {
+		t.Errorf("Unexpected deletion %+v", deletion)
+	}
+}
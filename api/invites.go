@@ -0,0 +1,358 @@
+package api
+
+import (
+	"HMCTS-Developer-Challenge/database"
+	"HMCTS-Developer-Challenge/errors"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const defaultInviteHours = 7 * 24
+const maxInviteHours = 30 * 24
+
+// minInviteSecretLength is the shortest INVITE_SECRET accepted, in bytes.
+const minInviteSecretLength = 32
+
+// inviteSecret signs invitation tokens. Without a configured secret a random
+// one is used, so invitations stop working when the server restarts.
+var inviteSecret []byte
+
+// openSignup lets anyone create a caseworker account without an invitation.
+var openSignup = false
+
+// invite lets someone create their own account with a role, and optionally in
+// a team, chosen by the admin who invited them.
+type invite struct {
+	ID        uint       `json:"id"`
+	Role      string     `json:"role"`
+	TeamID    *uint      `json:"team_id"`
+	CreatedBy *uint      `json:"created_by"`
+	CreatedAt time.Time  `json:"created_at"`
+	ExpiresAt time.Time  `json:"expires_at"`
+	UsedAt    *time.Time `json:"used_at"`
+	UsedBy    *uint      `json:"used_by"`
+	// Token is only returned when the invitation is created.
+	Token string `json:"token,omitempty"`
+}
+
+type inviteData struct {
+	Role           string `json:"role"`
+	TeamID         *uint  `json:"team_id"`
+	ExpiresInHours int    `json:"expires_in_hours"`
+}
+
+var errInviteNotFound = errors.Error("Invite Not Found")
+var errInvalidInviteExpiry = errors.Error("expires_in_hours must be between 1 and 720")
+var errInvalidInvite = errors.Error("invalid or expired invitation")
+
+// SetInviteSecret sets the key invitation tokens are signed with. An empty
+// value generates a random key.
+func SetInviteSecret(value string) error {
+	if value == "" {
+		inviteSecret = make([]byte, minInviteSecretLength)
+		if _, err := rand.Read(inviteSecret); err != nil {
+			return errors.AddContext(err, "invites.go: SetInviteSecret - rand.Read")
+		}
+		return nil
+	}
+
+	if len(value) < minInviteSecretLength {
+		return errors.Errorf("invites.go: SetInviteSecret - secret must be at least %d bytes", minInviteSecretLength)
+	}
+	inviteSecret = []byte(value)
+	return nil
+}
+
+// SetOpenSignup sets whether people can sign up without an invitation. An
+// empty value keeps the default of invitation only.
+func SetOpenSignup(value string) error {
+	if value == "" {
+		openSignup = false
+		return nil
+	}
+
+	open, err := strconv.ParseBool(value)
+	if err != nil {
+		return errors.Errorf("invites.go: SetOpenSignup - invalid value %q", value)
+	}
+	openSignup = open
+	return nil
+}
+
+// OpenSignup reports whether people can sign up without an invitation.
+func OpenSignup() bool {
+	return openSignup
+}
+
+// AdminInvitesHandler lets admins create, list and revoke invitations under
+// /api/admin/invites.
+func AdminInvitesHandler(w http.ResponseWriter, r *http.Request, adminID uint) {
+	pathParts := strings.Split(strings.TrimSuffix(r.URL.Path, "/"), "/")
+	inviteID := ""
+	if len(pathParts) > 4 {
+		inviteID = pathParts[4]
+	}
+	if len(pathParts) > 5 {
+		http.Error(w, "Not Found", http.StatusNotFound)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		if inviteID != "" {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			break
+		}
+
+		invites, err := getInvites()
+		if err != nil {
+			errors.HandleServerError(w, err, "invites.go: AdminInvitesHandler - getInvites")
+			break
+		}
+		writeJSON(w, http.StatusOK, invites)
+	case http.MethodPost:
+		if inviteID != "" {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			break
+		}
+
+		var data inviteData
+		if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+			http.Error(w, "Invalid JSON", http.StatusBadRequest)
+			break
+		}
+
+		inv, err := addInvite(adminID, data)
+		if err == errInvalidRole || err == errTeamNotFound || err == errInvalidInviteExpiry {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			break
+		} else if err != nil {
+			errors.HandleServerError(w, err, "invites.go: AdminInvitesHandler - addInvite")
+			break
+		}
+		writeJSON(w, http.StatusCreated, inv)
+	case http.MethodDelete:
+		if inviteID == "" {
+			http.Error(w, "Invite ID Required", http.StatusBadRequest)
+			break
+		}
+
+		if err := deleteInvite(inviteID); err == errInviteNotFound {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			break
+		} else if err != nil {
+			errors.HandleServerError(w, err, "invites.go: AdminInvitesHandler - deleteInvite")
+			break
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	case http.MethodOptions:
+		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, DELETE, OPTIONS")
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// signInvite returns the token for an invitation. The token carries the
+// invitation's ID and expiry signed with the invite secret, so tokens cannot
+// be forged or extended and only their use has to be tracked.
+func signInvite(inviteID uint, expires time.Time) string {
+	payload := strconv.FormatUint(uint64(inviteID), 10) + "." + strconv.FormatInt(expires.Unix(), 10)
+	return payload + "." + base64.RawURLEncoding.EncodeToString(inviteSignature(payload))
+}
+
+func inviteSignature(payload string) []byte {
+	mac := hmac.New(sha256.New, inviteSecret)
+	mac.Write([]byte(payload))
+	return mac.Sum(nil)
+}
+
+// parseInviteToken checks the signature and expiry of a token and returns
+// the ID of its invitation.
+func parseInviteToken(token string, now time.Time) (uint, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return 0, errInvalidInvite
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil || !hmac.Equal(signature, inviteSignature(parts[0]+"."+parts[1])) {
+		return 0, errInvalidInvite
+	}
+
+	inviteID, err := strconv.ParseUint(parts[0], 10, 32)
+	if err != nil {
+		return 0, errInvalidInvite
+	}
+	expires, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil || !now.Before(time.Unix(expires, 0)) {
+		return 0, errInvalidInvite
+	}
+	return uint(inviteID), nil
+}
+
+func addInvite(adminID uint, data inviteData) (invite, error) {
+	role, err := normalizeRole(data.Role)
+	if err != nil {
+		return invite{}, err
+	}
+
+	hours := data.ExpiresInHours
+	if hours == 0 {
+		hours = defaultInviteHours
+	} else if hours < 0 || hours > maxInviteHours {
+		return invite{}, errInvalidInviteExpiry
+	}
+	expires := time.Now().UTC().Add(time.Duration(hours) * time.Hour).Truncate(time.Second)
+
+	dbHandle, err := database.GetDBHandle()
+	if err != nil {
+		return invite{}, errors.AddContext(err, "invites.go: addInvite - GetDBHandle")
+	}
+
+	if data.TeamID != nil {
+		var exists bool
+		if err := dbHandle.QueryRow("SELECT EXISTS(SELECT 1 FROM teams WHERE id = ?)", *data.TeamID).Scan(&exists); err != nil {
+			return invite{}, errors.AddContext(err, "invites.go: addInvite - QueryRow")
+		} else if !exists {
+			return invite{}, errTeamNotFound
+		}
+	}
+
+	result, err := dbHandle.Exec(
+		"INSERT INTO invites (role, team_id, created_by, expires_at) VALUES (?, ?, ?, ?)",
+		role, data.TeamID, adminID, expires,
+	)
+	if err != nil {
+		return invite{}, errors.AddContext(err, "invites.go: addInvite - Exec")
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return invite{}, errors.AddContext(err, "invites.go: addInvite - LastInsertId")
+	}
+
+	inv, err := scanInvite(dbHandle.QueryRow(
+		"SELECT id, role, team_id, created_by, created_at, expires_at, used_at, used_by FROM invites WHERE id = ?",
+		id,
+	))
+	if err != nil {
+		return inv, errors.AddContext(err, "invites.go: addInvite - Scan")
+	}
+	inv.Token = signInvite(inv.ID, expires)
+	return inv, nil
+}
+
+// getInvites lists every invitation, newest first.
+func getInvites() ([]invite, error) {
+	invites := []invite{}
+
+	dbHandle, err := database.GetDBHandle()
+	if err != nil {
+		return invites, errors.AddContext(err, "invites.go: getInvites - GetDBHandle")
+	}
+
+	rows, err := dbHandle.Query("SELECT id, role, team_id, created_by, created_at, expires_at, used_at, used_by FROM invites ORDER BY id DESC")
+	if err != nil {
+		return invites, errors.AddContext(err, "invites.go: getInvites - Query")
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		inv, err := scanInvite(rows)
+		if err != nil {
+			return invites, errors.AddContext(err, "invites.go: getInvites - Scan")
+		}
+		invites = append(invites, inv)
+	}
+	if err := rows.Err(); err != nil {
+		return invites, errors.AddContext(err, "invites.go: getInvites - Rows")
+	}
+	return invites, nil
+}
+
+func scanInvite(row rowScanner) (invite, error) {
+	var inv invite
+	err := row.Scan(&inv.ID, &inv.Role, &inv.TeamID, &inv.CreatedBy, &inv.CreatedAt, &inv.ExpiresAt, &inv.UsedAt, &inv.UsedBy)
+	return inv, err
+}
+
+// deleteInvite revokes an invitation. Used invitations can be deleted too,
+// which leaves the account that was created with them alone.
+func deleteInvite(inviteID string) error {
+	dbHandle, err := database.GetDBHandle()
+	if err != nil {
+		return errors.AddContext(err, "invites.go: deleteInvite - GetDBHandle")
+	}
+
+	result, err := dbHandle.Exec("DELETE FROM invites WHERE id = ?", inviteID)
+	if err != nil {
+		return errors.AddContext(err, "invites.go: deleteInvite - Exec")
+	}
+	if affected, err := result.RowsAffected(); err != nil {
+		return errors.AddContext(err, "invites.go: deleteInvite - RowsAffected")
+	} else if affected == 0 {
+		return errInviteNotFound
+	}
+	return nil
+}
+
+// redeemInvite creates an account with the role and team of an invitation
+// and uses the invitation up. Both happen in one transaction with the
+// invitation locked, so a token cannot create two accounts.
+func redeemInvite(token, username, password string) (user, error) {
+	inviteID, err := parseInviteToken(token, time.Now())
+	if err != nil {
+		return user{}, err
+	}
+
+	dbHandle, err := database.GetDBHandle()
+	if err != nil {
+		return user{}, errors.AddContext(err, "invites.go: redeemInvite - GetDBHandle")
+	}
+
+	tx, err := dbHandle.Begin()
+	if err != nil {
+		return user{}, errors.AddContext(err, "invites.go: redeemInvite - Begin")
+	}
+	defer tx.Rollback()
+
+	var role string
+	var teamID *uint
+	if err := tx.QueryRow(
+		"SELECT role, team_id FROM invites WHERE id = ? AND used_at IS NULL AND expires_at > UTC_TIMESTAMP() FOR UPDATE",
+		inviteID,
+	).Scan(&role, &teamID); err == sql.ErrNoRows {
+		return user{}, errInvalidInvite
+	} else if err != nil {
+		return user{}, errors.AddContext(err, "invites.go: redeemInvite - QueryRow")
+	}
+
+	userID, err := insertUser(tx, username, password, role)
+	if err != nil {
+		return user{}, err
+	}
+
+	if teamID != nil {
+		if _, err := tx.Exec("INSERT INTO team_members (team_id, user_id, role) VALUES (?, ?, ?)", *teamID, userID, teamRoleMember); err != nil {
+			return user{}, errors.AddContext(err, "invites.go: redeemInvite - Exec member")
+		}
+	}
+
+	if _, err := tx.Exec("UPDATE invites SET used_at = UTC_TIMESTAMP(), used_by = ? WHERE id = ?", userID, inviteID); err != nil {
+		return user{}, errors.AddContext(err, "invites.go: redeemInvite - Exec used")
+	}
+
+	if err := tx.Commit(); err != nil {
+		return user{}, errors.AddContext(err, "invites.go: redeemInvite - Commit")
+	}
+	return user{ID: userID, Name: username, Role: role}, nil
+}
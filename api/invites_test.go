@@ -0,0 +1,177 @@
+package api
+
+import (
+	"HMCTS-Developer-Challenge/database"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func performSignUpRequest(t *testing.T, body string) *httptest.ResponseRecorder {
+	req, err := http.NewRequest("POST", "/api/signup", bytes.NewBufferString(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(SignUpHandler).ServeHTTP(rr, req)
+	return rr
+}
+
+func TestSignAndParseInviteToken(t *testing.T) {
+	if err := SetInviteSecret(""); err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	token := signInvite(42, now.Add(time.Hour))
+
+	if id, err := parseInviteToken(token, now); err != nil || id != 42 {
+		t.Errorf("Expected invite 42, got %d (%v)", id, err)
+	}
+
+	parts := strings.Split(token, ".")
+	for name, bad := range map[string]string{
+		"Expired":   token,
+		"Other ID":  "43." + parts[1] + "." + parts[2],
+		"Extended":  parts[0] + "." + fmt.Sprint(now.Add(48*time.Hour).Unix()) + "." + parts[2],
+		"Malformed": "not-a-token",
+		"Empty":     "",
+	} {
+		at := now
+		if name == "Expired" {
+			at = now.Add(2 * time.Hour)
+		}
+		if _, err := parseInviteToken(bad, at); err != errInvalidInvite {
+			t.Errorf("%s: expected %v, got %v", name, errInvalidInvite, err)
+		}
+	}
+
+	// Tokens signed with another secret are rejected
+	if err := SetInviteSecret(""); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := parseInviteToken(token, now); err != errInvalidInvite {
+		t.Errorf("Expected a token from another secret to be rejected, got %v", err)
+	}
+}
+
+func TestSetInviteSecret(t *testing.T) {
+	defer SetInviteSecret("")
+
+	if err := SetInviteSecret("too-short"); err == nil {
+		t.Error("Expected an error for a short secret")
+	}
+	if err := SetInviteSecret(strings.Repeat("s", minInviteSecretLength)); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+}
+
+func TestSetOpenSignup(t *testing.T) {
+	defer SetOpenSignup("")
+
+	for value, expected := range map[string]bool{"": false, "true": true, "0": false} {
+		if err := SetOpenSignup(value); err != nil || OpenSignup() != expected {
+			t.Errorf("%q: expected %v, got %v (%v)", value, expected, OpenSignup(), err)
+		}
+	}
+	if err := SetOpenSignup("sometimes"); err == nil {
+		t.Error("Expected an error for an invalid value")
+	}
+}
+
+func TestSignUpWithInvite(t *testing.T) {
+	if err := SetInviteSecret(""); err != nil {
+		t.Fatal(err)
+	}
+	teamData := createTestTeam(t, testAdminID, "Invited Team")
+
+	created := createTestResource[invite](t, AdminInvitesHandler, "/api/admin/invites", fmt.Sprintf(`{"role": "lead", "team_id": %d, "expires_in_hours": 2}`, teamData.ID), testAdminID, "invites")
+	if created.Token == "" || created.Role != RoleLead {
+		t.Fatalf("Expected a lead invitation with a token, got %+v", created)
+	}
+
+	rr := performSignUpRequest(t, fmt.Sprintf(`{"username": "inviteduser", "password": "password123", "invite": %q}`, created.Token))
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("handler returned wrong status code: got %v want %v, %s", rr.Code, http.StatusCreated, rr.Body.String())
+	}
+	var newUser user
+	if err := json.Unmarshal(rr.Body.Bytes(), &newUser); err != nil {
+		t.Fatalf("Failed to parse response as JSON: %v", err)
+	}
+	t.Cleanup(func() {
+		db, err := database.GetDBHandle()
+		if err != nil {
+			t.Fatal(err)
+		}
+		db.Exec("DELETE FROM users WHERE id = ?", newUser.ID)
+	})
+	if newUser.Role != RoleLead {
+		t.Errorf("Expected the invited role LEAD, got %q", newUser.Role)
+	}
+
+	loggedIn := false
+	for _, cookie := range rr.Result().Cookies() {
+		loggedIn = loggedIn || cookie.Name == "session_id"
+	}
+	if !loggedIn {
+		t.Error("Expected the new user to be logged in")
+	}
+
+	db, err := database.GetDBHandle()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var member bool
+	if err := db.QueryRow("SELECT EXISTS(SELECT 1 FROM team_members WHERE team_id = ? AND user_id = ?)", teamData.ID, newUser.ID).Scan(&member); err != nil || !member {
+		t.Errorf("Expected the new user to join team %d (%v)", teamData.ID, err)
+	}
+
+	// Invitations can only be used once
+	if rr := performSignUpRequest(t, fmt.Sprintf(`{"username": "seconduser", "password": "password123", "invite": %q}`, created.Token)); rr.Code != http.StatusBadRequest {
+		t.Errorf("handler returned wrong status code reusing an invite: got %v want %v", rr.Code, http.StatusBadRequest)
+	}
+
+	if rr := performHandlerRequest(t, AdminInvitesHandler, "POST", "/api/admin/invites", []byte(`{"expires_in_hours": 1000}`), nil, testAdminID); rr.Code != http.StatusBadRequest {
+		t.Errorf("handler returned wrong status code for an invalid expiry: got %v want %v", rr.Code, http.StatusBadRequest)
+	}
+	if rr := performHandlerRequest(t, AdminInvitesHandler, "DELETE", fmt.Sprintf("/api/admin/invites/%d", created.ID), nil, nil, testAdminID); rr.Code != http.StatusNoContent {
+		t.Errorf("handler returned wrong status code revoking: got %v want %v", rr.Code, http.StatusNoContent)
+	}
+	if rr := performHandlerRequest(t, AdminInvitesHandler, "DELETE", fmt.Sprintf("/api/admin/invites/%d", created.ID), nil, nil, testAdminID); rr.Code != http.StatusNotFound {
+		t.Errorf("handler returned wrong status code revoking again: got %v want %v", rr.Code, http.StatusNotFound)
+	}
+}
+
+func TestOpenSignup(t *testing.T) {
+	if err := SetOpenSignup("true"); err != nil {
+		t.Fatal(err)
+	}
+	defer SetOpenSignup("")
+
+	rr := performSignUpRequest(t, `{"username": "openuser", "password": "password123", "role": "admin"}`)
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("handler returned wrong status code: got %v want %v, %s", rr.Code, http.StatusCreated, rr.Body.String())
+	}
+	var newUser user
+	if err := json.Unmarshal(rr.Body.Bytes(), &newUser); err != nil {
+		t.Fatalf("Failed to parse response as JSON: %v", err)
+	}
+	t.Cleanup(func() {
+		db, err := database.GetDBHandle()
+		if err != nil {
+			t.Fatal(err)
+		}
+		db.Exec("DELETE FROM users WHERE id = ?", newUser.ID)
+	})
+
+	// Open signup only ever creates caseworkers
+	if newUser.Role != RoleCaseworker {
+		t.Errorf("Expected a caseworker, got %q", newUser.Role)
+	}
+}
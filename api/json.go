@@ -0,0 +1,22 @@
+package api
+
+import (
+	"HMCTS-Developer-Challenge/errors"
+	"bytes"
+	"encoding/json"
+	"net/http"
+)
+
+// writeJSON writes v as a JSON response with the status code. It is encoded
+// before anything is written, so a failure can still be answered with a 500.
+func writeJSON(w http.ResponseWriter, code int, v any) {
+	var buf bytes.Buffer
+	if err := json.NewEncoder(&buf).Encode(v); err != nil {
+		errors.HandleServerError(w, err, "json.go: writeJSON - Encode")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	buf.WriteTo(w)
+}
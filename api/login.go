@@ -1,6 +1,7 @@
 package api
 
 import (
+	"HMCTS-Developer-Challenge/audit"
 	"HMCTS-Developer-Challenge/database"
 	"HMCTS-Developer-Challenge/errors"
 	"HMCTS-Developer-Challenge/session"
@@ -29,6 +30,8 @@ type HashInfo struct {
 var errUserNotFound = errors.Error("user not found")
 var errWrongPassword = errors.Error("incorrect password")
 var errEmptyUsernameOrPassword = errors.Error("empty username or password")
+var errUserDisabled = errors.Error("account disabled")
+var errPasswordResetRequired = errors.Error("password reset required")
 
 func LoginHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
@@ -39,21 +42,39 @@ func LoginHandler(w http.ResponseWriter, r *http.Request) {
 	var jsonData struct {
 		Username string `json:"username"`
 		Password string `json:"password"`
+		// NewPassword replaces the password when an admin has forced a reset.
+		NewPassword string `json:"new_password"`
 	}
 	if err := json.NewDecoder(r.Body).Decode(&jsonData); err != nil {
 		http.Error(w, "Invalid JSON", http.StatusBadRequest)
 		return
 	}
 
-	userID, err := loginUser(jsonData.Username, jsonData.Password)
-	if err == errWrongPassword || err == errUserNotFound || err == errEmptyUsernameOrPassword {
+	userID, role, err := loginUser(jsonData.Username, jsonData.Password)
+	passwordReset := err == errPasswordResetRequired && jsonData.NewPassword != ""
+	if passwordReset {
+		err = setUserPassword(userID, jsonData.NewPassword, false)
+	}
+	if err == errWrongPassword || err == errUserNotFound || err == errEmptyUsernameOrPassword || err == errUserDisabled || err == errPasswordResetRequired {
+		audit.Log(audit.Entry{
+			Event:    audit.EventLoginFailed,
+			Username: jsonData.Username,
+			SourceIP: audit.SourceIP(r),
+			Details:  map[string]string{"reason": err.Error()},
+		})
+
+		status := http.StatusBadRequest
+		if err == errUserDisabled || err == errPasswordResetRequired {
+			status = http.StatusForbidden
+		}
+
 		var buf bytes.Buffer
 		if err := json.NewEncoder(&buf).Encode(map[string]string{"message": err.Error()}); err != nil {
 			errors.HandleServerError(w, err, "login.go: HandleLogin - Encode")
 			return
 		}
 		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusBadRequest)
+		w.WriteHeader(status)
 		buf.WriteTo(w)
 		return
 	} else if err != nil {
@@ -61,34 +82,44 @@ func LoginHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	session.CreateUserSessionCookie(w, userID)
+	session.CreateUserSessionCookie(w, userID, role)
+
+	entry := audit.Entry{Event: audit.EventLogin, UserID: audit.UserID(userID), Username: jsonData.Username, SourceIP: audit.SourceIP(r)}
+	if passwordReset {
+		entry.Details = map[string]string{"password_reset": "true"}
+	}
+	audit.Log(entry)
 
 	w.WriteHeader(http.StatusOK)
 }
 
-func loginUser(username, password string) (uint, error) {
+func loginUser(username, password string) (uint, string, error) {
 	if username == "" || password == "" {
-		return 0, errEmptyUsernameOrPassword
+		return 0, "", errEmptyUsernameOrPassword
 	}
 
 	dbHandle, err := database.GetDBHandle()
 	if err != nil {
-		return 0, errors.AddContext(err, "login.go: loginUser - GetDBHandle")
+		return 0, "", errors.AddContext(err, "login.go: loginUser - GetDBHandle")
 	}
 
 	var userID uint
-	var passwordHash string
-	if err := dbHandle.QueryRow("SELECT id, password_hash FROM users WHERE name = ?", username).Scan(&userID, &passwordHash); err != nil {
+	var passwordHash, role string
+	var disabled, resetRequired bool
+	if err := dbHandle.QueryRow(
+		"SELECT id, password_hash, role, disabled, password_reset_required FROM users WHERE name = ?",
+		username,
+	).Scan(&userID, &passwordHash, &role, &disabled, &resetRequired); err != nil {
 		if err == sql.ErrNoRows {
-			return 0, errUserNotFound
+			return 0, "", errUserNotFound
 		} else {
-			return 0, errors.AddContext(err, "login.go: loginUser - QueryRow")
+			return 0, "", errors.AddContext(err, "login.go: loginUser - QueryRow")
 		}
 	}
 
 	info, err := parseHash(passwordHash)
 	if err != nil {
-		return 0, errors.AddContext(err, "login.go: loginUser - parseHash")
+		return 0, "", errors.AddContext(err, "login.go: loginUser - parseHash")
 	}
 
 	newHash := argon2.IDKey(
@@ -101,10 +132,17 @@ func loginUser(username, password string) (uint, error) {
 	)
 
 	if subtle.ConstantTimeCompare(info.Hash, newHash) == 0 {
-		return 0, errWrongPassword
+		return 0, "", errWrongPassword
+	}
+
+	// Only tell someone who knows the password that the account is disabled
+	if disabled {
+		return 0, "", errUserDisabled
+	} else if resetRequired {
+		return userID, role, errPasswordResetRequired
 	}
 
-	return userID, nil
+	return userID, role, nil
 }
 
 func parseHash(encodedHash string) (*HashInfo, error) {
@@ -1,6 +1,7 @@
 package api
 
 import (
+	"HMCTS-Developer-Challenge/audit"
 	"HMCTS-Developer-Challenge/session"
 	"net/http"
 )
@@ -11,6 +12,12 @@ func LogoutHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Logging out without a session changes nothing, so it is not recorded
+	userSession, err := session.GetUserFromSession(w, r)
+	if err == nil {
+		audit.Log(audit.Entry{Event: audit.EventLogout, UserID: audit.UserID(userSession.UserID), SourceIP: audit.SourceIP(r)})
+	}
+
 	_ = session.DeleteUserSessionCookie(w, r)
 	http.Redirect(w, r, "/login", http.StatusSeeOther)
 }
\ No newline at end of file
@@ -0,0 +1,291 @@
+package api
+
+import (
+	"HMCTS-Developer-Challenge/errors"
+	"encoding/json"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+const (
+	mergePatchContentType = "application/merge-patch+json"
+	jsonPatchContentType  = "application/json-patch+json"
+)
+
+var errInvalidPatch = errors.Error("Invalid Patch")
+var errPatchTestFailed = errors.Error("Patch Test Failed")
+
+type jsonPatchOperation struct {
+	Op    string           `json:"op"`
+	Path  string           `json:"path"`
+	From  string           `json:"from"`
+	Value *json.RawMessage `json:"value"`
+}
+
+// applyMergePatch applies an RFC 7396 JSON Merge Patch to doc.
+func applyMergePatch(doc any, patch []byte) (any, error) {
+	var p any
+	if err := json.Unmarshal(patch, &p); err != nil {
+		return nil, errInvalidPatch
+	}
+	return mergePatch(doc, p), nil
+}
+
+func mergePatch(target, patch any) any {
+	patchObject, ok := patch.(map[string]any)
+	if !ok {
+		return patch
+	}
+
+	targetObject, ok := target.(map[string]any)
+	if !ok {
+		targetObject = map[string]any{}
+	}
+
+	for key, value := range patchObject {
+		if value == nil {
+			delete(targetObject, key)
+		} else {
+			targetObject[key] = mergePatch(targetObject[key], value)
+		}
+	}
+	return targetObject
+}
+
+// applyJSONPatch applies an RFC 6902 JSON Patch to doc. Operations are
+// applied in order and the first failure aborts the whole patch.
+func applyJSONPatch(doc any, patch []byte) (any, error) {
+	var operations []jsonPatchOperation
+	if err := json.Unmarshal(patch, &operations); err != nil {
+		return nil, errInvalidPatch
+	}
+
+	var err error
+	for _, op := range operations {
+		if doc, err = applyJSONPatchOperation(doc, op); err != nil {
+			return nil, err
+		}
+	}
+	return doc, nil
+}
+
+func applyJSONPatchOperation(doc any, op jsonPatchOperation) (any, error) {
+	path, err := parseJSONPointer(op.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	var value any
+	switch op.Op {
+	case "add", "replace", "test":
+		if op.Value == nil {
+			return nil, errInvalidPatch
+		}
+		if err := json.Unmarshal(*op.Value, &value); err != nil {
+			return nil, errInvalidPatch
+		}
+	}
+
+	switch op.Op {
+	case "add":
+		return addJSONValue(doc, path, value)
+	case "remove":
+		doc, _, err := removeJSONValue(doc, path)
+		return doc, err
+	case "replace":
+		if doc, _, err = removeJSONValue(doc, path); err != nil {
+			return nil, err
+		}
+		return addJSONValue(doc, path, value)
+	case "move", "copy":
+		from, err := parseJSONPointer(op.From)
+		if err != nil {
+			return nil, err
+		}
+		if op.Op == "move" {
+			// A value cannot be moved into one of its own children.
+			if len(path) > len(from) && reflect.DeepEqual(path[:len(from)], from) {
+				return nil, errInvalidPatch
+			}
+			if doc, value, err = removeJSONValue(doc, from); err != nil {
+				return nil, err
+			}
+		} else {
+			if value, err = getJSONValue(doc, from); err != nil {
+				return nil, err
+			}
+			value = copyJSONValue(value)
+		}
+		return addJSONValue(doc, path, value)
+	case "test":
+		current, err := getJSONValue(doc, path)
+		if err != nil || !reflect.DeepEqual(current, value) {
+			return nil, errPatchTestFailed
+		}
+		return doc, nil
+	default:
+		return nil, errInvalidPatch
+	}
+}
+
+// parseJSONPointer splits an RFC 6901 JSON Pointer into its unescaped
+// reference tokens.
+func parseJSONPointer(pointer string) ([]string, error) {
+	if pointer == "" {
+		return []string{}, nil
+	}
+	if !strings.HasPrefix(pointer, "/") {
+		return nil, errInvalidPatch
+	}
+
+	tokens := strings.Split(pointer[1:], "/")
+	for i, token := range tokens {
+		tokens[i] = strings.ReplaceAll(strings.ReplaceAll(token, "~1", "/"), "~0", "~")
+	}
+	return tokens, nil
+}
+
+func getJSONValue(doc any, path []string) (any, error) {
+	for _, token := range path {
+		switch node := doc.(type) {
+		case map[string]any:
+			value, ok := node[token]
+			if !ok {
+				return nil, errInvalidPatch
+			}
+			doc = value
+		case []any:
+			i, err := jsonArrayIndex(token, len(node)-1)
+			if err != nil {
+				return nil, err
+			}
+			doc = node[i]
+		default:
+			return nil, errInvalidPatch
+		}
+	}
+	return doc, nil
+}
+
+func addJSONValue(doc any, path []string, value any) (any, error) {
+	if len(path) == 0 {
+		return value, nil
+	}
+
+	parent, err := getJSONValue(doc, path[:len(path)-1])
+	if err != nil {
+		return nil, err
+	}
+	last := path[len(path)-1]
+
+	switch node := parent.(type) {
+	case map[string]any:
+		node[last] = value
+		return doc, nil
+	case []any:
+		i := len(node)
+		if last != "-" {
+			if i, err = jsonArrayIndex(last, len(node)); err != nil {
+				return nil, err
+			}
+		}
+		node = append(node, nil)
+		copy(node[i+1:], node[i:])
+		node[i] = value
+		return setJSONValue(doc, path[:len(path)-1], node)
+	default:
+		return nil, errInvalidPatch
+	}
+}
+
+func removeJSONValue(doc any, path []string) (any, any, error) {
+	if len(path) == 0 {
+		return nil, doc, nil
+	}
+
+	parent, err := getJSONValue(doc, path[:len(path)-1])
+	if err != nil {
+		return nil, nil, err
+	}
+	last := path[len(path)-1]
+
+	switch node := parent.(type) {
+	case map[string]any:
+		value, ok := node[last]
+		if !ok {
+			return nil, nil, errInvalidPatch
+		}
+		delete(node, last)
+		return doc, value, nil
+	case []any:
+		i, err := jsonArrayIndex(last, len(node)-1)
+		if err != nil {
+			return nil, nil, err
+		}
+		value := node[i]
+		node = append(node[:i:i], node[i+1:]...)
+		doc, err = setJSONValue(doc, path[:len(path)-1], node)
+		return doc, value, err
+	default:
+		return nil, nil, errInvalidPatch
+	}
+}
+
+// setJSONValue replaces the value at path, which must already exist. It is
+// needed because growing or shrinking a slice may give it a new backing array.
+func setJSONValue(doc any, path []string, value any) (any, error) {
+	if len(path) == 0 {
+		return value, nil
+	}
+
+	parent, err := getJSONValue(doc, path[:len(path)-1])
+	if err != nil {
+		return nil, err
+	}
+	last := path[len(path)-1]
+
+	switch node := parent.(type) {
+	case map[string]any:
+		node[last] = value
+	case []any:
+		i, err := jsonArrayIndex(last, len(node)-1)
+		if err != nil {
+			return nil, err
+		}
+		node[i] = value
+	default:
+		return nil, errInvalidPatch
+	}
+	return doc, nil
+}
+
+func jsonArrayIndex(token string, maxIndex int) (int, error) {
+	if token == "" || (len(token) > 1 && token[0] == '0') {
+		return 0, errInvalidPatch
+	}
+	i, err := strconv.Atoi(token)
+	if err != nil || i < 0 || i > maxIndex {
+		return 0, errInvalidPatch
+	}
+	return i, nil
+}
+
+func copyJSONValue(value any) any {
+	switch v := value.(type) {
+	case map[string]any:
+		c := make(map[string]any, len(v))
+		for key, item := range v {
+			c[key] = copyJSONValue(item)
+		}
+		return c
+	case []any:
+		c := make([]any, len(v))
+		for i, item := range v {
+			c[i] = copyJSONValue(item)
+		}
+		return c
+	default:
+		return v
+	}
+}
@@ -0,0 +1,110 @@
+package api
+
+import (
+	"HMCTS-Developer-Challenge/errors"
+	"math"
+	"slices"
+	"strings"
+	"time"
+)
+
+const defaultTaskPriority = "MEDIUM"
+
+var taskPriorities = []string{"LOW", "MEDIUM", "HIGH", "URGENT"}
+
+// Points each priority contributes to the urgency score. The remaining 50
+// points come from how close the deadline is.
+var priorityPoints = map[string]float64{
+	"LOW":    10,
+	"MEDIUM": 20,
+	"HIGH":   35,
+	"URGENT": 50,
+}
+
+const (
+	maxDeadlinePoints = 50
+
+	// Deadline points rise linearly from zero at this distance from the
+	// deadline to the maximum once the deadline has passed.
+	urgencyHorizon = 14 * 24 * time.Hour
+)
+
+var errInvalidTaskPriority = errors.Error("Invalid Task Priority")
+
+func isValidTaskPriority(priority string) bool {
+	return slices.Contains(taskPriorities, priority)
+}
+
+// normalizeTaskPriority upper-cases a priority the way the list filter does,
+// defaults an empty one and rejects unknown ones.
+func normalizeTaskPriority(priority string) (string, error) {
+	priority = strings.ToUpper(strings.TrimSpace(priority))
+	if priority == "" {
+		return defaultTaskPriority, nil
+	}
+	if !isValidTaskPriority(priority) {
+		return "", errInvalidTaskPriority
+	}
+	return priority, nil
+}
+
+// urgencyScore ranks open tasks from 10 (low priority, deadline over two
+// weeks away) to 100 (urgent and overdue). Tasks in a terminal status score 0.
+// It must be kept in step with urgencySQL.
+func urgencyScore(t task, now time.Time) float64 {
+	if s, ok := workflow.status(t.Status); ok && s.Terminal {
+		return 0
+	}
+
+	points := priorityPoints[t.Priority]
+	if points == 0 {
+		points = priorityPoints["LOW"]
+	}
+
+	if t.Deadline.IsZero() {
+		return points
+	}
+
+	remaining := t.Deadline.Sub(now)
+	switch {
+	case remaining <= 0:
+		points += maxDeadlinePoints
+	case remaining < urgencyHorizon:
+		points += maxDeadlinePoints * (1 - float64(remaining/time.Second)/float64(urgencyHorizon/time.Second))
+	}
+	return math.Round(points*100) / 100
+}
+
+// urgencySQL returns an SQL expression computing urgencyScore so that the
+// task list can be sorted and paginated by it in the database. The list
+// reads the score back from this expression so the cursor value always
+// matches what MySQL compares against.
+func urgencySQL(now time.Time) (string, []any) {
+	at := now.UTC().Format("2006-01-02 15:04:05")
+
+	var terminal []string
+	for _, s := range workflow.Statuses {
+		if s.Terminal {
+			terminal = append(terminal, s.Name)
+		}
+	}
+
+	var args []any
+	isTerminal := "FALSE"
+	if len(terminal) > 0 {
+		isTerminal = "status IN (?" + strings.Repeat(", ?", len(terminal)-1) + ")"
+		for _, s := range terminal {
+			args = append(args, s)
+		}
+	}
+
+	expr := "(CASE WHEN " + isTerminal + " THEN 0 ELSE ROUND(" +
+		"(CASE priority WHEN 'URGENT' THEN 50 WHEN 'HIGH' THEN 35 WHEN 'MEDIUM' THEN 20 ELSE 10 END) + " +
+		"(CASE WHEN deadline <= ? THEN 50 WHEN TIMESTAMPDIFF(SECOND, ?, deadline) >= ? THEN 0 " +
+		"ELSE 50 * (1 - TIMESTAMPDIFF(SECOND, ?, deadline) / ?) END), 2) END)"
+	// The horizon is passed as a float so MySQL divides in floating point
+	// like the Go implementation instead of truncating to four decimals.
+	horizon := float64(urgencyHorizon / time.Second)
+	args = append(args, at, at, horizon, at, horizon)
+	return expr, args
+}
@@ -0,0 +1,169 @@
+package api
+
+import (
+	"HMCTS-Developer-Challenge/database"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestUrgencyScore(t *testing.T) {
+	now := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+	at := func(d time.Duration) time.Time {
+		return now.Add(d)
+	}
+
+	cases := []struct {
+		name     string
+		task     task
+		expected float64
+	}{
+		{"low and far away", task{Priority: "LOW", Status: "INCOMPLETE", Deadline: at(30 * 24 * time.Hour)}, 10},
+		{"urgent and far away", task{Priority: "URGENT", Status: "INCOMPLETE", Deadline: at(30 * 24 * time.Hour)}, 50},
+		{"medium halfway to the horizon", task{Priority: "MEDIUM", Status: "INCOMPLETE", Deadline: at(7 * 24 * time.Hour)}, 45},
+		{"high and overdue", task{Priority: "HIGH", Status: "IN_PROGRESS", Deadline: at(-time.Hour)}, 85},
+		{"urgent and overdue", task{Priority: "URGENT", Status: "BLOCKED", Deadline: at(-time.Hour)}, 100},
+		{"complete", task{Priority: "URGENT", Status: "COMPLETE", Deadline: at(-time.Hour)}, 0},
+		{"cancelled", task{Priority: "HIGH", Status: "CANCELLED", Deadline: at(time.Hour)}, 0},
+	}
+
+	for _, c := range cases {
+		if score := urgencyScore(c.task, now); score != c.expected {
+			t.Errorf("%s: expected urgency %v, got %v", c.name, c.expected, score)
+		}
+	}
+}
+
+func TestNormalizeTaskPriority(t *testing.T) {
+	for given, expected := range map[string]string{"": "MEDIUM", "HIGH": "HIGH", " high ": "HIGH", "Urgent": "URGENT"} {
+		if priority, err := normalizeTaskPriority(given); err != nil || priority != expected {
+			t.Errorf("%q: expected %s, got %q, %v", given, expected, priority, err)
+		}
+	}
+
+	if _, err := normalizeTaskPriority("critical"); err != errInvalidTaskPriority {
+		t.Errorf("Expected errInvalidTaskPriority, got %v", err)
+	}
+}
+
+func TestAddTaskWithPriority(t *testing.T) {
+	body, err := json.Marshal(jsonData{Name: "Urgent injunction", Status: "INCOMPLETE", Deadline: "2025-12-31 00:00:00", Priority: "URGENT"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr := performTaskRequest(t, "POST", "/api/tasks/", body, nil, 1)
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusCreated)
+	}
+
+	db, err := database.GetDBHandle()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Exec("DELETE FROM tasks WHERE name = ?", "Urgent injunction")
+
+	var priority string
+	if err := db.QueryRow("SELECT priority FROM tasks WHERE name = ?", "Urgent injunction").Scan(&priority); err != nil {
+		t.Fatal(err)
+	}
+	if priority != "URGENT" {
+		t.Errorf("Expected priority URGENT, got %s", priority)
+	}
+}
+
+func TestAddTaskDefaultsAndValidatesPriority(t *testing.T) {
+	taskID := createTestTask(t, 1, "Task with default priority")
+	taskData, err := getTask(1, taskID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if taskData.Priority != "MEDIUM" {
+		t.Errorf("Expected default priority MEDIUM, got %s", taskData.Priority)
+	}
+
+	body, err := json.Marshal(jsonData{Name: "Task with invalid priority", Status: "INCOMPLETE", Deadline: "2025-12-31 00:00:00", Priority: "CRITICAL"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr := performTaskRequest(t, "POST", "/api/tasks/", body, nil, 1)
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("POST: handler returned wrong status code: got %v want %v", rr.Code, http.StatusBadRequest)
+	}
+
+	rr = performTaskRequest(t, "PATCH", "/api/tasks/"+taskID, []byte(`{"priority": "CRITICAL"}`), map[string]string{"If-Match": "*"}, 1)
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("PATCH: handler returned wrong status code: got %v want %v", rr.Code, http.StatusBadRequest)
+	}
+}
+
+func TestGetTasksSortByUrgency(t *testing.T) {
+	db, err := database.GetDBHandle()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now().UTC()
+	tasks := []struct {
+		name     string
+		priority string
+		deadline time.Time
+	}{
+		{"Urgency routine admin", "LOW", now.Add(60 * 24 * time.Hour)},
+		{"Urgency overdue injunction", "URGENT", now.Add(-24 * time.Hour)},
+		{"Urgency listing next week", "HIGH", now.Add(7 * 24 * time.Hour)},
+	}
+	for _, task := range tasks {
+		if _, err := db.Exec(
+			"INSERT INTO tasks (created_by, assignee_id, name, status, deadline, priority) VALUES (?, ?, ?, ?, ?, ?)",
+			2, 2, task.name, "INCOMPLETE", task.deadline.Format("2006-01-02 15:04:05"), task.priority,
+		); err != nil {
+			t.Fatal(err)
+		}
+	}
+	defer db.Exec("DELETE FROM tasks WHERE name LIKE 'Urgency %'")
+
+	var names []string
+	url := "/api/tasks/?sort=urgency&order=desc&limit=1&status=INCOMPLETE"
+	for url != "" {
+		rr, page := getTaskPage(t, url, 2)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusOK)
+		}
+		for _, task := range page.Tasks {
+			if len(task.Name) > 8 && task.Name[:8] == "Urgency " {
+				names = append(names, task.Name)
+			}
+		}
+		url = page.Next
+	}
+
+	expected := []string{"Urgency overdue injunction", "Urgency listing next week", "Urgency routine admin"}
+	if len(names) != len(expected) {
+		t.Fatalf("Expected %v, got %v", expected, names)
+	}
+	for i := range expected {
+		if names[i] != expected[i] {
+			t.Errorf("Expected %v, got %v", expected, names)
+			break
+		}
+	}
+}
+
+func TestGetTasksFilterByPriority(t *testing.T) {
+	rr, page := getTaskPage(t, "/api/tasks/?priority=HIGH,URGENT", 1)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusOK)
+	}
+	for _, task := range page.Tasks {
+		if task.Priority != "HIGH" && task.Priority != "URGENT" {
+			t.Errorf("Expected only HIGH or URGENT tasks, got task %d with priority %s", task.ID, task.Priority)
+		}
+	}
+
+	rr, _ = getTaskPage(t, "/api/tasks/?priority=CRITICAL", 1)
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusBadRequest)
+	}
+}
@@ -0,0 +1,494 @@
+package api
+
+import (
+	"HMCTS-Developer-Challenge/errors"
+	"database/sql"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// recurrenceSearchDays bounds how far ahead occurrences are looked for, so
+// that a rule which can never match does not loop forever.
+const recurrenceSearchDays = 100 * 366
+
+const maxRecurrenceRuleLength = 255
+
+const (
+	defaultRecurrencePreviewCount = 10
+	maxRecurrencePreviewCount     = 100
+)
+
+var errInvalidRecurrenceRule = errors.Error("Invalid Recurrence Rule")
+
+var recurrenceFrequencies = []string{"DAILY", "WEEKLY", "MONTHLY", "YEARLY"}
+
+var recurrenceWeekdays = map[string]time.Weekday{
+	"SU": time.Sunday,
+	"MO": time.Monday,
+	"TU": time.Tuesday,
+	"WE": time.Wednesday,
+	"TH": time.Thursday,
+	"FR": time.Friday,
+	"SA": time.Saturday,
+}
+
+var recurrenceUntilLayouts = []string{
+	"20060102T150405Z",
+	"20060102T150405",
+	"20060102",
+}
+
+// recurrenceDay is a BYDAY entry such as MO, or 1MO and -1FR for the first
+// Monday and last Friday of the month (or year for YEARLY rules).
+type recurrenceDay struct {
+	Ordinal int
+	Weekday time.Weekday
+}
+
+// recurrenceRule is the subset of an iCalendar RRULE (RFC 5545) that tasks
+// support: FREQ, INTERVAL, BYDAY, BYMONTHDAY, COUNT and UNTIL.
+type recurrenceRule struct {
+	Freq       string
+	Interval   int
+	ByDay      []recurrenceDay
+	ByMonthDay []int
+	Count      int
+	Until      time.Time
+}
+
+type recurrencePreview struct {
+	Rule        string      `json:"rule"`
+	Occurrences []time.Time `json:"occurrences"`
+}
+
+func RecurrencePreviewHandler(w http.ResponseWriter, r *http.Request, userID uint) {
+	switch r.Method {
+	case http.MethodGet:
+		loc, err := userLocation(userID)
+		if err != nil {
+			errors.HandleServerError(w, err, "recurrence.go: RecurrencePreviewHandler - userLocation")
+			break
+		}
+
+		preview, err := previewRecurrence(r.URL.Query(), loc)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			break
+		}
+
+		writeJSON(w, http.StatusOK, preview)
+	case http.MethodOptions:
+		w.Header().Set("Access-Control-Allow-Methods", "GET, OPTIONS")
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// previewRecurrence lists the next occurrences of the rule in the query from
+// start, which defaults to the beginning of today. Times are read and shown
+// in loc.
+func previewRecurrence(values url.Values, loc *time.Location) (recurrencePreview, error) {
+	var preview recurrencePreview
+
+	rule, err := parseRecurrenceRule(values.Get("rule"))
+	if err != nil {
+		return preview, err
+	}
+
+	now := time.Now().In(loc)
+	start := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, loc)
+	if value := values.Get("start"); value != "" {
+		var ok bool
+		if start, ok = parseTaskTime(value, loc); !ok {
+			return preview, errors.Error("Invalid Start")
+		}
+	}
+
+	count := defaultRecurrencePreviewCount
+	if value := values.Get("count"); value != "" {
+		if count, err = strconv.Atoi(value); err != nil || count < 1 || count > maxRecurrencePreviewCount {
+			return preview, errors.Errorf("Count must be between 1 and %d", maxRecurrencePreviewCount)
+		}
+	}
+
+	preview.Rule = rule.String()
+	preview.Occurrences = []time.Time{}
+	for _, occurrence := range rule.preview(start.In(courtLocation), count) {
+		preview.Occurrences = append(preview.Occurrences, occurrence.In(loc))
+	}
+	return preview, nil
+}
+
+// normalizeRecurrence validates a rule and returns it in canonical form. An
+// empty rule means the task does not recur.
+func normalizeRecurrence(value string) (string, error) {
+	if strings.TrimSpace(value) == "" {
+		return "", nil
+	}
+
+	rule, err := parseRecurrenceRule(value)
+	if err != nil {
+		return "", err
+	}
+	if len(rule.String()) > maxRecurrenceRuleLength {
+		return "", errInvalidRecurrenceRule
+	}
+	return rule.String(), nil
+}
+
+func parseRecurrenceRule(value string) (recurrenceRule, error) {
+	rule := recurrenceRule{Interval: 1}
+
+	value = strings.TrimPrefix(strings.ToUpper(strings.TrimSpace(value)), "RRULE:")
+	if value == "" {
+		return rule, errInvalidRecurrenceRule
+	}
+
+	seen := map[string]bool{}
+	for _, part := range strings.Split(value, ";") {
+		name, v, ok := strings.Cut(part, "=")
+		if !ok || v == "" || seen[name] {
+			return rule, errInvalidRecurrenceRule
+		}
+		seen[name] = true
+
+		var err error
+		switch name {
+		case "FREQ":
+			if !slices.Contains(recurrenceFrequencies, v) {
+				return rule, errInvalidRecurrenceRule
+			}
+			rule.Freq = v
+		case "INTERVAL":
+			if rule.Interval, err = strconv.Atoi(v); err != nil || rule.Interval < 1 {
+				return rule, errInvalidRecurrenceRule
+			}
+		case "COUNT":
+			if rule.Count, err = strconv.Atoi(v); err != nil || rule.Count < 1 {
+				return rule, errInvalidRecurrenceRule
+			}
+		case "UNTIL":
+			if rule.Until, err = parseRecurrenceUntil(v); err != nil {
+				return rule, errInvalidRecurrenceRule
+			}
+		case "BYDAY":
+			for _, entry := range strings.Split(v, ",") {
+				day, err := parseRecurrenceDay(entry)
+				if err != nil {
+					return rule, err
+				}
+				rule.ByDay = append(rule.ByDay, day)
+			}
+		case "BYMONTHDAY":
+			for _, entry := range strings.Split(v, ",") {
+				day, err := strconv.Atoi(entry)
+				if err != nil || day == 0 || day < -31 || day > 31 {
+					return rule, errInvalidRecurrenceRule
+				}
+				rule.ByMonthDay = append(rule.ByMonthDay, day)
+			}
+		default:
+			return rule, errInvalidRecurrenceRule
+		}
+	}
+
+	if rule.Freq == "" || (rule.Count > 0 && !rule.Until.IsZero()) {
+		return rule, errInvalidRecurrenceRule
+	}
+
+	// Numbered weekdays only make sense within a month or a year
+	if rule.Freq == "DAILY" || rule.Freq == "WEEKLY" {
+		for _, day := range rule.ByDay {
+			if day.Ordinal != 0 {
+				return rule, errInvalidRecurrenceRule
+			}
+		}
+	}
+	return rule, nil
+}
+
+func parseRecurrenceDay(value string) (recurrenceDay, error) {
+	if len(value) < 2 {
+		return recurrenceDay{}, errInvalidRecurrenceRule
+	}
+
+	weekday, ok := recurrenceWeekdays[value[len(value)-2:]]
+	if !ok {
+		return recurrenceDay{}, errInvalidRecurrenceRule
+	}
+
+	day := recurrenceDay{Weekday: weekday}
+	if ordinal := value[:len(value)-2]; ordinal != "" {
+		n, err := strconv.Atoi(ordinal)
+		if err != nil || n == 0 || n < -53 || n > 53 {
+			return recurrenceDay{}, errInvalidRecurrenceRule
+		}
+		day.Ordinal = n
+	}
+	return day, nil
+}
+
+// parseRecurrenceUntil accepts a UTC date-time or a date. A date includes
+// every occurrence on that day.
+func parseRecurrenceUntil(value string) (time.Time, error) {
+	for _, layout := range recurrenceUntilLayouts {
+		if t, err := time.Parse(layout, value); err == nil {
+			if len(value) == len("20060102") {
+				t = t.Add(24*time.Hour - time.Second)
+			}
+			return t, nil
+		}
+	}
+	return time.Time{}, errInvalidRecurrenceRule
+}
+
+// String formats the rule in a canonical order so that equal rules are stored
+// identically.
+func (r recurrenceRule) String() string {
+	parts := []string{"FREQ=" + r.Freq}
+	if r.Interval > 1 {
+		parts = append(parts, "INTERVAL="+strconv.Itoa(r.Interval))
+	}
+	if len(r.ByDay) > 0 {
+		days := make([]string, len(r.ByDay))
+		for i, day := range r.ByDay {
+			days[i] = strings.ToUpper(day.Weekday.String()[:2])
+			if day.Ordinal != 0 {
+				days[i] = strconv.Itoa(day.Ordinal) + days[i]
+			}
+		}
+		parts = append(parts, "BYDAY="+strings.Join(days, ","))
+	}
+	if len(r.ByMonthDay) > 0 {
+		days := make([]string, len(r.ByMonthDay))
+		for i, day := range r.ByMonthDay {
+			days[i] = strconv.Itoa(day)
+		}
+		parts = append(parts, "BYMONTHDAY="+strings.Join(days, ","))
+	}
+	if r.Count > 0 {
+		parts = append(parts, "COUNT="+strconv.Itoa(r.Count))
+	}
+	if !r.Until.IsZero() {
+		parts = append(parts, "UNTIL="+r.Until.Format("20060102T150405Z"))
+	}
+	return strings.Join(parts, ";")
+}
+
+// walk calls fn with each occurrence of the rule from start onwards until fn
+// returns false. start plays the part of DTSTART: it anchors the interval and
+// gives every occurrence its time of day.
+func (r recurrenceRule) walk(start time.Time, fn func(time.Time) bool) {
+	for i := 0; i < recurrenceSearchDays; i++ {
+		day := time.Date(start.Year(), start.Month(), start.Day()+i, start.Hour(), start.Minute(), start.Second(), 0, start.Location())
+		if !r.Until.IsZero() && day.After(r.Until) {
+			return
+		}
+		if r.matches(start, day) && !fn(day) {
+			return
+		}
+	}
+}
+
+// preview returns up to n occurrences from start, including start itself if
+// it matches the rule.
+func (r recurrenceRule) preview(start time.Time, n int) []time.Time {
+	if r.Count > 0 && r.Count < n {
+		n = r.Count
+	}
+
+	var occurrences []time.Time
+	r.walk(start, func(t time.Time) bool {
+		occurrences = append(occurrences, t)
+		return len(occurrences) < n
+	})
+	return occurrences
+}
+
+// next returns the first occurrence after the given one along with the rule
+// the next occurrence should carry. COUNT is the number of occurrences left
+// including the current one, so it counts down as the series progresses.
+func (r recurrenceRule) next(after time.Time) (time.Time, recurrenceRule, bool) {
+	if r.Count == 1 {
+		return time.Time{}, r, false
+	}
+
+	var next time.Time
+	r.walk(after, func(t time.Time) bool {
+		if t.After(after) {
+			next = t
+			return false
+		}
+		return true
+	})
+	if next.IsZero() {
+		return next, r, false
+	}
+
+	if r.Count > 0 {
+		r.Count--
+	}
+	return next, r, true
+}
+
+func (r recurrenceRule) matches(start, day time.Time) bool {
+	if r.period(start, day)%r.Interval != 0 {
+		return false
+	}
+
+	// Without BYDAY or BYMONTHDAY the rule repeats on the same day as start
+	switch r.Freq {
+	case "WEEKLY":
+		if len(r.ByDay) == 0 && day.Weekday() != start.Weekday() {
+			return false
+		}
+	case "MONTHLY":
+		if len(r.ByDay) == 0 && len(r.ByMonthDay) == 0 && day.Day() != start.Day() {
+			return false
+		}
+	case "YEARLY":
+		if len(r.ByDay) == 0 && len(r.ByMonthDay) == 0 && (day.Month() != start.Month() || day.Day() != start.Day()) {
+			return false
+		}
+	}
+	return r.matchesByDay(day) && r.matchesByMonthDay(day)
+}
+
+// period is the number of whole FREQ periods between start and day.
+func (r recurrenceRule) period(start, day time.Time) int {
+	switch r.Freq {
+	case "WEEKLY":
+		return daysBetween(startOfWeek(start), startOfWeek(day)) / 7
+	case "MONTHLY":
+		return (day.Year()-start.Year())*12 + int(day.Month()) - int(start.Month())
+	case "YEARLY":
+		return day.Year() - start.Year()
+	default:
+		return daysBetween(start, day)
+	}
+}
+
+func (r recurrenceRule) matchesByDay(day time.Time) bool {
+	if len(r.ByDay) == 0 {
+		return true
+	}
+
+	// Position of the weekday within the month or year, from either end
+	index, length := day.Day(), daysInMonth(day)
+	if r.Freq == "YEARLY" {
+		index, length = day.YearDay(), time.Date(day.Year(), time.December, 31, 0, 0, 0, 0, time.UTC).YearDay()
+	}
+	fromStart, fromEnd := (index-1)/7+1, (length-index)/7+1
+
+	for _, d := range r.ByDay {
+		if d.Weekday != day.Weekday() {
+			continue
+		}
+		if d.Ordinal == 0 || d.Ordinal == fromStart || -d.Ordinal == fromEnd {
+			return true
+		}
+	}
+	return false
+}
+
+func (r recurrenceRule) matchesByMonthDay(day time.Time) bool {
+	if len(r.ByMonthDay) == 0 {
+		return true
+	}
+
+	for _, d := range r.ByMonthDay {
+		if d == day.Day() || (d < 0 && daysInMonth(day)+d+1 == day.Day()) {
+			return true
+		}
+	}
+	return false
+}
+
+func daysInMonth(t time.Time) int {
+	return time.Date(t.Year(), t.Month()+1, 0, 0, 0, 0, 0, time.UTC).Day()
+}
+
+func startOfWeek(t time.Time) time.Time {
+	return t.AddDate(0, 0, -((int(t.Weekday()) + 6) % 7))
+}
+
+func daysBetween(from, to time.Time) int {
+	from = time.Date(from.Year(), from.Month(), from.Day(), 0, 0, 0, 0, time.UTC)
+	to = time.Date(to.Year(), to.Month(), to.Day(), 0, 0, 0, 0, time.UTC)
+	return int(to.Sub(from).Hours() / 24)
+}
+
+// scheduleNextOccurrence creates the next task in the series when a recurring
+// task moves to a terminal status. The new task keeps the name, description,
+// priority and tags and the same creator and assignee, starts in the first
+// initial status and carries the rule forward. Completing the same
+// occurrence again, after reopening it, does not create a second copy.
+func scheduleNextOccurrence(tx *sql.Tx, userID uint, current task, data jsonData, deadline time.Time, options taskEditOptions) error {
+	if data.Recurrence == "" {
+		return nil
+	}
+	if s, ok := workflow.status(data.Status); !ok || !s.Terminal {
+		return nil
+	}
+	if s, ok := workflow.status(current.Status); ok && s.Terminal {
+		return nil
+	}
+
+	var scheduled bool
+	if err := tx.QueryRow("SELECT EXISTS(SELECT 1 FROM tasks WHERE previous_occurrence_id = ?)", current.ID).Scan(&scheduled); err != nil {
+		return errors.AddContext(err, "recurrence.go: scheduleNextOccurrence - QueryRow")
+	} else if scheduled {
+		return nil
+	}
+
+	rule, err := parseRecurrenceRule(data.Recurrence)
+	if err != nil {
+		return err
+	}
+
+	// Occurrences are counted in the courts' zone so a weekly 9am deadline
+	// stays at 9am after the clocks change
+	next, rule, ok := rule.next(deadline.In(courtLocation))
+	if !ok {
+		return nil
+	}
+
+	result, err := tx.Exec(
+		"INSERT INTO tasks (created_by, assignee_id, team_id, case_id, parent_id, previous_occurrence_id, name, description, status, deadline, priority, recurrence) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)",
+		current.CreatedBy,
+		current.AssigneeID,
+		current.TeamID,
+		current.CaseID,
+		current.ParentID,
+		current.ID,
+		data.Name,
+		data.Description,
+		workflow.initial()[0],
+		next,
+		data.Priority,
+		rule.String(),
+	)
+	if err != nil {
+		return errors.AddContext(err, "recurrence.go: scheduleNextOccurrence - Exec")
+	}
+
+	taskID, err := result.LastInsertId()
+	if err != nil {
+		return errors.AddContext(err, "recurrence.go: scheduleNextOccurrence - LastInsertId")
+	}
+
+	// The tags have already been updated within this transaction
+	if _, err := tx.Exec("INSERT INTO task_tags (task_id, tag_id) SELECT ?, tag_id FROM task_tags WHERE task_id = ?", taskID, current.ID); err != nil {
+		return errors.AddContext(err, "recurrence.go: scheduleNextOccurrence - Exec tags")
+	}
+
+	if err := recordTaskCreated(tx, userID, uint(taskID), options); err != nil {
+		return errors.AddContext(err, "recurrence.go: scheduleNextOccurrence - recordTaskCreated")
+	}
+	return nil
+}
@@ -0,0 +1,228 @@
+package api
+
+import (
+	"HMCTS-Developer-Challenge/database"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestParseRecurrenceRule(t *testing.T) {
+	valid := map[string]string{
+		"FREQ=DAILY":                              "FREQ=DAILY",
+		"rrule:freq=weekly;byday=mo;interval=1":   "FREQ=WEEKLY;BYDAY=MO",
+		"FREQ=MONTHLY;BYDAY=-1FR;COUNT=12":        "FREQ=MONTHLY;BYDAY=-1FR;COUNT=12",
+		"FREQ=MONTHLY;BYMONTHDAY=1,15;INTERVAL=2": "FREQ=MONTHLY;INTERVAL=2;BYMONTHDAY=1,15",
+		"FREQ=DAILY;UNTIL=20251231":               "FREQ=DAILY;UNTIL=20251231T235959Z",
+	}
+	for value, expected := range valid {
+		if normalized, err := normalizeRecurrence(value); err != nil || normalized != expected {
+			t.Errorf("%q: expected %q, got %q (%v)", value, expected, normalized, err)
+		}
+	}
+
+	invalid := []string{
+		"BYDAY=MO",
+		"FREQ=HOURLY",
+		"FREQ=DAILY;FREQ=WEEKLY",
+		"FREQ=DAILY;INTERVAL=0",
+		"FREQ=DAILY;COUNT=2;UNTIL=20250101",
+		"FREQ=WEEKLY;BYDAY=1MO",
+		"FREQ=WEEKLY;BYDAY=XX",
+		"FREQ=MONTHLY;BYMONTHDAY=32",
+		"FREQ=DAILY;BYSETPOS=1",
+	}
+	for _, value := range invalid {
+		if _, err := normalizeRecurrence(value); err != errInvalidRecurrenceRule {
+			t.Errorf("%q: expected errInvalidRecurrenceRule, got %v", value, err)
+		}
+	}
+
+	if normalized, err := normalizeRecurrence(""); err != nil || normalized != "" {
+		t.Errorf("Expected an empty rule to mean no recurrence, got %q (%v)", normalized, err)
+	}
+}
+
+func TestRecurrencePreview(t *testing.T) {
+	tests := []struct {
+		rule     string
+		start    string
+		expected []string
+	}{
+		// 1 January 2025 is a Wednesday
+		{"FREQ=WEEKLY;INTERVAL=2;BYDAY=MO,WE", "2025-01-01 09:00:00", []string{"2025-01-01 09:00:00", "2025-01-13 09:00:00", "2025-01-15 09:00:00", "2025-01-27 09:00:00"}},
+		{"FREQ=MONTHLY;BYMONTHDAY=-1", "2025-01-15 00:00:00", []string{"2025-01-31 00:00:00", "2025-02-28 00:00:00", "2025-03-31 00:00:00", "2025-04-30 00:00:00"}},
+		{"FREQ=MONTHLY;BYDAY=-1FR", "2025-01-01 00:00:00", []string{"2025-01-31 00:00:00", "2025-02-28 00:00:00", "2025-03-28 00:00:00", "2025-04-25 00:00:00"}},
+		{"FREQ=MONTHLY", "2025-01-31 00:00:00", []string{"2025-01-31 00:00:00", "2025-03-31 00:00:00", "2025-05-31 00:00:00", "2025-07-31 00:00:00"}},
+		{"FREQ=DAILY;COUNT=2", "2025-01-01 00:00:00", []string{"2025-01-01 00:00:00", "2025-01-02 00:00:00"}},
+		{"FREQ=DAILY;INTERVAL=3;UNTIL=20250107", "2025-01-01 00:00:00", []string{"2025-01-01 00:00:00", "2025-01-04 00:00:00", "2025-01-07 00:00:00"}},
+		{"FREQ=YEARLY", "2024-02-29 00:00:00", []string{"2024-02-29 00:00:00", "2028-02-29 00:00:00", "2032-02-29 00:00:00", "2036-02-29 00:00:00"}},
+	}
+
+	for _, test := range tests {
+		rule, err := parseRecurrenceRule(test.rule)
+		if err != nil {
+			t.Fatalf("%s: %v", test.rule, err)
+		}
+		start, _ := time.Parse("2006-01-02 15:04:05", test.start)
+
+		var got []string
+		for _, occurrence := range rule.preview(start, 4) {
+			got = append(got, occurrence.Format("2006-01-02 15:04:05"))
+		}
+		if !reflect.DeepEqual(got, test.expected) {
+			t.Errorf("%s from %s: expected %v, got %v", test.rule, test.start, test.expected, got)
+		}
+	}
+}
+
+func TestRecurrenceNext(t *testing.T) {
+	rule, err := parseRecurrenceRule("FREQ=WEEKLY;BYDAY=MO,FR;COUNT=2")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	friday := time.Date(2025, time.January, 3, 9, 0, 0, 0, time.UTC)
+	next, rule, ok := rule.next(friday)
+	if !ok || !next.Equal(time.Date(2025, time.January, 6, 9, 0, 0, 0, time.UTC)) {
+		t.Fatalf("Expected Monday 6 January, got %v (%v)", next, ok)
+	}
+	if rule.Count != 1 {
+		t.Errorf("Expected one occurrence left, got %d", rule.Count)
+	}
+
+	if _, _, ok := rule.next(next); ok {
+		t.Error("Expected the series to end after the last occurrence")
+	}
+}
+
+func TestRecurrencePreviewHandler(t *testing.T) {
+	req, err := http.NewRequest("GET", "/api/tasks/recurrence?rule=FREQ%3DWEEKLY&start=2025-01-01&count=3", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr := httptest.NewRecorder()
+	RecurrencePreviewHandler(rr, req, 1)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusOK)
+	}
+
+	var preview recurrencePreview
+	if err := json.Unmarshal(rr.Body.Bytes(), &preview); err != nil {
+		t.Fatalf("Failed to parse response as JSON: %v", err)
+	}
+	expected := []string{"2025-01-01T00:00:00Z", "2025-01-08T00:00:00Z", "2025-01-15T00:00:00Z"}
+	var occurrences []string
+	for _, occurrence := range preview.Occurrences {
+		occurrences = append(occurrences, occurrence.Format(time.RFC3339))
+	}
+	if !reflect.DeepEqual(occurrences, expected) {
+		t.Errorf("Expected %v, got %v", expected, occurrences)
+	}
+
+	for _, query := range []string{"rule=FREQ%3DHOURLY", "rule=FREQ%3DDAILY&count=1000", "rule=FREQ%3DDAILY&start=soon"} {
+		req, _ := http.NewRequest("GET", "/api/tasks/recurrence?"+query, nil)
+		rr := httptest.NewRecorder()
+		RecurrencePreviewHandler(rr, req, 1)
+		if rr.Code != http.StatusBadRequest {
+			t.Errorf("%s: handler returned wrong status code: got %v want %v", query, rr.Code, http.StatusBadRequest)
+		}
+	}
+}
+
+func TestCompleteRecurringTask(t *testing.T) {
+	db, err := database.GetDBHandle()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	taskID := createTestTask(t, 1, "Weekly listings check")
+	t.Cleanup(func() {
+		db.Exec("DELETE FROM tasks WHERE previous_occurrence_id = ?", taskID)
+		db.Exec("DELETE FROM tags WHERE user_id = 1 AND name = 'listings'")
+	})
+
+	body := `{"recurrence": "FREQ=WEEKLY", "deadline": "2025-12-01 09:00:00", "tags": ["listings"]}`
+	rr := performTaskRequest(t, "PATCH", "/api/tasks/"+taskID, []byte(body), map[string]string{"If-Match": "*"}, 1)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusOK)
+	}
+
+	// Completing, reopening and completing again only schedules one task
+	for _, status := range []string{"COMPLETE", "INCOMPLETE", "COMPLETE"} {
+		rr = performTaskRequest(t, "PATCH", "/api/tasks/"+taskID, []byte(`{"status": "`+status+`"}`), map[string]string{"If-Match": "*"}, 1)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("handler returned wrong status code moving to %s: got %v want %v", status, rr.Code, http.StatusOK)
+		}
+	}
+
+	rows, err := db.Query("SELECT id FROM tasks WHERE previous_occurrence_id = ?", taskID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if len(ids) != 1 {
+		t.Fatalf("Expected one next occurrence, got %d", len(ids))
+	}
+
+	next, err := getTask(1, ids[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if next.Name != "Weekly listings check" || next.Description != "Description for Weekly listings check" {
+		t.Errorf("Expected the name and description to be kept, got %q and %q", next.Name, next.Description)
+	}
+	if next.Status != "INCOMPLETE" || next.Deadline.Format(time.DateTime) != "2025-12-08 09:00:00" || next.Recurrence != "FREQ=WEEKLY" {
+		t.Errorf("Unexpected next occurrence: %+v", next)
+	}
+	if !reflect.DeepEqual(next.Tags, []string{"listings"}) {
+		t.Errorf("Expected the tags to be kept, got %v", next.Tags)
+	}
+}
+
+func TestCascadeRecurringSubtask(t *testing.T) {
+	db, err := database.GetDBHandle()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	parentID := createTestTask(t, 1, "Monthly court returns")
+	child := createTestSubtask(t, parentID, "Collate monthly figures")
+	t.Cleanup(func() { db.Exec("DELETE FROM tasks WHERE previous_occurrence_id = ?", child.ID) })
+
+	childID := strconv.FormatUint(uint64(child.ID), 10)
+	body := `{"recurrence": "FREQ=MONTHLY", "deadline": "2025-11-28 17:00:00"}`
+	if rr := performTaskRequest(t, "PATCH", "/api/tasks/"+childID, []byte(body), map[string]string{"If-Match": "*"}, 1); rr.Code != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusOK)
+	}
+
+	rr := performTaskRequest(t, "PATCH", "/api/tasks/"+parentID+"?cascade=true", []byte(`{"status": "COMPLETE"}`), map[string]string{"If-Match": "*"}, 1)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusOK)
+	}
+
+	var nextID string
+	if err := db.QueryRow("SELECT id FROM tasks WHERE previous_occurrence_id = ?", child.ID).Scan(&nextID); err != nil {
+		t.Fatalf("Expected the cascade to schedule the next occurrence: %v", err)
+	}
+	next, err := getTask(1, nextID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if next.Name != "Collate monthly figures" || next.Status != "INCOMPLETE" || next.Deadline.Format(time.DateTime) != "2025-12-28 17:00:00" {
+		t.Errorf("Unexpected next occurrence: %+v", next)
+	}
+}
@@ -0,0 +1,68 @@
+package api
+
+import (
+	"HMCTS-Developer-Challenge/errors"
+	"database/sql"
+	"slices"
+	"strings"
+)
+
+// Permission names an action that is granted to users through their role.
+type Permission string
+
+const (
+	PermissionViewTasks   Permission = "tasks:view"
+	PermissionEditTasks   Permission = "tasks:edit"
+	PermissionManageTeams Permission = "teams:manage"
+	PermissionManageUsers Permission = "users:manage"
+	// PermissionViewAuditLog lets users search and verify the audit log.
+	PermissionViewAuditLog Permission = "audit:view"
+)
+
+// Every user has exactly one role. Caseworkers work their own and their
+// teams' tasks, leads can also set up teams, auditors can look at every task
+// and the audit log without changing anything and admins can do everything.
+const (
+	RoleAdmin      = "ADMIN"
+	RoleLead       = "LEAD"
+	RoleCaseworker = "CASEWORKER"
+	RoleAuditor    = "AUDITOR"
+)
+
+// defaultRole is given to new users unless another role is chosen.
+const defaultRole = RoleCaseworker
+
+var rolePermissions = map[string][]Permission{
+	RoleAdmin:      {PermissionViewTasks, PermissionEditTasks, PermissionManageTeams, PermissionManageUsers, PermissionViewAuditLog},
+	RoleLead:       {PermissionViewTasks, PermissionEditTasks, PermissionManageTeams},
+	RoleCaseworker: {PermissionViewTasks, PermissionEditTasks},
+	RoleAuditor:    {PermissionViewTasks, PermissionViewAuditLog},
+}
+
+var errInvalidRole = errors.Error("Invalid Role")
+
+// HasPermission reports whether a role grants a permission. Unknown roles
+// grant nothing.
+func HasPermission(role string, permission Permission) bool {
+	return slices.Contains(rolePermissions[role], permission)
+}
+
+func normalizeRole(role string) (string, error) {
+	role = strings.ToUpper(strings.TrimSpace(role))
+	if role == "" {
+		return defaultRole, nil
+	}
+	if _, ok := rolePermissions[role]; !ok {
+		return "", errInvalidRole
+	}
+	return role, nil
+}
+
+// userRole returns the role of a user, or an empty string for unknown users.
+func userRole(q rowQueryer, userID uint) (string, error) {
+	var role string
+	if err := q.QueryRow("SELECT role FROM users WHERE id = ?", userID).Scan(&role); err != nil && err != sql.ErrNoRows {
+		return "", err
+	}
+	return role, nil
+}
@@ -0,0 +1,465 @@
+package api
+
+import (
+	"HMCTS-Developer-Challenge/database"
+	"HMCTS-Developer-Challenge/errors"
+	"html"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+const (
+	defaultSearchLimit = 20
+	maxSearchLimit     = 100
+
+	// InnoDB ignores words shorter than innodb_ft_min_token_size (3 by
+	// default), so queries containing them are answered in-process instead.
+	fullTextMinTokenSize = 3
+
+	// Characters of context kept either side of a match in a highlight.
+	highlightContext = 40
+
+	// MySQL error returned when MATCH is used on columns without a FULLTEXT
+	// index.
+	errNoFullTextIndex = 1191
+)
+
+var errEmptySearchQuery = errors.Error("search query must contain at least one term")
+
+type searchQuery struct {
+	Terms    []string
+	Phrases  []string
+	Excluded []string
+}
+
+type searchResult struct {
+	Task       task                `json:"task"`
+	Score      float64             `json:"score"`
+	Highlights map[string][]string `json:"highlights"`
+}
+
+type searchResponse struct {
+	Query   string         `json:"query"`
+	Results []searchResult `json:"results"`
+}
+
+// taskSearcher finds and ranks a user's tasks. Highlighting is applied
+// afterwards so every implementation returns the same shaped results.
+type taskSearcher interface {
+	search(userID uint, query searchQuery, limit int) ([]searchResult, error)
+}
+
+func SearchTasksHandler(w http.ResponseWriter, r *http.Request, userID uint) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	raw := r.URL.Query().Get("q")
+	query, err := parseSearchQuery(raw)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	limit := defaultSearchLimit
+	if l := r.URL.Query().Get("limit"); l != "" {
+		n, err := strconv.Atoi(l)
+		if err != nil || n < 1 || n > maxSearchLimit {
+			http.Error(w, "limit must be between 1 and "+strconv.Itoa(maxSearchLimit), http.StatusBadRequest)
+			return
+		}
+		limit = n
+	}
+
+	results, err := searchTasks(userID, query, limit)
+	if err != nil {
+		errors.HandleServerError(w, err, "search.go: SearchTasksHandler - searchTasks")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, searchResponse{Query: raw, Results: results})
+}
+
+// parseSearchQuery splits a query into plain terms, "quoted phrases" and
+// excluded -terms or -"phrases".
+func parseSearchQuery(raw string) (searchQuery, error) {
+	var query searchQuery
+
+	runes := []rune(raw)
+	for i := 0; i < len(runes); {
+		if unicode.IsSpace(runes[i]) {
+			i++
+			continue
+		}
+
+		exclude := false
+		if runes[i] == '-' {
+			exclude = true
+			i++
+		}
+
+		var token string
+		phrase := false
+		if i < len(runes) && runes[i] == '"' {
+			end := i + 1
+			for end < len(runes) && runes[end] != '"' {
+				end++
+			}
+			token = string(runes[i+1 : end])
+			phrase = true
+			i = end + 1
+		} else {
+			end := i
+			for end < len(runes) && !unicode.IsSpace(runes[end]) {
+				end++
+			}
+			token = string(runes[i:end])
+			i = end
+		}
+
+		words := searchWords(token)
+		if len(words) == 0 {
+			continue
+		}
+
+		switch {
+		case exclude:
+			query.Excluded = append(query.Excluded, strings.Join(words, " "))
+		case phrase && len(words) > 1:
+			query.Phrases = append(query.Phrases, strings.Join(words, " "))
+		default:
+			query.Terms = append(query.Terms, words...)
+		}
+	}
+
+	if len(query.Terms) == 0 && len(query.Phrases) == 0 {
+		return query, errEmptySearchQuery
+	}
+	return query, nil
+}
+
+// searchWords lower-cases a token and drops anything that is not a letter or
+// digit, which also strips MySQL boolean mode operators from user input.
+func searchWords(token string) []string {
+	return strings.FieldsFunc(strings.ToLower(token), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// booleanMode renders the query for MATCH ... AGAINST (? IN BOOLEAN MODE).
+// Every term and phrase is required so results narrow as the user types.
+func (q searchQuery) booleanMode() string {
+	var parts []string
+	for _, term := range q.Terms {
+		parts = append(parts, "+"+term+"*")
+	}
+	for _, phrase := range q.Phrases {
+		parts = append(parts, `+"`+phrase+`"`)
+	}
+	for _, excluded := range q.Excluded {
+		parts = append(parts, `-"`+excluded+`"`)
+	}
+	return strings.Join(parts, " ")
+}
+
+func (q searchQuery) supportedByFullText() bool {
+	for _, words := range [][]string{q.Terms, q.Phrases, q.Excluded} {
+		for _, w := range words {
+			for _, word := range strings.Fields(w) {
+				if len([]rune(word)) < fullTextMinTokenSize {
+					return false
+				}
+			}
+		}
+	}
+	return true
+}
+
+func searchTasks(userID uint, query searchQuery, limit int) ([]searchResult, error) {
+	var searcher taskSearcher = fullTextSearcher{}
+	if !query.supportedByFullText() {
+		searcher = inProcessSearcher{}
+	}
+
+	results, err := searcher.search(userID, query, limit)
+	if mysqlErr, ok := err.(*mysql.MySQLError); ok && mysqlErr.Number == errNoFullTextIndex {
+		results, err = inProcessSearcher{}.search(userID, query, limit)
+	}
+	if err != nil {
+		return nil, errors.AddContext(err, "search.go: searchTasks - search")
+	}
+
+	tasks := make([]*task, len(results))
+	for i := range results {
+		results[i].Highlights = highlightTask(results[i].Task, query)
+		tasks[i] = &results[i].Task
+	}
+	if err := loadTaskDetails(userID, tasks...); err != nil {
+		return nil, errors.AddContext(err, "search.go: searchTasks - loadTaskDetails")
+	}
+	return results, nil
+}
+
+type fullTextSearcher struct{}
+
+func (fullTextSearcher) search(userID uint, query searchQuery, limit int) ([]searchResult, error) {
+	dbHandle, err := database.GetDBHandle()
+	if err != nil {
+		return nil, errors.AddContext(err, "search.go: fullTextSearcher.search - GetDBHandle")
+	}
+
+	against := query.booleanMode()
+	access, accessArgs := taskAccess(userID)
+	args := append(append([]any{against}, accessArgs...), against, limit)
+	rows, err := dbHandle.Query(
+		"SELECT "+taskColumns+", MATCH(name, description) AGAINST (? IN BOOLEAN MODE) AS score "+
+			"FROM tasks WHERE "+access+" AND MATCH(name, description) AGAINST (? IN BOOLEAN MODE) "+
+			"ORDER BY score DESC, id ASC LIMIT ?",
+		args...,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	results := []searchResult{}
+	for rows.Next() {
+		var result searchResult
+		if result.Task, err = scanTask(rows, &result.Score); err != nil {
+			return nil, errors.AddContext(err, "search.go: fullTextSearcher.search - Scan")
+		}
+		results = append(results, result)
+	}
+	return results, rows.Err()
+}
+
+// inProcessSearcher ranks tasks in Go. It is used when the store has no
+// FULLTEXT index or the query contains words too short for one.
+type inProcessSearcher struct{}
+
+func (inProcessSearcher) search(userID uint, query searchQuery, limit int) ([]searchResult, error) {
+	dbHandle, err := database.GetDBHandle()
+	if err != nil {
+		return nil, errors.AddContext(err, "search.go: inProcessSearcher.search - GetDBHandle")
+	}
+
+	access, accessArgs := taskAccess(userID)
+	rows, err := dbHandle.Query("SELECT "+taskColumns+" FROM tasks WHERE "+access, accessArgs...)
+	if err != nil {
+		return nil, errors.AddContext(err, "search.go: inProcessSearcher.search - Query")
+	}
+	defer rows.Close()
+
+	var tasks []task
+	for rows.Next() {
+		t, err := scanTask(rows)
+		if err != nil {
+			return nil, errors.AddContext(err, "search.go: inProcessSearcher.search - Scan")
+		}
+		tasks = append(tasks, t)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, errors.AddContext(err, "search.go: inProcessSearcher.search - Rows")
+	}
+
+	return rankTasks(tasks, query, limit), nil
+}
+
+// rankTasks scores each task by how often the query matches it, counting
+// matches in the name twice. Tasks missing a term or containing an excluded
+// word are dropped.
+func rankTasks(tasks []task, query searchQuery, limit int) []searchResult {
+	results := []searchResult{}
+	for _, t := range tasks {
+		name := strings.Join(searchWords(t.Name), " ")
+		description := strings.Join(searchWords(t.Description), " ")
+
+		score := 0.0
+		matched := true
+		for _, term := range query.Terms {
+			n := 2*countPrefixMatches(name, term) + countPrefixMatches(description, term)
+			if n == 0 {
+				matched = false
+				break
+			}
+			score += float64(n)
+		}
+		for _, phrase := range query.Phrases {
+			if !matched {
+				break
+			}
+			n := 2*countPhraseMatches(name, phrase) + countPhraseMatches(description, phrase)
+			if n == 0 {
+				matched = false
+				break
+			}
+			score += float64(n) * float64(len(strings.Fields(phrase)))
+		}
+		for _, excluded := range query.Excluded {
+			if countPhraseMatches(name, excluded)+countPhraseMatches(description, excluded) > 0 {
+				matched = false
+			}
+		}
+
+		if matched {
+			results = append(results, searchResult{Task: t, Score: score})
+		}
+	}
+
+	sort.SliceStable(results, func(i, j int) bool {
+		if results[i].Score != results[j].Score {
+			return results[i].Score > results[j].Score
+		}
+		return results[i].Task.ID < results[j].Task.ID
+	})
+
+	if len(results) > limit {
+		results = results[:limit]
+	}
+	return results
+}
+
+func countPrefixMatches(text, term string) int {
+	n := 0
+	for _, word := range strings.Fields(text) {
+		if strings.HasPrefix(word, term) {
+			n++
+		}
+	}
+	return n
+}
+
+func countPhraseMatches(text, phrase string) int {
+	return strings.Count(" "+text+" ", " "+phrase+" ")
+}
+
+// highlightTask returns the fragments of the name and description that
+// matched the query. Fragments are HTML escaped with matches wrapped in
+// <mark> so they can be inserted into the page as-is.
+func highlightTask(t task, query searchQuery) map[string][]string {
+	highlights := map[string][]string{}
+	if fragments := highlightText(t.Name, query, true); len(fragments) > 0 {
+		highlights["name"] = fragments
+	}
+	if fragments := highlightText(t.Description, query, false); len(fragments) > 0 {
+		highlights["description"] = fragments
+	}
+	return highlights
+}
+
+type matchSpan struct {
+	start, end int
+}
+
+func highlightText(text string, query searchQuery, whole bool) []string {
+	spans := findMatches(text, query)
+	if len(spans) == 0 {
+		return nil
+	}
+
+	runes := []rune(text)
+	if whole {
+		return []string{markSpans(runes, spans, 0, len(runes))}
+	}
+
+	// Group matches whose context windows overlap into a single fragment.
+	var fragments []string
+	for i := 0; i < len(spans); {
+		start := max(spans[i].start-highlightContext, 0)
+		end := min(spans[i].end+highlightContext, len(runes))
+		j := i + 1
+		for j < len(spans) && spans[j].start-highlightContext <= end {
+			end = min(spans[j].end+highlightContext, len(runes))
+			j++
+		}
+
+		fragment := markSpans(runes, spans[i:j], start, end)
+		if start > 0 {
+			fragment = "…" + fragment
+		}
+		if end < len(runes) {
+			fragment += "…"
+		}
+		fragments = append(fragments, fragment)
+		i = j
+	}
+	return fragments
+}
+
+func markSpans(runes []rune, spans []matchSpan, start, end int) string {
+	var b strings.Builder
+	pos := start
+	for _, s := range spans {
+		b.WriteString(html.EscapeString(string(runes[pos:s.start])))
+		b.WriteString("<mark>")
+		b.WriteString(html.EscapeString(string(runes[s.start:s.end])))
+		b.WriteString("</mark>")
+		pos = s.end
+	}
+	b.WriteString(html.EscapeString(string(runes[pos:end])))
+	return b.String()
+}
+
+// findMatches locates the words in text that match a term prefix or start a
+// phrase, returning non-overlapping spans in rune offsets.
+func findMatches(text string, query searchQuery) []matchSpan {
+	type word struct {
+		value      string
+		start, end int
+	}
+
+	var words []word
+	runes := []rune(text)
+	for i := 0; i < len(runes); {
+		if !unicode.IsLetter(runes[i]) && !unicode.IsDigit(runes[i]) {
+			i++
+			continue
+		}
+		end := i
+		for end < len(runes) && (unicode.IsLetter(runes[end]) || unicode.IsDigit(runes[end])) {
+			end++
+		}
+		words = append(words, word{strings.ToLower(string(runes[i:end])), i, end})
+		i = end
+	}
+
+	var spans []matchSpan
+	for i := 0; i < len(words); i++ {
+		matched := false
+		for _, phrase := range query.Phrases {
+			parts := strings.Fields(phrase)
+			if i+len(parts) > len(words) {
+				continue
+			}
+			ok := true
+			for k, part := range parts {
+				if words[i+k].value != part {
+					ok = false
+					break
+				}
+			}
+			if ok {
+				spans = append(spans, matchSpan{words[i].start, words[i+len(parts)-1].end})
+				i += len(parts) - 1
+				matched = true
+				break
+			}
+		}
+		if matched {
+			continue
+		}
+		for _, term := range query.Terms {
+			if strings.HasPrefix(words[i].value, term) {
+				spans = append(spans, matchSpan{words[i].start, words[i].end})
+				break
+			}
+		}
+	}
+	return spans
+}
@@ -0,0 +1,160 @@
+package api
+
+import (
+	"HMCTS-Developer-Challenge/database"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func performSearch(t *testing.T, q string, userID uint) (*httptest.ResponseRecorder, searchResponse) {
+	req, err := http.NewRequest("GET", "/api/tasks/search?q="+url.QueryEscape(q), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr := httptest.NewRecorder()
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		SearchTasksHandler(w, r, userID)
+	})
+	handler.ServeHTTP(rr, req)
+
+	var response searchResponse
+	if rr.Code == http.StatusOK {
+		if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil {
+			t.Fatalf("Failed to parse response as JSON: %v", err)
+		}
+	}
+	return rr, response
+}
+
+func addSearchTestTask(t *testing.T, name, description string) {
+	err := addTask(1, jsonData{
+		Name:        name,
+		Description: description,
+		Status:      "INCOMPLETE",
+		Deadline:    "2025-12-31 00:00:00",
+	}, taskEditOptions{})
+	if err != nil {
+		t.Fatalf("Failed to add task: %v", err)
+	}
+
+	t.Cleanup(func() {
+		db, err := database.GetDBHandle()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := db.Exec("DELETE FROM tasks WHERE name = ?", name); err != nil {
+			t.Fatalf("Failed to delete test task: %v", err)
+		}
+	})
+}
+
+func searchResultNames(response searchResponse) []string {
+	var names []string
+	for _, result := range response.Results {
+		names = append(names, result.Task.Name)
+	}
+	return names
+}
+
+func TestSearchTasks(t *testing.T) {
+	addSearchTestTask(t, "Prepare listing bundle", "Collate the bundle for the family hearing")
+	addSearchTestTask(t, "Chase payment", "Chase the applicant for the civil hearing fee")
+
+	rr, response := performSearch(t, "bundle", 1)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusOK)
+	}
+
+	if names := searchResultNames(response); !reflect.DeepEqual(names, []string{"Prepare listing bundle"}) {
+		t.Fatalf("Expected only the bundle task, got %v", names)
+	}
+
+	highlights := response.Results[0].Highlights
+	if len(highlights["name"]) != 1 || !strings.Contains(highlights["name"][0], "<mark>bundle</mark>") {
+		t.Errorf("Expected the name to be highlighted, got %v", highlights["name"])
+	}
+	if len(highlights["description"]) != 1 || !strings.Contains(highlights["description"][0], "<mark>bundle</mark>") {
+		t.Errorf("Expected the description to be highlighted, got %v", highlights["description"])
+	}
+}
+
+func TestSearchTasksPhraseAndExclusion(t *testing.T) {
+	addSearchTestTask(t, "Prepare listing bundle", "Collate the bundle for the family hearing")
+	addSearchTestTask(t, "Chase payment", "Chase the applicant for the civil hearing fee")
+
+	_, response := performSearch(t, `"civil hearing"`, 1)
+	if names := searchResultNames(response); !reflect.DeepEqual(names, []string{"Chase payment"}) {
+		t.Errorf("Expected only the civil hearing task, got %v", names)
+	}
+
+	_, response = performSearch(t, "hearing -family", 1)
+	if names := searchResultNames(response); !reflect.DeepEqual(names, []string{"Chase payment"}) {
+		t.Errorf("Expected the family hearing task to be excluded, got %v", names)
+	}
+}
+
+func TestSearchTasksShortTermsFallback(t *testing.T) {
+	addSearchTestTask(t, "File form C2", "Submit the C2 application")
+
+	_, response := performSearch(t, "c2", 1)
+	if names := searchResultNames(response); !reflect.DeepEqual(names, []string{"File form C2"}) {
+		t.Errorf("Expected the C2 task from the in-process search, got %v", names)
+	}
+}
+
+func TestSearchTasksOnlyReturnsOwnTasks(t *testing.T) {
+	_, response := performSearch(t, `"Task 3"`, 1)
+	for _, result := range response.Results {
+		if !canReassign(result.Task, 1) {
+			t.Errorf("Search returned task %d that is neither created by nor assigned to user 1", result.Task.ID)
+		}
+	}
+}
+
+func TestSearchTasksInvalidQuery(t *testing.T) {
+	for _, q := range []string{"", "   ", "-excluded", `""`} {
+		rr, _ := performSearch(t, q, 1)
+		if rr.Code != http.StatusBadRequest {
+			t.Errorf("%q: handler returned wrong status code: got %v want %v", q, rr.Code, http.StatusBadRequest)
+		}
+	}
+}
+
+func TestParseSearchQuery(t *testing.T) {
+	query, err := parseSearchQuery(`Bundle "Family  Hearing" -civil -"fee paid" +court*`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := searchQuery{
+		Terms:    []string{"bundle", "court"},
+		Phrases:  []string{"family hearing"},
+		Excluded: []string{"civil", "fee paid"},
+	}
+	if !reflect.DeepEqual(query, expected) {
+		t.Errorf("Expected %+v, got %+v", expected, query)
+	}
+
+	if mode := query.booleanMode(); mode != `+bundle* +court* +"family hearing" -"civil" -"fee paid"` {
+		t.Errorf("Unexpected boolean mode query: %s", mode)
+	}
+}
+
+func TestHighlightTextEscapesHTML(t *testing.T) {
+	query, err := parseSearchQuery("order")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fragments := highlightText("<b>Court</b> order & notice", query, true)
+	expected := []string{"&lt;b&gt;Court&lt;/b&gt; <mark>order</mark> &amp; notice"}
+	if !reflect.DeepEqual(fragments, expected) {
+		t.Errorf("Expected %v, got %v", expected, fragments)
+	}
+}
@@ -1,19 +1,28 @@
 package api
 
 import (
+	"HMCTS-Developer-Challenge/audit"
 	"HMCTS-Developer-Challenge/database"
 	"HMCTS-Developer-Challenge/errors"
 	"HMCTS-Developer-Challenge/session"
 	"bytes"
 	"crypto/rand"
+	"database/sql"
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
 	"golang.org/x/crypto/argon2"
 	"net/http"
+	"strconv"
 )
 
 var errUserExists = errors.Error("user already exists")
+var errSignUpForbidden = errors.Error("Signing Up Requires An Invitation")
+
+type execQueryer interface {
+	rowQueryer
+	Exec(query string, args ...any) (sql.Result, error)
+}
 
 type PasswordConfig struct {
 	time    uint32
@@ -29,7 +38,10 @@ var config = PasswordConfig{
 	keyLen:  32,
 }
 
-// This should probably require system admin credentials in production
+// SignUpHandler creates an account. Users allowed to manage users create
+// accounts for other people with any role. Anyone else needs an invitation,
+// which decides their role and team, unless open signup is configured, which
+// creates caseworkers. People signing up themselves are logged in.
 func SignUpHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
@@ -39,16 +51,44 @@ func SignUpHandler(w http.ResponseWriter, r *http.Request) {
 	var jsonData struct {
 		Username string `json:"username"`
 		Password string `json:"password"`
+		Role     string `json:"role"`
+		// Invite is the token of an invitation from an admin.
+		Invite string `json:"invite"`
 	}
 	if err := json.NewDecoder(r.Body).Decode(&jsonData); err != nil {
 		http.Error(w, "Invalid JSON", http.StatusBadRequest)
 		return
 	}
 
-	if err := createUser(jsonData.Username, jsonData.Password); err == errUserExists || err == errEmptyUsernameOrPassword {
+	current, err := session.GetUserFromSession(w, r)
+	isAdmin := err == nil && HasPermission(current.Role, PermissionManageUsers)
+	if !isAdmin && jsonData.Invite == "" && !openSignup {
+		forbidden(w, r, 0, errSignUpForbidden)
+		return
+	}
+
+	var created user
+	var method string
+	if isAdmin {
+		method = "admin"
+		created.Role, err = normalizeRole(jsonData.Role)
+		if err == nil {
+			created.ID, err = createUser(jsonData.Username, jsonData.Password, created.Role)
+		}
+	} else if jsonData.Invite != "" {
+		method = "invite"
+		created, err = redeemInvite(jsonData.Invite, jsonData.Username, jsonData.Password)
+	} else {
+		method = "open"
+		created.Role = defaultRole
+		created.ID, err = createUser(jsonData.Username, jsonData.Password, created.Role)
+	}
+	created.Name = jsonData.Username
+
+	if err == errUserExists || err == errEmptyUsernameOrPassword || err == errInvalidRole || err == errInvalidInvite {
 		var buf bytes.Buffer
 		if err := json.NewEncoder(&buf).Encode(map[string]string{"message": err.Error()}); err != nil {
-			errors.HandleServerError(w, err, "login.go: HandleLogin - Encode")
+			errors.HandleServerError(w, err, "signup.go: HandleSignUp - Encode")
 			return
 		}
 		w.Header().Set("Content-Type", "application/json")
@@ -60,42 +100,69 @@ func SignUpHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	dbHandle, err := database.GetDBHandle()
-	if err != nil {
-		errors.HandleServerError(w, err, "signup.go: HandleSignUp - GetDBHandle")
-		return
+	details := map[string]string{"method": method, "role": created.Role}
+	if isAdmin {
+		details["created_by"] = strconv.FormatUint(uint64(current.UserID), 10)
 	}
-
-	var userID uint
-	if err := dbHandle.QueryRow("SELECT id FROM users WHERE name = ?", jsonData.Username).Scan(&userID); err != nil {
-		errors.HandleServerError(w, err, "signup.go: HandleSignUp - GetUserID")
-		return
+	audit.Log(audit.Entry{
+		Event:    audit.EventSignup,
+		UserID:   audit.UserID(created.ID),
+		Username: created.Name,
+		SourceIP: audit.SourceIP(r),
+		Details:  details,
+	})
+
+	// Admins stay logged in as themselves
+	if !isAdmin {
+		session.CreateUserSessionCookie(w, created.ID, created.Role)
 	}
-	session.CreateUserSessionCookie(w, userID)
+	writeJSON(w, http.StatusCreated, created)
+}
 
-	w.WriteHeader(http.StatusOK)
+func createUser(username, password, role string) (uint, error) {
+	dbHandle, err := database.GetDBHandle()
+	if err != nil {
+		return 0, errors.AddContext(err, "signup.go: createUser - GetDBHandle")
+	}
+	return insertUser(dbHandle, username, password, role)
 }
 
-func createUser(username, password string) error {
+// insertUser creates a user through a database handle or a transaction.
+func insertUser(q execQueryer, username, password, role string) (uint, error) {
 	if username == "" || password == "" {
-		return errEmptyUsernameOrPassword
+		return 0, errEmptyUsernameOrPassword
 	}
 
-	exists, err := checkUserExists(username)
+	exists, err := checkUserExists(q, username)
 	if err != nil {
-		return errors.AddContext(err, "signup.go: createUser - checkUserExists")
+		return 0, errors.AddContext(err, "signup.go: insertUser - checkUserExists")
 	} else if exists {
-		return errUserExists
+		return 0, errUserExists
 	}
 
-	dbHandle, err := database.GetDBHandle()
+	passwordHash, err := hashPassword(password)
+	if err != nil {
+		return 0, errors.AddContext(err, "signup.go: insertUser - hashPassword")
+	}
+
+	result, err := q.Exec("INSERT INTO users (name, password_hash, role) VALUES (?, ?, ?)", username, passwordHash, role)
+	if err != nil {
+		return 0, errors.AddContext(err, "signup.go: insertUser - Exec")
+	}
+
+	userID, err := result.LastInsertId()
 	if err != nil {
-		return errors.AddContext(err, "signup.go: createUser - GetDBHandle")
+		return 0, errors.AddContext(err, "signup.go: insertUser - LastInsertId")
 	}
+	return uint(userID), nil
+}
 
+// hashPassword hashes a password with Argon2id and a random salt, encoding
+// the parameters with the hash so they can be changed later.
+func hashPassword(password string) (string, error) {
 	salt := make([]byte, 16)
 	if _, err := rand.Read(salt); err != nil {
-		return errors.AddContext(err, "signup.go: createUser - rand.Read")
+		return "", errors.AddContext(err, "signup.go: hashPassword - rand.Read")
 	}
 
 	// Hash the password
@@ -105,29 +172,19 @@ func createUser(username, password string) error {
 	b64Hash := base64.StdEncoding.EncodeToString(hash)
 	b64Salt := base64.StdEncoding.EncodeToString(salt)
 
-	_, err = dbHandle.Exec(
-		"INSERT INTO users (name, password_hash) VALUES (?, ?)",
-		username,
-		fmt.Sprintf(
-			"$argon2id$v=19$m=%d,t=%d,p=%d$%s$%s",
-			config.memory,
-			config.time,
-			config.threads,
-			b64Salt,
-			b64Hash,
-		),
-	)
-	return err
+	return fmt.Sprintf(
+		"$argon2id$v=19$m=%d,t=%d,p=%d$%s$%s",
+		config.memory,
+		config.time,
+		config.threads,
+		b64Salt,
+		b64Hash,
+	), nil
 }
 
-func checkUserExists(username string) (bool, error) {
-	dbHandle, err := database.GetDBHandle()
-	if err != nil {
-		return false, errors.AddContext(err, "signup.go: checkUserExists - GetDBHandle")
-	}
-
+func checkUserExists(q rowQueryer, username string) (bool, error) {
 	var count int
-	if err := dbHandle.QueryRow("SELECT COUNT(1) FROM users WHERE name = ?", username).Scan(&count); err != nil {
+	if err := q.QueryRow("SELECT COUNT(1) FROM users WHERE name = ?", username).Scan(&count); err != nil {
 		return false, errors.AddContext(err, "signup.go: checkUserExists - QueryRow")
 	}
 	return count > 0, nil
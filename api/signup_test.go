@@ -2,6 +2,7 @@ package api
 
 import (
 	"HMCTS-Developer-Challenge/database"
+	"HMCTS-Developer-Challenge/session"
 	"bytes"
 	"encoding/json"
 	"net/http"
@@ -9,14 +10,29 @@ import (
 	"testing"
 )
 
+// sessionCookie logs a user in with the given role and returns their cookie.
+func sessionCookie(t *testing.T, userID uint, role string) *http.Cookie {
+	rr := httptest.NewRecorder()
+	session.CreateUserSessionCookie(rr, userID, role)
+	for _, cookie := range rr.Result().Cookies() {
+		if cookie.Name == "session_id" {
+			return cookie
+		}
+	}
+	t.Fatal("No session cookie created")
+	return nil
+}
+
 func TestSignUpHandlerSuccess(t *testing.T) {
 	// Create valid signup data
 	signupData := struct {
 		Username string `json:"username"`
 		Password string `json:"password"`
+		Role     string `json:"role"`
 	}{
 		Username: "newtestuser",
 		Password: "password123",
+		Role:     "auditor",
 	}
 
 	signupJSON, err := json.Marshal(signupData)
@@ -30,6 +46,7 @@ func TestSignUpHandlerSuccess(t *testing.T) {
 		t.Fatal(err)
 	}
 	req.Header.Set("Content-Type", "application/json")
+	req.AddCookie(sessionCookie(t, 3, RoleAdmin))
 
 	// Create a response recorder
 	rr := httptest.NewRecorder()
@@ -38,26 +55,26 @@ func TestSignUpHandlerSuccess(t *testing.T) {
 	handler := http.HandlerFunc(SignUpHandler)
 	handler.ServeHTTP(rr, req)
 
-	// Check that the status code is OK
-	if rr.Code != http.StatusOK {
-		t.Errorf("handler returned wrong status code: got %v want %v, %s", rr.Code, http.StatusOK, rr.Body.String())
+	// Check that the status code is Created
+	if rr.Code != http.StatusCreated {
+		t.Errorf("handler returned wrong status code: got %v want %v, %s", rr.Code, http.StatusCreated, rr.Body.String())
 	}
 
-	// Verify a session cookie was created
-	cookies := rr.Result().Cookies()
-	var sessionCookie *http.Cookie
+	var created user
+	if err := json.Unmarshal(rr.Body.Bytes(), &created); err != nil {
+		t.Fatalf("Failed to parse response as JSON: %v", err)
+	}
+	if created.ID == 0 || created.Name != signupData.Username || created.Role != RoleAuditor {
+		t.Errorf("Expected a new auditor called %s, got %+v", signupData.Username, created)
+	}
 
-	for _, cookie := range cookies {
+	// The admin stays logged in as themselves
+	for _, cookie := range rr.Result().Cookies() {
 		if cookie.Name == "session_id" {
-			sessionCookie = cookie
-			break
+			t.Error("Expected no session cookie for the new user")
 		}
 	}
 
-	if sessionCookie == nil {
-		t.Error("No session cookie found after successful signup")
-	}
-
 	// Try to log in with the new credentials to verify account creation
 	loginData := struct {
 		Username string `json:"username"`
@@ -92,15 +109,15 @@ func TestSignUpHandlerSuccess(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	cookies = rr.Result().Cookies()
-	for _, cookie := range cookies {
+	var sessionCookie *http.Cookie
+	for _, cookie := range rr.Result().Cookies() {
 		if cookie.Name == "session_id" {
 			sessionCookie = cookie
 			break
 		}
 	}
 	if sessionCookie == nil {
-		t.Error("No session cookie found after successful signup")
+		t.Error("No session cookie found after logging in")
 	}
 	logoutReq.AddCookie(sessionCookie)
 
@@ -142,6 +159,7 @@ func TestSignUpHandlerDuplicateUser(t *testing.T) {
 		t.Fatal(err)
 	}
 	req.Header.Set("Content-Type", "application/json")
+	req.AddCookie(sessionCookie(t, 3, RoleAdmin))
 
 	// Create a response recorder
 	rr := httptest.NewRecorder()
@@ -199,6 +217,7 @@ func TestSignUpHandlerEmptyCredentials(t *testing.T) {
 				t.Fatal(err)
 			}
 			req.Header.Set("Content-Type", "application/json")
+			req.AddCookie(sessionCookie(t, 3, RoleAdmin))
 
 			rr := httptest.NewRecorder()
 			handler := http.HandlerFunc(SignUpHandler)
@@ -231,6 +250,7 @@ func TestSignUpHandlerInvalidJSON(t *testing.T) {
 		t.Fatal(err)
 	}
 	req.Header.Set("Content-Type", "application/json")
+	req.AddCookie(sessionCookie(t, 3, RoleAdmin))
 
 	// Create a response recorder
 	rr := httptest.NewRecorder()
@@ -267,3 +287,79 @@ func TestSignUpHandlerWrongMethod(t *testing.T) {
 		})
 	}
 }
+
+func TestSignUpHandlerRequiresInvitation(t *testing.T) {
+	testCases := []struct {
+		name   string
+		cookie *http.Cookie
+	}{
+		{"Anonymous", nil},
+		{"Caseworker", sessionCookie(t, 1, RoleCaseworker)},
+		{"Lead", sessionCookie(t, 1, RoleLead)},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req, err := http.NewRequest("POST", "/api/signup", bytes.NewBufferString(`{"username": "sneakyuser", "password": "password123", "role": "admin"}`))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tc.cookie != nil {
+				req.AddCookie(tc.cookie)
+			}
+
+			rr := httptest.NewRecorder()
+			handler := http.HandlerFunc(SignUpHandler)
+			handler.ServeHTTP(rr, req)
+
+			if status := rr.Code; status != http.StatusForbidden {
+				t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusForbidden)
+			}
+		})
+	}
+}
+
+func TestSignUpHandlerInvalidRole(t *testing.T) {
+	req, err := http.NewRequest("POST", "/api/signup", bytes.NewBufferString(`{"username": "rolelessuser", "password": "password123", "role": "superuser"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.AddCookie(sessionCookie(t, 3, RoleAdmin))
+
+	rr := httptest.NewRecorder()
+	handler := http.HandlerFunc(SignUpHandler)
+	handler.ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusBadRequest {
+		t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusBadRequest)
+	}
+}
+
+func TestHasPermission(t *testing.T) {
+	if !HasPermission(RoleAdmin, PermissionManageUsers) || HasPermission(RoleLead, PermissionManageUsers) {
+		t.Error("Expected only admins to manage users")
+	}
+	if !HasPermission(RoleLead, PermissionManageTeams) || HasPermission(RoleCaseworker, PermissionManageTeams) {
+		t.Error("Expected leads but not caseworkers to manage teams")
+	}
+	if !HasPermission(RoleAuditor, PermissionViewTasks) || HasPermission(RoleAuditor, PermissionEditTasks) {
+		t.Error("Expected auditors to view but not edit tasks")
+	}
+	if !HasPermission(RoleAuditor, PermissionViewAuditLog) || HasPermission(RoleLead, PermissionViewAuditLog) {
+		t.Error("Expected auditors but not leads to view the audit log")
+	}
+	if HasPermission("", PermissionViewTasks) {
+		t.Error("Expected an unknown role to grant nothing")
+	}
+}
+
+func TestNormalizeRole(t *testing.T) {
+	for input, expected := range map[string]string{"": RoleCaseworker, "lead": RoleLead, " Auditor ": RoleAuditor} {
+		if role, err := normalizeRole(input); err != nil || role != expected {
+			t.Errorf("%q: expected %q, got %q (%v)", input, expected, role, err)
+		}
+	}
+	if _, err := normalizeRole("superuser"); err != errInvalidRole {
+		t.Errorf("Expected errInvalidRole, got %v", err)
+	}
+}
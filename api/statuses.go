@@ -0,0 +1,207 @@
+package api
+
+import (
+	"HMCTS-Developer-Challenge/errors"
+	"encoding/json"
+	"net/http"
+	"os"
+	"slices"
+)
+
+type taskStatus struct {
+	Name        string   `json:"name"`
+	Label       string   `json:"label"`
+	Initial     bool     `json:"initial"`
+	Terminal    bool     `json:"terminal"`
+	Transitions []string `json:"transitions"`
+}
+
+// taskWorkflow is the state machine every status change must follow. A task
+// may only be created in an initial status and only moved along the listed
+// transitions; keeping the same status is always allowed.
+type taskWorkflow struct {
+	Statuses []taskStatus `json:"statuses"`
+}
+
+// statusError is returned when a status is unknown or a transition is not
+// allowed. It carries enough detail for the client to offer valid choices.
+type statusError struct {
+	Message string   `json:"message"`
+	Status  string   `json:"status"`
+	From    string   `json:"from,omitempty"`
+	Allowed []string `json:"allowed"`
+}
+
+func (e *statusError) Error() string {
+	return e.Message
+}
+
+var defaultTaskWorkflow = taskWorkflow{
+	Statuses: []taskStatus{
+		{
+			Name:        "INCOMPLETE",
+			Label:       "Incomplete",
+			Initial:     true,
+			Transitions: []string{"IN_PROGRESS", "BLOCKED", "AWAITING_INFORMATION", "COMPLETE", "CANCELLED"},
+		},
+		{
+			Name:        "IN_PROGRESS",
+			Label:       "In Progress",
+			Initial:     true,
+			Transitions: []string{"INCOMPLETE", "BLOCKED", "AWAITING_INFORMATION", "COMPLETE", "CANCELLED"},
+		},
+		{
+			Name:        "BLOCKED",
+			Label:       "Blocked",
+			Transitions: []string{"INCOMPLETE", "IN_PROGRESS", "CANCELLED"},
+		},
+		{
+			Name:        "AWAITING_INFORMATION",
+			Label:       "Awaiting Information",
+			Transitions: []string{"INCOMPLETE", "IN_PROGRESS", "BLOCKED", "CANCELLED"},
+		},
+		{
+			Name:        "COMPLETE",
+			Label:       "Complete",
+			Terminal:    true,
+			Transitions: []string{"INCOMPLETE"},
+		},
+		{
+			Name:        "CANCELLED",
+			Label:       "Cancelled",
+			Terminal:    true,
+			Transitions: []string{"INCOMPLETE"},
+		},
+	},
+}
+
+var workflow = defaultTaskWorkflow
+
+// LoadTaskWorkflow replaces the default workflow with one read from a JSON
+// file in the same format returned by /api/task-statuses. An empty path keeps
+// the default.
+func LoadTaskWorkflow(path string) error {
+	if path == "" {
+		workflow = defaultTaskWorkflow
+		return nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return errors.AddContext(err, "statuses.go: LoadTaskWorkflow - ReadFile")
+	}
+
+	var w taskWorkflow
+	if err := json.Unmarshal(data, &w); err != nil {
+		return errors.AddContext(err, "statuses.go: LoadTaskWorkflow - Unmarshal")
+	}
+
+	if err := w.validate(); err != nil {
+		return errors.AddContext(err, "statuses.go: LoadTaskWorkflow - validate")
+	}
+
+	workflow = w
+	return nil
+}
+
+func (w taskWorkflow) validate() error {
+	seen := map[string]bool{}
+	hasInitial := false
+	for _, s := range w.Statuses {
+		if s.Name == "" || len(s.Name) > 32 {
+			return errors.Errorf("status names must be between 1 and 32 characters: %q", s.Name)
+		}
+		if seen[s.Name] {
+			return errors.Errorf("duplicate status %q", s.Name)
+		}
+		seen[s.Name] = true
+		hasInitial = hasInitial || s.Initial
+	}
+
+	if !hasInitial {
+		return errors.Error("at least one status must be initial")
+	}
+
+	for _, s := range w.Statuses {
+		for _, t := range s.Transitions {
+			if !seen[t] {
+				return errors.Errorf("status %q has a transition to unknown status %q", s.Name, t)
+			}
+		}
+	}
+	return nil
+}
+
+func (w taskWorkflow) status(name string) (taskStatus, bool) {
+	for _, s := range w.Statuses {
+		if s.Name == name {
+			return s, true
+		}
+	}
+	return taskStatus{}, false
+}
+
+func (w taskWorkflow) names() []string {
+	names := make([]string, len(w.Statuses))
+	for i, s := range w.Statuses {
+		names[i] = s.Name
+	}
+	return names
+}
+
+func (w taskWorkflow) initial() []string {
+	var names []string
+	for _, s := range w.Statuses {
+		if s.Initial {
+			names = append(names, s.Name)
+		}
+	}
+	return names
+}
+
+// checkInitial validates the status of a task that is being created.
+func (w taskWorkflow) checkInitial(name string) error {
+	if _, ok := w.status(name); !ok {
+		return &statusError{Message: "Invalid Task Status", Status: name, Allowed: w.names()}
+	}
+	if initial := w.initial(); !slices.Contains(initial, name) {
+		return &statusError{Message: "Tasks cannot be created with this status", Status: name, Allowed: initial}
+	}
+	return nil
+}
+
+// checkTransition validates moving a task from one status to another.
+func (w taskWorkflow) checkTransition(from, to string) error {
+	if _, ok := w.status(to); !ok {
+		return &statusError{Message: "Invalid Task Status", Status: to, Allowed: w.names()}
+	}
+	if from == to {
+		return nil
+	}
+
+	// A task whose status has been removed from the workflow may move to
+	// any status so that it is not stranded.
+	current, ok := w.status(from)
+	if ok && !slices.Contains(current.Transitions, to) {
+		return &statusError{Message: "Invalid Status Transition", Status: to, From: from, Allowed: current.Transitions}
+	}
+	return nil
+}
+
+func isValidTaskStatus(status string) bool {
+	_, ok := workflow.status(status)
+	return ok
+}
+
+func TaskStatusesHandler(w http.ResponseWriter, r *http.Request, _ uint) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, workflow)
+}
+
+func writeStatusError(w http.ResponseWriter, statusErr *statusError) {
+	writeJSON(w, http.StatusUnprocessableEntity, statusErr)
+}
@@ -0,0 +1,164 @@
+package api
+
+import (
+	"HMCTS-Developer-Challenge/database"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestTaskStatusesHandler(t *testing.T) {
+	req, err := http.NewRequest("GET", "/api/task-statuses", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr := httptest.NewRecorder()
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		TaskStatusesHandler(w, r, 1)
+	})
+	handler.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusOK)
+	}
+
+	var response taskWorkflow
+	if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil {
+		t.Fatalf("Failed to parse response as JSON: %v", err)
+	}
+
+	expected := []string{"INCOMPLETE", "IN_PROGRESS", "BLOCKED", "AWAITING_INFORMATION", "COMPLETE", "CANCELLED"}
+	if names := response.names(); !reflect.DeepEqual(names, expected) {
+		t.Errorf("Expected statuses %v, got %v", expected, names)
+	}
+}
+
+func TestAddTaskStatusValidation(t *testing.T) {
+	cases := []struct {
+		status   string
+		expected int
+	}{
+		{"IN_PROGRESS", http.StatusCreated},
+		{"BLOCKED", http.StatusUnprocessableEntity},
+		{"NOT_A_STATUS", http.StatusUnprocessableEntity},
+	}
+
+	for _, c := range cases {
+		name := "Task created as " + c.status
+		body, err := json.Marshal(jsonData{Name: name, Status: c.status, Deadline: "2025-12-31 00:00:00"})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		rr := performTaskRequest(t, "POST", "/api/tasks/", body, nil, 1)
+		if rr.Code != c.expected {
+			t.Errorf("%s: handler returned wrong status code: got %v want %v", c.status, rr.Code, c.expected)
+		}
+
+		db, err := database.GetDBHandle()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := db.Exec("DELETE FROM tasks WHERE name = ?", name); err != nil {
+			t.Fatalf("Failed to delete test task: %v", err)
+		}
+	}
+}
+
+func TestEditTaskStatusTransition(t *testing.T) {
+	taskID := createTestTask(t, 1, "Task moving through the workflow")
+
+	rr := performTaskRequest(t, "PATCH", "/api/tasks/"+taskID, []byte(`{"status": "COMPLETE"}`), map[string]string{"If-Match": "*"}, 1)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusOK)
+	}
+
+	body, err := json.Marshal(jsonData{Name: "Task moving through the workflow", Status: "BLOCKED", Deadline: "2025-12-31 00:00:00"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr = performTaskRequest(t, "PUT", "/api/tasks/"+taskID, body, map[string]string{"If-Match": "*"}, 1)
+	if rr.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusUnprocessableEntity)
+	}
+
+	var response statusError
+	if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil {
+		t.Fatalf("Failed to parse response as JSON: %v", err)
+	}
+
+	expected := statusError{
+		Message: "Invalid Status Transition",
+		Status:  "BLOCKED",
+		From:    "COMPLETE",
+		Allowed: []string{"INCOMPLETE"},
+	}
+	if !reflect.DeepEqual(response, expected) {
+		t.Errorf("Expected %+v, got %+v", expected, response)
+	}
+}
+
+func TestTaskWorkflowTransitions(t *testing.T) {
+	cases := []struct {
+		from, to string
+		allowed  bool
+	}{
+		{"INCOMPLETE", "IN_PROGRESS", true},
+		{"IN_PROGRESS", "BLOCKED", true},
+		{"BLOCKED", "COMPLETE", false},
+		{"COMPLETE", "COMPLETE", true},
+		{"COMPLETE", "INCOMPLETE", true},
+		{"CANCELLED", "IN_PROGRESS", false},
+		{"INCOMPLETE", "DONE", false},
+		{"RETIRED", "INCOMPLETE", true},
+	}
+
+	for _, c := range cases {
+		err := defaultTaskWorkflow.checkTransition(c.from, c.to)
+		if (err == nil) != c.allowed {
+			t.Errorf("%s -> %s: expected allowed %v, got error %v", c.from, c.to, c.allowed, err)
+		}
+	}
+}
+
+func TestLoadTaskWorkflow(t *testing.T) {
+	defer LoadTaskWorkflow("")
+
+	dir := t.TempDir()
+	write := func(name, content string) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+
+	valid := write("valid.json", `{"statuses": [
+		{"name": "OPEN", "label": "Open", "initial": true, "transitions": ["DONE"]},
+		{"name": "DONE", "label": "Done", "terminal": true, "transitions": []}
+	]}`)
+	if err := LoadTaskWorkflow(valid); err != nil {
+		t.Fatalf("Expected workflow to load, got %v", err)
+	}
+	if !isValidTaskStatus("OPEN") || isValidTaskStatus("INCOMPLETE") {
+		t.Errorf("Expected the loaded workflow to replace the default, got %v", workflow.names())
+	}
+
+	invalid := map[string]string{
+		"unknown.json":   `{"statuses": [{"name": "OPEN", "initial": true, "transitions": ["MISSING"]}]}`,
+		"duplicate.json": `{"statuses": [{"name": "OPEN", "initial": true}, {"name": "OPEN"}]}`,
+		"initial.json":   `{"statuses": [{"name": "OPEN"}]}`,
+		"syntax.json":    `{"statuses": [`,
+	}
+	for name, content := range invalid {
+		if err := LoadTaskWorkflow(write(name, content)); err == nil {
+			t.Errorf("%s: expected an error loading an invalid workflow", name)
+		}
+	}
+}
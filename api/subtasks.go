@@ -0,0 +1,423 @@
+package api
+
+import (
+	"HMCTS-Developer-Challenge/audit"
+	"HMCTS-Developer-Challenge/database"
+	"HMCTS-Developer-Challenge/errors"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// defaultMaxTaskDepth allows a task, its subtasks and their subtasks.
+const defaultMaxTaskDepth = 3
+
+var maxTaskDepth = defaultMaxTaskDepth
+
+var errMaxTaskDepth = errors.Error("Maximum Subtask Depth Exceeded")
+var errOpenSubtasks = errors.Error("Task Has Open Subtasks")
+
+type progressCount struct {
+	Done  int `json:"done"`
+	Total int `json:"total"`
+}
+
+type taskProgress struct {
+	Subtasks  progressCount `json:"subtasks"`
+	Checklist progressCount `json:"checklist"`
+}
+
+// taskEditOptions are the parts of a request that change how an edit is
+// applied or recorded rather than what is written.
+type taskEditOptions struct {
+	// Cascade moves the open subtasks of a task to the same terminal status
+	// instead of rejecting the change.
+	Cascade bool
+	// SourceIP is the address the change came from, kept in the task
+	// history.
+	SourceIP string
+	// Restore is set when the edit takes a task back to an earlier version.
+	Restore *taskVersion
+	// Undelete is set when tasks are taken out of the trash.
+	Undelete bool
+	// Tx is the transaction of a bulk operation the edit is part of, which
+	// is left to the bulk operation to commit or roll back.
+	Tx *sql.Tx
+}
+
+// taskEditTx is the transaction an edit runs in. Committing and rolling back
+// do nothing when the edit joined the transaction of a bulk operation.
+type taskEditTx struct {
+	*sql.Tx
+	joined bool
+}
+
+// beginTaskEdit starts the transaction of an edit, or joins the one given in
+// options.
+func beginTaskEdit(dbHandle *sql.DB, options taskEditOptions) (taskEditTx, error) {
+	if options.Tx != nil {
+		return taskEditTx{Tx: options.Tx, joined: true}, nil
+	}
+	tx, err := dbHandle.Begin()
+	return taskEditTx{Tx: tx}, err
+}
+
+func (tx taskEditTx) Commit() error {
+	if tx.joined {
+		return nil
+	}
+	return tx.Tx.Commit()
+}
+
+func (tx taskEditTx) Rollback() error {
+	if tx.joined {
+		return nil
+	}
+	return tx.Tx.Rollback()
+}
+
+func parseTaskEditOptions(r *http.Request) taskEditOptions {
+	cascade, _ := strconv.ParseBool(r.URL.Query().Get("cascade"))
+	return taskEditOptions{Cascade: cascade, SourceIP: audit.SourceIP(r)}
+}
+
+// SetMaxTaskDepth sets how deeply subtasks may be nested, counting top level
+// tasks as depth 1. An empty value keeps the default.
+func SetMaxTaskDepth(value string) error {
+	if value == "" {
+		maxTaskDepth = defaultMaxTaskDepth
+		return nil
+	}
+
+	depth, err := strconv.Atoi(value)
+	if err != nil || depth < 1 {
+		return errors.Errorf("subtasks.go: SetMaxTaskDepth - invalid depth %q", value)
+	}
+	maxTaskDepth = depth
+	return nil
+}
+
+func subtasksHandler(w http.ResponseWriter, r *http.Request, userID uint, taskID string) {
+	switch r.Method {
+	case http.MethodGet:
+		subtasks, err := getSubtasks(userID, taskID)
+		if err == errTaskNotFound {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			break
+		} else if err != nil {
+			errors.HandleServerError(w, err, "subtasks.go: subtasksHandler - getSubtasks")
+			break
+		}
+
+		writeJSON(w, http.StatusOK, subtasks)
+	case http.MethodPost:
+		var data jsonData
+		if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+			http.Error(w, "Invalid JSON", http.StatusBadRequest)
+			break
+		}
+
+		t, err := addSubtask(userID, taskID, data, parseTaskEditOptions(r))
+		if err == errTaskNotFound {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			break
+		} else if err == errMissingJsonData || err == errInvalidTaskPriority || err == errInvalidTagName || err == errMaxTaskDepth || err == errInvalidRecurrenceRule || err == errTeamNotFound || err == errCaseNotFound || err == errHearingNotFound || err == errInvalidDeadlineRule || err == errHearingCaseMismatch || err == errInvalidWorkingDays || err == errInvalidDeadline {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			break
+		} else if statusErr, ok := err.(*statusError); ok {
+			writeStatusError(w, statusErr)
+			break
+		} else if err != nil {
+			errors.HandleServerError(w, err, "subtasks.go: subtasksHandler - addSubtask")
+			break
+		}
+
+		w.Header().Set("ETag", taskETag(t))
+		writeJSON(w, http.StatusCreated, t)
+	case http.MethodOptions:
+		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func getSubtasks(userID uint, taskID string) ([]task, error) {
+	subtasks := []task{}
+
+	parent, err := getTask(userID, taskID)
+	if err != nil {
+		return subtasks, err
+	}
+
+	dbHandle, err := database.GetDBHandle()
+	if err != nil {
+		return subtasks, errors.AddContext(err, "subtasks.go: getSubtasks - GetDBHandle")
+	}
+
+	access, accessArgs := taskAccess(userID)
+	rows, err := dbHandle.Query("SELECT "+taskColumns+" FROM tasks WHERE parent_id = ? AND "+access+" ORDER BY id", append([]any{parent.ID}, accessArgs...)...)
+	if err != nil {
+		return subtasks, errors.AddContext(err, "subtasks.go: getSubtasks - Query")
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		t, err := scanTask(rows)
+		if err != nil {
+			return subtasks, errors.AddContext(err, "subtasks.go: getSubtasks - Scan")
+		}
+		subtasks = append(subtasks, t)
+	}
+	if err := rows.Err(); err != nil {
+		return subtasks, errors.AddContext(err, "subtasks.go: getSubtasks - Rows")
+	}
+
+	tasks := make([]*task, len(subtasks))
+	for i := range subtasks {
+		tasks[i] = &subtasks[i]
+	}
+	if err := loadTaskDetails(userID, tasks...); err != nil {
+		return subtasks, errors.AddContext(err, "subtasks.go: getSubtasks - loadTaskDetails")
+	}
+	return subtasks, nil
+}
+
+func addSubtask(userID uint, parentID string, data jsonData, options taskEditOptions) (task, error) {
+	parent, err := getEditedTask(userID, parentID, options)
+	if err != nil {
+		return parent, err
+	}
+
+	dbHandle, err := database.GetDBHandle()
+	if err != nil {
+		return task{}, errors.AddContext(err, "subtasks.go: addSubtask - GetDBHandle")
+	}
+
+	var q rowQueryer = dbHandle
+	if options.Tx != nil {
+		q = options.Tx
+	}
+	depth, err := taskDepth(q, parent.ID)
+	if err != nil {
+		return task{}, errors.AddContext(err, "subtasks.go: addSubtask - taskDepth")
+	}
+	if depth+1 > maxTaskDepth {
+		return task{}, errMaxTaskDepth
+	}
+
+	if data.CaseID == nil {
+		data.CaseID = parent.CaseID
+	}
+
+	id, err := createTask(userID, &parent.ID, data, options)
+	if err != nil {
+		return task{}, err
+	}
+	return getEditedTask(userID, strconv.FormatUint(uint64(id), 10), options)
+}
+
+// taskDepth returns how many levels deep a task is, 1 being a top level task.
+func taskDepth(q rowQueryer, taskID uint) (int, error) {
+	var depth int
+	err := q.QueryRow(
+		"WITH RECURSIVE ancestors (id, parent_id, depth) AS ("+
+			"SELECT id, parent_id, 1 FROM tasks WHERE id = ? "+
+			"UNION ALL SELECT t.id, t.parent_id, a.depth + 1 FROM tasks t JOIN ancestors a ON t.id = a.parent_id"+
+			") SELECT MAX(depth) FROM ancestors",
+		taskID,
+	).Scan(&depth)
+	if err != nil {
+		return 0, errors.AddContext(err, "subtasks.go: taskDepth - QueryRow")
+	}
+	return depth, nil
+}
+
+// closeSubtasks is called when a task changes status. Moving a task to a
+// terminal status while any of its subtasks, at any depth, are still open is
+// rejected unless options.Cascade is set, in which case they are moved along
+// with it, as long as none of them is blocked by an open task, and recurring
+// ones schedule their next occurrence. Subtasks in the trash are left alone.
+func closeSubtasks(tx *sql.Tx, userID uint, current task, status string, options taskEditOptions) error {
+	if s, ok := workflow.status(status); !ok || !s.Terminal {
+		return nil
+	}
+
+	rows, err := tx.Query(
+		"WITH RECURSIVE descendants (id, status) AS ("+
+			"SELECT id, status FROM tasks WHERE parent_id = ? AND deleted_at IS NULL "+
+			"UNION ALL SELECT t.id, t.status FROM tasks t JOIN descendants d ON t.parent_id = d.id WHERE t.deleted_at IS NULL"+
+			") SELECT id, status FROM descendants ORDER BY id",
+		current.ID,
+	)
+	if err != nil {
+		return errors.AddContext(err, "subtasks.go: closeSubtasks - Query")
+	}
+
+	var open []uint
+	for rows.Next() {
+		var id uint
+		var from string
+		if err := rows.Scan(&id, &from); err != nil {
+			rows.Close()
+			return errors.AddContext(err, "subtasks.go: closeSubtasks - Scan")
+		}
+		if s, ok := workflow.status(from); ok && s.Terminal {
+			continue
+		}
+		if !options.Cascade {
+			rows.Close()
+			return errOpenSubtasks
+		}
+
+		if err := workflow.checkTransition(from, status); err != nil {
+			rows.Close()
+			statusErr := err.(*statusError)
+			statusErr.Message = fmt.Sprintf("Subtask %d cannot move to this status", id)
+			return statusErr
+		}
+		open = append(open, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return errors.AddContext(err, "subtasks.go: closeSubtasks - Rows")
+	}
+
+	if len(open) == 0 {
+		return nil
+	}
+
+	for _, id := range open {
+		if err := checkBlockers(tx, id, status); err == errOpenBlockers {
+			return &blockedSubtaskError{SubtaskID: id}
+		} else if err != nil {
+			return err
+		}
+	}
+
+	changes, err := trackTasks(tx, open...)
+	if err != nil {
+		return errors.AddContext(err, "subtasks.go: closeSubtasks - trackTasks")
+	}
+
+	ids := make([]any, len(open))
+	for i, id := range open {
+		ids[i] = id
+	}
+	placeholders := "?" + strings.Repeat(", ?", len(open)-1)
+
+	// Recurring subtasks are read before they move so that each one schedules
+	// its next occurrence as if it had been closed on its own
+	rows, err = tx.Query("SELECT "+taskColumns+" FROM tasks WHERE id IN ("+placeholders+") AND recurrence <> ''", ids...)
+	if err != nil {
+		return errors.AddContext(err, "subtasks.go: closeSubtasks - Query recurring")
+	}
+	var recurring []task
+	for rows.Next() {
+		t, err := scanTask(rows)
+		if err != nil {
+			rows.Close()
+			return errors.AddContext(err, "subtasks.go: closeSubtasks - scanTask")
+		}
+		recurring = append(recurring, t)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return errors.AddContext(err, "subtasks.go: closeSubtasks - Rows recurring")
+	}
+
+	_, err = tx.Exec(
+		"UPDATE tasks SET status = ?, version = version + 1 WHERE id IN ("+placeholders+")",
+		append([]any{status}, ids...)...,
+	)
+	if err != nil {
+		return errors.AddContext(err, "subtasks.go: closeSubtasks - Exec")
+	}
+
+	if err := changes.record(tx, userID, options); err != nil {
+		return errors.AddContext(err, "subtasks.go: closeSubtasks - record")
+	}
+
+	for _, t := range recurring {
+		data := jsonData{Name: t.Name, Description: t.Description, Status: status, Priority: t.Priority, Recurrence: t.Recurrence}
+		if err := scheduleNextOccurrence(tx, userID, t, data, t.Deadline, options); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// loadTaskProgress counts the done and total direct subtasks and checklist
+// items of each task. A subtask is done once it is in a terminal status.
+func loadTaskProgress(tasks ...*task) error {
+	if len(tasks) == 0 {
+		return nil
+	}
+
+	dbHandle, err := database.GetDBHandle()
+	if err != nil {
+		return errors.AddContext(err, "subtasks.go: loadTaskProgress - GetDBHandle")
+	}
+
+	byID := make(map[uint][]*task, len(tasks))
+	args := make([]any, 0, len(tasks))
+	for _, t := range tasks {
+		t.Progress = taskProgress{}
+		byID[t.ID] = append(byID[t.ID], t)
+		args = append(args, t.ID)
+	}
+	in := "(?" + strings.Repeat(", ?", len(args)-1) + ")"
+
+	rows, err := dbHandle.Query("SELECT parent_id, status, COUNT(*) FROM tasks WHERE parent_id IN "+in+" AND deleted_at IS NULL GROUP BY parent_id, status", args...)
+	if err != nil {
+		return errors.AddContext(err, "subtasks.go: loadTaskProgress - Query Subtasks")
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var parentID uint
+		var status string
+		var count int
+		if err := rows.Scan(&parentID, &status, &count); err != nil {
+			return errors.AddContext(err, "subtasks.go: loadTaskProgress - Scan Subtasks")
+		}
+		s, ok := workflow.status(status)
+		for _, t := range byID[parentID] {
+			t.Progress.Subtasks.Total += count
+			if ok && s.Terminal {
+				t.Progress.Subtasks.Done += count
+			}
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return errors.AddContext(err, "subtasks.go: loadTaskProgress - Rows Subtasks")
+	}
+
+	rows, err = dbHandle.Query("SELECT task_id, done, COUNT(*) FROM task_checklist_items WHERE task_id IN "+in+" GROUP BY task_id, done", args...)
+	if err != nil {
+		return errors.AddContext(err, "subtasks.go: loadTaskProgress - Query Checklist")
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var taskID uint
+		var done bool
+		var count int
+		if err := rows.Scan(&taskID, &done, &count); err != nil {
+			return errors.AddContext(err, "subtasks.go: loadTaskProgress - Scan Checklist")
+		}
+		for _, t := range byID[taskID] {
+			t.Progress.Checklist.Total += count
+			if done {
+				t.Progress.Checklist.Done += count
+			}
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return errors.AddContext(err, "subtasks.go: loadTaskProgress - Rows Checklist")
+	}
+	return nil
+}
@@ -0,0 +1,160 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func createTestSubtask(t *testing.T, parentID string, name string) task {
+	body, err := json.Marshal(jsonData{Name: name, Status: "INCOMPLETE", Deadline: "2025-12-31 00:00:00"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr := performTaskRequest(t, "POST", "/api/tasks/"+parentID+"/subtasks", body, nil, 1)
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("handler returned wrong status code creating subtask: got %v want %v", rr.Code, http.StatusCreated)
+	}
+
+	var subtask task
+	if err := json.Unmarshal(rr.Body.Bytes(), &subtask); err != nil {
+		t.Fatalf("Failed to parse response as JSON: %v", err)
+	}
+	return subtask
+}
+
+func TestSetMaxTaskDepth(t *testing.T) {
+	defer SetMaxTaskDepth("")
+
+	if err := SetMaxTaskDepth("5"); err != nil || maxTaskDepth != 5 {
+		t.Errorf("Expected depth 5, got %d (%v)", maxTaskDepth, err)
+	}
+	for _, value := range []string{"0", "-1", "deep"} {
+		if err := SetMaxTaskDepth(value); err == nil {
+			t.Errorf("%q: expected an error", value)
+		}
+	}
+	if err := SetMaxTaskDepth(""); err != nil || maxTaskDepth != defaultMaxTaskDepth {
+		t.Errorf("Expected the default depth, got %d (%v)", maxTaskDepth, err)
+	}
+}
+
+func TestSubtasksAndProgress(t *testing.T) {
+	parentID := createTestTask(t, 1, "Prepare bundle")
+	first := createTestSubtask(t, parentID, "Index documents")
+	createTestSubtask(t, parentID, "Paginate bundle")
+
+	if first.ParentID == nil || strconv.FormatUint(uint64(*first.ParentID), 10) != parentID {
+		t.Errorf("Expected parent_id %s, got %v", parentID, first.ParentID)
+	}
+
+	rr := performTaskRequest(t, "GET", "/api/tasks/"+parentID+"/subtasks", nil, nil, 1)
+	var subtasks []task
+	if err := json.Unmarshal(rr.Body.Bytes(), &subtasks); err != nil {
+		t.Fatalf("Failed to parse response as JSON: %v", err)
+	}
+	if len(subtasks) != 2 {
+		t.Fatalf("Expected 2 subtasks, got %d", len(subtasks))
+	}
+
+	subtaskID := strconv.FormatUint(uint64(first.ID), 10)
+	rr = performTaskRequest(t, "PATCH", "/api/tasks/"+subtaskID, []byte(`{"status": "COMPLETE"}`), map[string]string{"If-Match": "*"}, 1)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusOK)
+	}
+
+	parent, err := getTask(1, parentID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parent.Progress.Subtasks != (progressCount{Done: 1, Total: 2}) {
+		t.Errorf("Expected 1 of 2 subtasks done, got %+v", parent.Progress.Subtasks)
+	}
+	// Creating two subtasks and completing one each changed the parent
+	if parent.Version != 4 {
+		t.Errorf("Expected parent version 4, got %d", parent.Version)
+	}
+
+	_, page := getTaskPage(t, "/api/tasks/?parent="+parentID, 1)
+	if len(page.Tasks) != 2 {
+		t.Errorf("Expected 2 tasks filtered by parent, got %d", len(page.Tasks))
+	}
+}
+
+func TestCompleteParentWithOpenSubtasks(t *testing.T) {
+	parentID := createTestTask(t, 1, "Serve claim")
+	child := createTestSubtask(t, parentID, "Draft claim form")
+	grandchild := createTestSubtask(t, strconv.FormatUint(uint64(child.ID), 10), "Check claimant address")
+
+	rr := performTaskRequest(t, "PATCH", "/api/tasks/"+parentID, []byte(`{"status": "COMPLETE"}`), map[string]string{"If-Match": "*"}, 1)
+	if rr.Code != http.StatusConflict {
+		t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusConflict)
+	}
+
+	rr = performTaskRequest(t, "PATCH", "/api/tasks/"+parentID+"?cascade=true", []byte(`{"status": "COMPLETE"}`), map[string]string{"If-Match": "*"}, 1)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("handler returned wrong status code with cascade: got %v want %v", rr.Code, http.StatusOK)
+	}
+
+	for _, id := range []uint{child.ID, grandchild.ID} {
+		subtask, err := getTask(1, strconv.FormatUint(uint64(id), 10))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if subtask.Status != "COMPLETE" {
+			t.Errorf("Expected subtask %d to be completed by the cascade, got %s", id, subtask.Status)
+		}
+	}
+}
+
+func TestCascadeBlockedSubtask(t *testing.T) {
+	parentID := createTestTask(t, 1, "Prepare trial bundle")
+	child := createTestSubtask(t, parentID, "Paginate bundle")
+	blocker := createTestTask(t, 1, "Receive witness statements")
+	if rr := addTestBlocker(t, strconv.FormatUint(uint64(child.ID), 10), blocker); rr.Code != http.StatusCreated {
+		t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusCreated)
+	}
+
+	rr := performTaskRequest(t, "PATCH", "/api/tasks/"+parentID+"?cascade=true", []byte(`{"status": "COMPLETE"}`), map[string]string{"If-Match": "*"}, 1)
+	if rr.Code != http.StatusConflict {
+		t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusConflict)
+	}
+	if expected := (&blockedSubtaskError{SubtaskID: child.ID}).Error(); !strings.Contains(rr.Body.String(), expected) {
+		t.Errorf("Expected %q, got %q", expected, rr.Body.String())
+	}
+
+	parent, err := getTask(1, parentID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parent.Status != "INCOMPLETE" {
+		t.Errorf("Expected the parent to be left open, got %s", parent.Status)
+	}
+}
+
+func TestSubtaskMaxDepth(t *testing.T) {
+	defer SetMaxTaskDepth("")
+	if err := SetMaxTaskDepth("2"); err != nil {
+		t.Fatal(err)
+	}
+
+	parentID := createTestTask(t, 1, "Task with limited depth")
+	child := createTestSubtask(t, parentID, "Only level of subtasks")
+
+	body, err := json.Marshal(jsonData{Name: "Too deep", Status: "INCOMPLETE", Deadline: "2025-12-31 00:00:00"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr := performTaskRequest(t, "POST", "/api/tasks/"+strconv.FormatUint(uint64(child.ID), 10)+"/subtasks", body, nil, 1)
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusBadRequest)
+	}
+
+	rr = performTaskRequest(t, "POST", "/api/tasks/"+parentID+"/subtasks", body, nil, 2)
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("handler returned wrong status code for another user's task: got %v want %v", rr.Code, http.StatusNotFound)
+	}
+}
@@ -0,0 +1,486 @@
+package api
+
+import (
+	"HMCTS-Developer-Challenge/audit"
+	"HMCTS-Developer-Challenge/database"
+	"HMCTS-Developer-Challenge/errors"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+const (
+	maxTagNameLength = 64
+
+	// MySQL error returned when an insert or update violates a unique key.
+	errDuplicateEntry = 1062
+)
+
+// Tag names are lower case words joined by single hyphens or underscores,
+// e.g. "family" or "awaiting-payment". Commas are never allowed so that tags
+// can be listed in the tasks query string.
+var tagNamePattern = regexp.MustCompile(`^[\p{L}\p{N}]+(?:[-_][\p{L}\p{N}]+)*$`)
+
+type tag struct {
+	ID        uint   `json:"id"`
+	Name      string `json:"name"`
+	TaskCount uint   `json:"task_count"`
+}
+
+type tagData struct {
+	Name string `json:"name"`
+}
+
+type tagMergeData struct {
+	Into uint `json:"into"`
+}
+
+var errTagNotFound = errors.Error("Tag Not Found")
+var errTagExists = errors.Error("Tag Already Exists")
+var errInvalidTagName = errors.Error("Invalid Tag Name")
+var errTagMergeSelf = errors.Error("Cannot Merge A Tag Into Itself")
+
+func TagsHandler(w http.ResponseWriter, r *http.Request, userID uint) {
+	pathParts := strings.Split(strings.TrimSuffix(r.URL.Path, "/"), "/")
+	tagID := ""
+	if len(pathParts) > 3 {
+		tagID = pathParts[3]
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		var tags any
+		var err error
+		if tagID == "" {
+			tags, err = getTags(userID)
+		} else {
+			tags, err = getTag(userID, tagID)
+			if err == errTagNotFound {
+				http.Error(w, err.Error(), http.StatusNotFound)
+				break
+			}
+		}
+
+		if err != nil {
+			errors.HandleServerError(w, err, "tags.go: TagsHandler - getTags")
+			break
+		}
+		writeJSON(w, http.StatusOK, tags)
+	case http.MethodPost:
+		// POST /api/tags/{id}/merge moves every task from one tag to
+		// another and removes the source tag.
+		if tagID != "" {
+			if len(pathParts) != 5 || pathParts[4] != "merge" {
+				http.Error(w, "Not Found", http.StatusNotFound)
+				break
+			}
+
+			var data tagMergeData
+			if err := json.NewDecoder(r.Body).Decode(&data); err != nil || data.Into == 0 {
+				http.Error(w, "Invalid JSON", http.StatusBadRequest)
+				break
+			}
+
+			t, err := mergeTag(userID, tagID, data.Into, taskEditOptions{SourceIP: audit.SourceIP(r)})
+			if err == errTagNotFound {
+				http.Error(w, err.Error(), http.StatusNotFound)
+				break
+			} else if err == errTagMergeSelf {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				break
+			} else if err != nil {
+				errors.HandleServerError(w, err, "tags.go: TagsHandler - mergeTag")
+				break
+			}
+			writeJSON(w, http.StatusOK, t)
+			break
+		}
+
+		var data tagData
+		if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+			http.Error(w, "Invalid JSON", http.StatusBadRequest)
+			break
+		}
+
+		t, err := addTag(userID, data)
+		if err == errInvalidTagName {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			break
+		} else if err == errTagExists {
+			http.Error(w, err.Error(), http.StatusConflict)
+			break
+		} else if err != nil {
+			errors.HandleServerError(w, err, "tags.go: TagsHandler - addTag")
+			break
+		}
+		writeJSON(w, http.StatusCreated, t)
+	case http.MethodPut:
+		if tagID == "" {
+			http.Error(w, "Tag ID Required", http.StatusBadRequest)
+			break
+		}
+
+		var data tagData
+		if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+			http.Error(w, "Invalid JSON", http.StatusBadRequest)
+			break
+		}
+
+		t, err := renameTag(userID, tagID, data, taskEditOptions{SourceIP: audit.SourceIP(r)})
+		if err == errTagNotFound {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			break
+		} else if err == errInvalidTagName {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			break
+		} else if err == errTagExists {
+			http.Error(w, err.Error(), http.StatusConflict)
+			break
+		} else if err != nil {
+			errors.HandleServerError(w, err, "tags.go: TagsHandler - renameTag")
+			break
+		}
+		writeJSON(w, http.StatusOK, t)
+	case http.MethodDelete:
+		if tagID == "" {
+			http.Error(w, "Tag ID Required", http.StatusBadRequest)
+			break
+		}
+
+		if err := deleteTag(userID, tagID, taskEditOptions{SourceIP: audit.SourceIP(r)}); err == errTagNotFound {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			break
+		} else if err != nil {
+			errors.HandleServerError(w, err, "tags.go: TagsHandler - deleteTag")
+			break
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	case http.MethodOptions:
+		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func normalizeTagName(name string) (string, error) {
+	name = strings.ToLower(strings.TrimSpace(name))
+	if len(name) > maxTagNameLength || !tagNamePattern.MatchString(name) {
+		return "", errInvalidTagName
+	}
+	return name, nil
+}
+
+// normalizeTagNames validates a list of tags for a task, dropping duplicates.
+// A nil list stays nil so callers can tell "leave tags alone" apart from
+// "remove every tag".
+func normalizeTagNames(names []string) ([]string, error) {
+	if names == nil {
+		return nil, nil
+	}
+
+	normalized := []string{}
+	for _, name := range names {
+		n, err := normalizeTagName(name)
+		if err != nil {
+			return nil, err
+		}
+		if !slices.Contains(normalized, n) {
+			normalized = append(normalized, n)
+		}
+	}
+	slices.Sort(normalized)
+	return normalized, nil
+}
+
+// tagSelect counts the tasks carrying each tag, leaving out those in the trash.
+const tagSelect = "SELECT tg.id, tg.name, COUNT(t.id) FROM tags tg LEFT JOIN task_tags tt ON tt.tag_id = tg.id " +
+	"LEFT JOIN tasks t ON t.id = tt.task_id AND t.deleted_at IS NULL "
+
+func getTags(userID uint) ([]tag, error) {
+	tags := []tag{}
+
+	dbHandle, err := database.GetDBHandle()
+	if err != nil {
+		return tags, errors.AddContext(err, "tags.go: getTags - GetDBHandle")
+	}
+
+	rows, err := dbHandle.Query(tagSelect+"WHERE tg.user_id = ? GROUP BY tg.id, tg.name ORDER BY tg.name", userID)
+	if err != nil {
+		return tags, errors.AddContext(err, "tags.go: getTags - Query")
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var t tag
+		if err := rows.Scan(&t.ID, &t.Name, &t.TaskCount); err != nil {
+			return tags, errors.AddContext(err, "tags.go: getTags - Scan")
+		}
+		tags = append(tags, t)
+	}
+	if err := rows.Err(); err != nil {
+		return tags, errors.AddContext(err, "tags.go: getTags - Rows")
+	}
+	return tags, nil
+}
+
+func getTag(userID uint, tagID any) (tag, error) {
+	var t tag
+
+	dbHandle, err := database.GetDBHandle()
+	if err != nil {
+		return t, errors.AddContext(err, "tags.go: getTag - GetDBHandle")
+	}
+
+	err = dbHandle.QueryRow(tagSelect+"WHERE tg.id = ? AND tg.user_id = ? GROUP BY tg.id, tg.name", tagID, userID).
+		Scan(&t.ID, &t.Name, &t.TaskCount)
+	if err == sql.ErrNoRows {
+		return t, errTagNotFound
+	} else if err != nil {
+		return t, errors.AddContext(err, "tags.go: getTag - QueryRow")
+	}
+	return t, nil
+}
+
+func addTag(userID uint, data tagData) (tag, error) {
+	name, err := normalizeTagName(data.Name)
+	if err != nil {
+		return tag{}, err
+	}
+
+	dbHandle, err := database.GetDBHandle()
+	if err != nil {
+		return tag{}, errors.AddContext(err, "tags.go: addTag - GetDBHandle")
+	}
+
+	result, err := dbHandle.Exec("INSERT INTO tags (user_id, name) VALUES (?, ?)", userID, name)
+	if isDuplicateEntry(err) {
+		return tag{}, errTagExists
+	} else if err != nil {
+		return tag{}, errors.AddContext(err, "tags.go: addTag - Exec")
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return tag{}, errors.AddContext(err, "tags.go: addTag - LastInsertId")
+	}
+	return tag{ID: uint(id), Name: name}, nil
+}
+
+// renameTag changes the name of a tag on every task that has it at once.
+// Renaming to the name of another tag is refused; use mergeTag instead.
+func renameTag(userID uint, tagID string, data tagData, options taskEditOptions) (tag, error) {
+	name, err := normalizeTagName(data.Name)
+	if err != nil {
+		return tag{}, err
+	}
+
+	err = withTagTx(userID, []any{tagID}, options, func(tx *sql.Tx) error {
+		if _, err := tx.Exec("UPDATE tags SET name = ? WHERE id = ?", name, tagID); isDuplicateEntry(err) {
+			return errTagExists
+		} else if err != nil {
+			return errors.AddContext(err, "tags.go: renameTag - Exec")
+		}
+		return touchTaggedTasks(tx, tagID)
+	})
+	if err != nil {
+		return tag{}, err
+	}
+	return getTag(userID, tagID)
+}
+
+// mergeTag moves every task tagged with the source tag onto the target tag
+// and deletes the source, all in one transaction.
+func mergeTag(userID uint, sourceID string, targetID uint, options taskEditOptions) (tag, error) {
+	if id, err := strconv.ParseUint(sourceID, 10, 32); err == nil && uint(id) == targetID {
+		return tag{}, errTagMergeSelf
+	}
+
+	err := withTagTx(userID, []any{sourceID, targetID}, options, func(tx *sql.Tx) error {
+		if err := touchTaggedTasks(tx, sourceID); err != nil {
+			return err
+		}
+		if _, err := tx.Exec(
+			"INSERT IGNORE INTO task_tags (task_id, tag_id) SELECT task_id, ? FROM task_tags WHERE tag_id = ?",
+			targetID, sourceID,
+		); err != nil {
+			return errors.AddContext(err, "tags.go: mergeTag - Insert")
+		}
+		if _, err := tx.Exec("DELETE FROM tags WHERE id = ?", sourceID); err != nil {
+			return errors.AddContext(err, "tags.go: mergeTag - Delete")
+		}
+		return nil
+	})
+	if err != nil {
+		return tag{}, err
+	}
+	return getTag(userID, targetID)
+}
+
+func deleteTag(userID uint, tagID string, options taskEditOptions) error {
+	return withTagTx(userID, []any{tagID}, options, func(tx *sql.Tx) error {
+		if err := touchTaggedTasks(tx, tagID); err != nil {
+			return err
+		}
+		if _, err := tx.Exec("DELETE FROM tags WHERE id = ?", tagID); err != nil {
+			return errors.AddContext(err, "tags.go: deleteTag - Exec")
+		}
+		return nil
+	})
+}
+
+// withTagTx locks the given tags of a user and runs fn in a transaction,
+// recording the change in the history of the tasks carrying them. If any of
+// the tags does not exist nothing is changed and errTagNotFound is returned.
+func withTagTx(userID uint, tagIDs []any, options taskEditOptions, fn func(*sql.Tx) error) error {
+	dbHandle, err := database.GetDBHandle()
+	if err != nil {
+		return errors.AddContext(err, "tags.go: withTagTx - GetDBHandle")
+	}
+
+	tx, err := dbHandle.Begin()
+	if err != nil {
+		return errors.AddContext(err, "tags.go: withTagTx - Begin")
+	}
+	defer tx.Rollback()
+
+	var count int
+	args := append([]any{userID}, tagIDs...)
+	if err := tx.QueryRow(
+		"SELECT COUNT(*) FROM tags WHERE user_id = ? AND id IN (?"+strings.Repeat(", ?", len(tagIDs)-1)+") FOR UPDATE",
+		args...,
+	).Scan(&count); err != nil {
+		return errors.AddContext(err, "tags.go: withTagTx - QueryRow")
+	}
+	if count != len(tagIDs) {
+		return errTagNotFound
+	}
+
+	changes, err := trackTaskQuery(tx,
+		"SELECT DISTINCT task_id FROM task_tags WHERE tag_id IN (?"+strings.Repeat(", ?", len(tagIDs)-1)+") ORDER BY task_id",
+		tagIDs...,
+	)
+	if err != nil {
+		return errors.AddContext(err, "tags.go: withTagTx - trackTaskQuery")
+	}
+
+	if err := fn(tx); err != nil {
+		return err
+	}
+
+	if err := changes.record(tx, userID, options); err != nil {
+		return errors.AddContext(err, "tags.go: withTagTx - record")
+	}
+
+	if err := tx.Commit(); err != nil {
+		return errors.AddContext(err, "tags.go: withTagTx - Commit")
+	}
+	return nil
+}
+
+// touchTaggedTasks bumps the version of every task carrying a tag, since the
+// tag names are part of each task's representation and ETag.
+func touchTaggedTasks(tx *sql.Tx, tagID any) error {
+	if _, err := tx.Exec(
+		"UPDATE tasks SET version = version + 1 WHERE id IN (SELECT task_id FROM task_tags WHERE tag_id = ?)",
+		tagID,
+	); err != nil {
+		return errors.AddContext(err, "tags.go: touchTaggedTasks - Exec")
+	}
+	return nil
+}
+
+// setTaskTags replaces the user's tags on a task, creating any tags the user
+// does not have yet. The tags other users put on a shared task are kept.
+func setTaskTags(tx *sql.Tx, userID uint, taskID any, names []string) error {
+	if _, err := tx.Exec(
+		"DELETE tt FROM task_tags tt JOIN tags tg ON tg.id = tt.tag_id WHERE tt.task_id = ? AND tg.user_id = ?",
+		taskID, userID,
+	); err != nil {
+		return errors.AddContext(err, "tags.go: setTaskTags - Delete")
+	}
+
+	for _, name := range names {
+		// LAST_INSERT_ID(id) makes the id of an existing tag available
+		// through LastInsertId when the insert turns into an update.
+		result, err := tx.Exec(
+			"INSERT INTO tags (user_id, name) VALUES (?, ?) ON DUPLICATE KEY UPDATE id = LAST_INSERT_ID(id)",
+			userID, name,
+		)
+		if err != nil {
+			return errors.AddContext(err, "tags.go: setTaskTags - Insert Tag")
+		}
+
+		tagID, err := result.LastInsertId()
+		if err != nil {
+			return errors.AddContext(err, "tags.go: setTaskTags - LastInsertId")
+		}
+
+		if _, err := tx.Exec("INSERT INTO task_tags (task_id, tag_id) VALUES (?, ?)", taskID, tagID); err != nil {
+			return errors.AddContext(err, "tags.go: setTaskTags - Insert Task Tag")
+		}
+	}
+	return nil
+}
+
+// loadTaskTags fills in the user's tags on each task with a single query.
+func loadTaskTags(userID uint, tasks ...*task) error {
+	if len(tasks) == 0 {
+		return nil
+	}
+
+	dbHandle, err := database.GetDBHandle()
+	if err != nil {
+		return errors.AddContext(err, "tags.go: loadTaskTags - GetDBHandle")
+	}
+	return queryTaskTags(dbHandle, userID, tasks...)
+}
+
+// queryTaskTags is loadTaskTags through a given connection or transaction.
+func queryTaskTags(q queryer, userID uint, tasks ...*task) error {
+	byID := make(map[uint][]*task, len(tasks))
+	args := make([]any, 0, len(tasks)+1)
+	args = append(args, userID)
+	for _, t := range tasks {
+		t.Tags = []string{}
+		byID[t.ID] = append(byID[t.ID], t)
+		args = append(args, t.ID)
+	}
+
+	rows, err := q.Query(
+		"SELECT tt.task_id, tg.name FROM task_tags tt JOIN tags tg ON tg.id = tt.tag_id "+
+			"WHERE tg.user_id = ? AND tt.task_id IN (?"+strings.Repeat(", ?", len(tasks)-1)+") ORDER BY tg.name",
+		args...,
+	)
+	if err != nil {
+		return errors.AddContext(err, "tags.go: queryTaskTags - Query")
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var taskID uint
+		var name string
+		if err := rows.Scan(&taskID, &name); err != nil {
+			return errors.AddContext(err, "tags.go: queryTaskTags - Scan")
+		}
+		for _, t := range byID[taskID] {
+			t.Tags = append(t.Tags, name)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return errors.AddContext(err, "tags.go: queryTaskTags - Rows")
+	}
+	return nil
+}
+
+func isDuplicateEntry(err error) bool {
+	mysqlErr, ok := err.(*mysql.MySQLError)
+	return ok && mysqlErr.Number == errDuplicateEntry
+}
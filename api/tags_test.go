@@ -0,0 +1,220 @@
+package api
+
+import (
+	"HMCTS-Developer-Challenge/database"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+func createTestTag(t *testing.T, userID uint, name string) tag {
+	return createTestResource[tag](t, TagsHandler, "/api/tags", `{"name": "`+name+`"}`, userID, "tags")
+}
+
+func TestNormalizeTagNames(t *testing.T) {
+	tags, err := normalizeTagNames([]string{" Family ", "awaiting-payment", "family", "civil"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := []string{"awaiting-payment", "civil", "family"}; !reflect.DeepEqual(tags, expected) {
+		t.Errorf("Expected %v, got %v", expected, tags)
+	}
+
+	for _, name := range []string{"", "two words", "a,b", "-leading", "trailing-", "double--hyphen"} {
+		if _, err := normalizeTagNames([]string{name}); err != errInvalidTagName {
+			t.Errorf("%q: expected errInvalidTagName, got %v", name, err)
+		}
+	}
+
+	if tags, err := normalizeTagNames(nil); tags != nil || err != nil {
+		t.Errorf("Expected nil tags to stay nil, got %v, %v", tags, err)
+	}
+}
+
+func TestTagsCRUD(t *testing.T) {
+	family := createTestTag(t, 1, "test-family")
+	if family.Name != "test-family" || family.TaskCount != 0 {
+		t.Errorf("Unexpected tag %+v", family)
+	}
+
+	rr := performHandlerRequest(t, TagsHandler, "POST", "/api/tags", []byte(`{"name": "Test-Family"}`), nil, 1)
+	if rr.Code != http.StatusConflict {
+		t.Errorf("handler returned wrong status code for a duplicate tag: got %v want %v", rr.Code, http.StatusConflict)
+	}
+
+	// Tags are scoped per user, so another user may use the same name
+	createTestTag(t, 2, "test-family")
+
+	rr = performHandlerRequest(t, TagsHandler, "GET", fmt.Sprintf("/api/tags/%d", family.ID), nil, nil, 2)
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("handler returned wrong status code for another user's tag: got %v want %v", rr.Code, http.StatusNotFound)
+	}
+
+	rr = performHandlerRequest(t, TagsHandler, "PUT", fmt.Sprintf("/api/tags/%d", family.ID), []byte(`{"name": "test-renamed"}`), nil, 1)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("handler returned wrong status code renaming a tag: got %v want %v", rr.Code, http.StatusOK)
+	}
+
+	rr = performHandlerRequest(t, TagsHandler, "GET", "/api/tags", nil, nil, 1)
+	var tags []tag
+	if err := json.Unmarshal(rr.Body.Bytes(), &tags); err != nil {
+		t.Fatalf("Failed to parse response as JSON: %v", err)
+	}
+	found := false
+	for _, tg := range tags {
+		if tg.Name == "test-family" {
+			t.Errorf("Expected the old tag name to be gone, got %+v", tags)
+		}
+		found = found || tg.Name == "test-renamed"
+	}
+	if !found {
+		t.Errorf("Expected the renamed tag in %+v", tags)
+	}
+
+	rr = performHandlerRequest(t, TagsHandler, "DELETE", fmt.Sprintf("/api/tags/%d", family.ID), nil, nil, 1)
+	if rr.Code != http.StatusNoContent {
+		t.Errorf("handler returned wrong status code deleting a tag: got %v want %v", rr.Code, http.StatusNoContent)
+	}
+}
+
+func TestTaskTagsAndFilter(t *testing.T) {
+	both := createTestTask(t, 1, "Tagged family and civil")
+	family := createTestTask(t, 1, "Tagged family only")
+
+	for taskID, tags := range map[string]string{both: `["civil", "Family"]`, family: `["family"]`} {
+		rr := performTaskRequest(t, "PATCH", "/api/tasks/"+taskID, []byte(`{"tags": `+tags+`}`), map[string]string{"If-Match": "*"}, 1)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("handler returned wrong status code tagging task: got %v want %v", rr.Code, http.StatusOK)
+		}
+	}
+	t.Cleanup(func() {
+		db, err := database.GetDBHandle()
+		if err != nil {
+			t.Fatal(err)
+		}
+		db.Exec("DELETE FROM tags WHERE user_id = 1 AND name IN ('family', 'civil')")
+	})
+
+	taskData, err := getTask(1, both)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := []string{"civil", "family"}; !reflect.DeepEqual(taskData.Tags, expected) {
+		t.Errorf("Expected tags %v, got %v", expected, taskData.Tags)
+	}
+	if taskData.Version != 2 {
+		t.Errorf("Expected tagging to bump the version to 2, got %d", taskData.Version)
+	}
+
+	_, page := getTaskPage(t, "/api/tasks/?tags=family,civil", 1)
+	if len(page.Tasks) != 2 {
+		t.Errorf("Expected 2 tasks with any of the tags, got %d", len(page.Tasks))
+	}
+
+	_, page = getTaskPage(t, "/api/tasks/?tags=family,civil&tag_match=all", 1)
+	if len(page.Tasks) != 1 || fmt.Sprint(page.Tasks[0].ID) != both {
+		t.Errorf("Expected only task %s to have all the tags, got %+v", both, page.Tasks)
+	}
+
+	// Editing without tags keeps them, an empty list removes them
+	body, err := json.Marshal(jsonData{Name: "Tagged family only", Status: "INCOMPLETE", Deadline: "2025-12-31 00:00:00"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	performTaskRequest(t, "PUT", "/api/tasks/"+family, body, map[string]string{"If-Match": "*"}, 1)
+	if taskData, _ := getTask(1, family); !reflect.DeepEqual(taskData.Tags, []string{"family"}) {
+		t.Errorf("Expected tags to be kept when omitted, got %v", taskData.Tags)
+	}
+
+	body, err = json.Marshal(jsonData{Name: "Tagged family only", Status: "INCOMPLETE", Deadline: "2025-12-31 00:00:00", Tags: []string{}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	performTaskRequest(t, "PUT", "/api/tasks/"+family, body, map[string]string{"If-Match": "*"}, 1)
+	if taskData, _ := getTask(1, family); len(taskData.Tags) != 0 {
+		t.Errorf("Expected tags to be removed, got %v", taskData.Tags)
+	}
+}
+
+func TestSharedTaskTags(t *testing.T) {
+	taskID := createTestTask(t, 1, "Tagged by creator and assignee")
+	if rr := performTaskRequest(t, "PUT", "/api/tasks/"+taskID+"/assignee", []byte(`{"assignee_id": 2}`), nil, 1); rr.Code != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusOK)
+	}
+	t.Cleanup(func() {
+		db, err := database.GetDBHandle()
+		if err != nil {
+			t.Fatal(err)
+		}
+		db.Exec("DELETE FROM tags WHERE user_id IN (1, 2) AND name IN ('probate', 'shared-tag')")
+	})
+
+	for userID, tags := range map[uint]string{1: `["probate", "shared-tag"]`, 2: `["shared-tag"]`} {
+		rr := performTaskRequest(t, "PATCH", "/api/tasks/"+taskID, []byte(`{"tags": `+tags+`}`), map[string]string{"If-Match": "*"}, userID)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("handler returned wrong status code tagging task: got %v want %v", rr.Code, http.StatusOK)
+		}
+	}
+
+	// Each user sees and filters by their own tags only
+	for userID, expected := range map[uint][]string{1: {"probate", "shared-tag"}, 2: {"shared-tag"}} {
+		taskData, err := getTask(userID, taskID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(taskData.Tags, expected) {
+			t.Errorf("User %d: expected tags %v, got %v", userID, expected, taskData.Tags)
+		}
+	}
+	if _, page := getTaskPage(t, "/api/tasks/?tags=probate", 2); len(page.Tasks) != 0 {
+		t.Errorf("Expected user 2 not to match user 1's tag, got %+v", page.Tasks)
+	}
+	if _, page := getTaskPage(t, "/api/tasks/?tags=probate", 1); len(page.Tasks) != 1 {
+		t.Errorf("Expected user 1 to match their tag, got %+v", page.Tasks)
+	}
+}
+
+func TestMergeTags(t *testing.T) {
+	source := createTestTag(t, 1, "test-merge-source")
+	target := createTestTag(t, 1, "test-merge-target")
+
+	first := createTestTask(t, 1, "Task tagged with source")
+	second := createTestTask(t, 1, "Task tagged with both")
+	performTaskRequest(t, "PATCH", "/api/tasks/"+first, []byte(`{"tags": ["test-merge-source"]}`), map[string]string{"If-Match": "*"}, 1)
+	performTaskRequest(t, "PATCH", "/api/tasks/"+second, []byte(`{"tags": ["test-merge-source", "test-merge-target"]}`), map[string]string{"If-Match": "*"}, 1)
+
+	rr := performHandlerRequest(t, TagsHandler, "POST", fmt.Sprintf("/api/tags/%d/merge", source.ID), []byte(fmt.Sprintf(`{"into": %d}`, source.ID)), nil, 1)
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("handler returned wrong status code merging a tag into itself: got %v want %v", rr.Code, http.StatusBadRequest)
+	}
+
+	rr = performHandlerRequest(t, TagsHandler, "POST", fmt.Sprintf("/api/tags/%d/merge", source.ID), []byte(fmt.Sprintf(`{"into": %d}`, target.ID)), nil, 1)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusOK)
+	}
+
+	var merged tag
+	if err := json.Unmarshal(rr.Body.Bytes(), &merged); err != nil {
+		t.Fatalf("Failed to parse response as JSON: %v", err)
+	}
+	if merged.ID != target.ID || merged.TaskCount != 2 {
+		t.Errorf("Expected target tag with 2 tasks, got %+v", merged)
+	}
+
+	rr = performHandlerRequest(t, TagsHandler, "GET", fmt.Sprintf("/api/tags/%d", source.ID), nil, nil, 1)
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("Expected the source tag to be deleted, got status %v", rr.Code)
+	}
+
+	for _, taskID := range []string{first, second} {
+		taskData, err := getTask(1, taskID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(taskData.Tags, []string{"test-merge-target"}) {
+			t.Errorf("Task %s: expected only the target tag, got %v", taskID, taskData.Tags)
+		}
+	}
+}
@@ -6,45 +6,184 @@ import (
 	"bytes"
 	"database/sql"
 	"encoding/json"
+	"io"
+	"mime"
 	"net/http"
+	"net/url"
+	"slices"
 	"strings"
+	"time"
 )
 
 type task struct {
-	ID          uint   `json:"id"`
-	UserID      uint   `json:"user_id"`
-	Name        string `json:"name"`
-	Description string `json:"description"`
-	Status      string `json:"status"`
-	CreatedAt   string `json:"created_at"`
-	Deadline    string `json:"deadline"`
+	ID          uint      `json:"id"`
+	CreatedBy   *uint     `json:"created_by"`
+	AssigneeID  *uint     `json:"assignee_id"`
+	TeamID      *uint     `json:"team_id"`
+	CaseID      *uint     `json:"case_id"`
+	ParentID    *uint     `json:"parent_id"`
+	Name        string    `json:"name"`
+	Description string    `json:"description"`
+	Status      string    `json:"status"`
+	CreatedAt   time.Time `json:"created_at"`
+	Deadline    time.Time `json:"deadline"`
+	Priority    string    `json:"priority"`
+	Recurrence  string    `json:"recurrence"`
+	Version     uint      `json:"version"`
+	Tags        []string  `json:"tags"`
+	BlockedBy   []uint    `json:"blocked_by"`
+	Blocking    []uint    `json:"blocking"`
+
+	// Urgency is only given in lists. It changes with the clock rather than
+	// the version, so a single task, whose ETag is its version, leaves it out.
+	Urgency *float64 `json:"urgency,omitempty"`
+
+	// DeadlineRule is set when the deadline follows a hearing.
+	DeadlineRule *deadlineRule `json:"deadline_rule"`
+
+	// NonWorkingDeadline names the weekend day or bank holiday the deadline
+	// falls on, if any.
+	NonWorkingDeadline string `json:"non_working_deadline,omitempty"`
+
+	// Progress counts the direct subtasks and checklist items of the task
+	// that are done.
+	Progress taskProgress `json:"progress"`
 }
 
 type jsonData struct {
 	Name        string `json:"name"`
 	Description string `json:"description"`
 	Status      string `json:"status"`
-	Deadline    string `json:"deadline"`
+	Priority    string `json:"priority"`
+
+	// Deadline is an RFC 3339 time, or a date and time without an offset
+	// which is read in the user's zone.
+	Deadline string `json:"deadline"`
+
+	// Recurrence is an iCalendar RRULE such as FREQ=WEEKLY;BYDAY=MO. Completing
+	// a recurring task creates its next occurrence.
+	Recurrence string `json:"recurrence"`
+
+	// Tags replaces the tags of the task. When omitted on an edit the
+	// existing tags are kept.
+	Tags []string `json:"tags"`
+
+	// TeamID puts a new task in the worklist of one of the user's teams,
+	// unassigned until a member claims it. It is ignored on edits.
+	TeamID *uint `json:"team_id"`
+
+	// CaseID links a new task to a case. Subtasks are linked to the case of
+	// their parent when it is omitted. It is ignored on edits.
+	CaseID *uint `json:"case_id"`
+
+	// DeadlineRule sets the deadline of a new task relative to a hearing of
+	// its case instead of giving one. It is ignored on edits.
+	DeadlineRule *deadlineRule `json:"deadline_rule"`
+
+	// DeadlineWorkingDays sets the deadline of a new task a number of working
+	// days from now instead of giving one. It is ignored on edits.
+	DeadlineWorkingDays *int `json:"deadline_working_days"`
 }
 
 var errTaskNotFound = errors.Error("Task Not Found")
 var errMissingJsonData = errors.Error("Missing JSON Data")
+var errUnknownTaskField = errors.Error("Unknown Task Field")
+var errInvalidTaskField = errors.Error("Invalid Task Field")
+var errUnsupportedPatchType = errors.Error("Unsupported Patch Type")
+
+const taskColumns = "id, created_by, assignee_id, team_id, case_id, parent_id, name, description, status, created_at, deadline, priority, recurrence, version, hearing_id, hearing_offset, hearing_offset_unit"
+
+type rowScanner interface {
+	Scan(dest ...any) error
+}
 
 func TasksHandler(w http.ResponseWriter, r *http.Request, userID uint) {
+	// Nested resources live under /api/tasks/{id}/...
+	if pathParts := strings.Split(r.URL.Path, "/"); len(pathParts) > 4 && pathParts[3] != "" && pathParts[4] != "" {
+		switch pathParts[4] {
+		case "subtasks":
+			subtasksHandler(w, r, userID, pathParts[3])
+		case "checklist":
+			itemID := ""
+			if len(pathParts) > 5 {
+				itemID = pathParts[5]
+			}
+			checklistHandler(w, r, userID, pathParts[3], itemID)
+		case "assignee":
+			assigneeHandler(w, r, userID, pathParts[3])
+		case "assignments":
+			assignmentsHandler(w, r, userID, pathParts[3])
+		case "team":
+			taskTeamHandler(w, r, userID, pathParts[3])
+		case "case":
+			taskCaseHandler(w, r, userID, pathParts[3])
+		case "deadline-rule":
+			taskDeadlineRuleHandler(w, r, userID, pathParts[3])
+		case "claim":
+			claimHandler(w, r, userID, pathParts[3])
+		case "blockers":
+			blockerID := ""
+			if len(pathParts) > 5 {
+				blockerID = pathParts[5]
+			}
+			blockersHandler(w, r, userID, pathParts[3], blockerID)
+		case "attachments":
+			attachmentID := ""
+			if len(pathParts) > 5 {
+				attachmentID = pathParts[5]
+			}
+			attachmentsHandler(w, r, userID, pathParts[3], attachmentID)
+		case "comments":
+			commentID := ""
+			if len(pathParts) > 5 {
+				commentID = pathParts[5]
+			}
+			if len(pathParts) > 6 && commentID != "" && pathParts[6] == "history" {
+				commentHistoryHandler(w, r, userID, pathParts[3], commentID)
+			} else if len(pathParts) > 6 && pathParts[6] != "" {
+				http.Error(w, "Not Found", http.StatusNotFound)
+			} else {
+				commentsHandler(w, r, userID, pathParts[3], commentID)
+			}
+		case "history":
+			if version, restore, ok := parseHistoryPath(pathParts[5:]); ok {
+				taskHistoryHandler(w, r, userID, pathParts[3], version, restore)
+			} else {
+				http.Error(w, "Not Found", http.StatusNotFound)
+			}
+		default:
+			http.Error(w, "Not Found", http.StatusNotFound)
+		}
+		return
+	}
+
 	switch r.Method {
 	case http.MethodGet:
 		var tasks any
+		var etag string
 		var err error
 
 		pathParts := strings.Split(r.URL.Path, "/")
 		if len(pathParts) < 4 || pathParts[3] == "" {
-			tasks, err = getTasks(userID)
+			loc, locErr := userLocation(userID)
+			if locErr != nil {
+				errors.HandleServerError(w, locErr, "task.go: HandleTasks - userLocation")
+				break
+			}
+			query, qErr := parseTaskQuery(r.URL.Query(), loc)
+			if qErr != nil {
+				http.Error(w, qErr.Error(), http.StatusBadRequest)
+				break
+			}
+			tasks, err = getTasks(userID, query, r.URL)
 		} else {
-			tasks, err = getTask(userID, pathParts[3])
+			var t task
+			t, err = getTask(userID, pathParts[3])
 			if err == errTaskNotFound {
 				http.Error(w, errTaskNotFound.Error(), http.StatusNotFound)
 				break
 			}
+			tasks, etag = t, taskETag(t)
 		}
 
 		if err != nil {
@@ -58,6 +197,15 @@ func TasksHandler(w http.ResponseWriter, r *http.Request, userID uint) {
 			break
 		}
 
+		if etag == "" {
+			etag = bodyETag(buf.Bytes())
+		}
+		w.Header().Set("ETag", etag)
+		if notModified(r, etag) {
+			w.WriteHeader(http.StatusNotModified)
+			break
+		}
+
 		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(http.StatusOK)
 		buf.WriteTo(w)
@@ -68,9 +216,15 @@ func TasksHandler(w http.ResponseWriter, r *http.Request, userID uint) {
 			break
 		}
 
-		if err := addTask(userID, data); err == errMissingJsonData {
+		if err := addTask(userID, data, parseTaskEditOptions(r)); err == errMissingJsonData {
 			http.Error(w, "Missing JSON data", http.StatusBadRequest)
 			break
+		} else if err == errInvalidTaskPriority || err == errInvalidTagName || err == errInvalidRecurrenceRule || err == errTeamNotFound || err == errCaseNotFound || err == errHearingNotFound || err == errInvalidDeadlineRule || err == errHearingCaseMismatch || err == errInvalidWorkingDays || err == errInvalidDeadline {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			break
+		} else if statusErr, ok := err.(*statusError); ok {
+			writeStatusError(w, statusErr)
+			break
 		} else if err != nil {
 			errors.HandleServerError(w, err, "task.go: HandleTasks - addTask")
 			break
@@ -90,15 +244,74 @@ func TasksHandler(w http.ResponseWriter, r *http.Request, userID uint) {
 			break
 		}
 
-		if err := editTask(userID, pathParts[3], data); err == errMissingJsonData || err == errTaskNotFound {
+		if err := editTask(userID, pathParts[3], r.Header.Get("If-Match"), data, parseTaskEditOptions(r)); err == errMissingJsonData || err == errTaskNotFound || err == errInvalidTaskPriority || err == errInvalidTagName || err == errInvalidRecurrenceRule || err == errInvalidDeadline {
 			http.Error(w, err.Error(), http.StatusBadRequest)
 			break
+		} else if err == errPreconditionRequired {
+			http.Error(w, err.Error(), http.StatusPreconditionRequired)
+			break
+		} else if err == errPreconditionFailed {
+			http.Error(w, err.Error(), http.StatusPreconditionFailed)
+			break
+		} else if err == errOpenSubtasks || err == errOpenBlockers {
+			http.Error(w, err.Error(), http.StatusConflict)
+			break
+		} else if _, ok := err.(*blockedSubtaskError); ok {
+			http.Error(w, err.Error(), http.StatusConflict)
+			break
+		} else if statusErr, ok := err.(*statusError); ok {
+			writeStatusError(w, statusErr)
+			break
 		} else if err != nil {
 			errors.HandleServerError(w, err, "task.go: HandleTasks - editTask")
 			break
 		}
 
 		w.WriteHeader(http.StatusNoContent)
+	case http.MethodPatch:
+		pathParts := strings.Split(r.URL.Path, "/")
+		if len(pathParts) < 4 || pathParts[3] == "" {
+			http.Error(w, "Task ID Required", http.StatusBadRequest)
+			break
+		}
+
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, "Invalid JSON", http.StatusBadRequest)
+			break
+		}
+
+		t, err := patchTask(userID, pathParts[3], r.Header.Get("If-Match"), r.Header.Get("Content-Type"), body, parseTaskEditOptions(r))
+		switch err {
+		case nil:
+		case errTaskNotFound:
+			http.Error(w, err.Error(), http.StatusNotFound)
+		case errPreconditionRequired:
+			http.Error(w, err.Error(), http.StatusPreconditionRequired)
+		case errPreconditionFailed:
+			http.Error(w, err.Error(), http.StatusPreconditionFailed)
+		case errUnsupportedPatchType:
+			w.Header().Set("Accept-Patch", mergePatchContentType+", "+jsonPatchContentType)
+			http.Error(w, err.Error(), http.StatusUnsupportedMediaType)
+		case errPatchTestFailed, errOpenSubtasks, errOpenBlockers:
+			http.Error(w, err.Error(), http.StatusConflict)
+		case errInvalidPatch, errMissingJsonData, errUnknownTaskField, errInvalidTaskField, errInvalidTaskPriority, errInvalidTagName, errInvalidRecurrenceRule, errInvalidDeadline:
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		default:
+			if statusErr, ok := err.(*statusError); ok {
+				writeStatusError(w, statusErr)
+			} else if _, ok := err.(*blockedSubtaskError); ok {
+				http.Error(w, err.Error(), http.StatusConflict)
+			} else {
+				errors.HandleServerError(w, err, "task.go: HandleTasks - patchTask")
+			}
+		}
+		if err != nil {
+			break
+		}
+
+		w.Header().Set("ETag", taskETag(t))
+		writeJSON(w, http.StatusOK, t)
 	case http.MethodDelete:
 		pathParts := strings.Split(r.URL.Path, "/")
 		if len(pathParts) < 4 || pathParts[3] == "" {
@@ -106,9 +319,15 @@ func TasksHandler(w http.ResponseWriter, r *http.Request, userID uint) {
 			break
 		}
 
-		if err := deleteTask(userID, pathParts[3]); err == errTaskNotFound {
+		if err := deleteTask(userID, pathParts[3], r.Header.Get("If-Match"), parseTaskEditOptions(r)); err == errTaskNotFound {
 			http.Error(w, err.Error(), http.StatusNotFound)
 			break
+		} else if err == errPreconditionRequired {
+			http.Error(w, err.Error(), http.StatusPreconditionRequired)
+			break
+		} else if err == errPreconditionFailed {
+			http.Error(w, err.Error(), http.StatusPreconditionFailed)
+			break
 		} else if err != nil {
 			errors.HandleServerError(w, err, "task.go: HandleTasks - deleteTask")
 			break
@@ -116,7 +335,7 @@ func TasksHandler(w http.ResponseWriter, r *http.Request, userID uint) {
 
 		w.WriteHeader(http.StatusNoContent)
 	case http.MethodOptions:
-		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
+		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, PATCH, DELETE, OPTIONS")
 		w.WriteHeader(http.StatusNoContent)
 	default:
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
@@ -124,141 +343,673 @@ func TasksHandler(w http.ResponseWriter, r *http.Request, userID uint) {
 }
 
 func getTask(userID uint, taskID string) (task, error) {
-	var t task
-
 	dbHandle, err := database.GetDBHandle()
 	if err != nil {
-		return t, errors.AddContext(err, "task.go: HandleGetTask - GetDBHandle")
-	}
-
-	if err := dbHandle.QueryRow(
-		"SELECT * FROM tasks WHERE id = ? AND user_id = ?", taskID, userID).Scan(
-		&t.ID,
-		&t.UserID,
-		&t.Name,
-		&t.Description,
-		&t.Status,
-		&t.CreatedAt,
-		&t.Deadline,
-	); err != nil {
+		return task{}, errors.AddContext(err, "task.go: HandleGetTask - GetDBHandle")
+	}
+
+	access, accessArgs := taskAccess(userID)
+	t, err := scanTask(dbHandle.QueryRow(
+		"SELECT "+taskColumns+" FROM tasks WHERE id = ? AND "+access, append([]any{taskID}, accessArgs...)...))
+	if err != nil {
 		if err == sql.ErrNoRows {
 			return t, errTaskNotFound
 		}
 		return t, errors.AddContext(err, "task.go: HandleGetTask - QueryRow")
 	}
+	t.Urgency = nil
+
+	if err := loadTaskDetails(userID, &t); err != nil {
+		return t, errors.AddContext(err, "task.go: HandleGetTask - loadTaskDetails")
+	}
+	return t, nil
+}
+
+// getEditedTask reads the task an edit starts from. An edit that joined the
+// transaction of a bulk request reads it there, so that it sees what the
+// earlier operations did, leaving out the details an edit does not use.
+func getEditedTask(userID uint, taskID string, options taskEditOptions) (task, error) {
+	if options.Tx == nil {
+		return getTask(userID, taskID)
+	}
+
+	access, accessArgs := taskAccess(userID)
+	t, err := scanTask(options.Tx.QueryRow(
+		"SELECT "+taskColumns+" FROM tasks WHERE id = ? AND "+access, append([]any{taskID}, accessArgs...)...))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return t, errTaskNotFound
+		}
+		return t, errors.AddContext(err, "task.go: getEditedTask - QueryRow")
+	}
+	t.Urgency = nil
+
+	if err := localizeTasks(userID, &t); err != nil {
+		return t, errors.AddContext(err, "task.go: getEditedTask - localizeTasks")
+	}
+	if err := queryTaskTags(options.Tx, userID, &t); err != nil {
+		return t, errors.AddContext(err, "task.go: getEditedTask - queryTaskTags")
+	}
 	return t, nil
 }
 
-func getTasks(userID uint) ([]task, error) {
+func getTasks(userID uint, query taskQuery, requestURL *url.URL) (taskPage, error) {
+	page := taskPage{Tasks: []task{}}
+
 	dbHandle, err := database.GetDBHandle()
 	if err != nil {
-		return nil, errors.AddContext(err, "task.go: HandleGetTasks - GetDBHandle")
+		return page, errors.AddContext(err, "task.go: HandleGetTasks - GetDBHandle")
+	}
+
+	where, args := query.where(userID)
+	if err := dbHandle.QueryRow("SELECT COUNT(*) FROM tasks WHERE "+where, args...).Scan(&page.Total); err != nil {
+		return page, errors.AddContext(err, "task.go: HandleGetTasks - Count")
+	}
+
+	// Computed sort keys are selected in a derived table so the keyset and
+	// ordering can refer to them by name.
+	urgency, urgencyArgs := urgencySQL(query.Now)
+	statement := "SELECT " + taskColumns + ", urgency FROM (SELECT " + taskColumns + ", " + urgency + " AS urgency, " +
+		"FIELD(priority, 'LOW', 'MEDIUM', 'HIGH', 'URGENT') AS priority_rank FROM tasks WHERE " + where + ") AS filtered"
+	args = append(urgencyArgs, args...)
+
+	keyset, keysetArgs, orderBy := query.page()
+	if keyset != "" {
+		statement += " WHERE " + keyset
+		args = append(args, keysetArgs...)
 	}
+	// Fetch one extra row to find out whether there is another page.
+	statement += " ORDER BY " + orderBy + " LIMIT ?"
+	args = append(args, query.Limit+1)
 
-	rows, err := dbHandle.Query("SELECT * FROM tasks WHERE user_id = ?", userID)
+	rows, err := dbHandle.Query(statement, args...)
 	if err != nil {
-		return nil, errors.AddContext(err, "task.go: HandleGetTasks - Query")
+		return page, errors.AddContext(err, "task.go: HandleGetTasks - Query")
 	}
 	defer rows.Close()
 
-	var tasks []task
 	for rows.Next() {
-		var t task
-		err := rows.Scan(&t.ID, &t.UserID, &t.Name, &t.Description, &t.Status, &t.CreatedAt, &t.Deadline)
+		var urgency float64
+		t, err := scanTask(rows, &urgency)
+		if err != nil {
+			return page, errors.AddContext(err, "task.go: HandleGetTasks - Scan")
+		}
+		t.Urgency = &urgency
+		page.Tasks = append(page.Tasks, t)
+	}
+	if err := rows.Err(); err != nil {
+		return page, errors.AddContext(err, "task.go: HandleGetTasks - Rows")
+	}
+
+	tasks := make([]*task, len(page.Tasks))
+	for i := range page.Tasks {
+		tasks[i] = &page.Tasks[i]
+	}
+	if err := loadTaskDetails(userID, tasks...); err != nil {
+		return page, errors.AddContext(err, "task.go: HandleGetTasks - loadTaskDetails")
+	}
+
+	if len(page.Tasks) > query.Limit {
+		page.Tasks = page.Tasks[:query.Limit]
+		cursor, err := encodeTaskCursor(query.cursorFor(page.Tasks[query.Limit-1]))
 		if err != nil {
-			return nil, errors.AddContext(err, "task.go: HandleGetTasks - Scan")
+			return page, errors.AddContext(err, "task.go: HandleGetTasks - encodeTaskCursor")
 		}
-		tasks = append(tasks, t)
+		page.Next = nextTaskPageURL(requestURL, cursor)
 	}
-	return tasks, nil
+	return page, nil
+}
+
+func addTask(userID uint, data jsonData, options taskEditOptions) error {
+	_, err := createTask(userID, nil, data, options)
+	return err
 }
 
-func addTask(userID uint, data jsonData) error {
+// createTask validates and inserts a task, optionally as a subtask of
+// parentID, and returns its ID.
+func createTask(userID uint, parentID *uint, data jsonData, options taskEditOptions) (uint, error) {
 	dbHandle, err := database.GetDBHandle()
 	if err != nil {
-		return errors.AddContext(err, "task.go: addTask - GetDBHandle")
+		return 0, errors.AddContext(err, "task.go: createTask - GetDBHandle")
 	}
 
-	if data.Name == "" || data.Status == "" || data.Deadline == "" {
-		return errMissingJsonData
+	var deadline time.Time
+	if data.DeadlineRule != nil {
+		if data.DeadlineWorkingDays != nil {
+			return 0, errInvalidWorkingDays
+		}
+		if err := data.DeadlineRule.normalize(); err != nil {
+			return 0, err
+		}
+	} else if data.DeadlineWorkingDays != nil {
+		if deadline, err = workingDaysDeadline(*data.DeadlineWorkingDays); err != nil {
+			return 0, err
+		}
+	} else if deadline, err = parseDeadline(userID, data.Deadline); err == errMissingJsonData || err == errInvalidDeadline {
+		return 0, err
+	} else if err != nil {
+		return 0, errors.AddContext(err, "task.go: createTask - parseDeadline")
+	}
+	if data.Name == "" || data.Status == "" {
+		return 0, errMissingJsonData
+	}
+
+	if data.Priority, err = normalizeTaskPriority(data.Priority); err != nil {
+		return 0, err
+	}
+
+	if data.Tags, err = normalizeTagNames(data.Tags); err != nil {
+		return 0, err
+	}
+
+	if data.Recurrence, err = normalizeRecurrence(data.Recurrence); err != nil {
+		return 0, err
+	}
+
+	if err := workflow.checkInitial(data.Status); err != nil {
+		return 0, err
+	}
+
+	edit, err := beginTaskEdit(dbHandle, options)
+	if err != nil {
+		return 0, errors.AddContext(err, "task.go: createTask - Begin")
+	}
+	defer edit.Rollback()
+	tx := edit.Tx
+
+	// Team tasks wait in the team worklist until a member claims them
+	assigneeID := &userID
+	if data.TeamID != nil {
+		if role, err := teamRole(tx, *data.TeamID, userID); err != nil {
+			return 0, errors.AddContext(err, "task.go: createTask - teamRole")
+		} else if role == "" {
+			return 0, errTeamNotFound
+		}
+		assigneeID = nil
+	}
+
+	if data.CaseID != nil {
+		if exists, err := caseExists(tx, *data.CaseID); err != nil {
+			return 0, errors.AddContext(err, "task.go: createTask - caseExists")
+		} else if !exists {
+			return 0, errCaseNotFound
+		}
+	}
+
+	// A deadline following a hearing links the task to the hearing's case
+	var hearingID *uint
+	var hearingOffset *int
+	var hearingOffsetUnit *string
+	if rule := data.DeadlineRule; rule != nil {
+		if data.CaseID, deadline, err = applyDeadlineRule(tx, *rule, data.CaseID); err == errHearingNotFound || err == errHearingCaseMismatch {
+			return 0, err
+		} else if err != nil {
+			return 0, errors.AddContext(err, "task.go: createTask - applyDeadlineRule")
+		}
+		hearingID, hearingOffset, hearingOffsetUnit = &rule.HearingID, &rule.Offset, &rule.Unit
 	}
 
-	_, err = dbHandle.Exec(
-		"INSERT INTO tasks (user_id, name, description, status, deadline) VALUES (?, ?, ?, ?, ?)",
+	result, err := tx.Exec(
+		"INSERT INTO tasks (created_by, assignee_id, team_id, case_id, parent_id, name, description, status, deadline, priority, recurrence, hearing_id, hearing_offset, hearing_offset_unit) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)",
 		userID,
+		assigneeID,
+		data.TeamID,
+		data.CaseID,
+		parentID,
 		data.Name,
 		data.Description,
 		data.Status,
-		data.Deadline,
+		deadline,
+		data.Priority,
+		data.Recurrence,
+		hearingID,
+		hearingOffset,
+		hearingOffsetUnit,
 	)
 	if err != nil {
-		return errors.AddContext(err, "task.go: addTask - Exec")
+		return 0, errors.AddContext(err, "task.go: createTask - Exec")
 	}
 
-	return nil
+	taskID, err := result.LastInsertId()
+	if err != nil {
+		return 0, errors.AddContext(err, "task.go: createTask - LastInsertId")
+	}
+
+	if len(data.Tags) > 0 {
+		if err := setTaskTags(tx, userID, taskID, data.Tags); err != nil {
+			return 0, errors.AddContext(err, "task.go: createTask - setTaskTags")
+		}
+	}
+
+	if parentID != nil {
+		if err := touchTask(tx, *parentID); err != nil {
+			return 0, errors.AddContext(err, "task.go: createTask - touchTask")
+		}
+	}
+
+	if err := recordTaskCreated(tx, userID, uint(taskID), options); err != nil {
+		return 0, errors.AddContext(err, "task.go: createTask - recordTaskCreated")
+	}
+
+	if err := edit.Commit(); err != nil {
+		return 0, errors.AddContext(err, "task.go: createTask - Commit")
+	}
+	return uint(taskID), nil
+}
+
+func editTask(userID uint, taskID string, ifMatch string, data jsonData, options taskEditOptions) error {
+	current, err := getEditedTask(userID, taskID, options)
+	if err == errTaskNotFound {
+		return err
+	} else if err != nil {
+		return errors.AddContext(err, "task.go: editTask - getEditedTask")
+	}
+
+	if err := checkIfMatch(ifMatch, taskETag(current)); err != nil {
+		return err
+	}
+
+	if data.Name == "" || data.Status == "" {
+		return errMissingJsonData
+	}
+
+	deadline, err := parseDeadline(userID, data.Deadline)
+	if err == errMissingJsonData || err == errInvalidDeadline {
+		return err
+	} else if err != nil {
+		return errors.AddContext(err, "task.go: editTask - parseDeadline")
+	}
+
+	if data.Priority, err = normalizeTaskPriority(data.Priority); err != nil {
+		return err
+	}
+
+	if data.Tags, err = normalizeTagNames(data.Tags); err != nil {
+		return err
+	}
+
+	if data.Recurrence, err = normalizeRecurrence(data.Recurrence); err != nil {
+		return err
+	}
+
+	if err := workflow.checkTransition(current.Status, data.Status); err != nil {
+		return err
+	}
+
+	return updateTask(userID, current, data, deadline, options)
 }
 
-func editTask(userID uint, taskID string, data jsonData) error {
+// updateTask writes data over a task only if it is still at the version that
+// was read. A concurrent write in between is reported as a failed
+// precondition rather than silently overwritten. Tags are only replaced when
+// data.Tags is not nil. The deadline is taken from deadline rather than
+// data, which holds it as given.
+func updateTask(userID uint, current task, data jsonData, deadline time.Time, options taskEditOptions) error {
 	dbHandle, err := database.GetDBHandle()
 	if err != nil {
-		return errors.AddContext(err, "task.go: editTask - GetDBHandle")
+		return errors.AddContext(err, "task.go: updateTask - GetDBHandle")
 	}
 
-	if exists, err := checkTaskExists(userID, taskID); err != nil {
-		return errors.AddContext(err, "task.go: deleteTask - QueryRow")
-	} else if !exists {
-		return errTaskNotFound
+	edit, err := beginTaskEdit(dbHandle, options)
+	if err != nil {
+		return errors.AddContext(err, "task.go: updateTask - Begin")
 	}
+	defer edit.Rollback()
+	tx := edit.Tx
 
-	if data.Name == "" || data.Status == "" || data.Deadline == "" {
-		return errMissingJsonData
+	changes, err := trackTasks(tx, current.ID)
+	if err != nil {
+		return errors.AddContext(err, "task.go: updateTask - trackTasks")
 	}
 
-	_, err = dbHandle.Exec(
-		"UPDATE tasks SET name = ?, description = ?, status = ?, deadline = ? WHERE id = ? AND user_id = ?",
+	result, err := tx.Exec(
+		"UPDATE tasks SET name = ?, description = ?, status = ?, deadline = ?, priority = ?, recurrence = ?, version = version + 1 WHERE id = ? AND version = ?",
 		data.Name,
 		data.Description,
 		data.Status,
-		data.Deadline,
-		taskID,
-		userID,
+		deadline,
+		data.Priority,
+		data.Recurrence,
+		current.ID,
+		current.Version,
 	)
 	if err != nil {
-		return errors.AddContext(err, "task.go: editTask - Exec")
+		return errors.AddContext(err, "task.go: updateTask - Exec")
+	}
+
+	if affected, err := result.RowsAffected(); err != nil {
+		return errors.AddContext(err, "task.go: updateTask - RowsAffected")
+	} else if affected == 0 {
+		return errPreconditionFailed
+	}
+
+	// Moving the deadline by hand stops it following its hearing
+	if current.DeadlineRule != nil && !current.Deadline.Equal(deadline) {
+		if err := clearDeadlineRule(tx, current.ID); err != nil {
+			return errors.AddContext(err, "task.go: updateTask - clearDeadlineRule")
+		}
+	}
+
+	if data.Tags != nil {
+		if err := setTaskTags(tx, userID, current.ID, data.Tags); err != nil {
+			return errors.AddContext(err, "task.go: updateTask - setTaskTags")
+		}
+	}
+
+	if err := changes.record(tx, userID, options); err != nil {
+		return errors.AddContext(err, "task.go: updateTask - record")
+	}
+
+	if data.Status != current.Status {
+		if err := checkBlockers(tx, current.ID, data.Status); err != nil {
+			return err
+		}
+		if err := closeSubtasks(tx, userID, current, data.Status, options); err != nil {
+			return err
+		}
+		if current.ParentID != nil {
+			if err := touchTask(tx, *current.ParentID); err != nil {
+				return errors.AddContext(err, "task.go: updateTask - touchTask")
+			}
+		}
+		if err := scheduleNextOccurrence(tx, userID, current, data, deadline, options); err != nil {
+			return err
+		}
 	}
 
+	if err := edit.Commit(); err != nil {
+		return errors.AddContext(err, "task.go: updateTask - Commit")
+	}
 	return nil
 }
 
-func deleteTask(userID uint, taskID string) error {
+// patchTask applies a JSON Merge Patch or JSON Patch to the editable fields of
+// a task. Only the fields the patch changes are validated, so a task can be
+// marked complete without resending the rest of it.
+func patchTask(userID uint, taskID string, ifMatch string, contentType string, patch []byte, options taskEditOptions) (task, error) {
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+
+	var apply func(any, []byte) (any, error)
+	switch mediaType {
+	case mergePatchContentType, "application/json", "":
+		apply = applyMergePatch
+	case jsonPatchContentType:
+		apply = applyJSONPatch
+	default:
+		return task{}, errUnsupportedPatchType
+	}
+
+	current, err := getEditedTask(userID, taskID, options)
+	if err != nil {
+		return current, err
+	}
+
+	if err := checkIfMatch(ifMatch, taskETag(current)); err != nil {
+		return current, err
+	}
+
+	before := taskDocument(current)
+	patched, err := apply(taskDocument(current), patch)
+	if err != nil {
+		return current, err
+	}
+
+	after, ok := patched.(map[string]any)
+	if !ok {
+		return current, errInvalidPatch
+	}
+
+	data := jsonData{
+		Name:        current.Name,
+		Description: current.Description,
+		Status:      current.Status,
+		Priority:    current.Priority,
+		Recurrence:  current.Recurrence,
+	}
+	deadline := current.Deadline
+	for field, value := range after {
+		if _, ok := before[field]; !ok {
+			return current, errUnknownTaskField
+		}
+		if field == "tags" {
+			tags, ok := stringList(value)
+			if !ok {
+				return current, errInvalidTaskField
+			}
+			if !slices.Equal(tags, current.Tags) {
+				data.Tags = tags
+			}
+			continue
+		}
+		if value == before[field] {
+			continue
+		}
+
+		s, ok := value.(string)
+		if !ok {
+			return current, errInvalidTaskField
+		}
+		switch field {
+		case "name":
+			data.Name = s
+		case "description":
+			data.Description = s
+		case "status":
+			data.Status = s
+		case "deadline":
+			// The current deadline is already in the user's zone
+			if deadline, ok = parseTaskTime(s, current.Deadline.Location()); !ok {
+				return current, errInvalidDeadline
+			}
+		case "priority":
+			// Only a task being created or replaced defaults an empty priority
+			if strings.TrimSpace(s) == "" {
+				return current, errInvalidTaskPriority
+			}
+			if data.Priority, err = normalizeTaskPriority(s); err != nil {
+				return current, err
+			}
+		case "recurrence":
+			data.Recurrence = s
+		}
+	}
+	for field := range before {
+		if _, ok := after[field]; !ok {
+			switch field {
+			case "description":
+				data.Description = ""
+			case "recurrence":
+				data.Recurrence = ""
+			case "tags":
+				data.Tags = []string{}
+			default:
+				return current, errMissingJsonData
+			}
+		}
+	}
+
+	if data.Name == "" || data.Status == "" {
+		return current, errMissingJsonData
+	}
+	if data.Tags, err = normalizeTagNames(data.Tags); err != nil {
+		return current, err
+	}
+	if data.Recurrence, err = normalizeRecurrence(data.Recurrence); err != nil {
+		return current, err
+	}
+	if err := workflow.checkTransition(current.Status, data.Status); err != nil {
+		return current, err
+	}
+
+	if err := updateTask(userID, current, data, deadline, options); err != nil {
+		return current, err
+	}
+
+	return getEditedTask(userID, taskID, options)
+}
+
+// taskDocument returns the editable fields of a task in the generic form the
+// patch functions operate on.
+func taskDocument(t task) map[string]any {
+	tags := make([]any, len(t.Tags))
+	for i, name := range t.Tags {
+		tags[i] = name
+	}
+
+	return map[string]any{
+		"name":        t.Name,
+		"description": t.Description,
+		"status":      t.Status,
+		"deadline":    t.Deadline.Format(time.RFC3339),
+		"priority":    t.Priority,
+		"recurrence":  t.Recurrence,
+		"tags":        tags,
+	}
+}
+
+// stringList converts a decoded JSON array back into a list of strings.
+func stringList(value any) ([]string, bool) {
+	items, ok := value.([]any)
+	if !ok {
+		return nil, false
+	}
+
+	list := make([]string, len(items))
+	for i, item := range items {
+		if list[i], ok = item.(string); !ok {
+			return nil, false
+		}
+	}
+	return list, true
+}
+
+func deleteTask(userID uint, taskID string, ifMatch string, options taskEditOptions) error {
 	dbHandle, err := database.GetDBHandle()
 	if err != nil {
 		return errors.AddContext(err, "task.go: deleteTask - GetDBHandle")
 	}
 
-	if exists, err := checkTaskExists(userID, taskID); err != nil {
-		return errors.AddContext(err, "task.go: deleteTask - QueryRow")
-	} else if !exists {
-		return errTaskNotFound
+	current, err := getEditedTask(userID, taskID, options)
+	if err == errTaskNotFound {
+		return err
+	} else if err != nil {
+		return errors.AddContext(err, "task.go: deleteTask - getEditedTask")
+	}
+
+	if err := checkIfMatch(ifMatch, taskETag(current)); err != nil {
+		return err
 	}
 
-	_, err = dbHandle.Exec("DELETE FROM tasks WHERE id = ? AND user_id = ?", taskID, userID)
+	edit, err := beginTaskEdit(dbHandle, options)
+	if err != nil {
+		return errors.AddContext(err, "task.go: deleteTask - Begin")
+	}
+	defer edit.Rollback()
+	tx := edit.Tx
+
+	changes, err := trackTaskQuery(tx, taskTreeQuery, current.ID)
+	if err != nil {
+		return errors.AddContext(err, "task.go: deleteTask - trackTaskQuery")
+	}
+
+	// The subtasks go to the trash with the task and are marked with its ID,
+	// so that they come back with it
+	deletedAt := time.Now().UTC().Truncate(time.Second)
+	result, err := tx.Exec(
+		"UPDATE tasks SET deleted_at = ?, deleted_by = ?, deleted_with = id, version = version + 1 WHERE id = ? AND version = ?",
+		deletedAt, userID, current.ID, current.Version,
+	)
 	if err != nil {
 		return errors.AddContext(err, "task.go: deleteTask - Exec")
 	}
 
+	if affected, err := result.RowsAffected(); err != nil {
+		return errors.AddContext(err, "task.go: deleteTask - RowsAffected")
+	} else if affected == 0 {
+		return errPreconditionFailed
+	}
+
+	if subtasks := changes.ids[1:]; len(subtasks) > 0 {
+		args := []any{deletedAt, userID, current.ID}
+		for _, id := range subtasks {
+			args = append(args, id)
+		}
+		if _, err := tx.Exec(
+			"UPDATE tasks SET deleted_at = ?, deleted_by = ?, deleted_with = ?, version = version + 1 WHERE id IN (?"+strings.Repeat(", ?", len(subtasks)-1)+")",
+			args...,
+		); err != nil {
+			return errors.AddContext(err, "task.go: deleteTask - Exec subtasks")
+		}
+	}
+
+	if current.ParentID != nil {
+		if err := touchTask(tx, *current.ParentID); err != nil {
+			return errors.AddContext(err, "task.go: deleteTask - touchTask")
+		}
+	}
+
+	if err := changes.record(tx, userID, options); err != nil {
+		return errors.AddContext(err, "task.go: deleteTask - record")
+	}
+
+	if err := edit.Commit(); err != nil {
+		return errors.AddContext(err, "task.go: deleteTask - Commit")
+	}
 	return nil
 }
 
+// touchTask bumps the version of a task whose representation changed through
+// a related resource, such as the progress of its subtasks or checklist.
+func touchTask(tx *sql.Tx, taskID any) error {
+	_, err := tx.Exec("UPDATE tasks SET version = version + 1 WHERE id = ?", taskID)
+	return err
+}
+
+// loadTaskDetails fills in the parts of each task stored outside the tasks
+// table and shows its times in the zone of the user reading it.
+func loadTaskDetails(userID uint, tasks ...*task) error {
+	if err := localizeTasks(userID, tasks...); err != nil {
+		return err
+	}
+	if err := loadTaskTags(userID, tasks...); err != nil {
+		return err
+	}
+	if err := loadTaskDependencies(tasks...); err != nil {
+		return err
+	}
+	return loadTaskProgress(tasks...)
+}
+
 func checkTaskExists(userID uint, taskID string) (bool, error) {
 	dbHandle, err := database.GetDBHandle()
 	if err != nil {
 		return false, errors.AddContext(err, "task.go: checkTaskExists - GetDBHandle")
 	}
 
+	access, accessArgs := taskAccess(userID)
 	var exists bool
-	if err := dbHandle.QueryRow("SELECT EXISTS(SELECT 1 FROM tasks WHERE id = ? AND user_id = ?)", taskID, userID).Scan(&exists); err != nil {
+	if err := dbHandle.QueryRow("SELECT EXISTS(SELECT 1 FROM tasks WHERE id = ? AND "+access+")", append([]any{taskID}, accessArgs...)...).Scan(&exists); err != nil {
 		return false, errors.AddContext(err, "task.go: checkTaskExists - QueryRow")
 	}
 	return exists, nil
 }
+
+// scanTask reads the columns listed in taskColumns followed by any extra
+// columns the query selected.
+func scanTask(row rowScanner, extra ...any) (task, error) {
+	var t task
+	var hearingID *uint
+	var hearingOffset *int
+	var hearingOffsetUnit *string
+	dest := []any{&t.ID, &t.CreatedBy, &t.AssigneeID, &t.TeamID, &t.CaseID, &t.ParentID, &t.Name, &t.Description, &t.Status, &t.CreatedAt, &t.Deadline, &t.Priority, &t.Recurrence, &t.Version, &hearingID, &hearingOffset, &hearingOffsetUnit}
+	if err := row.Scan(append(dest, extra...)...); err != nil {
+		return t, err
+	}
+	if hearingID != nil && hearingOffset != nil && hearingOffsetUnit != nil {
+		t.DeadlineRule = &deadlineRule{HearingID: *hearingID, Offset: *hearingOffset, Unit: *hearingOffsetUnit}
+	}
+	t.NonWorkingDeadline = nonWorkingDeadline(t.Deadline)
+	urgency := urgencyScore(t, time.Now().UTC())
+	t.Urgency = &urgency
+	return t, nil
+}
@@ -0,0 +1,385 @@
+package api
+
+import (
+	"HMCTS-Developer-Challenge/errors"
+	"encoding/base64"
+	"encoding/json"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultTaskPageSize = 50
+	maxTaskPageSize     = 200
+)
+
+// Columns that may be used to sort the task list, keyed by the name accepted
+// in the "sort" query parameter. urgency and priority_rank are computed in the
+// derived table built by getTasks.
+var taskSortColumns = map[string]string{
+	"id":         "id",
+	"name":       "name",
+	"status":     "status",
+	"priority":   "priority_rank",
+	"urgency":    "urgency",
+	"created_at": "created_at",
+	"deadline":   "deadline",
+}
+
+// Times without an offset are read in the user's zone.
+var taskQueryTimeLayouts = []string{
+	time.RFC3339,
+	time.DateTime,
+	"2006-01-02T15:04:05",
+	"2006-01-02T15:04",
+	time.DateOnly,
+}
+
+type taskQuery struct {
+	// View narrows the list to the tasks assigned to or created by the
+	// user, or to the unclaimed tasks of their teams. Every task the user
+	// can see is included when it is empty.
+	View           string
+	Team           string
+	Case           string
+	Statuses       []string
+	Priorities     []string
+	Tags           []string
+	MatchAllTags   bool
+	Parent         string
+	DeadlineAfter  time.Time
+	DeadlineBefore time.Time
+	CreatedAfter   time.Time
+	CreatedBefore  time.Time
+	Sort           string
+	Order          string
+	Limit          int
+	Cursor         *taskCursor
+
+	// Now is the reference time for urgency scores. It is carried in the
+	// cursor so every page of a listing is scored against the same instant.
+	Now time.Time
+}
+
+type taskCursor struct {
+	Sort  string `json:"s"`
+	Order string `json:"o"`
+	Value string `json:"v"`
+	ID    uint   `json:"id"`
+	At    string `json:"at,omitempty"`
+}
+
+type taskPage struct {
+	Tasks []task `json:"tasks"`
+	Total int    `json:"total"`
+	Next  string `json:"next,omitempty"`
+}
+
+func parseTaskQuery(values url.Values, loc *time.Location) (taskQuery, error) {
+	query := taskQuery{
+		Sort:  "deadline",
+		Order: "asc",
+		Limit: defaultTaskPageSize,
+		Now:   time.Now().UTC().Truncate(time.Second),
+	}
+
+	switch view := strings.ToLower(values.Get("view")); view {
+	case "", "all":
+	case "assigned", "created", "unassigned":
+		query.View = view
+	default:
+		return query, errors.Errorf("invalid view: %q", view)
+	}
+
+	// team=<id> lists the worklist of one of the user's teams
+	if team := values.Get("team"); team != "" {
+		if _, err := strconv.ParseUint(team, 10, 32); err != nil {
+			return query, errors.Errorf("invalid team: %q", team)
+		}
+		query.Team = team
+	}
+
+	// case=<id> lists the tasks linked to a case
+	if c := values.Get("case"); c != "" {
+		if _, err := strconv.ParseUint(c, 10, 32); err != nil {
+			return query, errors.Errorf("invalid case: %q", c)
+		}
+		query.Case = c
+	}
+
+	if status := values.Get("status"); status != "" {
+		for _, s := range strings.Split(status, ",") {
+			s = strings.ToUpper(strings.TrimSpace(s))
+			if !isValidTaskStatus(s) {
+				return query, errors.Errorf("invalid status: %q", s)
+			}
+			query.Statuses = append(query.Statuses, s)
+		}
+	}
+
+	if priority := values.Get("priority"); priority != "" {
+		for _, p := range strings.Split(priority, ",") {
+			p = strings.ToUpper(strings.TrimSpace(p))
+			if !isValidTaskPriority(p) {
+				return query, errors.Errorf("invalid priority: %q", p)
+			}
+			query.Priorities = append(query.Priorities, p)
+		}
+	}
+
+	if tags := values.Get("tags"); tags != "" {
+		var err error
+		if query.Tags, err = normalizeTagNames(strings.Split(tags, ",")); err != nil {
+			return query, errors.Errorf("invalid tags: %q", tags)
+		}
+	}
+
+	switch match := strings.ToLower(values.Get("tag_match")); match {
+	case "", "any":
+	case "all":
+		query.MatchAllTags = true
+	default:
+		return query, errors.Errorf("invalid tag_match: %q", match)
+	}
+
+	// parent=none lists only top level tasks, parent=<id> only the direct
+	// subtasks of that task.
+	if parent := strings.ToLower(values.Get("parent")); parent != "" {
+		if _, err := strconv.ParseUint(parent, 10, 32); parent != "none" && err != nil {
+			return query, errors.Errorf("invalid parent: %q", parent)
+		}
+		query.Parent = parent
+	}
+
+	var err error
+	if query.DeadlineAfter, err = parseTaskQueryTime(values, "deadline_after", loc); err != nil {
+		return query, err
+	}
+	if query.DeadlineBefore, err = parseTaskQueryTime(values, "deadline_before", loc); err != nil {
+		return query, err
+	}
+	if query.CreatedAfter, err = parseTaskQueryTime(values, "created_after", loc); err != nil {
+		return query, err
+	}
+	if query.CreatedBefore, err = parseTaskQueryTime(values, "created_before", loc); err != nil {
+		return query, err
+	}
+
+	if sort := values.Get("sort"); sort != "" {
+		if _, ok := taskSortColumns[sort]; !ok {
+			return query, errors.Errorf("invalid sort field: %q", sort)
+		}
+		query.Sort = sort
+	}
+
+	if order := strings.ToLower(values.Get("order")); order != "" {
+		if order != "asc" && order != "desc" {
+			return query, errors.Errorf("invalid sort order: %q", order)
+		}
+		query.Order = order
+	}
+
+	if limit := values.Get("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n < 1 || n > maxTaskPageSize {
+			return query, errors.Errorf("limit must be between 1 and %d", maxTaskPageSize)
+		}
+		query.Limit = n
+	}
+
+	if cursor := values.Get("cursor"); cursor != "" {
+		c, err := decodeTaskCursor(cursor)
+		if err != nil || c.Sort != query.Sort || c.Order != query.Order {
+			return query, errors.Error("invalid cursor")
+		}
+		if c.At != "" {
+			if query.Now, err = time.Parse(time.RFC3339, c.At); err != nil {
+				return query, errors.Error("invalid cursor")
+			}
+		}
+		query.Cursor = &c
+	}
+
+	return query, nil
+}
+
+func parseTaskQueryTime(values url.Values, name string, loc *time.Location) (time.Time, error) {
+	value := values.Get(name)
+	if value == "" {
+		return time.Time{}, nil
+	}
+
+	if t, ok := parseTaskTime(value, loc); ok {
+		return t, nil
+	}
+	return time.Time{}, errors.Errorf("invalid value for %s: %q", name, value)
+}
+
+// parseTaskTime accepts any of the supported date formats, reading times
+// without an offset in loc, and returns the time in UTC to the second.
+func parseTaskTime(value string, loc *time.Location) (time.Time, bool) {
+	for _, layout := range taskQueryTimeLayouts {
+		if t, err := time.ParseInLocation(layout, value, loc); err == nil {
+			return t.UTC().Truncate(time.Second), true
+		}
+	}
+	return time.Time{}, false
+}
+
+// where builds the filter clause shared by the page and count queries. The
+// cursor is deliberately left out so that the total reflects the whole
+// result set rather than what remains after the current page.
+func (q taskQuery) where(userID uint) (string, []any) {
+	var clauses []string
+	var args []any
+	switch q.View {
+	case "assigned":
+		clauses, args = []string{"deleted_at IS NULL", "assignee_id = ?"}, []any{userID}
+	case "created":
+		clauses, args = []string{"deleted_at IS NULL", "created_by = ?"}, []any{userID}
+	default:
+		access, accessArgs := taskAccess(userID)
+		clauses, args = []string{access}, accessArgs
+		if q.View == "unassigned" {
+			clauses = append(clauses, "assignee_id IS NULL")
+		}
+	}
+
+	if q.Team != "" {
+		clauses = append(clauses, "team_id = ?")
+		args = append(args, q.Team)
+	}
+	if q.Case != "" {
+		clauses = append(clauses, "case_id = ?")
+		args = append(args, q.Case)
+	}
+	if len(q.Statuses) > 0 {
+		clauses = append(clauses, "status IN (?"+strings.Repeat(", ?", len(q.Statuses)-1)+")")
+		for _, s := range q.Statuses {
+			args = append(args, s)
+		}
+	}
+	if len(q.Priorities) > 0 {
+		clauses = append(clauses, "priority IN (?"+strings.Repeat(", ?", len(q.Priorities)-1)+")")
+		for _, p := range q.Priorities {
+			args = append(args, p)
+		}
+	}
+	if len(q.Tags) > 0 {
+		// Tasks carrying any of the user's tags, or with "all" only those
+		// where every one of the tags matched
+		clause := "id IN (SELECT tt.task_id FROM task_tags tt JOIN tags tg ON tg.id = tt.tag_id " +
+			"WHERE tg.user_id = ? AND tg.name IN (?" + strings.Repeat(", ?", len(q.Tags)-1) + ")"
+		args = append(args, userID)
+		for _, t := range q.Tags {
+			args = append(args, t)
+		}
+		if q.MatchAllTags {
+			clause += " GROUP BY tt.task_id HAVING COUNT(DISTINCT tg.name) = ?"
+			args = append(args, len(q.Tags))
+		}
+		clauses = append(clauses, clause+")")
+	}
+	switch q.Parent {
+	case "":
+	case "none":
+		clauses = append(clauses, "parent_id IS NULL")
+	default:
+		clauses = append(clauses, "parent_id = ?")
+		args = append(args, q.Parent)
+	}
+	if !q.DeadlineAfter.IsZero() {
+		clauses = append(clauses, "deadline >= ?")
+		args = append(args, q.DeadlineAfter)
+	}
+	if !q.DeadlineBefore.IsZero() {
+		clauses = append(clauses, "deadline < ?")
+		args = append(args, q.DeadlineBefore)
+	}
+	if !q.CreatedAfter.IsZero() {
+		clauses = append(clauses, "created_at >= ?")
+		args = append(args, q.CreatedAfter)
+	}
+	if !q.CreatedBefore.IsZero() {
+		clauses = append(clauses, "created_at < ?")
+		args = append(args, q.CreatedBefore)
+	}
+
+	return strings.Join(clauses, " AND "), args
+}
+
+// page returns the keyset clause and ordering for the current page. Rows are
+// always ordered by id as a tie breaker so the cursor is stable when several
+// tasks share a sort value.
+func (q taskQuery) page() (string, []any, string) {
+	column := taskSortColumns[q.Sort]
+	direction := strings.ToUpper(q.Order)
+	orderBy := column + " " + direction + ", id " + direction
+
+	if q.Cursor == nil {
+		return "", nil, orderBy
+	}
+
+	comparison := ">"
+	if q.Order == "desc" {
+		comparison = "<"
+	}
+
+	if column == "id" {
+		return "id " + comparison + " ?", []any{q.Cursor.ID}, orderBy
+	}
+	clause := "(" + column + " " + comparison + " ? OR (" + column + " = ? AND id " + comparison + " ?))"
+	return clause, []any{q.Cursor.Value, q.Cursor.Value, q.Cursor.ID}, orderBy
+}
+
+func (q taskQuery) cursorFor(t task) taskCursor {
+	c := taskCursor{Sort: q.Sort, Order: q.Order, ID: t.ID}
+	switch q.Sort {
+	case "name":
+		c.Value = t.Name
+	case "status":
+		c.Value = t.Status
+	case "created_at":
+		c.Value = t.CreatedAt.UTC().Format(time.DateTime)
+	case "deadline":
+		c.Value = t.Deadline.UTC().Format(time.DateTime)
+	case "priority":
+		c.Value = strconv.Itoa(slices.Index(taskPriorities, t.Priority) + 1)
+	case "urgency":
+		c.Value = strconv.FormatFloat(*t.Urgency, 'f', -1, 64)
+		c.At = q.Now.Format(time.RFC3339)
+	}
+	return c
+}
+
+func encodeTaskCursor(c taskCursor) (string, error) {
+	data, err := json.Marshal(c)
+	if err != nil {
+		return "", errors.AddContext(err, "tasks_query.go: encodeTaskCursor - Marshal")
+	}
+	return base64.RawURLEncoding.EncodeToString(data), nil
+}
+
+func decodeTaskCursor(cursor string) (taskCursor, error) {
+	var c taskCursor
+	data, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return c, errors.AddContext(err, "tasks_query.go: decodeTaskCursor - DecodeString")
+	}
+	if err := json.Unmarshal(data, &c); err != nil {
+		return c, errors.AddContext(err, "tasks_query.go: decodeTaskCursor - Unmarshal")
+	}
+	return c, nil
+}
+
+// nextTaskPageURL rebuilds the request URL with the cursor replaced so the
+// client can follow it without having to reapply its filters.
+func nextTaskPageURL(base *url.URL, cursor string) string {
+	values := base.Query()
+	values.Set("cursor", cursor)
+	next := url.URL{Path: base.Path, RawQuery: values.Encode()}
+	return next.String()
+}
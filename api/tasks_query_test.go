@@ -0,0 +1,129 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func getTaskPage(t *testing.T, url string, userID uint) (*httptest.ResponseRecorder, taskPage) {
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr := httptest.NewRecorder()
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		TasksHandler(w, r, userID)
+	})
+	handler.ServeHTTP(rr, req)
+
+	var page taskPage
+	if rr.Code == http.StatusOK {
+		if err := json.Unmarshal(rr.Body.Bytes(), &page); err != nil {
+			t.Fatalf("Failed to parse response as JSON: %v", err)
+		}
+	}
+	return rr, page
+}
+
+func TestGetTasksFilterByStatus(t *testing.T) {
+	rr, page := getTaskPage(t, "/api/tasks/?status=complete", 1)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusOK)
+	}
+
+	for _, task := range page.Tasks {
+		if task.Status != "COMPLETE" {
+			t.Errorf("Expected only COMPLETE tasks, got task %d with status %s", task.ID, task.Status)
+		}
+	}
+	if page.Total != len(page.Tasks) {
+		t.Errorf("Expected total %d, got %d", len(page.Tasks), page.Total)
+	}
+}
+
+func TestGetTasksDeadlineRange(t *testing.T) {
+	rr, page := getTaskPage(t, "/api/tasks/?deadline_after=2025-12-01&deadline_before=2026-01-01", 1)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusOK)
+	}
+
+	// Dates without a time are midnight in the user's zone
+	after := time.Date(2025, 12, 1, 0, 0, 0, 0, courtLocation)
+	before := time.Date(2026, 1, 1, 0, 0, 0, 0, courtLocation)
+	for _, task := range page.Tasks {
+		if task.Deadline.Before(after) || !task.Deadline.Before(before) {
+			t.Errorf("Task %d has deadline %s outside of the requested range", task.ID, task.Deadline)
+		}
+	}
+}
+
+func TestGetTasksPagination(t *testing.T) {
+	rr, first := getTaskPage(t, "/api/tasks/?limit=1&sort=id&order=asc", 1)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusOK)
+	}
+	if len(first.Tasks) != 1 {
+		t.Fatalf("Expected 1 task on the first page, got %d", len(first.Tasks))
+	}
+	if first.Total < 2 {
+		t.Fatalf("Expected a total of at least 2 tasks, got %d", first.Total)
+	}
+	if first.Next == "" {
+		t.Fatal("Expected a next link when more tasks are available")
+	}
+
+	rr, second := getTaskPage(t, first.Next, 1)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("handler returned wrong status code for next page: got %v want %v", rr.Code, http.StatusOK)
+	}
+	if len(second.Tasks) != 1 {
+		t.Fatalf("Expected 1 task on the second page, got %d", len(second.Tasks))
+	}
+	if second.Tasks[0].ID <= first.Tasks[0].ID {
+		t.Errorf("Expected second page to continue after task %d, got task %d", first.Tasks[0].ID, second.Tasks[0].ID)
+	}
+	if second.Total != first.Total {
+		t.Errorf("Expected total to be stable across pages, got %d and %d", first.Total, second.Total)
+	}
+}
+
+func TestGetTasksInvalidQuery(t *testing.T) {
+	urls := []string{
+		"/api/tasks/?status=UNKNOWN",
+		"/api/tasks/?deadline_before=tomorrow",
+		"/api/tasks/?created_after=2025-13-01",
+		"/api/tasks/?sort=password",
+		"/api/tasks/?order=sideways",
+		"/api/tasks/?limit=0",
+		"/api/tasks/?limit=1000",
+		"/api/tasks/?cursor=not-a-cursor",
+	}
+
+	for _, url := range urls {
+		rr, _ := getTaskPage(t, url, 1)
+		if rr.Code != http.StatusBadRequest {
+			t.Errorf("%s: handler returned wrong status code: got %v want %v", url, rr.Code, http.StatusBadRequest)
+		}
+	}
+}
+
+func TestGetTasksCursorMustMatchSort(t *testing.T) {
+	_, first := getTaskPage(t, "/api/tasks/?limit=1&sort=id", 1)
+	if first.Next == "" {
+		t.Fatal("Expected a next link when more tasks are available")
+	}
+
+	cursor, err := encodeTaskCursor(taskCursor{Sort: "id", Order: "asc", ID: first.Tasks[0].ID})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr, _ := getTaskPage(t, "/api/tasks/?sort=deadline&cursor="+cursor, 1)
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusBadRequest)
+	}
+}
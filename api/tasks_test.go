@@ -9,6 +9,7 @@ import (
 	"net/http/httptest"
 	"os"
 	"testing"
+	"time"
 )
 
 func TestMain(m *testing.M) {
@@ -40,16 +41,19 @@ func TestGetTasks(t *testing.T) {
 		t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusOK)
 	}
 
-	// Check that the response is a JSON array
-	var tasks []task
-	err = json.Unmarshal(rr.Body.Bytes(), &tasks)
+	// Check that the response is a JSON page of tasks
+	var page taskPage
+	err = json.Unmarshal(rr.Body.Bytes(), &page)
 	if err != nil {
 		t.Errorf("Failed to parse response as JSON: %v", err)
 	}
 
 	// Check that at least we have the tasks from the seed data for user 1
-	if len(tasks) < 2 {
-		t.Errorf("Expected at least 2 tasks for user 1, got %d", len(tasks))
+	if len(page.Tasks) < 2 {
+		t.Errorf("Expected at least 2 tasks for user 1, got %d", len(page.Tasks))
+	}
+	if page.Total != len(page.Tasks) {
+		t.Errorf("Expected total %d to match the number of tasks returned, got %d", len(page.Tasks), page.Total)
 	}
 }
 
@@ -78,12 +82,12 @@ func TestGetSingleTask(t *testing.T) {
 		t.Errorf("Failed to parse response as JSON: %v", err)
 	}
 
-	// Check the task ID and user ID
+	// Check the task ID and its users
 	if taskData.ID != 1 {
 		t.Errorf("Expected task ID 1, got %d", taskData.ID)
 	}
-	if taskData.UserID != 1 {
-		t.Errorf("Expected user ID 1, got %d", taskData.UserID)
+	if taskData.CreatedBy == nil || *taskData.CreatedBy != 1 || taskData.AssigneeID == nil || *taskData.AssigneeID != 1 {
+		t.Errorf("Expected task created by and assigned to user 1, got %v and %v", taskData.CreatedBy, taskData.AssigneeID)
 	}
 }
 
@@ -143,14 +147,14 @@ func TestAddTask(t *testing.T) {
 	rr = httptest.NewRecorder()
 	handler.ServeHTTP(rr, req)
 
-	var tasks []task
-	err = json.Unmarshal(rr.Body.Bytes(), &tasks)
+	var page taskPage
+	err = json.Unmarshal(rr.Body.Bytes(), &page)
 	if err != nil {
 		t.Errorf("Failed to parse response as JSON: %v", err)
 	}
 
 	found := false
-	for _, t := range tasks {
+	for _, t := range page.Tasks {
 		if t.Name == "Test Task" && t.Description == "This is a test task" {
 			found = true
 			break
@@ -205,12 +209,18 @@ func TestEditTask(t *testing.T) {
 		t.Fatal(err)
 	}
 
+	current, err := getTask(1, "1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
 	// Edit the task
 	req, err := http.NewRequest("PUT", "/api/tasks/1", bytes.NewBuffer(taskJSON))
 	if err != nil {
 		t.Fatal(err)
 	}
 	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("If-Match", taskETag(current))
 
 	rr := httptest.NewRecorder()
 	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -248,6 +258,9 @@ func TestEditTask(t *testing.T) {
 	if taskData.Status != "COMPLETE" {
 		t.Errorf("Expected task status 'COMPLETE', got '%s'", taskData.Status)
 	}
+	if taskData.Version != current.Version+1 {
+		t.Errorf("Expected task version %d, got %d", current.Version+1, taskData.Version)
+	}
 }
 
 func TestEditTaskNotFound(t *testing.T) {
@@ -316,15 +329,15 @@ func TestDeleteTask(t *testing.T) {
 	rr = httptest.NewRecorder()
 	handler.ServeHTTP(rr, req)
 
-	var tasks []task
-	err = json.Unmarshal(rr.Body.Bytes(), &tasks)
+	var page taskPage
+	err = json.Unmarshal(rr.Body.Bytes(), &page)
 	if err != nil {
 		t.Errorf("Failed to parse response as JSON: %v", err)
 	}
 
 	// Find the task we just added
 	var taskIDToDelete string
-	for _, task := range tasks {
+	for _, task := range page.Tasks {
 		if task.Name == "Task to Delete" {
 			taskIDToDelete = fmt.Sprintf("%d", task.ID)
 			break
@@ -340,6 +353,7 @@ func TestDeleteTask(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
+	req.Header.Set("If-Match", `"1"`)
 
 	rr = httptest.NewRecorder()
 	handler.ServeHTTP(rr, req)
@@ -400,7 +414,7 @@ func TestTasksHandlerHttpMethods(t *testing.T) {
 	}
 
 	// Test unsupported method
-	req, err = http.NewRequest("PATCH", "/api/tasks", nil)
+	req, err = http.NewRequest("TRACE", "/api/tasks", nil)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -526,3 +540,200 @@ func TestDeleteTaskBelongingToAnotherUser(t *testing.T) {
 		t.Errorf("Task was deleted when it shouldn't have been. Expected status OK, got %v", rr.Code)
 	}
 }
+
+// Helper function to insert a task directly and remove it once the test ends
+func createTestTask(t *testing.T, userID uint, name string) string {
+	db, err := database.GetDBHandle()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := db.Exec(
+		"INSERT INTO tasks (created_by, assignee_id, name, description, status, deadline) VALUES (?, ?, ?, ?, ?, ?)",
+		userID, userID, name, "Description for "+name, "INCOMPLETE", "2025-12-31 00:00:00",
+	)
+	if err != nil {
+		t.Fatalf("Failed to insert test task: %v", err)
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() {
+		if _, err := db.Exec("DELETE FROM tasks WHERE id = ?", id); err != nil {
+			t.Errorf("Failed to delete test task: %v", err)
+		}
+	})
+	return fmt.Sprintf("%d", id)
+}
+
+// Helper function to create a resource through its handler and delete it from
+// table once the test ends
+func createTestResource[T any](t *testing.T, handler func(http.ResponseWriter, *http.Request, uint), url string, body string, userID uint, table string) T {
+	rr := performHandlerRequest(t, handler, "POST", url, []byte(body), nil, userID)
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("handler returned wrong status code creating %s: got %v want %v, %s", body, rr.Code, http.StatusCreated, rr.Body.String())
+	}
+
+	var created T
+	var key struct {
+		ID uint `json:"id"`
+	}
+	for _, v := range []any{&created, &key} {
+		if err := json.Unmarshal(rr.Body.Bytes(), v); err != nil {
+			t.Fatalf("Failed to parse response as JSON: %v", err)
+		}
+	}
+
+	t.Cleanup(func() {
+		db, err := database.GetDBHandle()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := db.Exec("DELETE FROM "+table+" WHERE id = ?", key.ID); err != nil {
+			t.Errorf("Failed to delete test resource from %s: %v", table, err)
+		}
+	})
+	return created
+}
+
+func performPatch(t *testing.T, taskID string, contentType string, body string, userID uint) *httptest.ResponseRecorder {
+	req, err := http.NewRequest("PATCH", "/api/tasks/"+taskID, bytes.NewBufferString(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", contentType)
+	req.Header.Set("If-Match", "*")
+
+	rr := httptest.NewRecorder()
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		TasksHandler(w, r, userID)
+	})
+	handler.ServeHTTP(rr, req)
+	return rr
+}
+
+func TestPatchTaskMergePatch(t *testing.T) {
+	taskID := createTestTask(t, 1, "Task to Merge Patch")
+
+	rr := performPatch(t, taskID, "application/merge-patch+json", `{"status": "COMPLETE"}`, 1)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v: %s", rr.Code, http.StatusOK, rr.Body.String())
+	}
+
+	var taskData task
+	if err := json.Unmarshal(rr.Body.Bytes(), &taskData); err != nil {
+		t.Fatalf("Failed to parse response as JSON: %v", err)
+	}
+
+	if taskData.Status != "COMPLETE" {
+		t.Errorf("Expected task status 'COMPLETE', got '%s'", taskData.Status)
+	}
+	if taskData.Name != "Task to Merge Patch" {
+		t.Errorf("Expected task name to be unchanged, got '%s'", taskData.Name)
+	}
+	if taskData.Description != "Description for Task to Merge Patch" {
+		t.Errorf("Expected task description to be unchanged, got '%s'", taskData.Description)
+	}
+}
+
+func TestPatchTaskMergePatchRemovesDescription(t *testing.T) {
+	taskID := createTestTask(t, 1, "Task to Clear")
+
+	rr := performPatch(t, taskID, "application/merge-patch+json", `{"description": null}`, 1)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v: %s", rr.Code, http.StatusOK, rr.Body.String())
+	}
+
+	var taskData task
+	if err := json.Unmarshal(rr.Body.Bytes(), &taskData); err != nil {
+		t.Fatalf("Failed to parse response as JSON: %v", err)
+	}
+	if taskData.Description != "" {
+		t.Errorf("Expected description to be cleared, got '%s'", taskData.Description)
+	}
+}
+
+func TestPatchTaskJSONPatch(t *testing.T) {
+	taskID := createTestTask(t, 1, "Task to JSON Patch")
+
+	patch := `[
+		{"op": "test", "path": "/status", "value": "INCOMPLETE"},
+		{"op": "replace", "path": "/status", "value": "COMPLETE"},
+		{"op": "replace", "path": "/deadline", "value": "2026-01-15"}
+	]`
+	rr := performPatch(t, taskID, "application/json-patch+json", patch, 1)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v: %s", rr.Code, http.StatusOK, rr.Body.String())
+	}
+
+	var taskData task
+	if err := json.Unmarshal(rr.Body.Bytes(), &taskData); err != nil {
+		t.Fatalf("Failed to parse response as JSON: %v", err)
+	}
+	if taskData.Status != "COMPLETE" {
+		t.Errorf("Expected task status 'COMPLETE', got '%s'", taskData.Status)
+	}
+	if got := taskData.Deadline.Format(time.RFC3339); got != "2026-01-15T00:00:00Z" {
+		t.Errorf("Expected deadline '2026-01-15T00:00:00Z', got '%s'", got)
+	}
+
+	// The test operation now fails so nothing should change
+	rr = performPatch(t, taskID, "application/json-patch+json", patch, 1)
+	if rr.Code != http.StatusConflict {
+		t.Errorf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusConflict)
+	}
+}
+
+func TestPatchTaskValidation(t *testing.T) {
+	taskID := createTestTask(t, 1, "Task to Validate")
+
+	cases := []struct {
+		contentType string
+		body        string
+		expected    int
+	}{
+		{"application/merge-patch+json", `{"name": ""}`, http.StatusBadRequest},
+		{"application/merge-patch+json", `{"name": null}`, http.StatusBadRequest},
+		{"application/merge-patch+json", `{"status": "UNKNOWN"}`, http.StatusUnprocessableEntity},
+		{"application/merge-patch+json", `{"deadline": "tomorrow"}`, http.StatusBadRequest},
+		{"application/merge-patch+json", `{"name": 42}`, http.StatusBadRequest},
+		{"application/merge-patch+json", `{"user_id": 2}`, http.StatusBadRequest},
+		{"application/merge-patch+json", `not json`, http.StatusBadRequest},
+		{"application/json-patch+json", `[{"op": "remove", "path": "/deadline"}]`, http.StatusBadRequest},
+		{"application/json-patch+json", `[{"op": "add", "path": "/id", "value": 1}]`, http.StatusBadRequest},
+		{"text/plain", `{"status": "COMPLETE"}`, http.StatusUnsupportedMediaType},
+	}
+
+	for _, c := range cases {
+		rr := performPatch(t, taskID, c.contentType, c.body, 1)
+		if rr.Code != c.expected {
+			t.Errorf("%s %s: handler returned wrong status code: got %v want %v", c.contentType, c.body, rr.Code, c.expected)
+		}
+	}
+
+	taskData, err := getTask(1, taskID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if taskData.Name != "Task to Validate" || taskData.Status != "INCOMPLETE" {
+		t.Errorf("Task was modified by an invalid patch: %+v", taskData)
+	}
+}
+
+func TestPatchTaskBelongingToAnotherUser(t *testing.T) {
+	rr := performPatch(t, "3", "application/merge-patch+json", `{"status": "COMPLETE"}`, 1)
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusNotFound)
+	}
+
+	taskData, err := getTask(2, "3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if taskData.Status != "INCOMPLETE" {
+		t.Errorf("Task belonging to another user was modified: %+v", taskData)
+	}
+}
@@ -0,0 +1,649 @@
+package api
+
+import (
+	"HMCTS-Developer-Challenge/audit"
+	"HMCTS-Developer-Challenge/database"
+	"HMCTS-Developer-Challenge/errors"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+const maxTeamNameLength = 64
+
+// Roles of a team member. Managers can rename and delete the team, change its
+// membership and reassign any of its tasks; members can work and claim them.
+const (
+	teamRoleManager = "MANAGER"
+	teamRoleMember  = "MEMBER"
+)
+
+type team struct {
+	ID        uint      `json:"id"`
+	Name      string    `json:"name"`
+	CreatedAt time.Time `json:"created_at"`
+
+	// Role is the current user's role in the team.
+	Role    string       `json:"role"`
+	Members []teamMember `json:"members,omitempty"`
+}
+
+type teamMember struct {
+	UserID uint   `json:"user_id"`
+	Name   string `json:"name"`
+	Role   string `json:"role"`
+}
+
+type teamData struct {
+	Name string `json:"name"`
+}
+
+type teamMemberData struct {
+	Role string `json:"role"`
+}
+
+type taskTeamData struct {
+	// TeamID is the team to hand the task to, or null to take it out of its
+	// team.
+	TeamID *uint `json:"team_id"`
+}
+
+var errTeamNotFound = errors.Error("Team Not Found")
+var errTeamExists = errors.Error("Team Already Exists")
+var errInvalidTeamName = errors.Error("Invalid Team Name")
+var errInvalidTeamRole = errors.Error("Invalid Team Role")
+var errTeamManagersOnly = errors.Error("Only Team Managers Can Do This")
+var errLastTeamManager = errors.Error("Team Needs At Least One Manager")
+var errUnknownUser = errors.Error("Unknown User")
+
+type rowQueryer interface {
+	QueryRow(query string, args ...any) *sql.Row
+}
+
+func TeamsHandler(w http.ResponseWriter, r *http.Request, userID uint) {
+	pathParts := strings.Split(strings.TrimSuffix(r.URL.Path, "/"), "/")
+	teamID := ""
+	if len(pathParts) > 3 {
+		teamID = pathParts[3]
+	}
+
+	// Membership lives under /api/teams/{id}/members/{user_id}
+	if len(pathParts) > 4 {
+		if pathParts[4] != "members" || len(pathParts) != 6 {
+			http.Error(w, "Not Found", http.StatusNotFound)
+			return
+		}
+		teamMembersHandler(w, r, userID, teamID, pathParts[5])
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		var teams any
+		var err error
+		if teamID == "" {
+			teams, err = getTeams(userID)
+		} else {
+			teams, err = getTeam(userID, teamID)
+			if err == errTeamNotFound {
+				http.Error(w, err.Error(), http.StatusNotFound)
+				break
+			}
+		}
+
+		if err != nil {
+			errors.HandleServerError(w, err, "teams.go: TeamsHandler - getTeams")
+			break
+		}
+		writeJSON(w, http.StatusOK, teams)
+	case http.MethodPost:
+		// Teams are only created through /api/teams, which is routed with
+		// the permission to manage teams
+		if teamID != "" || r.URL.Path != "/api/teams" {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			break
+		}
+
+		var data teamData
+		if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+			http.Error(w, "Invalid JSON", http.StatusBadRequest)
+			break
+		}
+
+		t, err := addTeam(userID, data)
+		if err == errInvalidTeamName {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			break
+		} else if err == errTeamExists {
+			http.Error(w, err.Error(), http.StatusConflict)
+			break
+		} else if err != nil {
+			errors.HandleServerError(w, err, "teams.go: TeamsHandler - addTeam")
+			break
+		}
+		writeJSON(w, http.StatusCreated, t)
+	case http.MethodPut:
+		if teamID == "" {
+			http.Error(w, "Team ID Required", http.StatusBadRequest)
+			break
+		}
+
+		var data teamData
+		if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+			http.Error(w, "Invalid JSON", http.StatusBadRequest)
+			break
+		}
+
+		t, err := renameTeam(userID, teamID, data)
+		if err == errTeamNotFound {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			break
+		} else if err == errTeamManagersOnly {
+			forbidden(w, r, userID, err)
+			break
+		} else if err == errInvalidTeamName {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			break
+		} else if err == errTeamExists {
+			http.Error(w, err.Error(), http.StatusConflict)
+			break
+		} else if err != nil {
+			errors.HandleServerError(w, err, "teams.go: TeamsHandler - renameTeam")
+			break
+		}
+		writeJSON(w, http.StatusOK, t)
+	case http.MethodDelete:
+		if teamID == "" {
+			http.Error(w, "Team ID Required", http.StatusBadRequest)
+			break
+		}
+
+		if err := deleteTeam(userID, teamID, taskEditOptions{SourceIP: audit.SourceIP(r)}); err == errTeamNotFound {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			break
+		} else if err == errTeamManagersOnly {
+			forbidden(w, r, userID, err)
+			break
+		} else if err != nil {
+			errors.HandleServerError(w, err, "teams.go: TeamsHandler - deleteTeam")
+			break
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	case http.MethodOptions:
+		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func teamMembersHandler(w http.ResponseWriter, r *http.Request, userID uint, teamID string, memberID string) {
+	switch r.Method {
+	case http.MethodPut:
+		var data teamMemberData
+		if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+			http.Error(w, "Invalid JSON", http.StatusBadRequest)
+			break
+		}
+
+		t, err := setTeamMember(userID, teamID, memberID, data)
+		if err == errTeamNotFound {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			break
+		} else if err == errTeamManagersOnly {
+			forbidden(w, r, userID, err)
+			break
+		} else if err == errInvalidTeamRole || err == errUnknownUser {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			break
+		} else if err == errLastTeamManager {
+			http.Error(w, err.Error(), http.StatusConflict)
+			break
+		} else if err != nil {
+			errors.HandleServerError(w, err, "teams.go: teamMembersHandler - setTeamMember")
+			break
+		}
+		writeJSON(w, http.StatusOK, t)
+	case http.MethodDelete:
+		if err := removeTeamMember(userID, teamID, memberID); err == errTeamNotFound {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			break
+		} else if err == errTeamManagersOnly {
+			forbidden(w, r, userID, err)
+			break
+		} else if err == errLastTeamManager {
+			http.Error(w, err.Error(), http.StatusConflict)
+			break
+		} else if err != nil {
+			errors.HandleServerError(w, err, "teams.go: teamMembersHandler - removeTeamMember")
+			break
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	case http.MethodOptions:
+		w.Header().Set("Access-Control-Allow-Methods", "PUT, DELETE, OPTIONS")
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// taskTeamHandler moves a task into or out of a team's worklist.
+func taskTeamHandler(w http.ResponseWriter, r *http.Request, userID uint, taskID string) {
+	switch r.Method {
+	case http.MethodPut:
+		var data taskTeamData
+		if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+			http.Error(w, "Invalid JSON", http.StatusBadRequest)
+			break
+		}
+
+		t, err := setTaskTeam(userID, taskID, data.TeamID, parseTaskEditOptions(r))
+		if err == errTaskNotFound {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			break
+		} else if err == errTeamNotFound {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			break
+		} else if err == errReassignForbidden {
+			forbidden(w, r, userID, err)
+			break
+		} else if err == errPreconditionFailed {
+			http.Error(w, err.Error(), http.StatusPreconditionFailed)
+			break
+		} else if err != nil {
+			errors.HandleServerError(w, err, "teams.go: taskTeamHandler - setTaskTeam")
+			break
+		}
+
+		w.Header().Set("ETag", taskETag(t))
+		writeJSON(w, http.StatusOK, t)
+	case http.MethodOptions:
+		w.Header().Set("Access-Control-Allow-Methods", "PUT, OPTIONS")
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func normalizeTeamName(name string) (string, error) {
+	name = strings.TrimSpace(name)
+	if name == "" || utf8.RuneCountInString(name) > maxTeamNameLength {
+		return "", errInvalidTeamName
+	}
+	return name, nil
+}
+
+func normalizeTeamRole(role string) (string, error) {
+	switch role = strings.ToUpper(strings.TrimSpace(role)); role {
+	case "":
+		return teamRoleMember, nil
+	case teamRoleManager, teamRoleMember:
+		return role, nil
+	}
+	return "", errInvalidTeamRole
+}
+
+// teamRole returns the role of a user in a team, or "" if they are not a
+// member.
+func teamRole(q rowQueryer, teamID any, userID uint) (string, error) {
+	var role string
+	err := q.QueryRow("SELECT role FROM team_members WHERE team_id = ? AND user_id = ?", teamID, userID).Scan(&role)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	return role, err
+}
+
+// getTeams lists the teams the user belongs to.
+func getTeams(userID uint) ([]team, error) {
+	teams := []team{}
+
+	dbHandle, err := database.GetDBHandle()
+	if err != nil {
+		return teams, errors.AddContext(err, "teams.go: getTeams - GetDBHandle")
+	}
+
+	rows, err := dbHandle.Query(
+		"SELECT t.id, t.name, t.created_at, m.role FROM teams t JOIN team_members m ON m.team_id = t.id WHERE m.user_id = ? ORDER BY t.name",
+		userID,
+	)
+	if err != nil {
+		return teams, errors.AddContext(err, "teams.go: getTeams - Query")
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var t team
+		if err := rows.Scan(&t.ID, &t.Name, &t.CreatedAt, &t.Role); err != nil {
+			return teams, errors.AddContext(err, "teams.go: getTeams - Scan")
+		}
+		teams = append(teams, t)
+	}
+	if err := rows.Err(); err != nil {
+		return teams, errors.AddContext(err, "teams.go: getTeams - Rows")
+	}
+	return teams, nil
+}
+
+// getTeam returns a team with its members. Teams the user does not belong to
+// are reported as not found.
+func getTeam(userID uint, teamID any) (team, error) {
+	var t team
+
+	dbHandle, err := database.GetDBHandle()
+	if err != nil {
+		return t, errors.AddContext(err, "teams.go: getTeam - GetDBHandle")
+	}
+
+	err = dbHandle.QueryRow(
+		"SELECT t.id, t.name, t.created_at, m.role FROM teams t JOIN team_members m ON m.team_id = t.id WHERE t.id = ? AND m.user_id = ?",
+		teamID, userID,
+	).Scan(&t.ID, &t.Name, &t.CreatedAt, &t.Role)
+	if err == sql.ErrNoRows {
+		return t, errTeamNotFound
+	} else if err != nil {
+		return t, errors.AddContext(err, "teams.go: getTeam - QueryRow")
+	}
+
+	rows, err := dbHandle.Query(
+		"SELECT m.user_id, u.name, m.role FROM team_members m JOIN users u ON u.id = m.user_id WHERE m.team_id = ? ORDER BY u.name, m.user_id",
+		t.ID,
+	)
+	if err != nil {
+		return t, errors.AddContext(err, "teams.go: getTeam - Query")
+	}
+	defer rows.Close()
+
+	t.Members = []teamMember{}
+	for rows.Next() {
+		var m teamMember
+		if err := rows.Scan(&m.UserID, &m.Name, &m.Role); err != nil {
+			return t, errors.AddContext(err, "teams.go: getTeam - Scan")
+		}
+		t.Members = append(t.Members, m)
+	}
+	if err := rows.Err(); err != nil {
+		return t, errors.AddContext(err, "teams.go: getTeam - Rows")
+	}
+	return t, nil
+}
+
+// addTeam creates a team with the user as its first manager.
+func addTeam(userID uint, data teamData) (team, error) {
+	name, err := normalizeTeamName(data.Name)
+	if err != nil {
+		return team{}, err
+	}
+
+	dbHandle, err := database.GetDBHandle()
+	if err != nil {
+		return team{}, errors.AddContext(err, "teams.go: addTeam - GetDBHandle")
+	}
+
+	tx, err := dbHandle.Begin()
+	if err != nil {
+		return team{}, errors.AddContext(err, "teams.go: addTeam - Begin")
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec("INSERT INTO teams (name) VALUES (?)", name)
+	if isDuplicateEntry(err) {
+		return team{}, errTeamExists
+	} else if err != nil {
+		return team{}, errors.AddContext(err, "teams.go: addTeam - Exec")
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return team{}, errors.AddContext(err, "teams.go: addTeam - LastInsertId")
+	}
+
+	if _, err := tx.Exec("INSERT INTO team_members (team_id, user_id, role) VALUES (?, ?, ?)", id, userID, teamRoleManager); err != nil {
+		return team{}, errors.AddContext(err, "teams.go: addTeam - Exec member")
+	}
+
+	if err := tx.Commit(); err != nil {
+		return team{}, errors.AddContext(err, "teams.go: addTeam - Commit")
+	}
+	return getTeam(userID, id)
+}
+
+func renameTeam(userID uint, teamID string, data teamData) (team, error) {
+	name, err := normalizeTeamName(data.Name)
+	if err != nil {
+		return team{}, err
+	}
+
+	err = withTeamTx(userID, teamID, func(tx *sql.Tx) error {
+		if _, err := tx.Exec("UPDATE teams SET name = ? WHERE id = ?", name, teamID); isDuplicateEntry(err) {
+			return errTeamExists
+		} else if err != nil {
+			return errors.AddContext(err, "teams.go: renameTeam - Exec")
+		}
+		return nil
+	})
+	if err != nil {
+		return team{}, err
+	}
+	return getTeam(userID, teamID)
+}
+
+// deleteTeam removes a team. Its tasks are kept and stay with whoever
+// created or was assigned them.
+func deleteTeam(userID uint, teamID string, options taskEditOptions) error {
+	return withTeamTx(userID, teamID, func(tx *sql.Tx) error {
+		changes, err := trackTaskQuery(tx, "SELECT id FROM tasks WHERE team_id = ? ORDER BY id", teamID)
+		if err != nil {
+			return errors.AddContext(err, "teams.go: deleteTeam - trackTaskQuery")
+		}
+
+		// team_id is cleared by the foreign key
+		if _, err := tx.Exec("UPDATE tasks SET version = version + 1 WHERE team_id = ?", teamID); err != nil {
+			return errors.AddContext(err, "teams.go: deleteTeam - Exec touch")
+		}
+		if _, err := tx.Exec("DELETE FROM teams WHERE id = ?", teamID); err != nil {
+			return errors.AddContext(err, "teams.go: deleteTeam - Exec")
+		}
+		return changes.record(tx, userID, options)
+	})
+}
+
+// setTeamMember adds a user to a team or changes their role.
+func setTeamMember(userID uint, teamID string, memberID string, data teamMemberData) (team, error) {
+	role, err := normalizeTeamRole(data.Role)
+	if err != nil {
+		return team{}, err
+	}
+
+	err = withTeamTx(userID, teamID, func(tx *sql.Tx) error {
+		var exists bool
+		if err := tx.QueryRow("SELECT EXISTS(SELECT 1 FROM users WHERE id = ?)", memberID).Scan(&exists); err != nil {
+			return errors.AddContext(err, "teams.go: setTeamMember - QueryRow")
+		} else if !exists {
+			return errUnknownUser
+		}
+
+		if _, err := tx.Exec(
+			"INSERT INTO team_members (team_id, user_id, role) VALUES (?, ?, ?) ON DUPLICATE KEY UPDATE role = VALUES(role)",
+			teamID, memberID, role,
+		); err != nil {
+			return errors.AddContext(err, "teams.go: setTeamMember - Exec")
+		}
+		return checkTeamHasManager(tx, teamID)
+	})
+	if err != nil {
+		return team{}, err
+	}
+
+	// A manager who demoted themselves is still a member and can see the team
+	return getTeam(userID, teamID)
+}
+
+// removeTeamMember takes a user out of a team. Managers can remove anyone and
+// members can remove themselves. Tasks the user was working on stay assigned
+// to them.
+func removeTeamMember(userID uint, teamID string, memberID string) error {
+	self := memberID == strconv.FormatUint(uint64(userID), 10)
+
+	dbHandle, err := database.GetDBHandle()
+	if err != nil {
+		return errors.AddContext(err, "teams.go: removeTeamMember - GetDBHandle")
+	}
+
+	tx, err := dbHandle.Begin()
+	if err != nil {
+		return errors.AddContext(err, "teams.go: removeTeamMember - Begin")
+	}
+	defer tx.Rollback()
+
+	role, err := lockTeamMembership(tx, teamID, userID)
+	if err != nil {
+		return err
+	} else if role != teamRoleManager && !self {
+		return errTeamManagersOnly
+	}
+
+	result, err := tx.Exec("DELETE FROM team_members WHERE team_id = ? AND user_id = ?", teamID, memberID)
+	if err != nil {
+		return errors.AddContext(err, "teams.go: removeTeamMember - Exec")
+	}
+	if affected, err := result.RowsAffected(); err != nil {
+		return errors.AddContext(err, "teams.go: removeTeamMember - RowsAffected")
+	} else if affected == 0 {
+		return errTeamNotFound
+	}
+
+	if err := checkTeamHasManager(tx, teamID); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return errors.AddContext(err, "teams.go: removeTeamMember - Commit")
+	}
+	return nil
+}
+
+// withTeamTx runs fn in a transaction if the user manages the team. The
+// membership rows of the team are locked so concurrent changes cannot leave
+// it without a manager.
+func withTeamTx(userID uint, teamID string, fn func(*sql.Tx) error) error {
+	dbHandle, err := database.GetDBHandle()
+	if err != nil {
+		return errors.AddContext(err, "teams.go: withTeamTx - GetDBHandle")
+	}
+
+	tx, err := dbHandle.Begin()
+	if err != nil {
+		return errors.AddContext(err, "teams.go: withTeamTx - Begin")
+	}
+	defer tx.Rollback()
+
+	role, err := lockTeamMembership(tx, teamID, userID)
+	if err != nil {
+		return err
+	} else if role != teamRoleManager {
+		return errTeamManagersOnly
+	}
+
+	if err := fn(tx); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return errors.AddContext(err, "teams.go: withTeamTx - Commit")
+	}
+	return nil
+}
+
+// lockTeamMembership locks the members of a team and returns the user's role.
+// Teams the user does not belong to are reported as not found.
+func lockTeamMembership(tx *sql.Tx, teamID string, userID uint) (string, error) {
+	var members int
+	if err := tx.QueryRow("SELECT COUNT(*) FROM team_members WHERE team_id = ? FOR UPDATE", teamID).Scan(&members); err != nil {
+		return "", errors.AddContext(err, "teams.go: lockTeamMembership - QueryRow")
+	}
+
+	role, err := teamRole(tx, teamID, userID)
+	if err != nil {
+		return "", errors.AddContext(err, "teams.go: lockTeamMembership - teamRole")
+	} else if role == "" {
+		return "", errTeamNotFound
+	}
+	return role, nil
+}
+
+func checkTeamHasManager(tx *sql.Tx, teamID string) error {
+	var managers int
+	if err := tx.QueryRow("SELECT COUNT(*) FROM team_members WHERE team_id = ? AND role = ?", teamID, teamRoleManager).Scan(&managers); err != nil {
+		return errors.AddContext(err, "teams.go: checkTeamHasManager - QueryRow")
+	} else if managers == 0 {
+		return errLastTeamManager
+	}
+	return nil
+}
+
+// setTaskTeam moves a task into the worklist of a team the user belongs to,
+// or out of its team when teamID is nil. The task keeps its assignee.
+func setTaskTeam(userID uint, taskID string, teamID *uint, options taskEditOptions) (task, error) {
+	current, err := getTask(userID, taskID)
+	if err != nil {
+		return current, err
+	}
+
+	dbHandle, err := database.GetDBHandle()
+	if err != nil {
+		return current, errors.AddContext(err, "teams.go: setTaskTeam - GetDBHandle")
+	}
+
+	if ok, err := canReassignTask(dbHandle, current, userID); err != nil {
+		return current, errors.AddContext(err, "teams.go: setTaskTeam - canReassignTask")
+	} else if !ok {
+		return current, errReassignForbidden
+	}
+	if sameUser(current.TeamID, teamID) {
+		return current, nil
+	}
+
+	if teamID != nil {
+		if role, err := teamRole(dbHandle, *teamID, userID); err != nil {
+			return current, errors.AddContext(err, "teams.go: setTaskTeam - teamRole")
+		} else if role == "" {
+			return current, errTeamNotFound
+		}
+	}
+
+	tx, err := dbHandle.Begin()
+	if err != nil {
+		return current, errors.AddContext(err, "teams.go: setTaskTeam - Begin")
+	}
+	defer tx.Rollback()
+
+	changes, err := trackTasks(tx, current.ID)
+	if err != nil {
+		return current, errors.AddContext(err, "teams.go: setTaskTeam - trackTasks")
+	}
+
+	result, err := tx.Exec("UPDATE tasks SET team_id = ?, version = version + 1 WHERE id = ? AND version = ?", teamID, current.ID, current.Version)
+	if err != nil {
+		return current, errors.AddContext(err, "teams.go: setTaskTeam - Exec")
+	}
+	if affected, err := result.RowsAffected(); err != nil {
+		return current, errors.AddContext(err, "teams.go: setTaskTeam - RowsAffected")
+	} else if affected == 0 {
+		return current, errPreconditionFailed
+	}
+
+	if err := changes.record(tx, userID, options); err != nil {
+		return current, errors.AddContext(err, "teams.go: setTaskTeam - record")
+	}
+	if err := tx.Commit(); err != nil {
+		return current, errors.AddContext(err, "teams.go: setTaskTeam - Commit")
+	}
+	return getTask(userID, taskID)
+}
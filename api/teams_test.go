@@ -0,0 +1,180 @@
+package api
+
+import (
+	"HMCTS-Developer-Challenge/database"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"testing"
+)
+
+func createTestTeam(t *testing.T, userID uint, name string) team {
+	return createTestResource[team](t, TeamsHandler, "/api/teams", `{"name": "`+name+`"}`, userID, "teams")
+}
+
+func TestNormalizeTeamRole(t *testing.T) {
+	for input, expected := range map[string]string{"": teamRoleMember, "member": teamRoleMember, " Manager ": teamRoleManager} {
+		if role, err := normalizeTeamRole(input); err != nil || role != expected {
+			t.Errorf("%q: expected %q, got %q (%v)", input, expected, role, err)
+		}
+	}
+	if _, err := normalizeTeamRole("owner"); err != errInvalidTeamRole {
+		t.Errorf("Expected errInvalidTeamRole, got %v", err)
+	}
+}
+
+func TestTeamsCRUD(t *testing.T) {
+	created := createTestTeam(t, 1, "Test Family Court")
+	if created.Role != teamRoleManager || len(created.Members) != 1 || created.Members[0].UserID != 1 {
+		t.Errorf("Expected the creator to be the only manager, got %+v", created)
+	}
+	teamURL := fmt.Sprintf("/api/teams/%d", created.ID)
+
+	rr := performHandlerRequest(t, TeamsHandler, "POST", "/api/teams", []byte(`{"name": "Test Family Court"}`), nil, 2)
+	if rr.Code != http.StatusConflict {
+		t.Errorf("handler returned wrong status code for a duplicate team: got %v want %v", rr.Code, http.StatusConflict)
+	}
+
+	// Non-members cannot see the team
+	rr = performHandlerRequest(t, TeamsHandler, "GET", teamURL, nil, nil, 2)
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("handler returned wrong status code for a non-member: got %v want %v", rr.Code, http.StatusNotFound)
+	}
+
+	rr = performHandlerRequest(t, TeamsHandler, "PUT", teamURL+"/members/2", []byte(`{"role": "member"}`), nil, 1)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("handler returned wrong status code adding a member: got %v want %v", rr.Code, http.StatusOK)
+	}
+
+	// Members can see the team but not change it
+	rr = performHandlerRequest(t, TeamsHandler, "GET", "/api/teams", nil, nil, 2)
+	var teams []team
+	if err := json.Unmarshal(rr.Body.Bytes(), &teams); err != nil {
+		t.Fatalf("Failed to parse response as JSON: %v", err)
+	}
+	if !slices.ContainsFunc(teams, func(tm team) bool { return tm.ID == created.ID && tm.Role == teamRoleMember }) {
+		t.Errorf("Expected user 2 to be a member of team %d, got %+v", created.ID, teams)
+	}
+
+	rr = performHandlerRequest(t, TeamsHandler, "PUT", teamURL, []byte(`{"name": "Renamed"}`), nil, 2)
+	if rr.Code != http.StatusForbidden {
+		t.Errorf("handler returned wrong status code for a member renaming: got %v want %v", rr.Code, http.StatusForbidden)
+	}
+
+	rr = performHandlerRequest(t, TeamsHandler, "PUT", teamURL, []byte(`{"name": "Test Civil Court"}`), nil, 1)
+	if rr.Code != http.StatusOK {
+		t.Errorf("handler returned wrong status code renaming: got %v want %v", rr.Code, http.StatusOK)
+	}
+
+	// The only manager cannot step down or leave
+	rr = performHandlerRequest(t, TeamsHandler, "PUT", teamURL+"/members/1", []byte(`{"role": "member"}`), nil, 1)
+	if rr.Code != http.StatusConflict {
+		t.Errorf("handler returned wrong status code demoting the last manager: got %v want %v", rr.Code, http.StatusConflict)
+	}
+	rr = performHandlerRequest(t, TeamsHandler, "DELETE", teamURL+"/members/1", nil, nil, 1)
+	if rr.Code != http.StatusConflict {
+		t.Errorf("handler returned wrong status code removing the last manager: got %v want %v", rr.Code, http.StatusConflict)
+	}
+
+	// Members can leave by themselves
+	rr = performHandlerRequest(t, TeamsHandler, "DELETE", teamURL+"/members/2", nil, nil, 2)
+	if rr.Code != http.StatusNoContent {
+		t.Errorf("handler returned wrong status code leaving: got %v want %v", rr.Code, http.StatusNoContent)
+	}
+
+	rr = performHandlerRequest(t, TeamsHandler, "DELETE", teamURL, nil, nil, 1)
+	if rr.Code != http.StatusNoContent {
+		t.Errorf("handler returned wrong status code deleting: got %v want %v", rr.Code, http.StatusNoContent)
+	}
+}
+
+func TestTeamWorklist(t *testing.T) {
+	created := createTestTeam(t, 1, "Test Worklist Team")
+	if rr := performHandlerRequest(t, TeamsHandler, "PUT", fmt.Sprintf("/api/teams/%d/members/2", created.ID), []byte(`{}`), nil, 1); rr.Code != http.StatusOK {
+		t.Fatalf("handler returned wrong status code adding a member: got %v want %v", rr.Code, http.StatusOK)
+	}
+
+	body := fmt.Sprintf(`{"name": "Team task", "description": "", "status": "INCOMPLETE", "deadline": "2030-01-01 00:00:00", "team_id": %d}`, created.ID)
+	rr := performTaskRequest(t, "POST", "/api/tasks/", []byte(body), nil, 1)
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusCreated)
+	}
+
+	// The new task waits unassigned in the worklist of every member
+	worklist := fmt.Sprintf("/api/tasks/?team=%d&view=unassigned", created.ID)
+	_, page := getTaskPage(t, worklist, 2)
+	if len(page.Tasks) != 1 || page.Tasks[0].AssigneeID != nil {
+		t.Fatalf("Expected one unclaimed task in the worklist, got %+v", page.Tasks)
+	}
+	taskID := strconv.FormatUint(uint64(page.Tasks[0].ID), 10)
+	t.Cleanup(func() {
+		db, err := database.GetDBHandle()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := db.Exec("DELETE FROM tasks WHERE id = ?", taskID); err != nil {
+			t.Errorf("Failed to delete test task: %v", err)
+		}
+	})
+
+	rr = performTaskRequest(t, "POST", "/api/tasks/"+taskID+"/claim", nil, nil, 2)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("handler returned wrong status code claiming: got %v want %v", rr.Code, http.StatusOK)
+	}
+
+	rr = performTaskRequest(t, "POST", "/api/tasks/"+taskID+"/claim", nil, nil, 1)
+	if rr.Code != http.StatusConflict {
+		t.Errorf("handler returned wrong status code claiming a claimed task: got %v want %v", rr.Code, http.StatusConflict)
+	}
+
+	if _, page := getTaskPage(t, worklist, 1); len(page.Tasks) != 0 {
+		t.Errorf("Expected the claimed task to leave the worklist, got %+v", page.Tasks)
+	}
+
+	// The creator can still take it back from the member
+	rr = performTaskRequest(t, "PUT", "/api/tasks/"+taskID+"/assignee", []byte(`{"assignee_id": null}`), nil, 1)
+	if rr.Code != http.StatusOK {
+		t.Errorf("handler returned wrong status code for the creator reassigning: got %v want %v", rr.Code, http.StatusOK)
+	}
+
+	// Once user 2 leaves the team the task is out of their reach
+	if rr := performHandlerRequest(t, TeamsHandler, "DELETE", fmt.Sprintf("/api/teams/%d/members/2", created.ID), nil, nil, 2); rr.Code != http.StatusNoContent {
+		t.Fatalf("handler returned wrong status code leaving: got %v want %v", rr.Code, http.StatusNoContent)
+	}
+	rr = performTaskRequest(t, "GET", "/api/tasks/"+taskID, nil, nil, 2)
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("handler returned wrong status code for a former member: got %v want %v", rr.Code, http.StatusNotFound)
+	}
+
+	// Tasks can only be put in teams the user belongs to
+	body = `{"name": "Team task", "description": "", "status": "INCOMPLETE", "deadline": "2030-01-01 00:00:00", "team_id": 999999}`
+	if rr := performTaskRequest(t, "POST", "/api/tasks/", []byte(body), nil, 1); rr.Code != http.StatusBadRequest {
+		t.Errorf("handler returned wrong status code for an unknown team: got %v want %v", rr.Code, http.StatusBadRequest)
+	}
+}
+
+func TestSetTaskTeam(t *testing.T) {
+	created := createTestTeam(t, 1, "Test Handover Team")
+	taskID := createTestTask(t, 1, "Personal task")
+
+	rr := performTaskRequest(t, "PUT", "/api/tasks/"+taskID+"/team", []byte(fmt.Sprintf(`{"team_id": %d}`, created.ID)), nil, 1)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusOK)
+	}
+
+	var moved task
+	if err := json.Unmarshal(rr.Body.Bytes(), &moved); err != nil {
+		t.Fatalf("Failed to parse response as JSON: %v", err)
+	}
+	if moved.TeamID == nil || *moved.TeamID != created.ID || moved.AssigneeID == nil || *moved.AssigneeID != 1 {
+		t.Errorf("Expected the task to move to team %d and keep its assignee, got %+v", created.ID, moved)
+	}
+
+	// User 2 is not a member of the team and cannot see the task
+	rr = performTaskRequest(t, "PUT", "/api/tasks/"+taskID+"/team", []byte(`{"team_id": null}`), nil, 2)
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("handler returned wrong status code for a non-member: got %v want %v", rr.Code, http.StatusNotFound)
+	}
+}
@@ -0,0 +1,175 @@
+package api
+
+import (
+	"HMCTS-Developer-Challenge/database"
+	"HMCTS-Developer-Challenge/errors"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	// Europe/London has to load wherever the server runs, with or without
+	// zoneinfo installed
+	_ "time/tzdata"
+)
+
+// defaultTimezone is the zone times are shown in until a user picks their
+// own. It is also the zone of the courts, in which deadlines are counted.
+const defaultTimezone = "Europe/London"
+
+const maxTimezoneLength = 64
+
+// courtLocation keeps the wall-clock time of deadlines counted in days from
+// a hearing or from now across the changes to and from British Summer Time.
+var courtLocation = mustLoadLocation(defaultTimezone)
+
+var errInvalidTimezone = errors.Error("Invalid Timezone")
+var errInvalidDeadline = errors.Error("Invalid Deadline")
+
+type timezoneData struct {
+	// Timezone is an IANA zone name such as "Europe/London". An empty value
+	// goes back to the default.
+	Timezone string `json:"timezone"`
+}
+
+func mustLoadLocation(name string) *time.Location {
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		panic(err)
+	}
+	return loc
+}
+
+// TimezoneHandler reads and sets the zone the current user's times are shown
+// in, and in which times they give without an offset are read.
+func TimezoneHandler(w http.ResponseWriter, r *http.Request, userID uint) {
+	switch r.Method {
+	case http.MethodGet:
+		loc, err := userLocation(userID)
+		if err != nil {
+			errors.HandleServerError(w, err, "timezones.go: TimezoneHandler - userLocation")
+			break
+		}
+		writeJSON(w, http.StatusOK, timezoneData{Timezone: loc.String()})
+	case http.MethodPut:
+		var data timezoneData
+		if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+			http.Error(w, "Invalid JSON", http.StatusBadRequest)
+			break
+		}
+
+		loc, err := setUserTimezone(userID, data.Timezone)
+		if err == errInvalidTimezone {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			break
+		} else if err != nil {
+			errors.HandleServerError(w, err, "timezones.go: TimezoneHandler - setUserTimezone")
+			break
+		}
+		writeJSON(w, http.StatusOK, timezoneData{Timezone: loc.String()})
+	case http.MethodOptions:
+		w.Header().Set("Access-Control-Allow-Methods", "GET, PUT, OPTIONS")
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// loadTimezone finds a zone by its IANA name, giving the default for an empty
+// name. "Local" is refused since it means whatever zone the server runs in.
+func loadTimezone(name string) (*time.Location, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return courtLocation, nil
+	}
+	if name == "Local" || len(name) > maxTimezoneLength {
+		return nil, errInvalidTimezone
+	}
+
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		return nil, errInvalidTimezone
+	}
+	return loc, nil
+}
+
+// userLocation returns the zone a user has chosen, or the default. A zone
+// that can no longer be loaded also falls back to the default.
+func userLocation(userID uint) (*time.Location, error) {
+	dbHandle, err := database.GetDBHandle()
+	if err != nil {
+		return courtLocation, errors.AddContext(err, "timezones.go: userLocation - GetDBHandle")
+	}
+
+	var name sql.NullString
+	if err := dbHandle.QueryRow("SELECT timezone FROM users WHERE id = ?", userID).Scan(&name); err == sql.ErrNoRows {
+		return courtLocation, nil
+	} else if err != nil {
+		return courtLocation, errors.AddContext(err, "timezones.go: userLocation - QueryRow")
+	}
+
+	loc, err := loadTimezone(name.String)
+	if err != nil {
+		return courtLocation, nil
+	}
+	return loc, nil
+}
+
+func setUserTimezone(userID uint, name string) (*time.Location, error) {
+	loc, err := loadTimezone(name)
+	if err != nil {
+		return nil, err
+	}
+
+	dbHandle, err := database.GetDBHandle()
+	if err != nil {
+		return nil, errors.AddContext(err, "timezones.go: setUserTimezone - GetDBHandle")
+	}
+
+	// The default is stored as NULL so that users who never chose a zone
+	// follow it if it changes
+	var stored *string
+	if strings.TrimSpace(name) != "" {
+		s := loc.String()
+		stored = &s
+	}
+	if _, err := dbHandle.Exec("UPDATE users SET timezone = ? WHERE id = ?", stored, userID); err != nil {
+		return nil, errors.AddContext(err, "timezones.go: setUserTimezone - Exec")
+	}
+	return loc, nil
+}
+
+// parseDeadline reads the deadline given for a task, in the user's zone when
+// it has no offset.
+func parseDeadline(userID uint, value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, errMissingJsonData
+	}
+
+	loc, err := userLocation(userID)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	deadline, ok := parseTaskTime(value, loc)
+	if !ok {
+		return time.Time{}, errInvalidDeadline
+	}
+	return deadline, nil
+}
+
+// localizeTasks shows the times of tasks in the zone of the user reading
+// them.
+func localizeTasks(userID uint, tasks ...*task) error {
+	loc, err := userLocation(userID)
+	if err != nil {
+		return err
+	}
+
+	for _, t := range tasks {
+		t.CreatedAt = t.CreatedAt.In(loc)
+		t.Deadline = t.Deadline.In(loc)
+	}
+	return nil
+}
@@ -0,0 +1,132 @@
+package api
+
+import (
+	"HMCTS-Developer-Challenge/database"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func performTimezoneRequest(t *testing.T, method, body string, userID uint) *httptest.ResponseRecorder {
+	req, err := http.NewRequest(method, "/api/timezone", strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr := httptest.NewRecorder()
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		TimezoneHandler(w, r, userID)
+	})
+	handler.ServeHTTP(rr, req)
+	return rr
+}
+
+func TestParseTaskTime(t *testing.T) {
+	newYork := mustLoadLocation("America/New_York")
+	tests := []struct {
+		value    string
+		loc      *time.Location
+		expected string
+	}{
+		// Times without an offset are read in the zone given
+		{"2025-06-09 10:00:00", courtLocation, "2025-06-09T09:00:00Z"},
+		{"2025-12-09T10:00", courtLocation, "2025-12-09T10:00:00Z"},
+		{"2025-06-09", courtLocation, "2025-06-08T23:00:00Z"},
+		{"2025-06-09T10:00:00", newYork, "2025-06-09T14:00:00Z"},
+		// An offset wins over the zone
+		{"2025-06-09T10:00:00+02:00", newYork, "2025-06-09T08:00:00Z"},
+		{"2025-06-09T10:00:00.123Z", courtLocation, "2025-06-09T10:00:00Z"},
+	}
+	for _, tc := range tests {
+		got, ok := parseTaskTime(tc.value, tc.loc)
+		if !ok || got.Format(time.RFC3339) != tc.expected {
+			t.Errorf("%s in %s: expected %s, got %s (%v)", tc.value, tc.loc, tc.expected, got.Format(time.RFC3339), ok)
+		}
+	}
+
+	for _, invalid := range []string{"", "soon", "2025-02-30 10:00:00", "09/06/2025"} {
+		if _, ok := parseTaskTime(invalid, courtLocation); ok {
+			t.Errorf("%q: expected an invalid time", invalid)
+		}
+	}
+}
+
+func TestLoadTimezone(t *testing.T) {
+	if loc, err := loadTimezone(" "); err != nil || loc != courtLocation {
+		t.Errorf("Expected the default zone, got %v (%v)", loc, err)
+	}
+	if loc, err := loadTimezone("America/New_York"); err != nil || loc.String() != "America/New_York" {
+		t.Errorf("Expected New York, got %v (%v)", loc, err)
+	}
+	for _, invalid := range []string{"Local", "Mars/Olympus_Mons", strings.Repeat("A", maxTimezoneLength+1)} {
+		if _, err := loadTimezone(invalid); err != errInvalidTimezone {
+			t.Errorf("%q: expected %v, got %v", invalid, errInvalidTimezone, err)
+		}
+	}
+}
+
+func TestUserTimezone(t *testing.T) {
+	db, err := database.GetDBHandle()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		db.Exec("UPDATE users SET timezone = NULL WHERE id = 2")
+		db.Exec("DELETE FROM tasks WHERE name = 'Task in New York'")
+	})
+
+	rr := performTimezoneRequest(t, "GET", "", 2)
+	if rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), `"Europe/London"`) {
+		t.Fatalf("Expected the default zone, got %v %s", rr.Code, rr.Body.String())
+	}
+
+	rr = performTimezoneRequest(t, "PUT", `{"timezone": "America/New_York"}`, 2)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v, %s", rr.Code, http.StatusOK, rr.Body.String())
+	}
+	if rr := performTimezoneRequest(t, "PUT", `{"timezone": "Mars/Olympus_Mons"}`, 2); rr.Code != http.StatusBadRequest {
+		t.Errorf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusBadRequest)
+	}
+
+	// Deadlines are shown in the user's zone with its offset
+	taskID := createTestTask(t, 2, "Task shown in New York")
+	rr = performTaskRequest(t, "GET", "/api/tasks/"+taskID, nil, nil, 2)
+	etag := rr.Header().Get("ETag")
+	if !strings.Contains(rr.Body.String(), `"deadline":"2025-12-30T19:00:00-05:00"`) {
+		t.Errorf("Expected the deadline in New York time, got %s", rr.Body.String())
+	}
+
+	// and times given without an offset are read in it
+	body := []byte(`{"name": "Task in New York", "status": "INCOMPLETE", "deadline": "2026-03-01T09:00"}`)
+	if rr := performTaskRequest(t, "POST", "/api/tasks", body, nil, 2); rr.Code != http.StatusCreated {
+		t.Fatalf("handler returned wrong status code: got %v want %v, %s", rr.Code, http.StatusCreated, rr.Body.String())
+	}
+	var stored time.Time
+	if err := db.QueryRow("SELECT deadline FROM tasks WHERE name = 'Task in New York'").Scan(&stored); err != nil {
+		t.Fatal(err)
+	}
+	if got := stored.Format(time.RFC3339); got != "2026-03-01T14:00:00Z" {
+		t.Errorf("Expected the deadline stored in UTC, got %s", got)
+	}
+
+	body = []byte(`{"name": "Task in New York", "status": "INCOMPLETE", "deadline": "next Tuesday"}`)
+	if rr := performTaskRequest(t, "POST", "/api/tasks", body, nil, 2); rr.Code != http.StatusBadRequest {
+		t.Errorf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusBadRequest)
+	}
+	patch := []byte(`{"deadline": "2026-13-01"}`)
+	if rr := performTaskRequest(t, "PATCH", "/api/tasks/"+taskID, patch, map[string]string{"If-Match": etag}, 2); rr.Code != http.StatusBadRequest {
+		t.Errorf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusBadRequest)
+	}
+
+	rr = performTimezoneRequest(t, "PUT", `{"timezone": ""}`, 2)
+	if rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), `"Europe/London"`) {
+		t.Errorf("Expected the default zone back, got %v %s", rr.Code, rr.Body.String())
+	}
+
+	// A copy rendered in the old zone is no longer current
+	if rr := performTaskRequest(t, "GET", "/api/tasks/"+taskID, nil, map[string]string{"If-None-Match": etag}, 2); rr.Code != http.StatusOK {
+		t.Errorf("handler returned wrong status code after changing zone: got %v want %v", rr.Code, http.StatusOK)
+	}
+}
@@ -0,0 +1,400 @@
+package api
+
+import (
+	"HMCTS-Developer-Challenge/database"
+	"HMCTS-Developer-Challenge/errors"
+	"database/sql"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const defaultTrashRetentionDays = 30
+
+// trashRetention is how long deleted tasks stay in the trash before they are
+// purged.
+var trashRetention = defaultTrashRetentionDays * 24 * time.Hour
+
+var errTaskNotInTrash = errors.Error("Task Not Found In Trash")
+var errDeletedWithParent = errors.Error("Task Was Deleted With Its Parent Task")
+var errParentInTrash = errors.Error("Parent Task Is In The Trash")
+
+// trashedTask is a task that was deleted, with when it will be purged.
+// Subtasks counts the subtasks that went to the trash with it and come back
+// when it is restored.
+type trashedTask struct {
+	task
+	DeletedAt time.Time `json:"deleted_at"`
+	DeletedBy *uint     `json:"deleted_by"`
+	PurgeAt   time.Time `json:"purge_at"`
+	Subtasks  int       `json:"subtasks"`
+}
+
+// SetTrashRetention sets how many days deleted tasks are kept in the trash
+// before they are purged. An empty value keeps the default.
+func SetTrashRetention(value string) error {
+	if value == "" {
+		trashRetention = defaultTrashRetentionDays * 24 * time.Hour
+		return nil
+	}
+
+	days, err := strconv.Atoi(value)
+	if err != nil || days < 1 {
+		return errors.Errorf("trash.go: SetTrashRetention - invalid number of days %q", value)
+	}
+	trashRetention = time.Duration(days) * 24 * time.Hour
+	return nil
+}
+
+// TrashHandler lists the deleted tasks under /api/tasks/trash, restores one
+// with POST /api/tasks/trash/{id}/restore and deletes one for good with
+// DELETE /api/tasks/trash/{id}.
+func TrashHandler(w http.ResponseWriter, r *http.Request, userID uint) {
+	pathParts := strings.Split(strings.TrimSuffix(r.URL.Path, "/"), "/")
+	taskID, action := "", ""
+	if len(pathParts) > 4 {
+		taskID = pathParts[4]
+	}
+	if len(pathParts) > 5 {
+		action = pathParts[5]
+	}
+	if len(pathParts) > 6 || (action != "" && action != "restore") {
+		http.Error(w, "Not Found", http.StatusNotFound)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		if taskID != "" {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			break
+		}
+
+		trash, err := getTrash(userID)
+		if err != nil {
+			errors.HandleServerError(w, err, "trash.go: TrashHandler - getTrash")
+			break
+		}
+		writeJSON(w, http.StatusOK, trash)
+	case http.MethodPost:
+		if taskID == "" || action != "restore" {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			break
+		}
+
+		t, err := restoreTask(userID, taskID, parseTaskEditOptions(r))
+		if err == errTaskNotInTrash || err == errTaskNotFound {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			break
+		} else if err == errDeletedWithParent || err == errParentInTrash {
+			http.Error(w, err.Error(), http.StatusConflict)
+			break
+		} else if err != nil {
+			errors.HandleServerError(w, err, "trash.go: TrashHandler - restoreTask")
+			break
+		}
+
+		w.Header().Set("ETag", taskETag(t))
+		writeJSON(w, http.StatusOK, t)
+	case http.MethodDelete:
+		if taskID == "" || action != "" {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			break
+		}
+
+		if err := purgeTask(userID, taskID); err == errTaskNotInTrash {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			break
+		} else if err == errDeletedWithParent {
+			http.Error(w, err.Error(), http.StatusConflict)
+			break
+		} else if err != nil {
+			errors.HandleServerError(w, err, "trash.go: TrashHandler - purgeTask")
+			break
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	case http.MethodOptions:
+		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, DELETE, OPTIONS")
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// getTrash lists the tasks the user deleted or could see before they were
+// deleted, most recently deleted first. Subtasks that were deleted along with
+// their parent are only counted under it.
+func getTrash(userID uint) ([]trashedTask, error) {
+	trash := []trashedTask{}
+
+	dbHandle, err := database.GetDBHandle()
+	if err != nil {
+		return trash, errors.AddContext(err, "trash.go: getTrash - GetDBHandle")
+	}
+
+	loc, err := userLocation(userID)
+	if err != nil {
+		return trash, errors.AddContext(err, "trash.go: getTrash - userLocation")
+	}
+
+	ownership, args := taskOwnership(userID)
+	rows, err := dbHandle.Query(
+		"SELECT "+taskColumns+", deleted_at, deleted_by, "+
+			"(SELECT COUNT(*) FROM tasks s WHERE s.deleted_with = tasks.id AND s.id <> tasks.id) "+
+			"FROM tasks WHERE deleted_with = id AND "+ownership+" ORDER BY deleted_at DESC, id DESC",
+		args...,
+	)
+	if err != nil {
+		return trash, errors.AddContext(err, "trash.go: getTrash - Query")
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var item trashedTask
+		if item.task, err = scanTask(rows, &item.DeletedAt, &item.DeletedBy, &item.Subtasks); err != nil {
+			return trash, errors.AddContext(err, "trash.go: getTrash - Scan")
+		}
+		item.PurgeAt = item.DeletedAt.Add(trashRetention).In(loc)
+		item.DeletedAt = item.DeletedAt.In(loc)
+		trash = append(trash, item)
+	}
+	if err := rows.Err(); err != nil {
+		return trash, errors.AddContext(err, "trash.go: getTrash - Rows")
+	}
+
+	tasks := make([]*task, len(trash))
+	for i := range trash {
+		tasks[i] = &trash[i].task
+	}
+	if err := loadTaskDetails(userID, tasks...); err != nil {
+		return trash, errors.AddContext(err, "trash.go: getTrash - loadTaskDetails")
+	}
+	return trash, nil
+}
+
+// findTrashedTask locks a task in the trash that the user can see, returning
+// its parent. Only a task that was deleted itself can be restored or purged,
+// not a subtask that went with it.
+func findTrashedTask(tx *sql.Tx, userID uint, taskID string) (uint, *uint, error) {
+	var id uint
+	var deletedWith, parentID *uint
+	ownership, args := taskOwnership(userID)
+	err := tx.QueryRow(
+		"SELECT id, deleted_with, parent_id FROM tasks WHERE id = ? AND deleted_at IS NOT NULL AND "+ownership+" FOR UPDATE",
+		append([]any{taskID}, args...)...,
+	).Scan(&id, &deletedWith, &parentID)
+	if err == sql.ErrNoRows {
+		return 0, nil, errTaskNotInTrash
+	} else if err != nil {
+		return 0, nil, err
+	}
+
+	if deletedWith == nil || *deletedWith != id {
+		return 0, nil, errDeletedWithParent
+	}
+	return id, parentID, nil
+}
+
+// restoreTask takes a task and the subtasks deleted with it out of the trash.
+// A subtask can only come back under a parent that is not in the trash.
+func restoreTask(userID uint, taskID string, options taskEditOptions) (task, error) {
+	dbHandle, err := database.GetDBHandle()
+	if err != nil {
+		return task{}, errors.AddContext(err, "trash.go: restoreTask - GetDBHandle")
+	}
+
+	tx, err := dbHandle.Begin()
+	if err != nil {
+		return task{}, errors.AddContext(err, "trash.go: restoreTask - Begin")
+	}
+	defer tx.Rollback()
+
+	id, parentID, err := findTrashedTask(tx, userID, taskID)
+	if err == errTaskNotInTrash || err == errDeletedWithParent {
+		return task{}, err
+	} else if err != nil {
+		return task{}, errors.AddContext(err, "trash.go: restoreTask - findTrashedTask")
+	}
+
+	if parentID != nil {
+		var trashed bool
+		if err := tx.QueryRow("SELECT deleted_at IS NOT NULL FROM tasks WHERE id = ? FOR UPDATE", *parentID).Scan(&trashed); err != nil {
+			return task{}, errors.AddContext(err, "trash.go: restoreTask - QueryRow parent")
+		}
+		if trashed {
+			return task{}, errParentInTrash
+		}
+	}
+
+	changes, err := trackTaskQuery(tx, "SELECT id FROM tasks WHERE deleted_with = ? ORDER BY id", id)
+	if err != nil {
+		return task{}, errors.AddContext(err, "trash.go: restoreTask - trackTaskQuery")
+	}
+
+	if _, err := tx.Exec(
+		"UPDATE tasks SET deleted_at = NULL, deleted_by = NULL, deleted_with = NULL, version = version + 1 WHERE deleted_with = ?", id,
+	); err != nil {
+		return task{}, errors.AddContext(err, "trash.go: restoreTask - Exec")
+	}
+
+	if parentID != nil {
+		if err := touchTask(tx, *parentID); err != nil {
+			return task{}, errors.AddContext(err, "trash.go: restoreTask - touchTask")
+		}
+	}
+
+	options.Undelete = true
+	if err := changes.record(tx, userID, options); err != nil {
+		return task{}, errors.AddContext(err, "trash.go: restoreTask - record")
+	}
+
+	if err := tx.Commit(); err != nil {
+		return task{}, errors.AddContext(err, "trash.go: restoreTask - Commit")
+	}
+
+	t, err := getTask(userID, taskID)
+	if err == errTaskNotFound {
+		return t, err
+	} else if err != nil {
+		return t, errors.AddContext(err, "trash.go: restoreTask - getTask")
+	}
+	return t, nil
+}
+
+// purgeTask deletes a task in the trash for good, along with its subtasks and
+// attachments. Its history is kept.
+func purgeTask(userID uint, taskID string) error {
+	dbHandle, err := database.GetDBHandle()
+	if err != nil {
+		return errors.AddContext(err, "trash.go: purgeTask - GetDBHandle")
+	}
+
+	tx, err := dbHandle.Begin()
+	if err != nil {
+		return errors.AddContext(err, "trash.go: purgeTask - Begin")
+	}
+	defer tx.Rollback()
+
+	id, _, err := findTrashedTask(tx, userID, taskID)
+	if err == errTaskNotInTrash || err == errDeletedWithParent {
+		return err
+	} else if err != nil {
+		return errors.AddContext(err, "trash.go: purgeTask - findTrashedTask")
+	}
+
+	attachmentKeys, err := removeTask(tx, id)
+	if err != nil {
+		return errors.AddContext(err, "trash.go: purgeTask - removeTask")
+	}
+
+	if err := tx.Commit(); err != nil {
+		return errors.AddContext(err, "trash.go: purgeTask - Commit")
+	}
+
+	removeBlobs(attachmentKeys...)
+	return nil
+}
+
+// removeTask deletes a task from the database and returns the blobs of its
+// attachments, to be removed once the transaction commits.
+func removeTask(tx *sql.Tx, taskID uint) ([]string, error) {
+	// Subtasks and attachments are removed along with the task by the
+	// foreign keys, but the contents of the attachments have to be removed
+	// from the blob store once that is done.
+	attachmentKeys, err := taskAttachmentKeys(tx, taskID)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := tx.Exec("DELETE FROM tasks WHERE id = ?", taskID); err != nil {
+		return nil, err
+	}
+	return attachmentKeys, nil
+}
+
+// purgeTrash deletes for good the tasks that were put in the trash before
+// cutoff and returns how many it deleted, not counting their subtasks.
+func purgeTrash(cutoff time.Time) (int, error) {
+	dbHandle, err := database.GetDBHandle()
+	if err != nil {
+		return 0, errors.AddContext(err, "trash.go: purgeTrash - GetDBHandle")
+	}
+
+	rows, err := dbHandle.Query("SELECT id FROM tasks WHERE deleted_with = id AND deleted_at < ? ORDER BY deleted_at, id", cutoff)
+	if err != nil {
+		return 0, errors.AddContext(err, "trash.go: purgeTrash - Query")
+	}
+	var ids []uint
+	for rows.Next() {
+		var id uint
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return 0, errors.AddContext(err, "trash.go: purgeTrash - Scan")
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, errors.AddContext(err, "trash.go: purgeTrash - Rows")
+	}
+
+	// Each task goes in its own transaction, checking again that it is still
+	// in the trash, since it may have been restored in the meantime
+	purged := 0
+	for _, id := range ids {
+		removed, err := purgeExpiredTask(dbHandle, id, cutoff)
+		if err != nil {
+			return purged, errors.AddContext(err, "trash.go: purgeTrash - purgeExpiredTask")
+		}
+		if removed {
+			purged++
+		}
+	}
+	return purged, nil
+}
+
+func purgeExpiredTask(dbHandle *sql.DB, taskID uint, cutoff time.Time) (bool, error) {
+	tx, err := dbHandle.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	var expired bool
+	if err := tx.QueryRow(
+		"SELECT EXISTS(SELECT 1 FROM tasks WHERE id = ? AND deleted_with = id AND deleted_at < ? FOR UPDATE)", taskID, cutoff,
+	).Scan(&expired); err != nil || !expired {
+		return false, err
+	}
+
+	attachmentKeys, err := removeTask(tx, taskID)
+	if err != nil {
+		return false, err
+	}
+	if err := tx.Commit(); err != nil {
+		return false, err
+	}
+
+	removeBlobs(attachmentKeys...)
+	return true, nil
+}
+
+// TrashPurgeRoutine deletes for good, every hour, the tasks that have been in
+// the trash for longer than the retention period.
+func TrashPurgeRoutine() {
+	ticker := time.NewTicker(time.Hour)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		purged, err := purgeTrash(time.Now().UTC().Add(-trashRetention))
+		if err != nil {
+			log.Printf("Error: %s\n", errors.AddContext(err, "trash.go: TrashPurgeRoutine - purgeTrash"))
+			continue
+		}
+		if purged > 0 {
+			log.Printf("Purged %d tasks from the trash\n", purged)
+		}
+	}
+}
@@ -0,0 +1,167 @@
+package api
+
+import (
+	"HMCTS-Developer-Challenge/database"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func performTrashRequest(t *testing.T, method, url string, userID uint) *httptest.ResponseRecorder {
+	req, err := http.NewRequest(method, url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr := httptest.NewRecorder()
+	TrashHandler(rr, req, userID)
+	return rr
+}
+
+func trashListing(t *testing.T, userID uint, taskID string) *trashedTask {
+	rr := performTrashRequest(t, "GET", "/api/tasks/trash", userID)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusOK)
+	}
+	var trash []trashedTask
+	if err := json.Unmarshal(rr.Body.Bytes(), &trash); err != nil {
+		t.Fatalf("Failed to parse response as JSON: %v", err)
+	}
+	for _, item := range trash {
+		if strconv.FormatUint(uint64(item.ID), 10) == taskID {
+			return &item
+		}
+	}
+	return nil
+}
+
+func TestSetTrashRetention(t *testing.T) {
+	defer SetTrashRetention("")
+
+	if err := SetTrashRetention("7"); err != nil || trashRetention != 7*24*time.Hour {
+		t.Errorf("Expected 7 days, got %v (%v)", trashRetention, err)
+	}
+	for _, value := range []string{"0", "-1", "forever"} {
+		if err := SetTrashRetention(value); err == nil {
+			t.Errorf("%q: expected an error", value)
+		}
+	}
+	if err := SetTrashRetention(""); err != nil || trashRetention != defaultTrashRetentionDays*24*time.Hour {
+		t.Errorf("Expected the default retention, got %v (%v)", trashRetention, err)
+	}
+}
+
+func TestTrashRestore(t *testing.T) {
+	parentID := createTestTask(t, 1, "Draft skeleton argument")
+	subtask := createTestSubtask(t, parentID, "Check authorities")
+	subtaskID := strconv.FormatUint(uint64(subtask.ID), 10)
+
+	rr := performTaskRequest(t, "DELETE", "/api/tasks/"+parentID, nil, map[string]string{"If-Match": "*"}, 1)
+	if rr.Code != http.StatusNoContent {
+		t.Fatalf("handler returned wrong status code: got %v want %v, %s", rr.Code, http.StatusNoContent, rr.Body.String())
+	}
+
+	for _, id := range []string{parentID, subtaskID} {
+		if rr := performTaskRequest(t, "GET", "/api/tasks/"+id, nil, nil, 1); rr.Code != http.StatusNotFound {
+			t.Errorf("Expected task %s to be hidden, got %v", id, rr.Code)
+		}
+	}
+
+	item := trashListing(t, 1, parentID)
+	if item == nil {
+		t.Fatal("Expected the task in the trash")
+	}
+	if item.Subtasks != 1 || item.DeletedBy == nil || *item.DeletedBy != 1 || !item.PurgeAt.Equal(item.DeletedAt.Add(trashRetention)) {
+		t.Errorf("Unexpected trashed task %+v", item)
+	}
+	if trashListing(t, 1, subtaskID) != nil {
+		t.Error("Expected the subtask to be listed only under its parent")
+	}
+	if trashListing(t, 2, parentID) != nil {
+		t.Error("Expected another caseworker not to see the task in the trash")
+	}
+
+	if rr := performTrashRequest(t, "POST", "/api/tasks/trash/"+subtaskID+"/restore", 1); rr.Code != http.StatusConflict {
+		t.Errorf("Expected the subtask not to be restored on its own, got %v", rr.Code)
+	}
+
+	rr = performTrashRequest(t, "POST", "/api/tasks/trash/"+parentID+"/restore", 1)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v, %s", rr.Code, http.StatusOK, rr.Body.String())
+	}
+	var restored task
+	if err := json.Unmarshal(rr.Body.Bytes(), &restored); err != nil {
+		t.Fatalf("Failed to parse response as JSON: %v", err)
+	}
+	if restored.Progress.Subtasks.Total != 1 {
+		t.Errorf("Expected the subtask to come back, got %+v", restored.Progress)
+	}
+	if rr := performTaskRequest(t, "GET", "/api/tasks/"+subtaskID, nil, nil, 1); rr.Code != http.StatusOK {
+		t.Errorf("Expected the subtask to be restored, got %v", rr.Code)
+	}
+
+	rr = performTaskRequest(t, "GET", "/api/tasks/"+parentID+"/history", nil, nil, 1)
+	var events []taskEvent
+	if err := json.Unmarshal(rr.Body.Bytes(), &events); err != nil {
+		t.Fatalf("Failed to parse response as JSON: %v", err)
+	}
+	if n := len(events); n < 2 || events[n-2].Action != taskEventDelete || events[n-1].Action != taskEventUndelete {
+		t.Errorf("Expected DELETE then UNDELETE events, got %+v", events)
+	}
+
+	if rr := performTrashRequest(t, "POST", "/api/tasks/trash/"+parentID+"/restore", 1); rr.Code != http.StatusNotFound {
+		t.Errorf("Expected a task out of the trash not to be restored again, got %v", rr.Code)
+	}
+}
+
+func TestTrashPurge(t *testing.T) {
+	db, err := database.GetDBHandle()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	purgedID := createTestTask(t, 1, "Serve witness summons")
+	keptID := createTestTask(t, 1, "File certificate of service")
+	for _, id := range []string{purgedID, keptID} {
+		if rr := performTaskRequest(t, "DELETE", "/api/tasks/"+id, nil, map[string]string{"If-Match": versionETag(1, courtLocation)}, 1); rr.Code != http.StatusNoContent {
+			t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusNoContent)
+		}
+	}
+
+	// Backdate one of them past the retention period
+	if _, err := db.Exec("UPDATE tasks SET deleted_at = ? WHERE id = ?", time.Now().UTC().Add(-trashRetention-time.Hour), purgedID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := purgeTrash(time.Now().UTC().Add(-trashRetention)); err != nil {
+		t.Fatal(err)
+	}
+
+	var remaining []string
+	rows, err := db.Query("SELECT id FROM tasks WHERE id IN (?, ?)", purgedID, keptID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			t.Fatal(err)
+		}
+		remaining = append(remaining, id)
+	}
+	if len(remaining) != 1 || remaining[0] != keptID {
+		t.Errorf("Expected only task %s to be kept, got %v", keptID, remaining)
+	}
+
+	if rr := performTrashRequest(t, "DELETE", "/api/tasks/trash/"+keptID, 2); rr.Code != http.StatusNotFound {
+		t.Errorf("Expected another caseworker not to purge the task, got %v", rr.Code)
+	}
+	if rr := performTrashRequest(t, "DELETE", "/api/tasks/trash/"+keptID, 1); rr.Code != http.StatusNoContent {
+		t.Errorf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusNoContent)
+	}
+	if trashListing(t, 1, keptID) != nil {
+		t.Error("Expected the purged task to be gone from the trash")
+	}
+}
@@ -0,0 +1,195 @@
+package api
+
+import (
+	"HMCTS-Developer-Challenge/database"
+	"HMCTS-Developer-Challenge/errors"
+	"net/http"
+)
+
+// What happens to the tasks assigned to a user when the user is deleted.
+// Either way the tasks themselves are kept.
+const (
+	// userDeletionOrphan leaves the tasks unassigned.
+	userDeletionOrphan = "orphan"
+	// userDeletionReassign hands each task back to the user who created it,
+	// falling back to unassigned when that is the deleted user.
+	userDeletionReassign = "reassign"
+	// userDeletionTransfer hands every task to a chosen user. It can only be
+	// picked when deleting a user, as it needs someone to hand the tasks to.
+	userDeletionTransfer = "transfer"
+)
+
+var userDeletionPolicy = userDeletionOrphan
+
+type user struct {
+	ID   uint   `json:"id"`
+	Name string `json:"name"`
+	Role string `json:"role"`
+}
+
+// SetUserDeletionPolicy sets how the tasks of deleted users are handled, either
+// "orphan" or "reassign". An empty value keeps the default of "orphan".
+func SetUserDeletionPolicy(value string) error {
+	switch value {
+	case "":
+		userDeletionPolicy = userDeletionOrphan
+	case userDeletionOrphan, userDeletionReassign:
+		userDeletionPolicy = value
+	default:
+		return errors.Errorf("users.go: SetUserDeletionPolicy - invalid policy %q", value)
+	}
+	return nil
+}
+
+// UsersHandler lists the users that tasks can be assigned to, leaving out
+// disabled accounts.
+func UsersHandler(w http.ResponseWriter, r *http.Request, _ uint) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	users, err := getUsers()
+	if err != nil {
+		errors.HandleServerError(w, err, "users.go: UsersHandler - getUsers")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, users)
+}
+
+func getUsers() ([]user, error) {
+	users := []user{}
+
+	dbHandle, err := database.GetDBHandle()
+	if err != nil {
+		return users, errors.AddContext(err, "users.go: getUsers - GetDBHandle")
+	}
+
+	rows, err := dbHandle.Query("SELECT id, name, role FROM users WHERE NOT disabled ORDER BY name, id")
+	if err != nil {
+		return users, errors.AddContext(err, "users.go: getUsers - Query")
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var u user
+		if err := rows.Scan(&u.ID, &u.Name, &u.Role); err != nil {
+			return users, errors.AddContext(err, "users.go: getUsers - Scan")
+		}
+		users = append(users, u)
+	}
+	if err := rows.Err(); err != nil {
+		return users, errors.AddContext(err, "users.go: getUsers - Rows")
+	}
+	return users, nil
+}
+
+// deleteUser removes a user and applies a deletion policy to the tasks
+// assigned to them, recording each change in the assignment history. An empty
+// policy uses the configured one and transferTo is the user who gets the tasks
+// with userDeletionTransfer. Tasks the user created keep existing with no
+// creator. The changes to the tasks are recorded in their history as made by
+// adminID.
+func deleteUser(adminID uint, userID uint, policy string, transferTo *uint, options taskEditOptions) error {
+	if policy == "" {
+		policy = userDeletionPolicy
+	}
+
+	dbHandle, err := database.GetDBHandle()
+	if err != nil {
+		return errors.AddContext(err, "users.go: deleteUser - GetDBHandle")
+	}
+
+	tx, err := dbHandle.Begin()
+	if err != nil {
+		return errors.AddContext(err, "users.go: deleteUser - Begin")
+	}
+	defer tx.Rollback()
+
+	newAssignee, args := "NULL", []any{userID}
+	switch policy {
+	case userDeletionReassign:
+		newAssignee = "IF(created_by = assignee_id, NULL, created_by)"
+	case userDeletionTransfer:
+		if transferTo == nil || *transferTo == userID {
+			return errUnknownAssignee
+		}
+
+		var exists bool
+		if err := tx.QueryRow("SELECT EXISTS(SELECT 1 FROM users WHERE id = ? AND NOT disabled)", *transferTo).Scan(&exists); err != nil {
+			return errors.AddContext(err, "users.go: deleteUser - QueryRow")
+		} else if !exists {
+			return errUnknownAssignee
+		}
+		newAssignee, args = "?", []any{*transferTo, userID}
+	}
+
+	// The user's tags are removed from tasks by the foreign keys
+	changes, err := trackTaskQuery(tx,
+		"SELECT id FROM tasks WHERE assignee_id = ? UNION SELECT tt.task_id FROM task_tags tt JOIN tags tg ON tg.id = tt.tag_id WHERE tg.user_id = ?",
+		userID, userID,
+	)
+	if err != nil {
+		return errors.AddContext(err, "users.go: deleteUser - trackTaskQuery")
+	}
+
+	if _, err := tx.Exec(
+		"INSERT INTO task_assignments (task_id, from_user_id, to_user_id) SELECT id, assignee_id, "+newAssignee+" FROM tasks WHERE assignee_id = ?",
+		args...,
+	); err != nil {
+		return errors.AddContext(err, "users.go: deleteUser - Exec history")
+	}
+
+	if _, err := tx.Exec("UPDATE tasks SET assignee_id = "+newAssignee+", version = version + 1 WHERE assignee_id = ?", args...); err != nil {
+		return errors.AddContext(err, "users.go: deleteUser - Exec reassign")
+	}
+
+	// The creator of the user's tasks is cleared by the foreign key
+	if _, err := tx.Exec("UPDATE tasks SET version = version + 1 WHERE created_by = ?", userID); err != nil {
+		return errors.AddContext(err, "users.go: deleteUser - Exec touch")
+	}
+
+	result, err := tx.Exec("DELETE FROM users WHERE id = ?", userID)
+	if err != nil {
+		return errors.AddContext(err, "users.go: deleteUser - Exec delete")
+	}
+	if affected, err := result.RowsAffected(); err != nil {
+		return errors.AddContext(err, "users.go: deleteUser - RowsAffected")
+	} else if affected == 0 {
+		return errUserNotFound
+	}
+
+	if err := changes.record(tx, adminID, options); err != nil {
+		return errors.AddContext(err, "users.go: deleteUser - record")
+	}
+	if err := tx.Commit(); err != nil {
+		return errors.AddContext(err, "users.go: deleteUser - Commit")
+	}
+	return nil
+}
+
+// setUserPassword replaces a user's password. When resetRequired is set the
+// user has to choose a new password the next time they log in.
+func setUserPassword(userID uint, password string, resetRequired bool) error {
+	passwordHash, err := hashPassword(password)
+	if err != nil {
+		return errors.AddContext(err, "users.go: setUserPassword - hashPassword")
+	}
+
+	dbHandle, err := database.GetDBHandle()
+	if err != nil {
+		return errors.AddContext(err, "users.go: setUserPassword - GetDBHandle")
+	}
+
+	result, err := dbHandle.Exec("UPDATE users SET password_hash = ?, password_reset_required = ? WHERE id = ?", passwordHash, resetRequired, userID)
+	if err != nil {
+		return errors.AddContext(err, "users.go: setUserPassword - Exec")
+	}
+	if affected, err := result.RowsAffected(); err != nil {
+		return errors.AddContext(err, "users.go: setUserPassword - RowsAffected")
+	} else if affected == 0 {
+		return errUserNotFound
+	}
+	return nil
+}
@@ -0,0 +1,283 @@
+// Package audit keeps a tamper-evident log of security events: logins,
+// signups, logouts, expired sessions, permission denials and data exports.
+// Entries are only ever appended, and each one carries the hash of the entry
+// before it, so editing or deleting an entry breaks the chain from that point
+// on, which Verify reports.
+package audit
+
+import (
+	"HMCTS-Developer-Challenge/database"
+	"HMCTS-Developer-Challenge/errors"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// Event names what happened.
+type Event string
+
+const (
+	EventLogin            Event = "LOGIN"
+	EventLoginFailed      Event = "LOGIN_FAILED"
+	EventSignup           Event = "SIGNUP"
+	EventLogout           Event = "LOGOUT"
+	EventSessionExpired   Event = "SESSION_EXPIRED"
+	EventPermissionDenied Event = "PERMISSION_DENIED"
+	EventExport           Event = "EXPORT"
+)
+
+// Events lists every event, for validating filters.
+var Events = []Event{
+	EventLogin, EventLoginFailed, EventSignup, EventLogout,
+	EventSessionExpired, EventPermissionDenied, EventExport,
+}
+
+// genesisHash is the previous hash of the first entry.
+const genesisHash = "0000000000000000000000000000000000000000000000000000000000000000"
+
+const maxUsernameLength = 255
+
+// Entry is one event in the log. UserID is the user the event is about, when
+// there is one, and Username their name at the time, or the name someone
+// tried to log in with. Details holds anything else worth knowing about the
+// event, such as why a login failed.
+type Entry struct {
+	ID       uint              `json:"id"`
+	Time     time.Time         `json:"time"`
+	Event    Event             `json:"event"`
+	UserID   *uint             `json:"user_id"`
+	Username string            `json:"username"`
+	SourceIP string            `json:"source_ip"`
+	Details  map[string]string `json:"details"`
+	PrevHash string            `json:"prev_hash"`
+	Hash     string            `json:"hash"`
+
+	// details are the details as stored, which is what the hash covers.
+	details string
+}
+
+// hashedEntry is what the hash of an entry is computed over. Everything is
+// kept as stored so that the hash can be recomputed from the row alone.
+type hashedEntry struct {
+	ID       uint   `json:"id"`
+	Time     string `json:"time"`
+	Event    Event  `json:"event"`
+	UserID   *uint  `json:"user_id"`
+	Username string `json:"username"`
+	SourceIP string `json:"source_ip"`
+	Details  string `json:"details"`
+	PrevHash string `json:"prev_hash"`
+}
+
+// computeHash returns the hex SHA-256 of the stored fields of an entry,
+// including the hash of the entry before it.
+func (e Entry) computeHash() string {
+	data, err := json.Marshal(hashedEntry{
+		ID:       e.ID,
+		Time:     e.Time.UTC().Format(time.RFC3339Nano),
+		Event:    e.Event,
+		UserID:   e.UserID,
+		Username: e.Username,
+		SourceIP: e.SourceIP,
+		Details:  e.details,
+		PrevHash: e.PrevHash,
+	})
+	if err != nil {
+		// A struct of strings and numbers always encodes
+		panic(err)
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// UserID is a convenience for filling in Entry.UserID.
+func UserID(id uint) *uint {
+	return &id
+}
+
+// SourceIP returns the address a request came from, without the port.
+func SourceIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// Record appends an entry to the log, filling in its ID, time and hashes, and
+// the name of its user if it is not given.
+// Appends are serialized on the head of the chain, so every entry follows
+// exactly one other.
+func Record(e Entry) (Entry, error) {
+	e.Username = truncate(strings.ToValidUTF8(e.Username, "�"), maxUsernameLength)
+	details := []byte("{}")
+	if len(e.Details) > 0 {
+		var err error
+		if details, err = json.Marshal(e.Details); err != nil {
+			return e, errors.AddContext(err, "audit.go: Record - Marshal")
+		}
+	}
+	e.details = string(details)
+
+	dbHandle, err := database.GetDBHandle()
+	if err != nil {
+		return e, errors.AddContext(err, "audit.go: Record - GetDBHandle")
+	}
+
+	tx, err := dbHandle.Begin()
+	if err != nil {
+		return e, errors.AddContext(err, "audit.go: Record - Begin")
+	}
+	defer tx.Rollback()
+
+	// Keep the name of the user as it is now, as users can be renamed or
+	// deleted later
+	if e.Username == "" && e.UserID != nil {
+		if err := tx.QueryRow("SELECT name FROM users WHERE id = ?", *e.UserID).Scan(&e.Username); err != nil && err != sql.ErrNoRows {
+			return e, errors.AddContext(err, "audit.go: Record - QueryRow users")
+		}
+	}
+
+	var lastID uint
+	if err := tx.QueryRow("SELECT last_id, last_hash FROM audit_chain WHERE id = 1 FOR UPDATE").Scan(&lastID, &e.PrevHash); err != nil {
+		return e, errors.AddContext(err, "audit.go: Record - QueryRow")
+	}
+
+	// The time is taken under the lock so that times never go backwards
+	e.ID = lastID + 1
+	e.Time = time.Now().UTC().Truncate(time.Microsecond)
+	e.Hash = e.computeHash()
+
+	if _, err := tx.Exec(
+		"INSERT INTO audit_log (id, created_at, event, user_id, username, source_ip, details, prev_hash, hash) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)",
+		e.ID, e.Time, e.Event, e.UserID, e.Username, e.SourceIP, e.details, e.PrevHash, e.Hash,
+	); err != nil {
+		return e, errors.AddContext(err, "audit.go: Record - Exec INSERT")
+	}
+	if _, err := tx.Exec("UPDATE audit_chain SET last_id = ?, last_hash = ? WHERE id = 1", e.ID, e.Hash); err != nil {
+		return e, errors.AddContext(err, "audit.go: Record - Exec UPDATE")
+	}
+
+	if err := tx.Commit(); err != nil {
+		return e, errors.AddContext(err, "audit.go: Record - Commit")
+	}
+	return e, nil
+}
+
+// Log records an entry, only logging a failure so that the event itself, such
+// as a login, still goes ahead.
+func Log(e Entry) {
+	if _, err := Record(e); err != nil {
+		log.Printf("Error: %s\n", errors.AddContext(err, "audit.go: Log - Record "+string(e.Event)))
+	}
+}
+
+func truncate(s string, n int) string {
+	if utf8.RuneCountInString(s) <= n {
+		return s
+	}
+	return string([]rune(s)[:n])
+}
+
+// Filter selects entries. Zero fields match everything. Before is a cursor:
+// only entries with a smaller ID match.
+type Filter struct {
+	Events   []Event
+	UserID   *uint
+	Username string
+	SourceIP string
+	From     time.Time
+	To       time.Time
+	Before   uint
+	Limit    int
+}
+
+// Query returns the entries matching a filter, newest first.
+func Query(f Filter) ([]Entry, error) {
+	entries := []Entry{}
+
+	var clauses []string
+	var args []any
+	if len(f.Events) > 0 {
+		clauses = append(clauses, "event IN (?"+strings.Repeat(", ?", len(f.Events)-1)+")")
+		for _, event := range f.Events {
+			args = append(args, event)
+		}
+	}
+	if f.UserID != nil {
+		clauses, args = append(clauses, "user_id = ?"), append(args, *f.UserID)
+	}
+	if f.Username != "" {
+		clauses, args = append(clauses, "username = ?"), append(args, f.Username)
+	}
+	if f.SourceIP != "" {
+		clauses, args = append(clauses, "source_ip = ?"), append(args, f.SourceIP)
+	}
+	if !f.From.IsZero() {
+		clauses, args = append(clauses, "created_at >= ?"), append(args, f.From)
+	}
+	if !f.To.IsZero() {
+		clauses, args = append(clauses, "created_at < ?"), append(args, f.To)
+	}
+	if f.Before > 0 {
+		clauses, args = append(clauses, "id < ?"), append(args, f.Before)
+	}
+
+	query := "SELECT id, created_at, event, user_id, username, source_ip, details, prev_hash, hash FROM audit_log"
+	if len(clauses) > 0 {
+		query += " WHERE " + strings.Join(clauses, " AND ")
+	}
+	query += " ORDER BY id DESC"
+	if f.Limit > 0 {
+		query += " LIMIT ?"
+		args = append(args, f.Limit)
+	}
+
+	dbHandle, err := database.GetDBHandle()
+	if err != nil {
+		return entries, errors.AddContext(err, "audit.go: Query - GetDBHandle")
+	}
+
+	rows, err := dbHandle.Query(query, args...)
+	if err != nil {
+		return entries, errors.AddContext(err, "audit.go: Query - Query")
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		e, err := scanEntry(rows)
+		if err != nil {
+			return entries, errors.AddContext(err, "audit.go: Query - scanEntry")
+		}
+		entries = append(entries, e)
+	}
+	if err := rows.Err(); err != nil {
+		return entries, errors.AddContext(err, "audit.go: Query - Rows")
+	}
+	return entries, nil
+}
+
+func scanEntry(rows *sql.Rows) (Entry, error) {
+	var e Entry
+	var userID sql.NullInt64
+	if err := rows.Scan(&e.ID, &e.Time, &e.Event, &userID, &e.Username, &e.SourceIP, &e.details, &e.PrevHash, &e.Hash); err != nil {
+		return e, err
+	}
+	if userID.Valid {
+		e.UserID = UserID(uint(userID.Int64))
+	}
+
+	// Details that no longer parse have been tampered with, which Verify
+	// reports, so the entry is still shown as far as possible
+	if err := json.Unmarshal([]byte(e.details), &e.Details); err != nil {
+		e.Details = map[string]string{"unreadable": e.details}
+	}
+	return e, nil
+}
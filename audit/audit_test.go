@@ -0,0 +1,137 @@
+package audit
+
+import (
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// testChain builds a valid chain of n entries as Record would store them.
+func testChain(n int) []Entry {
+	entries := make([]Entry, n)
+	prevHash := genesisHash
+	start := time.Date(2025, 6, 1, 9, 0, 0, 0, time.UTC)
+	for i := range entries {
+		e := Entry{
+			ID:       uint(i + 1),
+			Time:     start.Add(time.Duration(i) * time.Minute),
+			Event:    EventLogin,
+			UserID:   UserID(1),
+			Username: "testuser1",
+			SourceIP: "192.0.2.1",
+			details:  "{}",
+			PrevHash: prevHash,
+		}
+		e.Hash = e.computeHash()
+		prevHash = e.Hash
+		entries[i] = e
+	}
+	return entries
+}
+
+func verifyChain(entries []Entry, headID uint, headHash string) Report {
+	v := newVerifier()
+	for _, e := range entries {
+		v.check(e)
+	}
+	v.finish(headID, headHash)
+	return v.report
+}
+
+func TestComputeHash(t *testing.T) {
+	e := testChain(1)[0]
+	if len(e.Hash) != 64 || e.computeHash() != e.Hash {
+		t.Fatalf("Expected a stable SHA-256 hash, got %q", e.Hash)
+	}
+
+	// The zone a time is read back in must not matter
+	inLondon := e
+	inLondon.Time = e.Time.In(time.FixedZone("BST", 3600))
+	if inLondon.computeHash() != e.Hash {
+		t.Error("Expected the same hash for the same instant in another zone")
+	}
+
+	changed := e
+	changed.details = `{"reason":"incorrect password"}`
+	if changed.computeHash() == e.Hash {
+		t.Error("Expected changing the details to change the hash")
+	}
+	changed = e
+	changed.UserID = nil
+	if changed.computeHash() == e.Hash {
+		t.Error("Expected removing the user to change the hash")
+	}
+}
+
+func TestVerifier(t *testing.T) {
+	entries := testChain(5)
+	head := entries[4]
+
+	report := verifyChain(entries, head.ID, head.Hash)
+	if !report.Valid || report.Checked != 5 || len(report.Problems) != 0 || report.LastID != 5 || report.LastHash != head.Hash {
+		t.Errorf("Expected a valid chain, got %+v", report)
+	}
+
+	if report := verifyChain(nil, 0, genesisHash); !report.Valid {
+		t.Errorf("Expected an empty log to be valid, got %+v", report)
+	}
+
+	tests := []struct {
+		name     string
+		entries  func() []Entry
+		headID   uint
+		problems []Problem
+	}{
+		{"edited", func() []Entry {
+			edited := testChain(5)
+			edited[1].Username = "testadmin"
+			return edited
+		}, 5, []Problem{{2, "has been changed"}}},
+		{"edited and rehashed", func() []Entry {
+			edited := testChain(5)
+			edited[1].SourceIP = "198.51.100.7"
+			edited[1].Hash = edited[1].computeHash()
+			return edited
+		}, 5, []Problem{{3, "does not follow the entry before it"}}},
+		{"deleted", func() []Entry {
+			chain := testChain(5)
+			return append(chain[:2:2], chain[3:]...)
+		}, 5, []Problem{{4, "entries 3 to 3 are missing"}}},
+		{"deleted from the start", func() []Entry {
+			return testChain(5)[2:]
+		}, 5, []Problem{{3, "entries 1 to 2 are missing"}}},
+		{"deleted from the end", func() []Entry {
+			return testChain(5)[:3]
+		}, 5, []Problem{{5, "entries 4 to 5 are missing"}}},
+	}
+	for _, tc := range tests {
+		report := verifyChain(tc.entries(), tc.headID, head.Hash)
+		if report.Valid || !reflect.DeepEqual(report.Problems, tc.problems) {
+			t.Errorf("%s: expected problems %+v, got %+v", tc.name, tc.problems, report)
+		}
+	}
+
+	// Rewriting the chain up to the end shows at the head
+	rewritten := testChain(5)
+	rewritten[4].Username = "testadmin"
+	rewritten[4].Hash = rewritten[4].computeHash()
+	report = verifyChain(rewritten, head.ID, head.Hash)
+	if report.Valid || !reflect.DeepEqual(report.Problems, []Problem{{5, "the head of the chain does not match the last entry"}}) {
+		t.Errorf("Expected a rewritten last entry to be reported, got %+v", report)
+	}
+}
+
+func TestSourceIP(t *testing.T) {
+	r := httptest.NewRequest("GET", "/api/tasks/1/history", nil)
+	for remoteAddr, expected := range map[string]string{
+		"192.0.2.1:1234":    "192.0.2.1",
+		"[2001:db8::1]:443": "2001:db8::1",
+		"192.0.2.1":         "192.0.2.1",
+	} {
+		r.RemoteAddr = remoteAddr
+		if got := SourceIP(r); got != expected {
+			t.Errorf("%s: expected %s, got %s", remoteAddr, expected, got)
+		}
+	}
+}
@@ -0,0 +1,118 @@
+package audit
+
+import (
+	"HMCTS-Developer-Challenge/database"
+	"HMCTS-Developer-Challenge/errors"
+	"fmt"
+)
+
+// maxProblems stops a badly damaged log from producing a huge report.
+const maxProblems = 100
+
+// Problem is an entry that breaks the chain and why.
+type Problem struct {
+	ID     uint   `json:"id"`
+	Reason string `json:"reason"`
+}
+
+// Report is the outcome of checking the whole log. LastID and LastHash are
+// the head of the chain, which can be kept somewhere else to notice the log
+// being rewritten from that point on.
+type Report struct {
+	Valid    bool      `json:"valid"`
+	Checked  int       `json:"checked"`
+	LastID   uint      `json:"last_id"`
+	LastHash string    `json:"last_hash"`
+	Problems []Problem `json:"problems"`
+}
+
+// Verify walks the log from the first entry and checks that no entry is
+// missing, that each entry follows the one before it and that its hash still
+// matches its contents, then that the last entry is the head of the chain.
+func Verify() (Report, error) {
+	v := newVerifier()
+
+	dbHandle, err := database.GetDBHandle()
+	if err != nil {
+		return v.report, errors.AddContext(err, "verify.go: Verify - GetDBHandle")
+	}
+
+	// Read the head first, so entries appended during the walk are not
+	// taken for a broken chain
+	var headID uint
+	var headHash string
+	if err := dbHandle.QueryRow("SELECT last_id, last_hash FROM audit_chain WHERE id = 1").Scan(&headID, &headHash); err != nil {
+		return v.report, errors.AddContext(err, "verify.go: Verify - QueryRow")
+	}
+
+	rows, err := dbHandle.Query(
+		"SELECT id, created_at, event, user_id, username, source_ip, details, prev_hash, hash FROM audit_log WHERE id <= ? ORDER BY id",
+		headID,
+	)
+	if err != nil {
+		return v.report, errors.AddContext(err, "verify.go: Verify - Query")
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		e, err := scanEntry(rows)
+		if err != nil {
+			return v.report, errors.AddContext(err, "verify.go: Verify - scanEntry")
+		}
+		v.check(e)
+	}
+	if err := rows.Err(); err != nil {
+		return v.report, errors.AddContext(err, "verify.go: Verify - Rows")
+	}
+
+	v.finish(headID, headHash)
+	return v.report, nil
+}
+
+// verifier checks entries one at a time in order of ID. After a problem it
+// carries on from the entry as stored, so that every break is reported once.
+type verifier struct {
+	nextID   uint
+	prevHash string
+	report   Report
+}
+
+func newVerifier() *verifier {
+	return &verifier{nextID: 1, prevHash: genesisHash, report: Report{Valid: true, Problems: []Problem{}}}
+}
+
+func (v *verifier) check(e Entry) {
+	v.report.Checked++
+	if e.ID > v.nextID {
+		v.problem(e.ID, fmt.Sprintf("entries %d to %d are missing", v.nextID, e.ID-1))
+	} else if e.PrevHash != v.prevHash {
+		v.problem(e.ID, "does not follow the entry before it")
+	}
+	if e.computeHash() != e.Hash {
+		v.problem(e.ID, "has been changed")
+	}
+
+	v.nextID = e.ID + 1
+	v.prevHash = e.Hash
+	v.report.LastID = e.ID
+	v.report.LastHash = e.Hash
+}
+
+// finish compares the last entry with the head of the chain, which catches
+// entries removed from the end of the log.
+func (v *verifier) finish(headID uint, headHash string) {
+	if headID >= v.nextID {
+		v.problem(headID, fmt.Sprintf("entries %d to %d are missing", v.nextID, headID))
+	} else if headHash != v.prevHash {
+		v.problem(headID, "the head of the chain does not match the last entry")
+	}
+	v.report.LastID = headID
+	v.report.LastHash = headHash
+}
+
+func (v *verifier) problem(id uint, reason string) {
+	v.report.Valid = false
+	if len(v.report.Problems) < maxProblems {
+		v.report.Problems = append(v.report.Problems, Problem{ID: id, Reason: reason})
+	}
+}
@@ -0,0 +1,63 @@
+// Package blobstore keeps the contents of uploaded files apart from the
+// database, which only holds their metadata. Files are stored on the local
+// filesystem by default, or in an S3-compatible object store.
+package blobstore
+
+import (
+	"HMCTS-Developer-Challenge/errors"
+	"context"
+	"io"
+	"regexp"
+)
+
+// Store saves, reads and removes blobs by key. Keys are chosen by the caller
+// and may only contain letters, digits, dots, dashes and underscores.
+type Store interface {
+	// Put saves size bytes read from r under key, replacing any blob already
+	// stored there.
+	Put(ctx context.Context, key string, r io.Reader, size int64) error
+	// Get opens the blob stored under key. The caller must close it.
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+	// Delete removes the blob stored under key. Removing a blob that does
+	// not exist is not an error.
+	Delete(ctx context.Context, key string) error
+}
+
+var ErrNotFound = errors.Error("Blob Not Found")
+var ErrInvalidKey = errors.Error("Invalid Blob Key")
+
+var keyPattern = regexp.MustCompile(`^[A-Za-z0-9_-][A-Za-z0-9._-]*$`)
+
+func checkKey(key string) error {
+	if len(key) > 255 || !keyPattern.MatchString(key) {
+		return ErrInvalidKey
+	}
+	return nil
+}
+
+// Config chooses and configures a store. Kind is "local" (the default) or
+// "s3".
+type Config struct {
+	Kind string
+	// Dir is where the local store keeps its files.
+	Dir string
+	S3  S3Config
+}
+
+const defaultDir = "./data/blobs"
+
+// Open creates the store described by config.
+func Open(config Config) (Store, error) {
+	switch config.Kind {
+	case "", "local":
+		dir := config.Dir
+		if dir == "" {
+			dir = defaultDir
+		}
+		return NewLocal(dir)
+	case "s3":
+		return NewS3(config.S3)
+	default:
+		return nil, errors.Errorf("blobstore.go: Open - unknown store %q", config.Kind)
+	}
+}
@@ -0,0 +1,76 @@
+package blobstore
+
+import (
+	"HMCTS-Developer-Challenge/errors"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// Local keeps each blob in a file named after its key in one directory.
+type Local struct {
+	dir string
+}
+
+func NewLocal(dir string) (*Local, error) {
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		return nil, errors.AddContext(err, "local.go: NewLocal - MkdirAll")
+	}
+	return &Local{dir: dir}, nil
+}
+
+// Put writes the blob to a temporary file first and renames it into place,
+// so a failed upload never leaves a partial blob behind.
+func (l *Local) Put(ctx context.Context, key string, r io.Reader, size int64) error {
+	if err := checkKey(key); err != nil {
+		return err
+	}
+
+	f, err := os.CreateTemp(l.dir, ".upload-*")
+	if err != nil {
+		return errors.AddContext(err, "local.go: Put - CreateTemp")
+	}
+	defer os.Remove(f.Name())
+
+	n, err := io.Copy(f, r)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return errors.AddContext(err, "local.go: Put - Copy")
+	}
+	if n != size {
+		return errors.Errorf("local.go: Put - expected %d bytes, got %d", size, n)
+	}
+
+	if err := os.Rename(f.Name(), filepath.Join(l.dir, key)); err != nil {
+		return errors.AddContext(err, "local.go: Put - Rename")
+	}
+	return nil
+}
+
+func (l *Local) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	if err := checkKey(key); err != nil {
+		return nil, err
+	}
+
+	f, err := os.Open(filepath.Join(l.dir, key))
+	if os.IsNotExist(err) {
+		return nil, ErrNotFound
+	} else if err != nil {
+		return nil, errors.AddContext(err, "local.go: Get - Open")
+	}
+	return f, nil
+}
+
+func (l *Local) Delete(ctx context.Context, key string) error {
+	if err := checkKey(key); err != nil {
+		return err
+	}
+
+	if err := os.Remove(filepath.Join(l.dir, key)); err != nil && !os.IsNotExist(err) {
+		return errors.AddContext(err, "local.go: Delete - Remove")
+	}
+	return nil
+}
@@ -0,0 +1,78 @@
+package blobstore
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// testStore saves, reads, replaces and removes a blob, as every store must.
+func testStore(t *testing.T, store Store) {
+	ctx := context.Background()
+
+	if err := store.Put(ctx, "order.pdf", strings.NewReader("first"), 5); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Put(ctx, "order.pdf", strings.NewReader("second"), 6); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := store.Get(ctx, "order.pdf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := io.ReadAll(r)
+	r.Close()
+	if err != nil || string(data) != "second" {
+		t.Errorf("Expected the replaced blob, got %q (%v)", data, err)
+	}
+
+	if err := store.Delete(ctx, "order.pdf"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Get(ctx, "order.pdf"); err != ErrNotFound {
+		t.Errorf("Expected %v, got %v", ErrNotFound, err)
+	}
+	if err := store.Delete(ctx, "order.pdf"); err != nil {
+		t.Errorf("Expected deleting a missing blob to succeed, got %v", err)
+	}
+
+	for _, key := range []string{"", "../order.pdf", "a/b", ".hidden"} {
+		if err := store.Put(ctx, key, strings.NewReader(""), 0); err != ErrInvalidKey {
+			t.Errorf("%q: expected %v, got %v", key, ErrInvalidKey, err)
+		}
+	}
+}
+
+func TestLocal(t *testing.T) {
+	dir := t.TempDir()
+	store, err := NewLocal(filepath.Join(dir, "blobs"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	testStore(t, store)
+
+	// A short upload leaves nothing behind
+	if err := store.Put(context.Background(), "short", strings.NewReader("abc"), 10); err == nil {
+		t.Error("Expected a short upload to fail")
+	}
+	entries, err := os.ReadDir(filepath.Join(dir, "blobs"))
+	if err != nil || len(entries) != 0 {
+		t.Errorf("Expected an empty directory, got %v (%v)", entries, err)
+	}
+}
+
+func TestOpen(t *testing.T) {
+	if _, err := Open(Config{Kind: "local", Dir: t.TempDir()}); err != nil {
+		t.Errorf("Expected a local store, got %v", err)
+	}
+	if _, err := Open(Config{Kind: "s3"}); err == nil {
+		t.Error("Expected an S3 store without a bucket to be rejected")
+	}
+	if _, err := Open(Config{Kind: "ftp"}); err == nil {
+		t.Error("Expected an unknown store to be rejected")
+	}
+}
@@ -0,0 +1,173 @@
+// Package calendar knows the UK bank holidays and counts working days around
+// them. Holidays are read from a file in the format published at
+// https://www.gov.uk/bank-holidays.json; a copy is bundled with the server
+// and can be replaced with a newer one through Load.
+package calendar
+
+import (
+	"HMCTS-Developer-Challenge/errors"
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Division is one of the parts of the UK with its own bank holidays.
+type Division string
+
+const (
+	EnglandAndWales Division = "england-and-wales"
+	Scotland        Division = "scotland"
+	NorthernIreland Division = "northern-ireland"
+)
+
+// Divisions lists every division in the order GOV.UK publishes them.
+var Divisions = []Division{EnglandAndWales, Scotland, NorthernIreland}
+
+type Holiday struct {
+	Title   string `json:"title"`
+	Date    string `json:"date"`
+	Notes   string `json:"notes"`
+	Bunting bool   `json:"bunting"`
+}
+
+type divisionHolidays struct {
+	Division Division  `json:"division"`
+	Events   []Holiday `json:"events"`
+}
+
+// Calendar holds the bank holidays of every division, keyed by date.
+type Calendar struct {
+	holidays map[Division]map[string]Holiday
+}
+
+var ErrInvalidDivision = errors.Error("Invalid Division")
+
+//go:embed bank-holidays.json
+var bundledHolidays []byte
+
+var current = mustParse(bundledHolidays)
+
+func mustParse(data []byte) *Calendar {
+	c, err := Parse(data)
+	if err != nil {
+		panic(err)
+	}
+	return c
+}
+
+// Parse reads bank holidays in the GOV.UK format. Every division must be
+// present.
+func Parse(data []byte) (*Calendar, error) {
+	var file map[Division]divisionHolidays
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, errors.AddContext(err, "calendar.go: Parse - Unmarshal")
+	}
+
+	c := &Calendar{holidays: map[Division]map[string]Holiday{}}
+	for _, division := range Divisions {
+		entry, ok := file[division]
+		if !ok {
+			return nil, errors.Errorf("calendar.go: Parse - no holidays for %s", division)
+		}
+
+		c.holidays[division] = map[string]Holiday{}
+		for _, h := range entry.Events {
+			if _, err := time.Parse(time.DateOnly, h.Date); err != nil {
+				return nil, errors.Errorf("calendar.go: Parse - invalid date %q for %s", h.Date, division)
+			}
+			c.holidays[division][h.Date] = h
+		}
+	}
+	return c, nil
+}
+
+// Load replaces the bundled bank holidays with those read from a file. An
+// empty path restores the bundled ones.
+func Load(path string) error {
+	c := mustParse(bundledHolidays)
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return errors.AddContext(err, "calendar.go: Load - ReadFile")
+		}
+		if c, err = Parse(data); err != nil {
+			return err
+		}
+	}
+
+	current = c
+	return nil
+}
+
+// Current returns the bank holidays in use.
+func Current() *Calendar {
+	return current
+}
+
+// ParseDivision accepts a division by name, ignoring case. An empty name
+// gives England and Wales.
+func ParseDivision(name string) (Division, error) {
+	name = strings.ToLower(strings.TrimSpace(name))
+	if name == "" {
+		return EnglandAndWales, nil
+	}
+	if d := Division(name); slices.Contains(Divisions, d) {
+		return d, nil
+	}
+	return "", ErrInvalidDivision
+}
+
+// Holiday returns the bank holiday falling on the date of t, if any.
+func (c *Calendar) Holiday(d Division, t time.Time) (Holiday, bool) {
+	h, ok := c.holidays[d][t.Format(time.DateOnly)]
+	return h, ok
+}
+
+// Holidays lists the bank holidays of a division in a year in date order.
+func (c *Calendar) Holidays(d Division, year int) []Holiday {
+	holidays := []Holiday{}
+	prefix := fmt.Sprintf("%04d-", year)
+	for date, h := range c.holidays[d] {
+		if strings.HasPrefix(date, prefix) {
+			holidays = append(holidays, h)
+		}
+	}
+	slices.SortFunc(holidays, func(a, b Holiday) int { return strings.Compare(a.Date, b.Date) })
+	return holidays
+}
+
+// NonWorkingReason says why the date of t is not a working day: the name of
+// the weekday or of the bank holiday. It is empty for working days.
+func (c *Calendar) NonWorkingReason(d Division, t time.Time) string {
+	if t.Weekday() == time.Saturday || t.Weekday() == time.Sunday {
+		return t.Weekday().String()
+	}
+	if h, ok := c.Holiday(d, t); ok {
+		return h.Title
+	}
+	return ""
+}
+
+func (c *Calendar) IsWorkingDay(d Division, t time.Time) bool {
+	return c.NonWorkingReason(d, t) == ""
+}
+
+// AddWorkingDays moves n working days forwards, or backwards for a negative
+// n, keeping the time of day. Zero leaves t where it is, even on a day off.
+func (c *Calendar) AddWorkingDays(d Division, t time.Time, n int) time.Time {
+	step := 1
+	if n < 0 {
+		step = -1
+	}
+	for n != 0 {
+		t = t.AddDate(0, 0, step)
+		if c.IsWorkingDay(d, t) {
+			n -= step
+		}
+	}
+	return t
+}
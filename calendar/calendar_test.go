@@ -0,0 +1,162 @@
+package calendar
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func date(s string) time.Time {
+	t, err := time.Parse(time.DateTime, s)
+	if err != nil {
+		panic(err)
+	}
+	return t
+}
+
+func TestBundledHolidays(t *testing.T) {
+	c := Current()
+	for _, division := range Divisions {
+		for year := 2024; year <= 2027; year++ {
+			holidays := c.Holidays(division, year)
+			if len(holidays) < 8 {
+				t.Errorf("%s %d: expected at least 8 bank holidays, got %d", division, year, len(holidays))
+			}
+			for _, h := range holidays {
+				if c.IsWorkingDay(division, date(h.Date+" 00:00:00")) {
+					t.Errorf("%s: %s should not be a working day", division, h.Date)
+				}
+			}
+		}
+	}
+
+	tests := []struct {
+		division Division
+		date     string
+		title    string
+	}{
+		{EnglandAndWales, "2025-08-25", "Summer bank holiday"},
+		{Scotland, "2025-08-04", "Summer bank holiday"},
+		{Scotland, "2025-01-02", "2nd January"},
+		{NorthernIreland, "2025-07-14", "Battle of the Boyne (Orangemen's Day)"},
+		{EnglandAndWales, "2026-12-28", "Boxing Day"},
+	}
+	for _, tc := range tests {
+		if h, ok := c.Holiday(tc.division, date(tc.date+" 12:00:00")); !ok || h.Title != tc.title {
+			t.Errorf("%s %s: expected %q, got %q", tc.division, tc.date, tc.title, h.Title)
+		}
+	}
+
+	// Regional holidays are working days elsewhere
+	if !c.IsWorkingDay(EnglandAndWales, date("2025-01-02 09:00:00")) {
+		t.Error("Expected 2 January to be a working day in England and Wales")
+	}
+	if !c.IsWorkingDay(Scotland, date("2025-08-25 09:00:00")) {
+		t.Error("Expected the English summer bank holiday to be a working day in Scotland")
+	}
+}
+
+func TestNonWorkingReason(t *testing.T) {
+	c := Current()
+	tests := []struct {
+		date     string
+		expected string
+	}{
+		{"2025-06-14 10:00:00", "Saturday"},
+		{"2025-06-15 10:00:00", "Sunday"},
+		{"2025-06-16 10:00:00", ""},
+		{"2025-12-25 10:00:00", "Christmas Day"},
+	}
+	for _, tc := range tests {
+		if got := c.NonWorkingReason(EnglandAndWales, date(tc.date)); got != tc.expected {
+			t.Errorf("%s: expected %q, got %q", tc.date, tc.expected, got)
+		}
+	}
+}
+
+func TestAddWorkingDays(t *testing.T) {
+	c := Current()
+	tests := []struct {
+		division Division
+		start    string
+		n        int
+		expected string
+	}{
+		// 2025-06-16 is a Monday
+		{EnglandAndWales, "2025-06-16 10:00:00", 0, "2025-06-16 10:00:00"},
+		{EnglandAndWales, "2025-06-16 10:00:00", 1, "2025-06-17 10:00:00"},
+		{EnglandAndWales, "2025-06-16 10:00:00", 5, "2025-06-23 10:00:00"},
+		{EnglandAndWales, "2025-06-16 10:00:00", -1, "2025-06-13 10:00:00"},
+		{EnglandAndWales, "2025-06-16 10:00:00", -6, "2025-06-06 10:00:00"},
+		// Counting from a Saturday starts with the next working day
+		{EnglandAndWales, "2025-06-21 10:00:00", 1, "2025-06-23 10:00:00"},
+		{EnglandAndWales, "2025-06-21 10:00:00", -1, "2025-06-20 10:00:00"},
+		// Christmas and Boxing Day fall on Thursday and Friday in 2025
+		{EnglandAndWales, "2025-12-24 17:00:00", 1, "2025-12-29 17:00:00"},
+		// Easter: Good Friday and Easter Monday in England, only Good Friday in Scotland
+		{EnglandAndWales, "2025-04-17 09:00:00", 1, "2025-04-22 09:00:00"},
+		{Scotland, "2025-04-17 09:00:00", 1, "2025-04-21 09:00:00"},
+		{NorthernIreland, "2025-07-11 09:00:00", 1, "2025-07-15 09:00:00"},
+		{EnglandAndWales, "2025-07-11 09:00:00", 1, "2025-07-14 09:00:00"},
+	}
+	for _, tc := range tests {
+		if got := c.AddWorkingDays(tc.division, date(tc.start), tc.n).Format(time.DateTime); got != tc.expected {
+			t.Errorf("%s %s %+d: expected %s, got %s", tc.division, tc.start, tc.n, tc.expected, got)
+		}
+	}
+}
+
+func TestParseDivision(t *testing.T) {
+	tests := map[string]Division{
+		"":                  EnglandAndWales,
+		"Scotland":          Scotland,
+		" northern-ireland": NorthernIreland,
+	}
+	for name, expected := range tests {
+		if d, err := ParseDivision(name); err != nil || d != expected {
+			t.Errorf("%q: expected %s, got %s (%v)", name, expected, d, err)
+		}
+	}
+	if _, err := ParseDivision("wales"); err != ErrInvalidDivision {
+		t.Errorf("Expected %v, got %v", ErrInvalidDivision, err)
+	}
+}
+
+func TestLoad(t *testing.T) {
+	t.Cleanup(func() { Load("") })
+
+	path := filepath.Join(t.TempDir(), "bank-holidays.json")
+	data := `{
+		"england-and-wales": {"division": "england-and-wales", "events": [{"title": "Extra bank holiday", "date": "2025-06-16", "notes": "", "bunting": true}]},
+		"scotland": {"division": "scotland", "events": []},
+		"northern-ireland": {"division": "northern-ireland", "events": []}
+	}`
+	if err := os.WriteFile(path, []byte(data), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := Load(path); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if got := Current().NonWorkingReason(EnglandAndWales, date("2025-06-16 10:00:00")); got != "Extra bank holiday" {
+		t.Errorf("Expected the loaded holiday, got %q", got)
+	}
+	if !Current().IsWorkingDay(EnglandAndWales, date("2025-12-25 10:00:00")) {
+		t.Error("Expected the bundled holidays to be replaced")
+	}
+
+	if err := os.WriteFile(path, []byte(`{"england-and-wales": {"events": []}}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := Load(path); err == nil {
+		t.Error("Expected an error for a file missing divisions")
+	}
+	if err := Load(filepath.Join(t.TempDir(), "missing.json")); err == nil {
+		t.Error("Expected an error for a missing file")
+	}
+
+	if err := Load(""); err != nil || Current().IsWorkingDay(EnglandAndWales, date("2025-12-25 10:00:00")) {
+		t.Errorf("Expected the bundled holidays back, got %v", err)
+	}
+}
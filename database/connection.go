@@ -32,8 +32,9 @@ func Connect() error {
 		return errors.Error("DB_PASSWORD environment variable is not set")
 	}
 
+	// Times are read and written in UTC whatever the zone of the server
 	var err error = nil
-	db, err = sql.Open("mysql", fmt.Sprintf("%s:%s@tcp(%s:%s)/%s", user, pwd, host, port, dbname))
+	db, err = sql.Open("mysql", fmt.Sprintf("%s:%s@tcp(%s:%s)/%s?parseTime=true&loc=UTC&time_zone=%%27%%2B00%%3A00%%27", user, pwd, host, port, dbname))
 	return err
 }
 
@@ -1,27 +1,298 @@
 CREATE TABLE IF NOT EXISTS users (
   id INT UNSIGNED AUTO_INCREMENT PRIMARY KEY,
   name VARCHAR(32) NOT NULL,
-  password_hash VARCHAR(255) NOT NULL
+  password_hash VARCHAR(255) NOT NULL,
+  role ENUM('ADMIN', 'LEAD', 'CASEWORKER', 'AUDITOR') NOT NULL DEFAULT 'CASEWORKER',
+  disabled BOOLEAN NOT NULL DEFAULT FALSE,
+  password_reset_required BOOLEAN NOT NULL DEFAULT FALSE,
+  timezone VARCHAR(64) NULL
 );
 
-CREATE TABLE IF NOT EXISTS tasks (
+CREATE TABLE IF NOT EXISTS teams (
   id INT UNSIGNED AUTO_INCREMENT PRIMARY KEY,
+  name VARCHAR(64) NOT NULL,
+  created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+  UNIQUE KEY uq_teams_name (name)
+);
+
+CREATE TABLE IF NOT EXISTS team_members (
+  team_id INT UNSIGNED NOT NULL,
   user_id INT UNSIGNED NOT NULL,
+  role ENUM('MANAGER', 'MEMBER') NOT NULL DEFAULT 'MEMBER',
+  PRIMARY KEY (team_id, user_id),
+  FOREIGN KEY (team_id) REFERENCES teams(id) ON DELETE CASCADE,
+  FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE,
+  INDEX idx_team_members_user (user_id, team_id)
+);
+
+-- Invitations to sign up. The token handed out is signed rather than stored;
+-- used_at makes each invitation single use.
+CREATE TABLE IF NOT EXISTS invites (
+  id INT UNSIGNED AUTO_INCREMENT PRIMARY KEY,
+  role ENUM('ADMIN', 'LEAD', 'CASEWORKER', 'AUDITOR') NOT NULL DEFAULT 'CASEWORKER',
+  team_id INT UNSIGNED NULL,
+  created_by INT UNSIGNED NULL,
+  created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+  expires_at DATETIME NOT NULL,
+  used_at DATETIME NULL,
+  used_by INT UNSIGNED NULL,
+  FOREIGN KEY (team_id) REFERENCES teams(id) ON DELETE SET NULL,
+  FOREIGN KEY (created_by) REFERENCES users(id) ON DELETE SET NULL,
+  FOREIGN KEY (used_by) REFERENCES users(id) ON DELETE SET NULL
+);
+
+-- The cases tasks are about. References are unique within a jurisdiction and
+-- stored in the normalised form the API checks them in.
+CREATE TABLE IF NOT EXISTS cases (
+  id INT UNSIGNED AUTO_INCREMENT PRIMARY KEY,
+  reference VARCHAR(32) NOT NULL,
+  jurisdiction VARCHAR(16) NOT NULL,
+  case_type VARCHAR(64) NOT NULL DEFAULT '',
+  status ENUM('OPEN', 'STAYED', 'CLOSED') NOT NULL DEFAULT 'OPEN',
+  created_by INT UNSIGNED NULL,
+  created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+  FOREIGN KEY (created_by) REFERENCES users(id) ON DELETE SET NULL,
+  UNIQUE KEY uq_cases_reference (jurisdiction, reference)
+);
+
+CREATE TABLE IF NOT EXISTS case_parties (
+  case_id INT UNSIGNED NOT NULL,
+  position INT UNSIGNED NOT NULL,
+  name VARCHAR(255) NOT NULL,
+  role VARCHAR(32) NOT NULL DEFAULT '',
+  PRIMARY KEY (case_id, position),
+  FOREIGN KEY (case_id) REFERENCES cases(id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS hearings (
+  id INT UNSIGNED AUTO_INCREMENT PRIMARY KEY,
+  case_id INT UNSIGNED NOT NULL,
+  hearing_type VARCHAR(64) NOT NULL DEFAULT '',
+  starts_at DATETIME NOT NULL,
+  created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+  FOREIGN KEY (case_id) REFERENCES cases(id) ON DELETE CASCADE,
+  INDEX idx_hearings_case (case_id, starts_at)
+);
+
+-- A task whose deadline follows a hearing has all three hearing columns set:
+-- the deadline is hearing_offset days (or working days) from its start.
+CREATE TABLE IF NOT EXISTS tasks (
+  id INT UNSIGNED AUTO_INCREMENT PRIMARY KEY,
+  created_by INT UNSIGNED NULL,
+  assignee_id INT UNSIGNED NULL,
+  team_id INT UNSIGNED NULL,
+  case_id INT UNSIGNED NULL,
+  parent_id INT UNSIGNED NULL,
+  previous_occurrence_id INT UNSIGNED NULL,
   name TINYTEXT NOT NULL,
   description TEXT,
-  status ENUM('COMPLETE', 'INCOMPLETE') NOT NULL DEFAULT 'INCOMPLETE',
+  status VARCHAR(32) NOT NULL DEFAULT 'INCOMPLETE',
   created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
   deadline TIMESTAMP NOT NULL,
-  FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+  priority ENUM('LOW', 'MEDIUM', 'HIGH', 'URGENT') NOT NULL DEFAULT 'MEDIUM',
+  recurrence VARCHAR(255) NOT NULL DEFAULT '',
+  version INT UNSIGNED NOT NULL DEFAULT 1,
+  hearing_id INT UNSIGNED NULL,
+  hearing_offset INT NULL,
+  hearing_offset_unit ENUM('DAYS', 'WORKING_DAYS') NULL,
+  deleted_at TIMESTAMP NULL DEFAULT NULL,
+  deleted_by INT UNSIGNED NULL,
+  deleted_with INT UNSIGNED NULL,
+  FOREIGN KEY (created_by) REFERENCES users(id) ON DELETE SET NULL,
+  FOREIGN KEY (assignee_id) REFERENCES users(id) ON DELETE SET NULL,
+  FOREIGN KEY (team_id) REFERENCES teams(id) ON DELETE SET NULL,
+  FOREIGN KEY (case_id) REFERENCES cases(id) ON DELETE SET NULL,
+  FOREIGN KEY (hearing_id) REFERENCES hearings(id) ON DELETE SET NULL,
+  FOREIGN KEY (parent_id) REFERENCES tasks(id) ON DELETE CASCADE,
+  FOREIGN KEY (previous_occurrence_id) REFERENCES tasks(id) ON DELETE SET NULL,
+  FOREIGN KEY (deleted_by) REFERENCES users(id) ON DELETE SET NULL,
+  INDEX idx_tasks_parent (parent_id),
+  INDEX idx_tasks_assignee_status (assignee_id, status, id),
+  INDEX idx_tasks_assignee_priority (assignee_id, priority, id),
+  INDEX idx_tasks_assignee_deadline (assignee_id, deadline, id),
+  INDEX idx_tasks_assignee_created_at (assignee_id, created_at, id),
+  INDEX idx_tasks_created_by (created_by, deadline, id),
+  INDEX idx_tasks_team (team_id, assignee_id, deadline, id),
+  INDEX idx_tasks_case (case_id, deadline, id),
+  INDEX idx_tasks_hearing (hearing_id),
+  INDEX idx_tasks_deleted (deleted_at),
+  FULLTEXT INDEX ft_tasks_name_description (name, description)
+);
+
+-- History of task reassignments. The user columns have no foreign keys so
+-- that the history outlives deleted users; assigned_by is NULL when the
+-- change was made by the system, e.g. on user deletion.
+CREATE TABLE IF NOT EXISTS task_assignments (
+  id INT UNSIGNED AUTO_INCREMENT PRIMARY KEY,
+  task_id INT UNSIGNED NOT NULL,
+  from_user_id INT UNSIGNED NULL,
+  to_user_id INT UNSIGNED NULL,
+  assigned_by INT UNSIGNED NULL,
+  assigned_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+  FOREIGN KEY (task_id) REFERENCES tasks(id) ON DELETE CASCADE,
+  INDEX idx_task_assignments_task (task_id, id)
+);
+
+CREATE TABLE IF NOT EXISTS task_checklist_items (
+  id INT UNSIGNED AUTO_INCREMENT PRIMARY KEY,
+  task_id INT UNSIGNED NOT NULL,
+  text VARCHAR(255) NOT NULL,
+  done BOOLEAN NOT NULL DEFAULT FALSE,
+  position INT UNSIGNED NOT NULL,
+  created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+  FOREIGN KEY (task_id) REFERENCES tasks(id) ON DELETE CASCADE,
+  INDEX idx_task_checklist_items_task (task_id, position)
+);
+
+-- Comments on tasks. Each edit keeps the body it replaced as a revision;
+-- edited_by has no foreign key so that the history outlives deleted users.
+CREATE TABLE IF NOT EXISTS task_comments (
+  id INT UNSIGNED AUTO_INCREMENT PRIMARY KEY,
+  task_id INT UNSIGNED NOT NULL,
+  author_id INT UNSIGNED NULL,
+  body TEXT NOT NULL,
+  created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+  edited_at TIMESTAMP NULL,
+  FOREIGN KEY (task_id) REFERENCES tasks(id) ON DELETE CASCADE,
+  FOREIGN KEY (author_id) REFERENCES users(id) ON DELETE SET NULL,
+  INDEX idx_task_comments_task (task_id, id)
+);
+
+CREATE TABLE IF NOT EXISTS task_comment_revisions (
+  id INT UNSIGNED AUTO_INCREMENT PRIMARY KEY,
+  comment_id INT UNSIGNED NOT NULL,
+  body TEXT NOT NULL,
+  edited_by INT UNSIGNED NULL,
+  edited_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+  FOREIGN KEY (comment_id) REFERENCES task_comments(id) ON DELETE CASCADE,
+  INDEX idx_task_comment_revisions_comment (comment_id, id)
+);
+
+-- Users mentioned in comments, waiting to be notified while notified_at is
+-- NULL.
+CREATE TABLE IF NOT EXISTS task_comment_mentions (
+  comment_id INT UNSIGNED NOT NULL,
+  user_id INT UNSIGNED NOT NULL,
+  created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+  notified_at TIMESTAMP NULL,
+  PRIMARY KEY (comment_id, user_id),
+  FOREIGN KEY (comment_id) REFERENCES task_comments(id) ON DELETE CASCADE,
+  FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE,
+  INDEX idx_task_comment_mentions_user (user_id, notified_at)
+);
+
+-- Files attached to tasks. The contents are kept in the blob store under
+-- storage_key; size counts towards the quota of the user who uploaded them.
+CREATE TABLE IF NOT EXISTS task_attachments (
+  id INT UNSIGNED AUTO_INCREMENT PRIMARY KEY,
+  task_id INT UNSIGNED NOT NULL,
+  uploaded_by INT UNSIGNED NULL,
+  file_name VARCHAR(255) NOT NULL,
+  content_type VARCHAR(127) NOT NULL,
+  size BIGINT UNSIGNED NOT NULL,
+  sha256 CHAR(64) NOT NULL,
+  storage_key VARCHAR(64) NOT NULL,
+  created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+  FOREIGN KEY (task_id) REFERENCES tasks(id) ON DELETE CASCADE,
+  FOREIGN KEY (uploaded_by) REFERENCES users(id) ON DELETE SET NULL,
+  UNIQUE KEY uq_task_attachments_storage_key (storage_key),
+  INDEX idx_task_attachments_task (task_id, id),
+  INDEX idx_task_attachments_uploaded_by (uploaded_by, size)
+);
+
+-- Changes to tasks. Events are only ever added: the triggers refuse to change
+-- or remove them, and there are no foreign keys so that the history outlives
+-- the task and the users involved. actor_id is NULL for changes made by the
+-- system.
+CREATE TABLE IF NOT EXISTS task_events (
+  id INT UNSIGNED AUTO_INCREMENT PRIMARY KEY,
+  task_id INT UNSIGNED NOT NULL,
+  version INT UNSIGNED NOT NULL,
+  action ENUM('CREATE', 'UPDATE', 'STATUS', 'DELETE', 'UNDELETE', 'RESTORE') NOT NULL,
+  actor_id INT UNSIGNED NULL,
+  source_ip VARCHAR(45) NOT NULL DEFAULT '',
+  changes JSON NOT NULL,
+  snapshot JSON NOT NULL,
+  restored_from INT UNSIGNED NULL,
+  created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+  INDEX idx_task_events_task (task_id, version, id)
+);
+
+CREATE TRIGGER task_events_no_update BEFORE UPDATE ON task_events
+FOR EACH ROW SIGNAL SQLSTATE '45000' SET MESSAGE_TEXT = 'Task events cannot be changed';
+
+CREATE TRIGGER task_events_no_delete BEFORE DELETE ON task_events
+FOR EACH ROW SIGNAL SQLSTATE '45000' SET MESSAGE_TEXT = 'Task events cannot be removed';
+
+CREATE TABLE IF NOT EXISTS audit_log (
+  id INT UNSIGNED PRIMARY KEY,
+  created_at DATETIME(6) NOT NULL,
+  event ENUM('LOGIN', 'LOGIN_FAILED', 'SIGNUP', 'LOGOUT', 'SESSION_EXPIRED', 'PERMISSION_DENIED', 'EXPORT') NOT NULL,
+  user_id INT UNSIGNED NULL,
+  username VARCHAR(255) NOT NULL DEFAULT '',
+  source_ip VARCHAR(45) NOT NULL DEFAULT '',
+  details TEXT NOT NULL,
+  prev_hash CHAR(64) NOT NULL,
+  hash CHAR(64) NOT NULL,
+  INDEX idx_audit_log_event (event, id),
+  INDEX idx_audit_log_user (user_id, id),
+  INDEX idx_audit_log_created (created_at)
+);
+
+CREATE TRIGGER audit_log_no_update BEFORE UPDATE ON audit_log
+FOR EACH ROW SIGNAL SQLSTATE '45000' SET MESSAGE_TEXT = 'Audit log entries cannot be changed';
+
+CREATE TRIGGER audit_log_no_delete BEFORE DELETE ON audit_log
+FOR EACH ROW SIGNAL SQLSTATE '45000' SET MESSAGE_TEXT = 'Audit log entries cannot be removed';
+
+-- The head of the audit log's hash chain, locked to append one entry at a time
+CREATE TABLE IF NOT EXISTS audit_chain (
+  id TINYINT UNSIGNED PRIMARY KEY,
+  last_id INT UNSIGNED NOT NULL,
+  last_hash CHAR(64) NOT NULL
+);
+
+INSERT INTO audit_chain (id, last_id, last_hash) VALUES (1, 0, REPEAT('0', 64));
+
+CREATE TRIGGER audit_chain_no_delete BEFORE DELETE ON audit_chain
+FOR EACH ROW SIGNAL SQLSTATE '45000' SET MESSAGE_TEXT = 'The audit chain cannot be removed';
+
+CREATE TABLE IF NOT EXISTS task_dependencies (
+  task_id INT UNSIGNED NOT NULL,
+  blocker_id INT UNSIGNED NOT NULL,
+  PRIMARY KEY (task_id, blocker_id),
+  FOREIGN KEY (task_id) REFERENCES tasks(id) ON DELETE CASCADE,
+  FOREIGN KEY (blocker_id) REFERENCES tasks(id) ON DELETE CASCADE,
+  INDEX idx_task_dependencies_blocker (blocker_id, task_id)
+);
+
+CREATE TABLE IF NOT EXISTS tags (
+  id INT UNSIGNED AUTO_INCREMENT PRIMARY KEY,
+  user_id INT UNSIGNED NOT NULL,
+  name VARCHAR(64) NOT NULL,
+  created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+  FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE,
+  UNIQUE KEY uq_tags_user_name (user_id, name)
+);
+
+CREATE TABLE IF NOT EXISTS task_tags (
+  task_id INT UNSIGNED NOT NULL,
+  tag_id INT UNSIGNED NOT NULL,
+  PRIMARY KEY (task_id, tag_id),
+  FOREIGN KEY (task_id) REFERENCES tasks(id) ON DELETE CASCADE,
+  FOREIGN KEY (tag_id) REFERENCES tags(id) ON DELETE CASCADE,
+  INDEX idx_task_tags_tag (tag_id, task_id)
 );
 
 -- Add a demo users (password: 'demo123')
-INSERT INTO users (id, name, password_hash) VALUES
-(1, 'testuser1', '$argon2id$v=19$m=65536,t=3,p=4$pqJ2kWwyHs6Uszb0saO8wQ==$i93hewu5pDcYtrEjUSaKnd6yB00FLwIjWzpuOK5o9/Q='),
-(2, 'testuser2', '$argon2id$v=19$m=65536,t=3,p=4$pqJ2kWwyHs6Uszb0saO8wQ==$i93hewu5pDcYtrEjUSaKnd6yB00FLwIjWzpuOK5o9/Q=');
+INSERT INTO users (id, name, password_hash, role) VALUES
+(1, 'testuser1', '$argon2id$v=19$m=65536,t=3,p=4$pqJ2kWwyHs6Uszb0saO8wQ==$i93hewu5pDcYtrEjUSaKnd6yB00FLwIjWzpuOK5o9/Q=', 'CASEWORKER'),
+(2, 'testuser2', '$argon2id$v=19$m=65536,t=3,p=4$pqJ2kWwyHs6Uszb0saO8wQ==$i93hewu5pDcYtrEjUSaKnd6yB00FLwIjWzpuOK5o9/Q=', 'CASEWORKER'),
+(3, 'testadmin', '$argon2id$v=19$m=65536,t=3,p=4$pqJ2kWwyHs6Uszb0saO8wQ==$i93hewu5pDcYtrEjUSaKnd6yB00FLwIjWzpuOK5o9/Q=', 'ADMIN'),
+(4, 'testauditor', '$argon2id$v=19$m=65536,t=3,p=4$pqJ2kWwyHs6Uszb0saO8wQ==$i93hewu5pDcYtrEjUSaKnd6yB00FLwIjWzpuOK5o9/Q=', 'AUDITOR');
 
 -- Insert mock tasks for testing
-INSERT INTO tasks (id, user_id, name, description, status, deadline) VALUES
-(1, 1, 'Task 1', 'Description for Task 1', 'INCOMPLETE', '2025-12-31 00:00:00'),
-(2, 1, 'Task 2', 'Description for Task 2', 'COMPLETE', '2025-11-30 00:00:00'),
-(3, 2, 'Task 3', 'Description for Task 3', 'INCOMPLETE', '2025-10-15 00:00:00');
\ No newline at end of file
+INSERT INTO tasks (id, created_by, assignee_id, name, description, status, deadline) VALUES
+(1, 1, 1, 'Task 1', 'Description for Task 1', 'INCOMPLETE', '2025-12-31 00:00:00'),
+(2, 1, 1, 'Task 2', 'Description for Task 2', 'COMPLETE', '2025-11-30 00:00:00'),
+(3, 2, 2, 'Task 3', 'Description for Task 3', 'INCOMPLETE', '2025-10-15 00:00:00');
\ No newline at end of file
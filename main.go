@@ -2,13 +2,18 @@ package main
 
 import (
 	"HMCTS-Developer-Challenge/api"
+	"HMCTS-Developer-Challenge/audit"
+	"HMCTS-Developer-Challenge/blobstore"
+	"HMCTS-Developer-Challenge/calendar"
 	"HMCTS-Developer-Challenge/database"
 	"HMCTS-Developer-Challenge/errors"
 	"HMCTS-Developer-Challenge/session"
 	"bytes"
+	"encoding/json"
 	"html/template"
 	"log"
 	"net/http"
+	"os"
 )
 
 const (
@@ -16,6 +21,7 @@ const (
 	LoginSignUpPage
 	TasksPage
 	TasksAddEditPage
+	AdminUsersPage
 
 	PageCount
 )
@@ -23,8 +29,75 @@ const (
 var templates = make([]*template.Template, PageCount)
 
 func main() {
+	// "server verify-audit" checks the audit log instead of serving
+	if len(os.Args) > 1 && os.Args[1] == "verify-audit" {
+		os.Exit(verifyAuditLog())
+	}
+
 	loadTemplates()
 
+	if err := api.LoadTaskWorkflow(os.Getenv("TASK_WORKFLOW_FILE")); err != nil {
+		log.Println(err)
+		return
+	}
+
+	if err := api.SetMaxTaskDepth(os.Getenv("TASK_MAX_DEPTH")); err != nil {
+		log.Println(err)
+		return
+	}
+
+	if err := api.SetUserDeletionPolicy(os.Getenv("USER_DELETION_POLICY")); err != nil {
+		log.Println(err)
+		return
+	}
+
+	if err := api.SetInviteSecret(os.Getenv("INVITE_SECRET")); err != nil {
+		log.Println(err)
+		return
+	}
+
+	if err := api.SetOpenSignup(os.Getenv("OPEN_SIGNUP")); err != nil {
+		log.Println(err)
+		return
+	}
+
+	if err := calendar.Load(os.Getenv("BANK_HOLIDAYS_FILE")); err != nil {
+		log.Println(err)
+		return
+	}
+
+	if err := api.SetCalendarDivision(os.Getenv("BANK_HOLIDAY_DIVISION")); err != nil {
+		log.Println(err)
+		return
+	}
+
+	store, err := blobstore.Open(blobstore.Config{
+		Kind: os.Getenv("BLOB_STORE"),
+		Dir:  os.Getenv("BLOB_DIR"),
+		S3: blobstore.S3Config{
+			Endpoint:        os.Getenv("S3_ENDPOINT"),
+			Region:          os.Getenv("S3_REGION"),
+			Bucket:          os.Getenv("S3_BUCKET"),
+			AccessKeyID:     os.Getenv("S3_ACCESS_KEY_ID"),
+			SecretAccessKey: os.Getenv("S3_SECRET_ACCESS_KEY"),
+		},
+	})
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	api.SetBlobStore(store)
+
+	if err := api.SetAttachmentLimits(os.Getenv("ATTACHMENT_MAX_SIZE"), os.Getenv("ATTACHMENT_USER_QUOTA")); err != nil {
+		log.Println(err)
+		return
+	}
+
+	if err := api.SetTrashRetention(os.Getenv("TRASH_RETENTION_DAYS")); err != nil {
+		log.Println(err)
+		return
+	}
+
 	if err := database.Connect(); err != nil {
 		log.Println(err)
 		return
@@ -35,6 +108,11 @@ func main() {
 		}
 	}()
 
+	if err := api.BootstrapAdmin(os.Getenv("ADMIN_USERNAME"), os.Getenv("ADMIN_PASSWORD")); err != nil {
+		log.Println(err)
+		return
+	}
+
 	http.HandleFunc("/", servePageWithRedirect(templates[HomePage]))
 
 	http.HandleFunc("/api/logout", apiWrapper(api.LogoutHandler))
@@ -43,12 +121,39 @@ func main() {
 	http.HandleFunc("/api/login", apiWrapper(api.LoginHandler))
 
 	http.HandleFunc("/signup", servePageSignupLogin(templates[LoginSignUpPage], "signup", "Create Account"))
+	// SignUpHandler checks for an admin, an invitation or open signup itself
 	http.HandleFunc("/api/signup", apiWrapper(api.SignUpHandler))
 
-	http.HandleFunc("/api/tasks/", apiWrapperWithSessionCheck(api.TasksHandler))
+	http.HandleFunc("/api/tasks/", apiWrapperWithPermission(api.PermissionViewTasks, api.PermissionEditTasks, api.TasksHandler))
+	http.HandleFunc("/api/tasks/search", apiWrapperWithPermission(api.PermissionViewTasks, api.PermissionEditTasks, api.SearchTasksHandler))
+	http.HandleFunc("/api/tasks/plan", apiWrapperWithPermission(api.PermissionViewTasks, api.PermissionEditTasks, api.TaskPlanHandler))
+	http.HandleFunc("/api/tasks/recurrence", apiWrapperWithPermission(api.PermissionViewTasks, api.PermissionEditTasks, api.RecurrencePreviewHandler))
+	http.HandleFunc("/api/tasks/bulk", apiWrapperWithPermission(api.PermissionViewTasks, api.PermissionEditTasks, api.BulkTasksHandler))
+	http.HandleFunc("/api/tasks/trash", apiWrapperWithPermission(api.PermissionViewTasks, api.PermissionEditTasks, api.TrashHandler))
+	http.HandleFunc("/api/tasks/trash/", apiWrapperWithPermission(api.PermissionViewTasks, api.PermissionEditTasks, api.TrashHandler))
+	http.HandleFunc("/api/calendar/", apiWrapperWithPermission(api.PermissionViewTasks, api.PermissionEditTasks, api.CalendarHandler))
+	// Every user chooses their own zone, so reading and setting it need the same permission
+	http.HandleFunc("/api/timezone", apiWrapperWithPermission(api.PermissionViewTasks, api.PermissionViewTasks, api.TimezoneHandler))
+	http.HandleFunc("/api/task-statuses", apiWrapperWithPermission(api.PermissionViewTasks, api.PermissionEditTasks, api.TaskStatusesHandler))
+	http.HandleFunc("/api/users", apiWrapperWithPermission(api.PermissionViewTasks, api.PermissionManageUsers, api.UsersHandler))
+	// Creating teams needs the permission to manage teams, while the members
+	// of a team manage it through their role in the team
+	http.HandleFunc("/api/teams", apiWrapperWithPermission(api.PermissionViewTasks, api.PermissionManageTeams, api.TeamsHandler))
+	http.HandleFunc("/api/teams/", apiWrapperWithPermission(api.PermissionViewTasks, api.PermissionEditTasks, api.TeamsHandler))
+	http.HandleFunc("/api/tags", apiWrapperWithPermission(api.PermissionViewTasks, api.PermissionEditTasks, api.TagsHandler))
+	http.HandleFunc("/api/tags/", apiWrapperWithPermission(api.PermissionViewTasks, api.PermissionEditTasks, api.TagsHandler))
+	http.HandleFunc("/api/cases", apiWrapperWithPermission(api.PermissionViewTasks, api.PermissionEditTasks, api.CasesHandler))
+	http.HandleFunc("/api/cases/", apiWrapperWithPermission(api.PermissionViewTasks, api.PermissionEditTasks, api.CasesHandler))
+	http.HandleFunc("/api/admin/users", apiWrapperWithPermission(api.PermissionManageUsers, api.PermissionManageUsers, api.AdminUsersHandler))
+	http.HandleFunc("/api/admin/users/", apiWrapperWithPermission(api.PermissionManageUsers, api.PermissionManageUsers, api.AdminUsersHandler))
+	http.HandleFunc("/api/admin/invites", apiWrapperWithPermission(api.PermissionManageUsers, api.PermissionManageUsers, api.AdminInvitesHandler))
+	http.HandleFunc("/api/admin/invites/", apiWrapperWithPermission(api.PermissionManageUsers, api.PermissionManageUsers, api.AdminInvitesHandler))
+	http.HandleFunc("/api/admin/audit", apiWrapperWithPermission(api.PermissionViewAuditLog, api.PermissionViewAuditLog, api.AdminAuditHandler))
+	http.HandleFunc("/api/admin/audit/", apiWrapperWithPermission(api.PermissionViewAuditLog, api.PermissionViewAuditLog, api.AdminAuditHandler))
 	http.HandleFunc("/tasks", servePageWithRedirect(templates[TasksPage]))
 	http.HandleFunc("/tasks/add", servePageTask(templates[TasksAddEditPage], false))
 	http.HandleFunc("/tasks/edit/", servePageTask(templates[TasksAddEditPage], true))
+	http.HandleFunc("/admin/users", servePageWithPermission(templates[AdminUsersPage], api.PermissionManageUsers))
 
 	http.Handle("/static/", http.StripPrefix("/static/", http.FileServer(http.Dir("static"))))
 	http.HandleFunc("/static/README.md", func(w http.ResponseWriter, r *http.Request) {
@@ -56,6 +161,7 @@ func main() {
 	})
 
 	go session.SessionCleanupRoutine()
+	go api.TrashPurgeRoutine()
 
 	go func() {
 		redirectHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -80,15 +186,24 @@ func loadTemplates() {
 	templates[LoginSignUpPage] = template.Must(template.ParseFiles(baseTemplate, navbarTemplate, "./templates/login-signup.html"))
 	templates[TasksPage] = template.Must(template.ParseFiles(baseTemplate, navbarTemplate, "./templates/tasks.html"))
 	templates[TasksAddEditPage] = template.Must(template.ParseFiles(baseTemplate, navbarTemplate, "./templates/add-edit_task.html"))
+	templates[AdminUsersPage] = template.Must(template.ParseFiles(baseTemplate, navbarTemplate, "./templates/admin_users.html"))
 }
 
 type pageData struct {
 	IsLoggedIn bool
+	Role       string
+	OpenSignup bool
 	Edit       bool
 	Action     string
 	SubmitText string
 }
 
+// Can reports whether the logged in user has a permission, so templates can
+// hide the actions the user cannot perform.
+func (p pageData) Can(permission string) bool {
+	return api.HasPermission(p.Role, api.Permission(permission))
+}
+
 func servePageSignupLogin(template *template.Template, action string, submitText string) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodGet {
@@ -96,13 +211,21 @@ func servePageSignupLogin(template *template.Template, action string, submitText
 			return
 		}
 
-		loggedIn := false
-		if _, err := session.GetUserIDFromSession(w, r); err == nil {
-			loggedIn = true
+		data := pageData{Action: action, SubmitText: submitText, OpenSignup: api.OpenSignup()}
+		if userSession, err := session.GetUserFromSession(w, r); err == nil {
+			data.IsLoggedIn = true
+			data.Role = userSession.Role
+		}
+
+		// Without open signup only admins and invited people can sign up
+		invited := r.URL.Query().Get("invite") != ""
+		if action == "signup" && !data.OpenSignup && !invited && !data.Can(string(api.PermissionManageUsers)) {
+			http.Redirect(w, r, "/login", http.StatusSeeOther)
+			return
 		}
 
 		var buf bytes.Buffer
-		if err := template.Execute(&buf, &pageData{IsLoggedIn: loggedIn, Action: action, SubmitText: submitText}); err != nil {
+		if err := template.Execute(&buf, &data); err != nil {
 			errors.HandleServerError(w, err, "main.go: servePageWithForm - Execute")
 			return
 		}
@@ -117,14 +240,22 @@ func servePageTask(template *template.Template, edit bool) http.HandlerFunc {
 			return
 		}
 
-		_, err := session.GetUserIDFromSession(w, r)
+		userSession, err := session.GetUserFromSession(w, r)
 		if err != nil {
 			http.Redirect(w, r, "/login", http.StatusSeeOther)
 			return
 		}
 
+		// Users who cannot change tasks get the read-only task list instead
+		data := pageData{IsLoggedIn: true, Role: userSession.Role, Edit: edit}
+		if !data.Can(string(api.PermissionEditTasks)) {
+			api.RecordPermissionDenied(r, userSession.UserID, "Missing Permission "+string(api.PermissionEditTasks))
+			http.Redirect(w, r, "/tasks", http.StatusSeeOther)
+			return
+		}
+
 		var buf bytes.Buffer
-		if err := template.Execute(&buf, &pageData{IsLoggedIn: true, Edit: edit}); err != nil {
+		if err := template.Execute(&buf, &data); err != nil {
 			errors.HandleServerError(w, err, "main.go: servePageWithRedirect - Execute")
 			return
 		}
@@ -139,14 +270,14 @@ func servePageWithRedirect(template *template.Template) http.HandlerFunc {
 			return
 		}
 
-		_, err := session.GetUserIDFromSession(w, r)
+		userSession, err := session.GetUserFromSession(w, r)
 		if err != nil {
 			http.Redirect(w, r, "/login", http.StatusSeeOther)
 			return
 		}
 
 		var buf bytes.Buffer
-		if err := template.Execute(&buf, &pageData{IsLoggedIn: true}); err != nil {
+		if err := template.Execute(&buf, &pageData{IsLoggedIn: true, Role: userSession.Role}); err != nil {
 			errors.HandleServerError(w, err, "main.go: servePageWithRedirect - Execute")
 			return
 		}
@@ -154,6 +285,37 @@ func servePageWithRedirect(template *template.Template) http.HandlerFunc {
 	}
 }
 
+// servePageWithPermission serves a page to logged in users whose role grants
+// a permission, sending everyone else back to their tasks.
+func servePageWithPermission(template *template.Template, permission api.Permission) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		userSession, err := session.GetUserFromSession(w, r)
+		if err != nil {
+			http.Redirect(w, r, "/login", http.StatusSeeOther)
+			return
+		}
+
+		data := pageData{IsLoggedIn: true, Role: userSession.Role}
+		if !data.Can(string(permission)) {
+			api.RecordPermissionDenied(r, userSession.UserID, "Missing Permission "+string(permission))
+			http.Redirect(w, r, "/tasks", http.StatusSeeOther)
+			return
+		}
+
+		var buf bytes.Buffer
+		if err := template.Execute(&buf, &data); err != nil {
+			errors.HandleServerError(w, err, "main.go: servePageWithPermission - Execute")
+			return
+		}
+		buf.WriteTo(w)
+	}
+}
+
 func apiWrapper(fn func(http.ResponseWriter, *http.Request)) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Access-Control-Allow-Origin", "*")
@@ -161,16 +323,61 @@ func apiWrapper(fn func(http.ResponseWriter, *http.Request)) http.HandlerFunc {
 	}
 }
 
-func apiWrapperWithSessionCheck(fn func(http.ResponseWriter, *http.Request, uint)) http.HandlerFunc {
+// apiWrapperWithPermission checks that the request has a session and that the
+// user's role grants the read permission for safe methods or the write
+// permission for anything else.
+func apiWrapperWithPermission(read, write api.Permission, fn func(http.ResponseWriter, *http.Request, uint)) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Access-Control-Allow-Origin", "*")
 
-		userID, err := session.GetUserIDFromSession(w, r)
+		userSession, err := session.GetUserFromSession(w, r)
 		if err != nil {
 			http.Error(w, "Unauthorized", http.StatusUnauthorized)
 			return
 		}
 
-		fn(w, r, userID)
+		permission := write
+		if r.Method == http.MethodGet || r.Method == http.MethodHead || r.Method == http.MethodOptions {
+			permission = read
+		}
+		if !api.HasPermission(userSession.Role, permission) {
+			api.RecordPermissionDenied(r, userSession.UserID, "Missing Permission "+string(permission))
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+
+		fn(w, r, userSession.UserID)
+	}
+}
+
+// verifyAuditLog checks the audit log, printing the report as JSON, and
+// returns the exit code: 0 if the log is intact, 1 if it has been tampered
+// with and 2 if it could not be checked.
+func verifyAuditLog() int {
+	if err := database.Connect(); err != nil {
+		log.Println(err)
+		return 2
+	}
+	defer func() {
+		if err := database.Disconnect(); err != nil {
+			log.Println(err)
+		}
+	}()
+
+	report, err := audit.Verify()
+	if err != nil {
+		log.Println(err)
+		return 2
+	}
+
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(report); err != nil {
+		log.Println(err)
+		return 2
+	}
+	if !report.Valid {
+		return 1
 	}
+	return 0
 }
@@ -1,22 +1,30 @@
 package session
 
 import (
+	"HMCTS-Developer-Challenge/audit"
 	"HMCTS-Developer-Challenge/errors"
 	"crypto/rand"
 	"log"
 	"net/http"
+	"sync"
 	"time"
 )
 
 var sessionTimeout = 0*time.Second + 5*time.Minute + 0*time.Hour
 
 type Session struct {
-	UserID    uint
+	UserID uint
+	// Role is the user's role when they logged in, used to decide what they
+	// may do without a database lookup on every request.
+	Role      string
 	Timestamp time.Time
 }
 
 var sessions = make(map[string]Session)
 
+// sessionsMu guards sessions, which requests and the cleanup routine share.
+var sessionsMu sync.RWMutex
+
 var errSessionExpired = errors.Error("Session Expired")
 var errSessionNotFound = errors.Error("Session Not Found")
 
@@ -40,19 +48,27 @@ func SessionCleanupRoutine() {
 		log.Println("Running session cleanup...")
 		now := time.Now()
 
+		expired := map[string]Session{}
+		sessionsMu.Lock()
 		for sessionID, session := range sessions {
 			if now.Sub(session.Timestamp) > sessionTimeout {
+				expired[sessionID] = session
 				delete(sessions, sessionID)
-				log.Printf("Session %s expired and removed\n", sessionID)
 			}
 		}
+		sessionsMu.Unlock()
+
+		for sessionID, session := range expired {
+			recordSessionExpired(session, "")
+			log.Printf("Session %s expired and removed\n", sessionID)
+		}
 
 		log.Println("Session cleanup completed")
 	}
 }
 
-func CreateUserSessionCookie(w http.ResponseWriter, userID uint) {
-	sessionID, sessionTimout := createUserSession(userID)
+func CreateUserSessionCookie(w http.ResponseWriter, userID uint, role string) {
+	sessionID, sessionTimout := createUserSession(userID, role)
 	SetCookie(w, "session_id", sessionID, sessionTimout)
 }
 
@@ -70,23 +86,73 @@ func GetUserIDFromSession(w http.ResponseWriter, r *http.Request) (uint, error)
 	return userID, nil
 }
 
+// GetUserFromSession returns the session of the request, which carries the
+// user's ID and role.
+func GetUserFromSession(w http.ResponseWriter, r *http.Request) (Session, error) {
+	sessionID, err := getSessionID(w, r)
+	if err != nil {
+		return Session{}, errors.AddContext(err, "session.go: GetUserFromSession - getSessionID")
+	}
+
+	sessionsMu.RLock()
+	session, exists := sessions[sessionID]
+	sessionsMu.RUnlock()
+	if !exists {
+		return Session{}, errSessionNotFound
+	}
+
+	return session, nil
+}
+
 func DeleteUserSessionCookie(w http.ResponseWriter, r *http.Request) error {
 	sessionID, err := getSessionID(w, r)
 	if err == nil {
+		sessionsMu.Lock()
 		delete(sessions, sessionID)
+		sessionsMu.Unlock()
 		SetCookie(w, "session_id", "", time.Time{})
 	}
 	return err
 }
 
-func createUserSession(userID uint) (string, time.Time) {
+// SetUserRole changes the role of every session of a user, so a new role
+// applies without the user logging in again.
+func SetUserRole(userID uint, role string) {
+	sessionsMu.Lock()
+	defer sessionsMu.Unlock()
+
+	for sessionID, session := range sessions {
+		if session.UserID == userID {
+			session.Role = role
+			sessions[sessionID] = session
+		}
+	}
+}
+
+// DeleteUserSessions ends every session of a user, e.g. when their account is
+// disabled or deleted.
+func DeleteUserSessions(userID uint) {
+	sessionsMu.Lock()
+	defer sessionsMu.Unlock()
+
+	for sessionID, session := range sessions {
+		if session.UserID == userID {
+			delete(sessions, sessionID)
+		}
+	}
+}
+
+func createUserSession(userID uint, role string) (string, time.Time) {
 	sessionID := rand.Text()
 	timeStamp := time.Now()
 
+	sessionsMu.Lock()
 	sessions[sessionID] = Session{
 		UserID:    userID,
+		Role:      role,
 		Timestamp: timeStamp,
 	}
+	sessionsMu.Unlock()
 
 	return sessionID, timeStamp.Add(sessionTimeout)
 }
@@ -98,22 +164,41 @@ func getSessionID(w http.ResponseWriter, r *http.Request) (string, error) {
 	}
 
 	sessionID := cookie.Value
-	if time.Since(sessions[sessionID].Timestamp) > sessionTimeout {
+	sessionsMu.Lock()
+	session, exists := sessions[sessionID]
+	if time.Since(session.Timestamp) > sessionTimeout {
 		delete(sessions, sessionID)
+		sessionsMu.Unlock()
+		if exists {
+			recordSessionExpired(session, audit.SourceIP(r))
+		}
 		SetCookie(w, "session_id", "", time.Time{})
 		return "", errSessionExpired
 	}
 
-	sessions[sessionID] = Session{
-		UserID:    sessions[sessionID].UserID,
-		Timestamp: time.Now(),
-	}
+	session.Timestamp = time.Now()
+	sessions[sessionID] = session
+	sessionsMu.Unlock()
 
 	return sessionID, nil
 }
 
+// recordSessionExpired records a session that has timed out in the audit log
+// once it has been removed. Sessions found by the cleanup routine have no
+// request to take a source address from.
+func recordSessionExpired(session Session, sourceIP string) {
+	audit.Log(audit.Entry{
+		Event:    audit.EventSessionExpired,
+		UserID:   audit.UserID(session.UserID),
+		SourceIP: sourceIP,
+		Details:  map[string]string{"last_active": session.Timestamp.UTC().Format(time.RFC3339)},
+	})
+}
+
 func getUserID(sessionID string) (uint, error) {
+	sessionsMu.RLock()
 	session, exists := sessions[sessionID]
+	sessionsMu.RUnlock()
 
 	if !exists {
 		return 0, errSessionNotFound
@@ -3,13 +3,14 @@ package session
 import (
 	"net/http"
 	"net/http/httptest"
+	"sync"
 	"testing"
 	"time"
 )
 
 func TestCreateUserSessionCookie(t *testing.T) {
 	w := httptest.NewRecorder()
-	CreateUserSessionCookie(w, 1)
+	CreateUserSessionCookie(w, 1, "CASEWORKER")
 
 	// Check if the cookie is set
 	cookies := w.Result().Cookies()
@@ -48,6 +49,60 @@ func TestGetUserIDFromSession(t *testing.T) {
 	delete(sessions, "test-session-id")
 }
 
+func TestGetUserFromSession(t *testing.T) {
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.AddCookie(&http.Cookie{Name: "session_id", Value: "test-session-id"})
+
+	sessions["test-session-id"] = Session{
+		UserID:    1,
+		Role:      "AUDITOR",
+		Timestamp: time.Now().Add(-time.Minute),
+	}
+
+	session, err := GetUserFromSession(w, r)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	if session.UserID != 1 || session.Role != "AUDITOR" {
+		t.Errorf("Expected user 1 with role AUDITOR, got %v with role %v", session.UserID, session.Role)
+	}
+
+	// Refreshing the session must keep the role
+	if sessions["test-session-id"].Role != "AUDITOR" {
+		t.Errorf("Expected the refreshed session to keep its role, got %v", sessions["test-session-id"].Role)
+	}
+
+	delete(sessions, "test-session-id")
+}
+
+func TestSetUserRoleAndDeleteUserSessions(t *testing.T) {
+	sessions["first-session-id"] = Session{UserID: 1, Role: "CASEWORKER", Timestamp: time.Now()}
+	sessions["second-session-id"] = Session{UserID: 1, Role: "CASEWORKER", Timestamp: time.Now()}
+	sessions["other-session-id"] = Session{UserID: 2, Role: "CASEWORKER", Timestamp: time.Now()}
+	defer delete(sessions, "other-session-id")
+
+	SetUserRole(1, "LEAD")
+	if sessions["first-session-id"].Role != "LEAD" || sessions["second-session-id"].Role != "LEAD" {
+		t.Errorf("Expected every session of user 1 to have role LEAD, got %v and %v", sessions["first-session-id"].Role, sessions["second-session-id"].Role)
+	}
+	if sessions["other-session-id"].Role != "CASEWORKER" {
+		t.Errorf("Expected the session of user 2 to keep its role, got %v", sessions["other-session-id"].Role)
+	}
+
+	DeleteUserSessions(1)
+	if _, exists := sessions["first-session-id"]; exists {
+		t.Error("Expected the first session of user 1 to be deleted")
+	}
+	if _, exists := sessions["second-session-id"]; exists {
+		t.Error("Expected the second session of user 1 to be deleted")
+	}
+	if _, exists := sessions["other-session-id"]; !exists {
+		t.Error("Expected the session of user 2 to be kept")
+	}
+}
+
 func TestDeleteUserSessionCookie(t *testing.T) {
 	w := httptest.NewRecorder()
 	r := httptest.NewRequest(http.MethodGet, "/", nil)
@@ -74,7 +129,7 @@ func TestDeleteUserSessionCookie(t *testing.T) {
 }
 
 func TestCreateUserSession(t *testing.T) {
-	sessionID, timeout := createUserSession(1)
+	sessionID, timeout := createUserSession(1, "CASEWORKER")
 
 	if sessionID == "" {
 		t.Fatalf("Expected a valid session ID, got an empty string")
@@ -89,6 +144,10 @@ func TestCreateUserSession(t *testing.T) {
 		t.Errorf("Expected UserID 1, got %v", session.UserID)
 	}
 
+	if session.Role != "CASEWORKER" {
+		t.Errorf("Expected role CASEWORKER, got %v", session.Role)
+	}
+
 	if temp := timeout.Sub(session.Timestamp); temp != sessionTimeout {
 		t.Errorf("Expected timeout to be %v, got %v", sessionTimeout, temp)
 	}
@@ -135,3 +194,32 @@ func TestGetUserID(t *testing.T) {
 
 	delete(sessions, "test-session-id")
 }
+
+func TestConcurrentSessionAccess(t *testing.T) {
+	var wg sync.WaitGroup
+	for i := range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			userID := uint(100 + i%2)
+			for range 100 {
+				sessionID, _ := createUserSession(userID, "CASEWORKER")
+				SetUserRole(userID, "LEAD")
+				getUserID(sessionID)
+				DeleteUserSessions(userID)
+			}
+		}()
+	}
+	wg.Wait()
+
+	for _, userID := range []uint{100, 101} {
+		DeleteUserSessions(userID)
+	}
+	sessionsMu.RLock()
+	defer sessionsMu.RUnlock()
+	for _, session := range sessions {
+		if session.UserID == 100 || session.UserID == 101 {
+			t.Errorf("Expected every session of user %d to be deleted", session.UserID)
+		}
+	}
+}